@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access defaults for senders and groups on neither list
+const (
+	accessAllow = "allow"
+	accessDeny  = "deny"
+)
+
+// refusalInterval is how often one unauthorized sender is told so
+const refusalInterval = 24 * time.Hour
+
+// accessPolicy decides who may use the bot, from the ALLOW_*/DENY_* lists
+type accessPolicy struct {
+	defaultDeny  bool
+	allowSenders map[string]bool // numbers and UUIDs
+	denySenders  map[string]bool
+	allowGroups  map[string]bool // group IDs
+	denyGroups   map[string]bool
+}
+
+func newAccessPolicy(config Config) accessPolicy {
+	set := func(items []string) map[string]bool {
+		m := make(map[string]bool, len(items))
+		for _, item := range items {
+			m[strings.TrimPrefix(item, "group:")] = true
+		}
+		return m
+	}
+	return accessPolicy{
+		defaultDeny:  config.AccessDefault == accessDeny,
+		allowSenders: set(config.AllowSenders),
+		denySenders:  set(config.DenySenders),
+		allowGroups:  set(config.AllowGroups),
+		denyGroups:   set(config.DenyGroups),
+	}
+}
+
+// Allows reports whether sender may use the bot in groupID ("" for a DM).
+// Denials win over allowances, and an allowed sender or group is enough.
+func (p accessPolicy) Allows(sender AgentSender, groupID string) bool {
+	if p.denySenders[sender.Number] || p.denySenders[sender.UUID] || p.denyGroups[groupID] {
+		return false
+	}
+	if p.allowSenders[sender.Number] || p.allowSenders[sender.UUID] || p.allowGroups[groupID] {
+		return true
+	}
+	return !p.defaultDeny
+}
+
+// refusalLog remembers when unauthorized senders were last refused so
+// each hears it at most once per refusalInterval
+type refusalLog struct {
+	mu      sync.Mutex
+	refused map[string]time.Time
+}
+
+// Due records a refusal to sender at now, reporting whether one is due
+func (r *refusalLog) Due(sender string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.refused == nil {
+		r.refused = make(map[string]time.Time)
+	}
+	for key, at := range r.refused {
+		if now.Sub(at) >= refusalInterval {
+			delete(r.refused, key)
+		}
+	}
+	if _, recent := r.refused[sender]; recent {
+		return false
+	}
+	r.refused[sender] = now
+	return true
+}
+
+// addressesBot reports whether content is meant for the bot: a command or
+// a triggered prompt
+func (bot *SignalBot) addressesBot(content string) bool {
+	return strings.HasPrefix(content, "!") || bot.isTriggered(content)
+}
+
+// authorized reports whether msg may be handled. The owner always may.
+func (bot *SignalBot) authorized(msg *Message) bool {
+	return bot.isAdmin(msg) || bot.live.Load().access.Allows(msg.sender(), msg.extractGroupId())
+}
+
+// refuseUnauthorized answers a message from someone who may not use the
+// bot with the unauthorized error template, when it was meant for the bot:
+// a command or a triggered prompt. Other messages, in DMs too, are left
+// alone, since the account is a personal number whose contacts chat with
+// its owner. An empty template means silence. Senders refused too often
+// may be blocked instead; see autoBlock.
+func (bot *SignalBot) refuseUnauthorized(msg *Message) {
+	bot.logger.Printf("Ignoring message from unauthorized sender %s", msg.Envelope.Source)
+	if !bot.addressesBot(msg.extractContent()) {
+		return
+	}
+	refusal := bot.errorMessage(msg.chatID(), errorUnauthorized, nil)
+	if bot.autoBlock(msg) || refusal == "" {
+		return
+	}
+	if !bot.refusals.Due(msg.Envelope.Source, time.Now()) {
+		return
+	}
+	if err := bot.sendReply(msg.replyRecipient(), refusal, msg.extractTimestamp(), msg.Envelope.Source); err != nil {
+		bot.logger.Printf("Error sending refusal: %v", err)
+	}
+}
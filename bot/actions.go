@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Action types an agent may attach to a response
+const (
+	actionReact    = "react"
+	actionSchedule = "schedule"
+	actionForward  = "forward"
+)
+
+// AgentAction is a side effect requested by a v2 agent, e.g.
+//
+//	{"type": "react", "emoji": "👍"}
+//	{"type": "schedule", "at": "tomorrow at 9", "text": "Stand-up!"}
+//	{"type": "forward", "to": "family"}
+type AgentAction struct {
+	Type  string `json:"type"`
+	Emoji string `json:"emoji,omitempty"`
+	At    string `json:"at,omitempty"`
+	Text  string `json:"text,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// runActions validates each action against AGENT_ACTIONS and executes it.
+// Natural-language times are read in loc.
+func (bot *SignalBot) runActions(ctx context.Context, result agentAnswer, target replyTarget, loc *time.Location) {
+	for _, action := range result.Actions {
+		if !contains(bot.config.AgentActions, action.Type) {
+			bot.logger.Printf("Rejected agent action %q: not in AGENT_ACTIONS", action.Type)
+			continue
+		}
+		if err := bot.runAction(action, result, target, loc); err != nil {
+			bot.logger.Printf("Agent action %s failed: %v", action.Type, err)
+			continue
+		}
+		bot.logger.Printf("Executed agent action %s", action.Type)
+	}
+}
+
+// runAction executes a single allowlisted action
+func (bot *SignalBot) runAction(action AgentAction, result agentAnswer, target replyTarget, loc *time.Location) error {
+	switch action.Type {
+	case actionReact:
+		if action.Emoji == "" || target.QuoteTimestamp == 0 || target.QuoteAuthor == "" {
+			return fmt.Errorf("react needs an emoji and a prompt message")
+		}
+		return bot.sendReaction(target.Recipient, action.Emoji, target.QuoteAuthor, target.QuoteTimestamp)
+
+	case actionSchedule:
+		if action.Text == "" {
+			return fmt.Errorf("schedule needs text")
+		}
+		at, err := parseActionTime(action.At, loc)
+		if err != nil {
+			return err
+		}
+		text := bot.redactTo(target.Recipient, []string{action.Text})[0]
+		item, err := bot.scheduler.Add(at, target.Recipient, text, AgentSender{Number: "agent"}, "")
+		bot.logger.Printf("Agent scheduled message %d for %s", item.ID, at.Format(time.RFC3339))
+		return err
+
+	case actionForward:
+		recipient, exists := bot.config.AgentForwardTargets[action.To]
+		if !exists {
+			return fmt.Errorf("unknown forward target %q", action.To)
+		}
+		bot.spaceBroadcast(recipient)
+		return bot.sendReplies(recipient, bot.redactTo(recipient, result.Replies), 0, "")
+
+	default:
+		return fmt.Errorf("unknown action type")
+	}
+}
+
+// parseActionTime accepts RFC 3339 timestamps or natural-language times in
+// loc
+func parseActionTime(at string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, at); err == nil {
+		return t, nil
+	}
+	t, rest, err := parseNaturalTime(at, time.Now(), loc)
+	if err != nil || strings.TrimSpace(rest) != "" {
+		return time.Time{}, fmt.Errorf("invalid schedule time %q", at)
+	}
+	return t, nil
+}
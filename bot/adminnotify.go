@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// noteToSelf is the ADMIN_NOTIFY value sending notes to the account's own
+// Note to Self
+const noteToSelf = "self"
+
+// Events reported to ADMIN_NOTIFY, selected with ADMIN_NOTIFY_EVENTS
+const (
+	adminEventStartup   = "startup"    // the bot started
+	adminEventShutdown  = "shutdown"   // the bot is stopping
+	adminEventAgent     = "agent"      // the agent keeps failing, and recovered
+	adminEventRateLimit = "rate-limit" // Signal rate-limits the account
+	adminEventChallenge = "challenge"  // captcha instructions (CHALLENGE_CHANNEL=owner)
+	adminEventIdentity  = "identity"   // safety number changes and untrusted sends
+	adminEventQueue     = "queue"      // received messages dropped from a full work queue
+	adminEventWatchdog  = "watchdog"   // signal-cli keeps failing to receive, and recovered
+	adminEventUsage     = "usage"      // periodic usage summaries
+	adminEventErrors    = "errors"     // prompts answered with ❌ under SILENT_FAILURES
+	adminEventFlood     = "flood"      // senders and groups muted for flooding
+	adminEventBlock     = "block"      // strangers blocked under ACCESS_AUTO_BLOCK
+)
+
+// adminEvents lists every event, the default of ADMIN_NOTIFY_EVENTS
+var adminEvents = []string{
+	adminEventStartup, adminEventShutdown, adminEventAgent, adminEventRateLimit, adminEventChallenge,
+	adminEventIdentity, adminEventQueue, adminEventWatchdog, adminEventUsage, adminEventErrors,
+	adminEventFlood, adminEventBlock,
+}
+
+// queueAlertInterval is the least time between two queue overflow alerts
+const queueAlertInterval = 10 * time.Minute
+
+// adminRecipient returns the signal-cli recipient of ADMIN_NOTIFY, "" when
+// unset
+func (bot *SignalBot) adminRecipient() string {
+	recipient := bot.config.AdminNotify
+	if groupID, isGroup := strings.CutPrefix(recipient, "group:"); isGroup {
+		return "-g " + groupID
+	}
+	return recipient
+}
+
+// notifyAdmin sends a note about event to ADMIN_NOTIFY, unless the event
+// isn't in ADMIN_NOTIFY_EVENTS. Failed notes wait in the outbox, so they
+// arrive once signal-cli works again.
+func (bot *SignalBot) notifyAdmin(event, text string) {
+	recipient := bot.adminRecipient()
+	if recipient == "" || !bot.adminEvents[event] {
+		return
+	}
+	if err := bot.sendReply(recipient, text, 0, ""); err != nil {
+		bot.logger.Printf("Error notifying %s: %v", bot.config.AdminNotify, err)
+	}
+}
+
+// alertQueueOverflow tells the admin that received messages are being
+// dropped, at most once per queueAlertInterval
+func (bot *SignalBot) alertQueueOverflow() {
+	if !bot.queueAlerts.Allow(time.Now()) {
+		return
+	}
+	bot.notifyAdmin(adminEventQueue, "⚠️ The work queue is full, so received messages are being dropped: "+bot.workQueueStatus())
+}
+
+// validateAdminEvents checks the names in ADMIN_NOTIFY_EVENTS
+func validateAdminEvents(events []string) error {
+	for _, event := range events {
+		known := false
+		for _, name := range adminEvents {
+			known = known || event == name
+		}
+		if !known {
+			return fmt.Errorf("ADMIN_NOTIFY_EVENTS: unknown event %q (use %s)", event, strings.Join(adminEvents, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxAliasesPerChat caps how many aliases a chat can define with !alias
+const maxAliasesPerChat = 50
+
+// aliasNamePattern is what an alias can be called: a short word
+var aliasNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// loadAliases reads an optional JSON file mapping alias names to prompts,
+// the aliases the operator offers in every chat
+func loadAliases(path string) (map[string]string, error) {
+	aliases := make(map[string]string)
+	if path == "" {
+		return aliases, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read aliases file: %w", err)
+	}
+
+	var custom map[string]string
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("failed to parse aliases file %s: %w", path, err)
+	}
+	for name, prompt := range custom {
+		name = strings.ToLower(name)
+		if _, isCommand := commands[name]; isCommand {
+			return nil, fmt.Errorf("alias %q in %s clashes with the !%s command", name, path, name)
+		}
+		aliases[name] = prompt
+	}
+	return aliases, nil
+}
+
+// alias returns the prompt an alias expands to in a chat. A chat's own
+// aliases take precedence over the operator's.
+func (bot *SignalBot) alias(chatID, name string) (string, bool) {
+	if chatID != "" {
+		if prompt := bot.aliases.Get(chatID, name); prompt != "" {
+			return prompt, true
+		}
+	}
+	prompt, exists := bot.operatorAliases[name]
+	return prompt, exists
+}
+
+// expandAlias turns "!<alias> [more text]" into the alias's prompt, with
+// any text after the alias appended
+func (bot *SignalBot) expandAlias(chatID, content string) (string, bool) {
+	if !strings.HasPrefix(content, "!") {
+		return "", false
+	}
+	fields := strings.Fields(strings.TrimPrefix(content, "!"))
+	if len(fields) == 0 {
+		return "", false
+	}
+	prompt, exists := bot.alias(chatID, strings.ToLower(fields[0]))
+	if !exists {
+		return "", false
+	}
+	if rest := commandText(content, 1); rest != "" {
+		prompt += "\n\n" + rest
+	}
+	return prompt, true
+}
+
+// preview shortens text to its first line and at most n characters
+func preview(text string, n int) string {
+	text, _, _ = strings.Cut(strings.TrimSpace(text), "\n")
+	if runes := []rune(text); len(runes) > n {
+		return string(runes[:n-1]) + "…"
+	}
+	return text
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "alias",
+		usage:   "!alias <name> <prompt> | !alias remove <name>",
+		handler: aliasCommand,
+	})
+	registerCommand(&command{
+		name:    "aliases",
+		usage:   "!aliases",
+		handler: aliasesCommand,
+	})
+
+	userDataCategories["aliases"] = userDataCategory{
+		description: "aliases defined in your direct chat",
+		count: func(bot *SignalBot, who AgentSender) int {
+			return len(bot.aliases.Keys(dmChatID(who)))
+		},
+		forget: func(bot *SignalBot, who AgentSender) error {
+			return bot.aliases.Clear(dmChatID(who))
+		},
+	}
+}
+
+// aliasCommand defines or removes one of the chat's aliases. In groups only
+// group admins and the bot owner may change them.
+func aliasCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	usage := "Usage: " + commands["alias"].usage
+	chatID := msg.chatID()
+	if chatID == "" {
+		return "Sorry, I can't tell which chat this is."
+	}
+	if len(args) < 2 {
+		return usage
+	}
+	if groupID := msg.extractGroupId(); groupID != "" && !bot.isAdmin(msg) && !bot.isGroupAdmin(ctx, groupID, msg.sender()) {
+		return bot.localizef(chatID, "Only group admins can change %s.", "aliases")
+	}
+
+	if strings.EqualFold(args[0], "remove") {
+		name := strings.ToLower(strings.TrimPrefix(args[1], "!"))
+		if bot.aliases.Get(chatID, name) == "" {
+			return fmt.Sprintf("This chat has no alias !%s.", name)
+		}
+		if err := bot.aliases.Set(chatID, name, ""); err != nil {
+			bot.logger.Printf("Error removing alias: %v", err)
+			return "Sorry, I couldn't remove that alias."
+		}
+		return fmt.Sprintf("Alias !%s removed.", name)
+	}
+
+	name := strings.ToLower(strings.TrimPrefix(args[0], "!"))
+	if !aliasNamePattern.MatchString(name) {
+		return "Alias names are one short word of letters, digits, - or _."
+	}
+	if _, isCommand := commands[name]; isCommand {
+		return fmt.Sprintf("!%s is already a command.", name)
+	}
+	if bot.aliases.Get(chatID, name) == "" && len(bot.aliases.Keys(chatID)) >= maxAliasesPerChat {
+		return fmt.Sprintf("This chat already has %d aliases. Remove one first.", maxAliasesPerChat)
+	}
+
+	prompt := commandText(msg.extractContent(), 2)
+	if err := bot.aliases.Set(chatID, name, prompt); err != nil {
+		bot.logger.Printf("Error saving alias: %v", err)
+		return "Sorry, I couldn't save that alias."
+	}
+	return fmt.Sprintf("Alias !%s saved. Send \"!%s\" to use it.", name, name)
+}
+
+// aliasesCommand lists the aliases available in the chat
+func aliasesCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	chatID := msg.chatID()
+	available := make(map[string]string, len(bot.operatorAliases))
+	for name, prompt := range bot.operatorAliases {
+		available[name] = prompt
+	}
+	if chatID != "" {
+		for _, name := range bot.aliases.Keys(chatID) {
+			available[name] = bot.aliases.Get(chatID, name)
+		}
+	}
+	if len(available) == 0 {
+		return "No aliases yet. Define one with \"!alias <name> <prompt>\"."
+	}
+
+	names := make([]string, 0, len(available))
+	for name := range available {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{"Aliases:"}
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("!%s → %s", name, preview(available[name], 80)))
+	}
+	return strings.Join(lines, "\n")
+}
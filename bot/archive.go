@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of archived entries
+const (
+	archiveKindMessage = "message" // a processed message, with its envelope
+	archiveKindPrompt  = "prompt"  // a prompt the bot answered
+	archiveKindReply   = "reply"   // the bot's answer to a prompt
+)
+
+// archivePruneInterval is how often retention is applied to the archive
+const archivePruneInterval = time.Hour
+
+// archiveEntry is one archived message, prompt or bot reply of a chat
+type archiveEntry struct {
+	ChatID     string          `json:"chat_id"`
+	Timestamp  int64           `json:"timestamp"` // Unix milliseconds
+	Kind       string          `json:"kind"`
+	Sender     string          `json:"sender,omitempty"` // number of the person who wrote it or asked
+	SenderUUID string          `json:"sender_uuid,omitempty"`
+	SenderName string          `json:"sender_name,omitempty"`
+	Text       string          `json:"text"`
+	Envelope   json.RawMessage `json:"envelope,omitempty"` // as received from signal-cli, for messages
+}
+
+// from reports whether who wrote (or, for replies, asked for) the entry
+func (e archiveEntry) from(who AgentSender) bool {
+	return (who.UUID != "" && e.SenderUUID == who.UUID) || (who.Number != "" && e.Sender == who.Number)
+}
+
+// messageArchive persists what the bot processes and says, for search,
+// exports and replay. Backends are selected with ARCHIVE_STORE.
+type messageArchive interface {
+	Open(ctx context.Context) error
+	Append(ctx context.Context, entry archiveEntry) error
+	// Entries returns a chat's entries at or after since, oldest first
+	Entries(ctx context.Context, chatID string, since time.Time) ([]archiveEntry, error)
+	// Prune drops entries older than cutoff returns for their chat (the
+	// zero time keeps them all) and returns how many were dropped
+	Prune(ctx context.Context, cutoff func(chatID string) time.Time) (int, error)
+	// Forget drops every entry match accepts and returns how many there were;
+	// with dryRun it only counts them
+	Forget(ctx context.Context, match func(archiveEntry) bool, dryRun bool) (int, error)
+	Close() error
+}
+
+// archiveStores maps ARCHIVE_STORE values to archive constructors. The JSONL
+// archive is always available; others register from build-tagged files.
+var archiveStores = map[string]func(bot *SignalBot) (messageArchive, error){
+	"jsonl": func(bot *SignalBot) (messageArchive, error) {
+		return &jsonlArchive{dir: filepath.Join(bot.config.DataDir, "archive")}, nil
+	},
+}
+
+// registerArchiveStore makes an archive backend selectable with ARCHIVE_STORE
+func registerArchiveStore(name string, open func(bot *SignalBot) (messageArchive, error)) {
+	archiveStores[name] = open
+}
+
+// openArchive creates and opens the archive selected by ARCHIVE_STORE, or
+// returns nil when archiving is off
+func (bot *SignalBot) openArchive(ctx context.Context) (messageArchive, error) {
+	if !bot.config.ArchiveEnabled {
+		return nil, nil
+	}
+	open, exists := archiveStores[bot.config.ArchiveStore]
+	if !exists {
+		names := make([]string, 0, len(archiveStores))
+		for name := range archiveStores {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown ARCHIVE_STORE %q (available in this build: %s)", bot.config.ArchiveStore, strings.Join(names, ", "))
+	}
+	archive, err := open(bot)
+	if err != nil {
+		return nil, err
+	}
+	if err := archive.Open(ctx); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// archives reports whether a chat's messages are archived: ARCHIVE_ENABLED
+// is set and the chat didn't turn it off with "!set archive off"
+func (bot *SignalBot) archives(chatID string) bool {
+	return bot.archive != nil && chatID != "" && bot.settings.Get(chatID, "archive") != "off"
+}
+
+// archiveRecord appends an entry when its chat is archived
+func (bot *SignalBot) archiveRecord(ctx context.Context, entry archiveEntry) {
+	if !bot.archives(entry.ChatID) {
+		return
+	}
+	if err := bot.archive.Append(ctx, entry); err != nil {
+		bot.logger.Printf("Error archiving %s of %s: %v", entry.Kind, entry.ChatID, err)
+	}
+}
+
+// archiveMessage records a processed message with its envelope
+func (bot *SignalBot) archiveMessage(ctx context.Context, msg *Message) {
+	content := msg.extractContent()
+	chatID := msg.chatID()
+	if content == "" || !bot.archives(chatID) {
+		return
+	}
+	envelope, err := json.Marshal(msg.Envelope)
+	if err != nil {
+		bot.logger.Printf("Error encoding envelope for the archive: %v", err)
+	}
+	sender := msg.sender()
+	bot.archiveRecord(ctx, archiveEntry{
+		ChatID:     chatID,
+		Timestamp:  msg.extractTimestamp(),
+		Kind:       archiveKindMessage,
+		Sender:     sender.Number,
+		SenderUUID: sender.UUID,
+		SenderName: sender.Name,
+		Text:       content,
+		Envelope:   envelope,
+	})
+}
+
+// archiveAnswer records a prompt the bot answered and its replies
+func (bot *SignalBot) archiveAnswer(ctx context.Context, request AgentRequest, replies []string) {
+	entry := archiveEntry{ChatID: requestChatID(request), Timestamp: request.Timestamp, Kind: archiveKindPrompt, Text: request.Prompt}
+	if entry.Timestamp == 0 {
+		entry.Timestamp = time.Now().UnixMilli()
+	}
+	if s := request.Sender; s != nil {
+		entry.Sender, entry.SenderUUID, entry.SenderName = s.Number, s.UUID, s.Name
+	}
+	bot.archiveRecord(ctx, entry)
+
+	entry.Kind = archiveKindReply
+	entry.Timestamp = time.Now().UnixMilli()
+	entry.Text = strings.Join(replies, "\n")
+	bot.archiveRecord(ctx, entry)
+}
+
+// archiveCutoff returns before when a chat's archived entries expire: its
+// "!set retention" if shorter than ARCHIVE_RETENTION, otherwise
+// ARCHIVE_RETENTION. The zero time keeps them all.
+func (bot *SignalBot) archiveCutoff(chatID string, now time.Time) time.Time {
+	retention := bot.config.ArchiveRetention
+	if value := bot.settings.Get(chatID, "retention"); value != "" {
+		if chat, err := parseRetention(value); err == nil && (retention <= 0 || chat < retention) {
+			retention = chat
+		}
+	}
+	if retention <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-retention)
+}
+
+// runArchivePruner applies archive retention every archivePruneInterval,
+// recording what it drops in the audit trail
+func (bot *SignalBot) runArchivePruner(ctx context.Context) error {
+	ticker := time.NewTicker(archivePruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			pruned, err := bot.archive.Prune(ctx, func(chatID string) time.Time { return bot.archiveCutoff(chatID, now) })
+			if err != nil {
+				bot.logger.Printf("Error pruning the archive: %v", err)
+			} else if pruned > 0 {
+				bot.logger.Printf("Pruned %d archived entries", pruned)
+				bot.audit(auditEntry{Event: auditPurge, Kind: "archive", Count: pruned})
+			}
+		}
+	}
+}
+
+// parseRetention parses a retention period like "30d", "12h" or "90m"
+func parseRetention(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("retention must look like 30d or 12h")
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("retention must look like 30d or 12h")
+	}
+	return d, nil
+}
+
+func init() {
+	chatSettingDefs["archive"] = chatSetting{
+		description: "on/off: keep this chat's messages and my replies in the archive (when ARCHIVE_ENABLED)",
+		groupAdmin:  true,
+		normalize: func(value string) (string, error) {
+			switch strings.ToLower(value) {
+			case "on":
+				return "", nil
+			case "off":
+				return "off", nil
+			}
+			return "", fmt.Errorf("archive must be on or off")
+		},
+	}
+	chatSettingDefs["retention"] = chatSetting{
+		description: "how long this chat's archive is kept, e.g. 30d (at most ARCHIVE_RETENTION)",
+		groupAdmin:  true,
+		normalize: func(value string) (string, error) {
+			if _, err := parseRetention(value); err != nil {
+				return "", err
+			}
+			return value, nil
+		},
+	}
+
+	chatSettingDefs["history_retention"] = chatSetting{
+		description: "how long this chat's conversation history is kept, e.g. 7d (at most AGENT_HISTORY_MAX_AGE)",
+		groupAdmin:  true,
+		normalize: func(value string) (string, error) {
+			if _, err := parseRetention(value); err != nil {
+				return "", err
+			}
+			return value, nil
+		},
+	}
+
+	userDataCategories["archive"] = userDataCategory{
+		description: "archived messages you wrote and answers to your prompts",
+		count: func(bot *SignalBot, who AgentSender) int {
+			if bot.archive == nil {
+				return 0
+			}
+			n, err := bot.archive.Forget(context.Background(), func(e archiveEntry) bool { return e.from(who) }, true)
+			if err != nil {
+				bot.logger.Printf("Error counting archived entries: %v", err)
+			}
+			return n
+		},
+		forget: func(bot *SignalBot, who AgentSender) error {
+			if bot.archive == nil {
+				return nil
+			}
+			_, err := bot.archive.Forget(context.Background(), func(e archiveEntry) bool { return e.from(who) }, false)
+			return err
+		},
+	}
+}
+
+// memoryArchive keeps entries in memory only, for replays
+type memoryArchive struct {
+	mu      sync.Mutex
+	entries []archiveEntry
+}
+
+// Open implements messageArchive
+func (a *memoryArchive) Open(ctx context.Context) error {
+	return nil
+}
+
+// Append implements messageArchive
+func (a *memoryArchive) Append(ctx context.Context, entry archiveEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, entry)
+	return nil
+}
+
+// Entries implements messageArchive
+func (a *memoryArchive) Entries(ctx context.Context, chatID string, since time.Time) ([]archiveEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var entries []archiveEntry
+	for _, entry := range a.entries {
+		if entry.ChatID == chatID && entry.Timestamp >= since.UnixMilli() {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Prune implements messageArchive
+func (a *memoryArchive) Prune(ctx context.Context, cutoff func(chatID string) time.Time) (int, error) {
+	return a.Forget(ctx, func(entry archiveEntry) bool {
+		t := cutoff(entry.ChatID)
+		return !t.IsZero() && entry.Timestamp < t.UnixMilli()
+	}, false)
+}
+
+// Forget implements messageArchive
+func (a *memoryArchive) Forget(ctx context.Context, match func(archiveEntry) bool, dryRun bool) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	kept, n := a.entries[:0:0], 0
+	for _, entry := range a.entries {
+		if match(entry) {
+			n++
+		} else {
+			kept = append(kept, entry)
+		}
+	}
+	if !dryRun {
+		a.entries = kept
+	}
+	return n, nil
+}
+
+// Close implements messageArchive
+func (a *memoryArchive) Close() error {
+	return nil
+}
+
+// jsonlArchive writes entries to one JSON Lines file per day in dir, so old
+// days can be dropped as files. Reads scan the files a query covers.
+type jsonlArchive struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// Open implements messageArchive
+func (a *jsonlArchive) Open(ctx context.Context) error {
+	return os.MkdirAll(a.dir, 0o700)
+}
+
+// dayFile is the file entries of a day go to
+func (a *jsonlArchive) dayFile(t time.Time) string {
+	return filepath.Join(a.dir, t.UTC().Format("2006-01-02")+".jsonl")
+}
+
+// Append implements messageArchive
+func (a *jsonlArchive) Append(ctx context.Context, entry archiveEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, err := os.OpenFile(a.dayFile(time.UnixMilli(entry.Timestamp)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// files returns the archive's day files from since on, oldest first
+func (a *jsonlArchive) files(since time.Time) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(a.dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	first := filepath.Base(a.dayFile(since))
+	kept := paths[:0]
+	for _, path := range paths {
+		if since.IsZero() || filepath.Base(path) >= first {
+			kept = append(kept, path)
+		}
+	}
+	return kept, nil
+}
+
+// readArchiveFile decodes a day file; lines that don't parse are skipped
+func readArchiveFile(path string) ([]archiveEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []archiveEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var entry archiveEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Entries implements messageArchive
+func (a *jsonlArchive) Entries(ctx context.Context, chatID string, since time.Time) ([]archiveEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	paths, err := a.files(since)
+	if err != nil {
+		return nil, err
+	}
+	var entries []archiveEntry
+	for _, path := range paths {
+		day, err := readArchiveFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range day {
+			if entry.ChatID == chatID && entry.Timestamp >= since.UnixMilli() {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp < entries[j].Timestamp })
+	return entries, nil
+}
+
+// rewrite keeps the entries of every day file that keep accepts, deleting
+// files left empty, and returns how many entries were dropped. With dryRun
+// nothing is changed.
+func (a *jsonlArchive) rewrite(keep func(archiveEntry) bool, dryRun bool) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	paths, err := a.files(time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	dropped := 0
+	for _, path := range paths {
+		entries, err := readArchiveFile(path)
+		if err != nil {
+			return dropped, err
+		}
+		var kept []byte
+		n := 0
+		for _, entry := range entries {
+			if !keep(entry) {
+				n++
+				continue
+			}
+			line, err := json.Marshal(entry)
+			if err != nil {
+				return dropped, err
+			}
+			kept = append(append(kept, line...), '\n')
+		}
+		dropped += n
+		switch {
+		case dryRun || n == 0:
+		case len(kept) == 0:
+			if err := os.Remove(path); err != nil {
+				return dropped, err
+			}
+		default:
+			if err := writeFileAtomic(path, kept); err != nil {
+				return dropped, err
+			}
+		}
+	}
+	return dropped, nil
+}
+
+// Prune implements messageArchive
+func (a *jsonlArchive) Prune(ctx context.Context, cutoff func(chatID string) time.Time) (int, error) {
+	cutoffs := make(map[string]time.Time)
+	return a.rewrite(func(e archiveEntry) bool {
+		before, known := cutoffs[e.ChatID]
+		if !known {
+			before = cutoff(e.ChatID)
+			cutoffs[e.ChatID] = before
+		}
+		return before.IsZero() || e.Timestamp >= before.UnixMilli()
+	}, false)
+}
+
+// Forget implements messageArchive
+func (a *jsonlArchive) Forget(ctx context.Context, match func(archiveEntry) bool, dryRun bool) (int, error) {
+	return a.rewrite(func(e archiveEntry) bool { return !match(e) }, dryRun)
+}
+
+// Close implements messageArchive
+func (a *jsonlArchive) Close() error {
+	return nil
+}
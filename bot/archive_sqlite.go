@@ -0,0 +1,177 @@
+//go:build archive
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	registerArchiveStore("sqlite", func(bot *SignalBot) (messageArchive, error) {
+		return &sqliteArchive{path: filepath.Join(bot.config.DataDir, "archive.db")}, nil
+	})
+}
+
+// sqliteArchive stores archived entries in one SQLite table indexed by chat
+// and time
+type sqliteArchive struct {
+	path string
+	db   *sql.DB
+}
+
+// Open implements messageArchive, creating the schema
+func (a *sqliteArchive) Open(ctx context.Context) error {
+	db, err := sql.Open("sqlite", a.path)
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(1)
+	a.db = db
+
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS entries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id TEXT NOT NULL,
+			timestamp INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			sender TEXT NOT NULL DEFAULT '',
+			sender_uuid TEXT NOT NULL DEFAULT '',
+			sender_name TEXT NOT NULL DEFAULT '',
+			text TEXT NOT NULL,
+			envelope TEXT
+		);
+		CREATE INDEX IF NOT EXISTS entries_chat ON entries (chat_id, timestamp);
+	`); err != nil {
+		return fmt.Errorf("failed to create archive schema: %w", err)
+	}
+	return nil
+}
+
+// Append implements messageArchive
+func (a *sqliteArchive) Append(ctx context.Context, e archiveEntry) error {
+	var envelope any
+	if len(e.Envelope) > 0 {
+		envelope = string(e.Envelope)
+	}
+	_, err := a.db.ExecContext(ctx,
+		`INSERT INTO entries (chat_id, timestamp, kind, sender, sender_uuid, sender_name, text, envelope) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ChatID, e.Timestamp, e.Kind, e.Sender, e.SenderUUID, e.SenderName, e.Text, envelope)
+	return err
+}
+
+// scan reads the entries a query returned
+func (a *sqliteArchive) scan(rows *sql.Rows) ([]archiveEntry, []int64, error) {
+	defer rows.Close()
+
+	var entries []archiveEntry
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var e archiveEntry
+		var envelope sql.NullString
+		if err := rows.Scan(&id, &e.ChatID, &e.Timestamp, &e.Kind, &e.Sender, &e.SenderUUID, &e.SenderName, &e.Text, &envelope); err != nil {
+			return nil, nil, err
+		}
+		if envelope.Valid {
+			e.Envelope = json.RawMessage(envelope.String)
+		}
+		entries = append(entries, e)
+		ids = append(ids, id)
+	}
+	return entries, ids, rows.Err()
+}
+
+// Entries implements messageArchive
+func (a *sqliteArchive) Entries(ctx context.Context, chatID string, since time.Time) ([]archiveEntry, error) {
+	var from int64
+	if !since.IsZero() {
+		from = since.UnixMilli()
+	}
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT id, chat_id, timestamp, kind, sender, sender_uuid, sender_name, text, envelope FROM entries WHERE chat_id = ? AND timestamp >= ? ORDER BY timestamp, id`,
+		chatID, from)
+	if err != nil {
+		return nil, err
+	}
+	entries, _, err := a.scan(rows)
+	return entries, err
+}
+
+// Prune implements messageArchive
+func (a *sqliteArchive) Prune(ctx context.Context, cutoff func(chatID string) time.Time) (int, error) {
+	rows, err := a.db.QueryContext(ctx, `SELECT DISTINCT chat_id FROM entries`)
+	if err != nil {
+		return 0, err
+	}
+	var chats []string
+	for rows.Next() {
+		var chatID string
+		if err := rows.Scan(&chatID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		chats = append(chats, chatID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, chatID := range chats {
+		before := cutoff(chatID)
+		if before.IsZero() {
+			continue
+		}
+		result, err := a.db.ExecContext(ctx, `DELETE FROM entries WHERE chat_id = ? AND timestamp < ?`, chatID, before.UnixMilli())
+		if err != nil {
+			return pruned, err
+		}
+		n, _ := result.RowsAffected()
+		pruned += int(n)
+	}
+	return pruned, nil
+}
+
+// Forget implements messageArchive. Entries are matched in Go so any
+// predicate works; the archive of a personal bot is small enough to scan.
+func (a *sqliteArchive) Forget(ctx context.Context, match func(archiveEntry) bool, dryRun bool) (int, error) {
+	rows, err := a.db.QueryContext(ctx, `SELECT id, chat_id, timestamp, kind, sender, sender_uuid, sender_name, text, NULL FROM entries`)
+	if err != nil {
+		return 0, err
+	}
+	entries, ids, err := a.scan(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	forgotten := 0
+	for i, e := range entries {
+		if !match(e) {
+			continue
+		}
+		forgotten++
+		if dryRun {
+			continue
+		}
+		if _, err := a.db.ExecContext(ctx, `DELETE FROM entries WHERE id = ?`, ids[i]); err != nil {
+			return forgotten, err
+		}
+	}
+	return forgotten, nil
+}
+
+// Close implements messageArchive
+func (a *sqliteArchive) Close() error {
+	if a.db == nil {
+		return nil
+	}
+	return a.db.Close()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AttachmentRecord is an attachment shared in a chat, newest last
+type AttachmentRecord struct {
+	Attachment
+	Sender     string    `json:"sender"`
+	SenderUUID string    `json:"sender_uuid,omitempty"`
+	SenderName string    `json:"sender_name,omitempty"`
+	SharedAt   time.Time `json:"shared_at"`
+}
+
+// from reports whether who shared the attachment
+func (r AttachmentRecord) from(who AgentSender) bool {
+	return (who.UUID != "" && r.SenderUUID == who.UUID) || (who.Number != "" && r.Sender == who.Number)
+}
+
+// attachmentLog keeps the most recent attachments of each chat, persisted
+// as JSON so !files survives restarts
+type attachmentLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int
+	chats   map[string][]AttachmentRecord // chat ID -> records, oldest first
+}
+
+// newAttachmentLog creates a log keeping up to maxSize records per chat
+func newAttachmentLog(path string, maxSize int) *attachmentLog {
+	return &attachmentLog{path: path, maxSize: maxSize, chats: make(map[string][]AttachmentRecord)}
+}
+
+// Load reads the log file; a missing file means an empty log
+func (l *attachmentLog) Load() error {
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	chats := make(map[string][]AttachmentRecord)
+	if err := json.Unmarshal(data, &chats); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", l.path, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.chats = chats
+	return nil
+}
+
+// Add appends records for a chat, trimming the oldest, and persists the log
+func (l *attachmentLog) Add(chatID string, records ...AttachmentRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := append(l.chats[chatID], records...)
+	if l.maxSize > 0 && len(list) > l.maxSize {
+		list = list[len(list)-l.maxSize:]
+	}
+	l.chats[chatID] = list
+	return l.save()
+}
+
+// CountBySender returns the number of records shared by who across chats
+func (l *attachmentLog) CountBySender(who AgentSender) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	count := 0
+	for _, list := range l.chats {
+		for _, record := range list {
+			if record.from(who) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// ForgetSender removes every record shared by who and persists the log
+func (l *attachmentLog) ForgetSender(who AgentSender) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for chatID, list := range l.chats {
+		kept := list[:0]
+		for _, record := range list {
+			if !record.from(who) {
+				kept = append(kept, record)
+			}
+		}
+		if len(kept) == 0 {
+			delete(l.chats, chatID)
+		} else {
+			l.chats[chatID] = kept
+		}
+	}
+	return l.save()
+}
+
+// save writes the log file; callers must hold l.mu
+func (l *attachmentLog) save() error {
+	data, err := json.MarshalIndent(l.chats, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(l.path, data)
+}
+
+// Recent returns up to n records for a chat, newest first
+func (l *attachmentLog) Recent(chatID string, n int) []AttachmentRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := l.chats[chatID]
+	var recent []AttachmentRecord
+	for i := len(list) - 1; i >= 0 && len(recent) < n; i-- {
+		recent = append(recent, list[i])
+	}
+	return recent
+}
+
+// recordAttachments adds the attachments of msg to its chat's log
+func (bot *SignalBot) recordAttachments(msg *Message) {
+	attachments := msg.extractAttachments()
+	chatID := msg.chatID()
+	if len(attachments) == 0 || chatID == "" {
+		return
+	}
+
+	sender := msg.sender()
+	sharedAt := time.UnixMilli(msg.extractTimestamp())
+	records := make([]AttachmentRecord, 0, len(attachments))
+	for _, a := range attachments {
+		records = append(records, AttachmentRecord{Attachment: a, Sender: sender.Number, SenderUUID: sender.UUID, SenderName: sender.Name, SharedAt: sharedAt})
+	}
+
+	if err := bot.attachments.Add(chatID, records...); err != nil {
+		bot.logger.Printf("Error recording attachments: %v", err)
+	}
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "files",
+		usage:   "!files [N] | !files get <number>",
+		handler: filesCommand,
+	})
+}
+
+// filesCommand lists or re-sends recently shared attachments in this chat
+func filesCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	if !bot.config.AttachmentsEnabled {
+		return "Attachment tracking isn't enabled on this bot."
+	}
+
+	chatID := msg.chatID()
+	if chatID == "" {
+		return "Sorry, I can't tell which chat this is."
+	}
+
+	if len(args) >= 1 && strings.EqualFold(args[0], "get") {
+		if len(args) < 2 {
+			return "Usage: " + commands["files"].usage
+		}
+		index, err := strconv.Atoi(args[1])
+		recent := bot.attachments.Recent(chatID, index)
+		if err != nil || index < 1 || index > len(recent) {
+			return "No such file. Use !files to list them."
+		}
+		record := recent[index-1]
+		path := filepath.Join(bot.config.AttachmentsDir, record.ID)
+		if _, err := os.Stat(path); err != nil {
+			return "Sorry, that file is no longer available."
+		}
+		if err := bot.sendAttachment(msg.replyRecipient(), path, record.displayName()); err != nil {
+			bot.logger.Printf("Error re-sending attachment: %v", err)
+			return "Sorry, I couldn't send that file."
+		}
+		return ""
+	}
+
+	n := 10
+	if len(args) >= 1 {
+		if parsed, err := strconv.Atoi(args[0]); err == nil && parsed > 0 {
+			n = parsed
+		}
+	}
+
+	recent := bot.attachments.Recent(chatID, n)
+	if len(recent) == 0 {
+		return "No files shared here yet."
+	}
+	sender := msg.sender()
+	loc := bot.userLocation(chatID, &sender)
+	lines := []string{"Recent files:"}
+	for i, record := range recent {
+		sender := record.SenderName
+		if sender == "" {
+			sender = record.Sender
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s (%s) from %s on %s", i+1, record.displayName(), record.ContentType, sender, record.SharedAt.In(loc).Format("2 Jan 15:04")))
+	}
+	lines = append(lines, "Send \"!files get <number>\" to get one again.")
+	return strings.Join(lines, "\n")
+}
+
+// displayName is the filename, or the attachment ID if it had none
+func (r AttachmentRecord) displayName() string {
+	if r.Filename != "" {
+		return r.Filename
+	}
+	return r.ID
+}
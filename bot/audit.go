@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audit trail events
+const (
+	auditPurge  = "purge"  // retention dropped expired history or archive entries
+	auditForget = "forget" // someone deleted their data with !mydata or !forgetme
+)
+
+// auditTopN is how many entries "!admin audit" shows by default
+const auditTopN = 10
+
+// auditEntry is one line of the audit trail
+type auditEntry struct {
+	Time    string `json:"time"` // RFC 3339, UTC
+	Event   string `json:"event"`
+	Kind    string `json:"kind"`           // what was deleted: history, archive or !mydata categories
+	ChatID  string `json:"chat,omitempty"` // chat the data belonged to
+	Subject string `json:"subject,omitempty"`
+	Count   int    `json:"count,omitempty"`
+}
+
+// auditTrail appends data deletions to the JSON Lines file at AUDIT_LOG,
+// so what the bot forgot and when can be shown later
+type auditTrail struct {
+	mu   sync.Mutex
+	path string // "" = no audit trail
+}
+
+// newAuditTrail writes to AUDIT_LOG, by default audit.jsonl in DATA_DIR
+func newAuditTrail(config Config) *auditTrail {
+	switch config.AuditLog {
+	case "off":
+		return &auditTrail{}
+	case "":
+		return &auditTrail{path: filepath.Join(config.DataDir, "audit.jsonl")}
+	default:
+		return &auditTrail{path: config.AuditLog}
+	}
+}
+
+// Record appends entry, stamped with the current time
+func (a *auditTrail) Record(entry auditEntry) error {
+	if a.path == "" {
+		return nil
+	}
+	entry.Time = time.Now().UTC().Format(time.RFC3339)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Recent returns the last n entries, oldest first
+func (a *auditTrail) Recent(n int) ([]auditEntry, error) {
+	if a.path == "" {
+		return nil, nil
+	}
+	a.mu.Lock()
+	data, err := os.ReadFile(a.path)
+	a.mu.Unlock()
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	var entries []auditEntry
+	for _, line := range lines {
+		var entry auditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// audit records entry in the audit trail, logging failures
+func (bot *SignalBot) audit(entry auditEntry) {
+	if err := bot.auditLog.Record(entry); err != nil {
+		bot.logger.Printf("Error writing the audit trail: %v", err)
+	}
+}
+
+// auditCommand shows the latest audit trail entries; it backs
+// "!admin audit [<count>]"
+func auditCommand(bot *SignalBot, args []string) string {
+	if bot.auditLog.path == "" {
+		return "The audit trail is off (AUDIT_LOG=off)."
+	}
+	n := auditTopN
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n <= 0 {
+			return "Usage: !admin audit [<count>]"
+		}
+	}
+
+	entries, err := bot.auditLog.Recent(n)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	if len(entries) == 0 {
+		return "The audit trail is empty."
+	}
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		line := fmt.Sprintf("%s %s %s", e.Time, e.Event, e.Kind)
+		if e.ChatID != "" {
+			line += " in " + e.ChatID
+		}
+		if e.Subject != "" {
+			line += " of " + e.Subject
+		}
+		if e.Count > 0 {
+			line += fmt.Sprintf(": %d", e.Count)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// autoBlocker blocks unknown senders who keep sending commands and prompts
+// to a default-deny bot after being refused ACCESS_AUTO_BLOCK times. Refusal counts live in
+// memory; the senders it blocked are persisted so admins can list and
+// unblock them.
+type autoBlocker struct {
+	mu      sync.Mutex
+	after   int            // 0 = never block
+	refused map[string]int // sender -> refused commands and prompts
+	blocked *chatSettings  // sender -> "blocked", the time it was blocked
+}
+
+func newAutoBlocker(config Config) *autoBlocker {
+	return &autoBlocker{
+		after:   config.AccessAutoBlock,
+		refused: make(map[string]int),
+		blocked: newChatSettings("blocked_senders"),
+	}
+}
+
+// Refused counts a refused message from sender, reporting whether the
+// sender should now be blocked
+func (b *autoBlocker) Refused(sender string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.after <= 0 {
+		return false
+	}
+	b.refused[sender]++
+	if b.refused[sender] < b.after {
+		return false
+	}
+	delete(b.refused, sender)
+	return true
+}
+
+// Blocked lists the senders blocked automatically, with when
+func (b *autoBlocker) Blocked() []string {
+	ids := b.blocked.IDsWith("blocked")
+	sort.Strings(ids)
+	for i, id := range ids {
+		ids[i] = fmt.Sprintf("%s (since %s)", id, b.blocked.Get(id, "blocked"))
+	}
+	return ids
+}
+
+// blockSender blocks sender in signal-cli, so its messages are no longer
+// received, and records it
+func (bot *SignalBot) blockSender(sender string) error {
+	if err := bot.runBlock("block", sender); err != nil {
+		return err
+	}
+	return bot.autoBlocks.blocked.Set(sender, "blocked", time.Now().UTC().Format(time.RFC3339))
+}
+
+// runBlock runs signal-cli block or unblock for id
+func (bot *SignalBot) runBlock(action, id string) error {
+	if bot.config.DryRun {
+		bot.logger.Printf("[dry run] signal-cli %s %s", action, id)
+		return nil
+	}
+	var stderr bytes.Buffer
+	if err := runSignalCLI(context.Background(), bot.config.SignalSendTimeout, nil, &stderr, action, id); err != nil {
+		return fmt.Errorf("signal-cli %s %s failed: %w (stderr: %s)", action, id, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// autoBlock counts a refused command or prompt from an unauthorized sender
+// and, when the bot denies strangers by default and the sender has been
+// refused ACCESS_AUTO_BLOCK times, blocks them and tells the admin. Plain
+// messages never count: they are contacts chatting with the owner. Senders
+// on ALLOW_SENDERS, refused only in a denied group, are never blocked. It
+// reports whether the sender was blocked.
+func (bot *SignalBot) autoBlock(msg *Message) bool {
+	access := bot.live.Load().access
+	sender := msg.sender()
+	if !access.defaultDeny || access.allowSenders[sender.Number] || access.allowSenders[sender.UUID] {
+		return false
+	}
+	if !bot.addressesBot(msg.extractContent()) {
+		return false
+	}
+	id := quotaID(&sender)
+	if !bot.autoBlocks.Refused(id) {
+		return false
+	}
+	if err := bot.blockSender(id); err != nil {
+		bot.logger.Printf("Error blocking %s: %v", id, err)
+		return false
+	}
+	bot.logger.Printf("Blocked %s after %d refused commands and prompts", id, bot.config.AccessAutoBlock)
+	bot.notifyAdmin(adminEventBlock, fmt.Sprintf("Blocked %s after %d refused commands and prompts. Undo it with !admin unblock %s",
+		id, bot.config.AccessAutoBlock, id))
+	return true
+}
+
+// unblockCommand lists the senders blocked automatically or unblocks one;
+// it backs "!admin unblock [<number|uuid>]"
+func unblockCommand(bot *SignalBot, args []string) string {
+	if len(args) == 0 {
+		blocked := bot.autoBlocks.Blocked()
+		if len(blocked) == 0 {
+			return "No one was blocked automatically."
+		}
+		return "Blocked automatically: " + strings.Join(blocked, ", ")
+	}
+
+	if err := bot.runBlock("unblock", args[0]); err != nil {
+		return "Error: " + err.Error()
+	}
+	if err := bot.autoBlocks.blocked.Set(args[0], "blocked", ""); err != nil {
+		return "Error: " + err.Error()
+	}
+	bot.logger.Printf("Unblocked %s", args[0])
+	return args[0] + " is unblocked. Add them to ALLOW_SENDERS to let them use the bot."
+}
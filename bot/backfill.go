@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backfillSkip says why a message missed during downtime is not processed,
+// or is "" when it is
+func (bot *SignalBot) backfillSkip(msg *Message, now time.Time) string {
+	if !bot.config.BackfillEnabled {
+		return "backfill disabled"
+	}
+	sent := time.UnixMilli(msg.Envelope.Timestamp)
+	if maxAge := bot.config.BackfillMaxAge; maxAge > 0 && now.Sub(sent) > maxAge {
+		return "older than " + maxAge.String()
+	}
+	if chats := bot.config.BackfillChats; len(chats) > 0 && !contains(chats, msg.chatID()) {
+		return "chat not in BACKFILL_CHATS"
+	}
+	return ""
+}
+
+// filterBackfill drops the messages sent before the bot started that
+// backfill settings exclude, logging what it kept and skipped. signal-cli
+// delivers messages that arrived while the bot was down on the first polls.
+// Receipts and other messages without a text of their own always pass.
+func (bot *SignalBot) filterBackfill(messages []Message) []Message {
+	now := time.Now()
+	kept := messages[:0]
+	backfilled := 0
+	skipped := make(map[string]int)
+	for _, msg := range messages {
+		if msg.dedupKey() == "" || msg.Envelope.Timestamp >= bot.startedAt.UnixMilli() {
+			kept = append(kept, msg)
+			continue
+		}
+		if reason := bot.backfillSkip(&msg, now); reason != "" {
+			skipped[reason]++
+			continue
+		}
+		kept = append(kept, msg)
+		backfilled++
+	}
+
+	if backfilled > 0 || len(skipped) > 0 {
+		total := 0
+		var reasons []string
+		for reason, n := range skipped {
+			total += n
+			reasons = append(reasons, fmt.Sprintf("%s: %d", reason, n))
+		}
+		sort.Strings(reasons)
+		summary := ""
+		if total > 0 {
+			summary = " (" + strings.Join(reasons, ", ") + ")"
+		}
+		bot.logger.Printf("Backfill: processing %d messages missed while down, skipped %d%s", backfilled, total, summary)
+	}
+	return kept
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned when the agent circuit breaker rejects a call
+var errCircuitOpen = errors.New("agent circuit breaker is open")
+
+// agentStatusError reports a non-200 response from the agent
+type agentStatusError struct {
+	StatusCode int
+}
+
+func (e *agentStatusError) Error() string {
+	return fmt.Sprintf("agent returned status %d", e.StatusCode)
+}
+
+// isRetryable reports whether an agent error is worth retrying: network
+// failures, timeouts, 429 and 5xx responses
+func isRetryable(err error) bool {
+	var statusErr *agentStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+
+	// Transport errors from http.Client.Do are wrapped in *url.Error,
+	// which implements net.Error; marshal/decode errors are permanent
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// backoffDelay returns the delay before the given retry attempt (1-based),
+// doubling the base each time and applying equal jitter
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	d := base << (attempt - 1)
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker stops calling the agent after a run of consecutive
+// failures, then lets a single trial call through once the cooldown expires
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	trial     bool // a half-open trial call is in flight
+}
+
+// newCircuitBreaker creates a closed circuit breaker
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     breakerClosed,
+	}
+}
+
+// Allow reports whether a call may proceed
+func (cb *circuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = breakerHalfOpen
+		cb.trial = true
+		return true
+	case breakerHalfOpen:
+		if cb.trial {
+			return false
+		}
+		cb.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Success records a successful call and closes the breaker, reporting
+// whether it was open before
+func (cb *circuitBreaker) Success() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	reopened := cb.state != breakerClosed
+	cb.state = breakerClosed
+	cb.failures = 0
+	cb.trial = false
+	return reopened
+}
+
+// Failure records a failed call, opening the breaker once the threshold
+// is reached or when a half-open trial fails. It reports whether a closed
+// breaker just opened.
+func (cb *circuitBreaker) Failure() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	cb.trial = false
+	opened := false
+	if cb.state == breakerHalfOpen || cb.failures >= cb.threshold {
+		opened = cb.state == breakerClosed
+		cb.state = breakerOpen
+		cb.openedAt = time.Now()
+	}
+	return opened
+}
+
+// Trip forces the breaker open, e.g. when a health probe fails
+func (cb *circuitBreaker) Trip() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.state = breakerOpen
+	cb.openedAt = time.Now()
+	cb.trial = false
+}
+
+// State returns the current breaker state
+func (cb *circuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
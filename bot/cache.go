@@ -0,0 +1,136 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// responseCache is an LRU cache with TTL of agent answers to identical
+// prompts, so several group members sending the same "qq" in quick
+// succession cost a single agent call
+type responseCache struct {
+	mu      sync.Mutex
+	maxSize int
+	ttl     time.Duration
+	order   *list.List // front = most recently used
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	answer  agentAnswer
+	expires time.Time
+}
+
+// newResponseCache creates a cache; a zero size or TTL disables it
+func newResponseCache(maxSize int, ttl time.Duration) *responseCache {
+	return &responseCache{
+		maxSize: maxSize,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// enabled reports whether the cache stores anything
+func (c *responseCache) enabled() bool {
+	return c.maxSize > 0 && c.ttl > 0
+}
+
+// Get returns a cached, unexpired answer for key
+func (c *responseCache) Get(key string) (agentAnswer, bool) {
+	if !c.enabled() {
+		return agentAnswer{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.entries[key]
+	if !exists {
+		return agentAnswer{}, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return agentAnswer{}, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.answer, true
+}
+
+// Put stores an answer, evicting the least recently used entry when full
+func (c *responseCache) Put(key string, answer agentAnswer) {
+	if !c.enabled() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, exists := c.entries[key]; exists {
+		c.order.Remove(elem)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, answer: answer, expires: time.Now().Add(c.ttl)})
+
+	for c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Len returns the number of cached answers
+func (c *responseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// normalizePrompt lowercases a prompt, collapses whitespace and drops
+// trailing punctuation so trivially different spellings share a cache entry
+func normalizePrompt(prompt string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(prompt)), " ")
+	return strings.TrimRightFunc(normalized, unicode.IsPunct)
+}
+
+// cacheKey identifies a request for caching and coalescing: the normalized
+// prompt plus everything that shapes the answer besides the conversation,
+// its history and the asker, including the asker's role, retrieved
+// documents and attached images
+func cacheKey(request AgentRequest, userPrompt string) string {
+	persona := ""
+	if request.Persona != nil {
+		persona = request.Persona.Name
+	}
+	params := ""
+	if p := request.Parameters; p != nil {
+		if p.Temperature != nil {
+			params += fmt.Sprintf("t=%g;", *p.Temperature)
+		}
+		if p.MaxTokens != nil {
+			params += fmt.Sprintf("m=%d;", *p.MaxTokens)
+		}
+	}
+	docs := ""
+	if len(request.Documents) > 0 {
+		h := fnv.New64a()
+		for _, doc := range request.Documents {
+			h.Write([]byte(doc.Source + "\x00" + doc.Text + "\x00"))
+		}
+		docs = fmt.Sprintf("%x", h.Sum64())
+	}
+	for _, image := range request.Images {
+		h := fnv.New64a()
+		h.Write([]byte(image.Data))
+		docs += fmt.Sprintf(";%x", h.Sum64())
+	}
+	return strings.Join([]string{request.role, persona, request.Model, params, docs, request.Language, request.Timezone,
+		request.SystemPrompt, normalizePrompt(userPrompt)}, "\x00")
+}
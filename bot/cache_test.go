@@ -0,0 +1,170 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheKey(t *testing.T) {
+	base := AgentRequest{
+		Prompt:         "qq what's the capital of France?",
+		Sender:         &AgentSender{Number: "+15550001", Name: "Ana"},
+		ConversationID: "group:abc:+15550001",
+		History:        []AgentTurn{{Role: "user", Content: "hi", Timestamp: 1}},
+		Persona:        &AgentPersona{Name: "pirate"},
+		Model:          "small",
+		role:           roleUser,
+	}
+	temperature := 0.2
+
+	tests := []struct {
+		name       string
+		change     func(r *AgentRequest)
+		userPrompt string
+		wantShared bool
+	}{
+		{
+			name: "another group member asking",
+			change: func(r *AgentRequest) {
+				r.Sender = &AgentSender{UUID: "u-2", Name: "Bo"}
+				r.ConversationID = "group:abc:u-2"
+			},
+			userPrompt: "what's the capital of France?",
+			wantShared: true,
+		},
+		{
+			name: "another history",
+			change: func(r *AgentRequest) {
+				r.History = append(r.History, AgentTurn{Role: "assistant", Content: "hello", Timestamp: 2})
+			},
+			userPrompt: "what's the capital of France?",
+			wantShared: true,
+		},
+		{
+			name:       "case, spacing and trailing punctuation",
+			change:     func(r *AgentRequest) {},
+			userPrompt: "  What's the   capital of france?!",
+			wantShared: true,
+		},
+		{
+			name:       "another chat",
+			change:     func(r *AgentRequest) { r.ConversationID = "dm:+15550002" },
+			userPrompt: "what's the capital of France?",
+			wantShared: true,
+		},
+		{name: "another prompt", change: func(r *AgentRequest) {}, userPrompt: "what's the capital of Spain?"},
+		{name: "another persona", change: func(r *AgentRequest) { r.Persona = nil }, userPrompt: "what's the capital of France?"},
+		{name: "another model", change: func(r *AgentRequest) { r.Model = "large" }, userPrompt: "what's the capital of France?"},
+		{
+			name:       "other parameters",
+			change:     func(r *AgentRequest) { r.Parameters = &AgentParameters{Temperature: &temperature} },
+			userPrompt: "what's the capital of France?",
+		},
+		{name: "another system prompt", change: func(r *AgentRequest) { r.SystemPrompt = "Be brief." }, userPrompt: "what's the capital of France?"},
+		{name: "another language", change: func(r *AgentRequest) { r.Language = "pt" }, userPrompt: "what's the capital of France?"},
+		{name: "another role", change: func(r *AgentRequest) { r.role = roleAdmin }, userPrompt: "what's the capital of France?"},
+		{
+			name:       "retrieved documents",
+			change:     func(r *AgentRequest) { r.Documents = []AgentDocument{{Source: "notes", Text: "Paris"}} },
+			userPrompt: "what's the capital of France?",
+		},
+		{
+			name:       "an attached image",
+			change:     func(r *AgentRequest) { r.Images = []AgentImage{{Source: "map.png", Data: "aGk="}} },
+			userPrompt: "what's the capital of France?",
+		},
+	}
+
+	want := cacheKey(base, "what's the capital of France?")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := base
+			tt.change(&request)
+			if shared := cacheKey(request, tt.userPrompt) == want; shared != tt.wantShared {
+				t.Errorf("cacheKey() shared = %t, want %t", shared, tt.wantShared)
+			}
+		})
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	answer := func(text string) agentAnswer { return textAnswer(text) }
+
+	tests := []struct {
+		name    string
+		size    int
+		ttl     time.Duration
+		puts    []string
+		gets    []string // looked up in order after the puts
+		wantHit []bool
+		wantLen int
+	}{
+		{
+			name:    "hit and miss",
+			size:    2,
+			ttl:     time.Minute,
+			puts:    []string{"a"},
+			gets:    []string{"a", "b"},
+			wantHit: []bool{true, false},
+			wantLen: 1,
+		},
+		{
+			name:    "least recently put is evicted",
+			size:    2,
+			ttl:     time.Minute,
+			puts:    []string{"a", "b", "c"},
+			gets:    []string{"a", "b", "c"},
+			wantHit: []bool{false, true, true},
+			wantLen: 2,
+		},
+		{
+			name:    "putting a key again refreshes it",
+			size:    2,
+			ttl:     time.Minute,
+			puts:    []string{"a", "b", "a", "c"},
+			gets:    []string{"a", "b", "c"},
+			wantHit: []bool{true, false, true},
+			wantLen: 2,
+		},
+		{
+			name:    "expired answers are dropped",
+			size:    2,
+			ttl:     time.Nanosecond,
+			puts:    []string{"a"},
+			gets:    []string{"a"},
+			wantHit: []bool{false},
+			wantLen: 0,
+		},
+		{
+			name:    "zero size disables the cache",
+			size:    0,
+			ttl:     time.Minute,
+			puts:    []string{"a"},
+			gets:    []string{"a"},
+			wantHit: []bool{false},
+			wantLen: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newResponseCache(tt.size, tt.ttl)
+			for _, key := range tt.puts {
+				c.Put(key, answer(key))
+			}
+			time.Sleep(time.Millisecond)
+			for i, key := range tt.gets {
+				got, hit := c.Get(key)
+				if hit != tt.wantHit[i] {
+					t.Errorf("Get(%s) hit = %t, want %t", key, hit, tt.wantHit[i])
+				}
+				if hit && got.Replies[0] != key {
+					t.Errorf("Get(%s) = %v, want the answer put for it", key, got.Replies)
+				}
+			}
+			if c.Len() != tt.wantLen {
+				t.Errorf("Len() = %d, want %d", c.Len(), tt.wantLen)
+			}
+		})
+	}
+}
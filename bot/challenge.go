@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Side channels for proof-required challenges. While the account can't
+// send, a note to the owner through it only arrives once the challenge is
+// solved some other way.
+const (
+	challengeChannelOwner   = "owner"   // ADMIN_NOTIFY, queued until sends resume
+	challengeChannelAccount = "account" // ADMIN_NOTIFY, sent from CHALLENGE_ACCOUNT
+	challengeChannelWebhook = "webhook" // POSTed to CHALLENGE_WEBHOOK_URL, e.g. an email relay
+	challengeChannelLog     = "log"     // the log only
+)
+
+// validChallengeChannel reports whether channel is a CHALLENGE_CHANNEL value
+func validChallengeChannel(channel string) bool {
+	switch channel {
+	case challengeChannelOwner, challengeChannelAccount, challengeChannelWebhook, challengeChannelLog:
+		return true
+	}
+	return false
+}
+
+// deliverChallenge gets the instructions for solving a proof-required
+// challenge to the owner over CHALLENGE_CHANNEL. They are always logged.
+func (bot *SignalBot) deliverChallenge(text, token string) {
+	bot.logger.Printf("Captcha challenge: %s", text)
+
+	var err error
+	switch bot.config.ChallengeChannel {
+	case challengeChannelOwner:
+		bot.notifyAdmin(adminEventChallenge, text)
+	case challengeChannelAccount:
+		err = bot.sendFromChallengeAccount(text)
+	case challengeChannelWebhook:
+		if bot.switches.Disabled(switchWebhooks) {
+			bot.logger.Printf("Webhooks are switched off, not posting the captcha challenge")
+		} else {
+			err = bot.postChallenge(text, token)
+		}
+	}
+	if err != nil {
+		bot.logger.Printf("Error delivering the captcha challenge over %s: %v", bot.config.ChallengeChannel, err)
+	}
+}
+
+// sendFromChallengeAccount sends text to ADMIN_NOTIFY from the second
+// signal-cli account in CHALLENGE_ACCOUNT. Note to Self becomes a message
+// to the rate-limited account.
+func (bot *SignalBot) sendFromChallengeAccount(text string) error {
+	args := []string{"-a", bot.config.ChallengeAccount, "send", "-m", text}
+	if groupID, isGroup := strings.CutPrefix(bot.config.AdminNotify, "group:"); isGroup {
+		args = append(args, "-g", groupID)
+	} else if bot.config.AdminNotify == noteToSelf {
+		args = append(args, bot.config.SignalAccount)
+	} else {
+		args = append(args, bot.config.AdminNotify)
+	}
+
+	var stderr bytes.Buffer
+	if err := runSignalCLI(context.Background(), bot.config.SignalSendTimeout, nil, &stderr, args...); err != nil {
+		return fmt.Errorf("%w (stderr: %s)", err, stderr.String())
+	}
+	return nil
+}
+
+// postChallenge POSTs {"text", "challenge", "captcha_url"} to
+// CHALLENGE_WEBHOOK_URL
+func (bot *SignalBot) postChallenge(text, token string) error {
+	body, err := json.Marshal(map[string]string{
+		"text":        text,
+		"challenge":   token,
+		"captcha_url": captchaURL,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", bot.config.ChallengeWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create challenge request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := bot.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call challenge webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("challenge webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// resolveChallengeCommand submits a solved captcha for the pending
+// proof-required challenge and resumes sending; it backs
+// "!admin resolve-challenge"
+func resolveChallengeCommand(bot *SignalBot, args []string) string {
+	usage := "Usage: !admin resolve-challenge [<challenge token>] <signalcaptcha:// link>"
+	token := bot.sendPause.Challenge()
+	switch len(args) {
+	case 1:
+	case 2:
+		token = args[0]
+	default:
+		return usage
+	}
+	if token == "" {
+		return "There's no pending challenge; give its token: " + usage
+	}
+	captcha := args[len(args)-1]
+	if !strings.HasPrefix(captcha, "signalcaptcha://") {
+		return usage
+	}
+
+	var stderr bytes.Buffer
+	if err := runSignalCLI(context.Background(), bot.config.SignalSendTimeout, nil, &stderr,
+		"submitRateLimitChallenge", "--challenge", token, "--captcha", captcha); err != nil {
+		bot.logger.Printf("Error submitting the captcha challenge: %v (stderr: %s)", err, stderr.String())
+		return "Signal didn't accept that captcha: " + strings.TrimSpace(stderr.String())
+	}
+
+	bot.sendPause.Lift()
+	bot.logger.Printf("Captcha challenge solved, sends resumed")
+	return "Challenge solved, sending again. Queued replies go out shortly."
+}
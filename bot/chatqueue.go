@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// queuedNote tells an asker their prompt waits for an earlier one in the
+// chat, which in a group may be someone else's
+const queuedNote = "⏳ Queued behind an earlier question in this chat, I'll answer in order."
+
+// chatQueues lets each chat have one agent request in flight at a time,
+// serving later prompts in arrival order
+type chatQueues struct {
+	mu    sync.Mutex
+	tails map[string]*chatTicket // chat -> most recently issued ticket
+}
+
+// chatTicket is a prompt's place in its chat's queue
+type chatTicket struct {
+	queues *chatQueues
+	key    string
+	prev   chan struct{} // closed when the ticket ahead is done; nil if first
+	done   chan struct{}
+}
+
+func newChatQueues() *chatQueues {
+	return &chatQueues{tails: make(map[string]*chatTicket)}
+}
+
+// Enter issues the next ticket for a chat. Tickets must be issued in the
+// order prompts arrived.
+func (q *chatQueues) Enter(key string) *chatTicket {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ticket := &chatTicket{queues: q, key: key, done: make(chan struct{})}
+	if tail, exists := q.tails[key]; exists {
+		ticket.prev = tail.done
+	}
+	q.tails[key] = ticket
+	return ticket
+}
+
+// Queued reports whether another prompt in the chat is still ahead
+func (t *chatTicket) Queued() bool {
+	if t.prev == nil {
+		return false
+	}
+	select {
+	case <-t.prev:
+		return false
+	default:
+		return true
+	}
+}
+
+// Wait blocks until the ticket ahead is done or ctx ends
+func (t *chatTicket) Wait(ctx context.Context) error {
+	if t.prev == nil {
+		return nil
+	}
+	select {
+	case <-t.prev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done lets the next prompt in the chat proceed
+func (t *chatTicket) Done() {
+	t.queues.mu.Lock()
+	if t.queues.tails[t.key] == t {
+		delete(t.queues.tails, t.key)
+	}
+	t.queues.mu.Unlock()
+	close(t.done)
+}
+
+// Len returns the number of chats with a request in flight
+func (q *chatQueues) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.tails)
+}
+
+// enterChatQueue issues request's ticket in its chat's queue
+func (bot *SignalBot) enterChatQueue(request AgentRequest, target replyTarget) *chatTicket {
+	key := target.Recipient
+	if request.Chat != nil {
+		key = conversationID(*request.Chat)
+	}
+	return bot.chatQueues.Enter(key)
+}
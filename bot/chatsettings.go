@@ -0,0 +1,162 @@
+package main
+
+import "sync"
+
+// chatSettings holds small per-chat values (persona, model, ...) keyed by
+// conversation ID, persisted in one namespace of the state store
+type chatSettings struct {
+	mu        sync.RWMutex
+	namespace string
+	store     stateStore                   // nil until loaded
+	values    map[string]map[string]string // conversation ID -> key -> value
+	defaults  map[string]map[string]string // values used when unset, from the config file
+}
+
+// newChatSettings creates settings persisted under namespace
+func newChatSettings(namespace string) *chatSettings {
+	return &chatSettings{namespace: namespace, values: make(map[string]map[string]string)}
+}
+
+// Load reads the settings from store, which later changes are saved to
+func (cs *chatSettings) Load(store stateStore) error {
+	values, err := store.Settings(cs.namespace)
+	if err != nil {
+		return err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.store = store
+	cs.values = values
+	return nil
+}
+
+// Get returns a chat's value for key, falling back to its default, or ""
+// when unset
+func (cs *chatSettings) Get(chatID, key string) string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if value, exists := cs.values[chatID][key]; exists {
+		return value
+	}
+	return cs.defaults[chatID][key]
+}
+
+// SetDefaults replaces the values chats get for keys they haven't set.
+// Defaults are never persisted.
+func (cs *chatSettings) SetDefaults(defaults map[string]map[string]string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.defaults = defaults
+}
+
+// Set stores a chat's value for key and persists the change. An empty
+// value removes the key.
+func (cs *chatSettings) Set(chatID, key, value string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if value == "" {
+		delete(cs.values[chatID], key)
+		if len(cs.values[chatID]) == 0 {
+			delete(cs.values, chatID)
+		}
+	} else {
+		if cs.values[chatID] == nil {
+			cs.values[chatID] = make(map[string]string)
+		}
+		cs.values[chatID][key] = value
+	}
+
+	return cs.save(chatID)
+}
+
+// Keys returns the keys set for a chat
+func (cs *chatSettings) Keys(chatID string) []string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	keys := make([]string, 0, len(cs.values[chatID]))
+	for key := range cs.values[chatID] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// IDs returns the IDs that have any value set
+func (cs *chatSettings) IDs() []string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	ids := make([]string, 0, len(cs.values))
+	for id := range cs.values {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// IDsWith returns the IDs that have a value set for key
+func (cs *chatSettings) IDsWith(key string) []string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	var ids []string
+	for id, values := range cs.values {
+		if values[key] != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Clear removes all of a chat's settings and persists the change
+func (cs *chatSettings) Clear(chatID string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if _, exists := cs.values[chatID]; !exists {
+		return nil
+	}
+	delete(cs.values, chatID)
+	return cs.save(chatID)
+}
+
+// CountMatching returns the number of values stored under the IDs match
+// accepts
+func (cs *chatSettings) CountMatching(match func(id string) bool) int {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	count := 0
+	for id, values := range cs.values {
+		if match(id) {
+			count += len(values)
+		}
+	}
+	return count
+}
+
+// ClearMatching removes the settings of every ID match accepts and persists
+// the change
+func (cs *chatSettings) ClearMatching(match func(id string) bool) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for id := range cs.values {
+		if match(id) {
+			delete(cs.values, id)
+			if err := cs.save(id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// save persists one ID's settings; callers must hold cs.mu
+func (cs *chatSettings) save(id string) error {
+	if cs.store == nil {
+		return nil
+	}
+	return cs.store.SaveSettings(cs.namespace, id, cs.values[id])
+}
@@ -0,0 +1,53 @@
+package main
+
+import "sync"
+
+// inflightCalls coalesces identical agent requests: while one call for a
+// key is running, later callers wait for its answer instead of issuing
+// their own request
+type inflightCalls struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+}
+
+type inflightCall struct {
+	done   chan struct{}
+	answer agentAnswer
+	ok     bool
+}
+
+func newInflightCalls() *inflightCalls {
+	return &inflightCalls{calls: make(map[string]*inflightCall)}
+}
+
+// Do runs fn for key unless a call for key is already in flight, in which
+// case it waits for that call and returns its result with shared set. ok
+// reports whether the answer came from the agent rather than an apology.
+func (c *inflightCalls) Do(key string, fn func() (agentAnswer, bool)) (answer agentAnswer, ok, shared bool) {
+	c.mu.Lock()
+	if call, exists := c.calls[key]; exists {
+		c.mu.Unlock()
+		<-call.done
+		return call.answer, call.ok, true
+	}
+	call := &inflightCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.answer, call.ok = fn()
+	return call.answer, call.ok, false
+}
+
+// Len returns the number of distinct calls in flight
+func (c *inflightCalls) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.calls)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInflightCallsDo(t *testing.T) {
+	tests := []struct {
+		name       string
+		keys       []string // the first call is in flight while the others start
+		wantCalls  int32
+		wantShared []bool
+	}{
+		{name: "same prompt shares the call", keys: []string{"a", "a", "a"}, wantCalls: 1, wantShared: []bool{false, true, true}},
+		{name: "different prompts don't", keys: []string{"a", "b"}, wantCalls: 2, wantShared: []bool{false, false}},
+		{name: "mixed", keys: []string{"a", "b", "a"}, wantCalls: 2, wantShared: []bool{false, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newInflightCalls()
+			release := make(chan struct{})
+			var calls atomic.Int32
+			fn := func(key string) func() (agentAnswer, bool) {
+				return func() (agentAnswer, bool) {
+					calls.Add(1)
+					<-release
+					return textAnswer("answer to " + key), true
+				}
+			}
+
+			answers := make([]agentAnswer, len(tt.keys))
+			shared := make([]bool, len(tt.keys))
+			var wg sync.WaitGroup
+			for i, key := range tt.keys {
+				wg.Add(1)
+				go func(i int, key string) {
+					defer wg.Done()
+					var ok bool
+					answers[i], ok, shared[i] = c.Do(key, fn(key))
+					if !ok {
+						t.Errorf("Do(%s) ok = false", key)
+					}
+				}(i, key)
+				if i == 0 {
+					for c.Len() == 0 {
+						time.Sleep(time.Millisecond)
+					}
+				}
+			}
+			// Give the later callers time to find the call in flight
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if calls.Load() != tt.wantCalls {
+				t.Errorf("agent called %d times, want %d", calls.Load(), tt.wantCalls)
+			}
+			for i, key := range tt.keys {
+				if shared[i] != tt.wantShared[i] {
+					t.Errorf("call %d for %s shared = %t, want %t", i+1, key, shared[i], tt.wantShared[i])
+				}
+				if got := answers[i].Replies; len(got) != 1 || got[0] != "answer to "+key {
+					t.Errorf("call %d for %s answered %v", i+1, key, got)
+				}
+			}
+			if c.Len() != 0 {
+				t.Errorf("Len() = %d after all calls finished, want 0", c.Len())
+			}
+		})
+	}
+}
+
+func TestInflightCallsDoAfterFinish(t *testing.T) {
+	c := newInflightCalls()
+	var calls int
+	fn := func() (agentAnswer, bool) {
+		calls++
+		return textAnswer("answer"), true
+	}
+	c.Do("a", fn)
+	if _, _, shared := c.Do("a", fn); shared || calls != 2 {
+		t.Errorf("second call after the first finished: shared = %t, calls = %d; want false, 2", shared, calls)
+	}
+}
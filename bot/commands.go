@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// command is a "!name args" chat command handled by the bot itself
+// rather than forwarded to the agent
+type command struct {
+	name    string
+	usage   string
+	role    string // least role that may run it, "" for everyone
+	handler func(ctx context.Context, bot *SignalBot, msg *Message, args []string) string
+}
+
+// commands lists every registered chat command by name
+var commands = map[string]*command{}
+
+// registerCommand adds a command to the registry
+func registerCommand(cmd *command) {
+	commands[cmd.name] = cmd
+}
+
+// parseCommand splits "!name arg1 arg2" into the command and its args
+func parseCommand(content string) (*command, []string) {
+	if !strings.HasPrefix(content, "!") {
+		return nil, nil
+	}
+	fields := strings.Fields(strings.TrimPrefix(content, "!"))
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	cmd, exists := commands[strings.ToLower(fields[0])]
+	if !exists {
+		return nil, nil
+	}
+	return cmd, fields[1:]
+}
+
+// commandText returns what follows the first n words of a command message,
+// keeping its line breaks
+func commandText(content string, n int) string {
+	rest := strings.TrimSpace(content)
+	for i := 0; i < n; i++ {
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			return ""
+		}
+		rest = strings.TrimLeftFunc(rest[end:], unicode.IsSpace)
+	}
+	return rest
+}
+
+// replyInBackground sends the reply produced by fn to msg's chat once it's
+// ready, for commands that wait on a remote service. Shutdown waits for it
+// like for agent answers.
+func (bot *SignalBot) replyInBackground(msg *Message, fn func() string) {
+	recipient, timestamp, author := msg.replyRecipient(), msg.extractTimestamp(), msg.Envelope.Source
+	bot.answering.Add(1)
+	go func() {
+		defer bot.answering.Done()
+		if reply := fn(); reply != "" {
+			if err := bot.sendReply(recipient, reply, timestamp, author); err != nil {
+				bot.logger.Printf("Error sending command reply: %v", err)
+			}
+		}
+	}()
+}
+
+// isAdmin reports whether msg was sent by an admin or owner
+func (bot *SignalBot) isAdmin(msg *Message) bool {
+	return bot.hasRole(msg, roleAdmin)
+}
+
+// handleCommand runs content as a chat command, returning false when it
+// isn't one so the caller can treat it as a regular message
+func (bot *SignalBot) handleCommand(ctx context.Context, msg *Message, content string) bool {
+	cmd, args := parseCommand(content)
+	if cmd == nil {
+		return false
+	}
+
+	if role := bot.commandRole(cmd.name, cmd.role); !bot.hasRole(msg, role) {
+		bot.logger.Printf("Ignoring !%s from %s, who isn't %s", cmd.name, msg.Envelope.Source, role)
+		return true
+	}
+
+	if cmd.role == "" && bot.switches.Disabled(switchCommands) {
+		bot.logger.Printf("Commands are disabled, ignoring !%s", cmd.name)
+		return true
+	}
+
+	recipient := msg.replyRecipient()
+	if recipient == "" {
+		bot.logger.Printf("No recipient found for command !%s", cmd.name)
+		return true
+	}
+
+	bot.logger.Printf("Running command !%s from %s", cmd.name, msg.Envelope.Source)
+	reply := bot.localize(msg.chatID(), cmd.handler(ctx, bot, msg, args))
+	if reply == "" {
+		return true
+	}
+
+	if err := bot.sendReply(recipient, reply, msg.extractTimestamp(), msg.Envelope.Source); err != nil {
+		bot.logger.Printf("Error sending command reply: %v", err)
+	}
+	return true
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "admin",
+		usage:   "!admin switches | !admin disable <subsystem> | !admin enable <subsystem> | !admin config | !admin reload | !admin flags | !admin flag <name> on|off | !admin outbox | !admin resolve-challenge <link> | !admin exempt [<number> on|off] | !admin unmute [<number|group:<id>>] | !admin unblock [<number>] | !admin audit [<count>]",
+		role:    roleAdmin,
+		handler: adminCommand,
+	})
+}
+
+// adminCommand manages kill switches at runtime
+func adminCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	if len(args) == 0 {
+		return "Usage: " + commands["admin"].usage
+	}
+
+	subcommand := strings.ToLower(args[0])
+	if role := bot.commandRole("admin."+subcommand, adminSubcommandRoles[subcommand]); !bot.hasRole(msg, role) {
+		return fmt.Sprintf("Only an %s can run !admin %s.", role, subcommand)
+	}
+
+	switch subcommand {
+	case "switches":
+		return "Subsystems: " + bot.switches.String()
+	case "config":
+		return configCommand(bot, msg)
+	case "outbox":
+		return outboxCommand(bot, args[1:])
+	case "resolve-challenge":
+		return resolveChallengeCommand(bot, args[1:])
+	case "exempt":
+		return exemptCommand(bot, args[1:])
+	case "unmute":
+		return unmuteCommand(bot, args[1:])
+	case "unblock":
+		return unblockCommand(bot, args[1:])
+	case "audit":
+		return auditCommand(bot, args[1:])
+	case "flags", "flag":
+		return flagCommand(ctx, bot, msg, args)
+	case "reload":
+		if err := bot.reloadConfig(); err != nil {
+			return "Config not reloaded: " + err.Error()
+		}
+		return "Config reloaded."
+	case "disable", "enable":
+		if len(args) < 2 {
+			return "Usage: " + commands["admin"].usage
+		}
+		disabled := strings.ToLower(args[0]) == "disable"
+		if err := bot.switches.Set(strings.ToLower(args[1]), disabled); err != nil {
+			return "Error: " + err.Error()
+		}
+		bot.logger.Printf("Kill switch changed: %s", bot.switches.String())
+		return "Subsystems: " + bot.switches.String()
+	default:
+		return "Usage: " + commands["admin"].usage
+	}
+}
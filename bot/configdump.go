@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// configDump renders the effective configuration, including reloaded
+// settings, one "Field: value" per line, followed by the runtime overrides:
+// kill switches, feature flags, exemptions, mutes, blocks and chat
+// settings. Fields tagged secret are masked and credentials embedded in
+// URLs are redacted.
+func (bot *SignalBot) configDump() string {
+	config := bot.config
+	bot.live.Load().apply(&config)
+	v := reflect.ValueOf(config)
+	t := v.Type()
+
+	lines := make([]string, 0, t.NumField()+2)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := formatConfigValue(v.Field(i).Interface())
+		if field.Tag.Get("secret") == "true" {
+			value = maskSecret(value)
+		}
+		lines = append(lines, field.Name+": "+value)
+	}
+
+	lines = append(lines, "", "Runtime overrides:", "Subsystems: "+bot.switches.String())
+	lines = append(lines, bot.runtimeOverrides()...)
+	return strings.Join(lines, "\n")
+}
+
+// runtimeOverrides lists what admins and chat members changed at runtime,
+// other than the kill switches, one kind per line and one line per chat's
+// settings
+func (bot *SignalBot) runtimeOverrides() []string {
+	list := func(items []string) string {
+		if len(items) == 0 {
+			return "none"
+		}
+		return strings.Join(items, ", ")
+	}
+	lines := []string{
+		"Feature flags: " + list(bot.flags.Overrides()),
+		"Rate limit exemptions: " + list(bot.rateLimits.RuntimeExemptions()),
+		"Flood mutes: " + list(bot.floods.Muted(time.Now())),
+		"Blocked senders: " + list(bot.autoBlocks.Blocked()),
+	}
+
+	ids := bot.settings.IDs()
+	sort.Strings(ids)
+	if len(ids) == 0 {
+		return append(lines, "Chat settings: none")
+	}
+	lines = append(lines, "Chat settings:")
+	for _, id := range ids {
+		keys := bot.settings.Keys(id)
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(keys))
+		for _, key := range keys {
+			pairs = append(pairs, key+"="+bot.settings.Get(id, key))
+		}
+		lines = append(lines, "  "+id+": "+strings.Join(pairs, ", "))
+	}
+	return lines
+}
+
+// configCommand backs "!admin config". The dump goes to the owner's direct
+// chat, so asking in a group doesn't show the configuration to its
+// members.
+func configCommand(bot *SignalBot, msg *Message) string {
+	if msg.extractGroupId() == "" {
+		return bot.configDump()
+	}
+	recipient := msg.sender().Number
+	if recipient == "" {
+		recipient = msg.Envelope.SourceUuid
+	}
+	if recipient == "" {
+		return "Sorry, I can't tell who to send the config to."
+	}
+	if err := bot.sendReply(recipient, bot.configDump(), 0, ""); err != nil {
+		bot.logger.Printf("Error sending config dump: %v", err)
+		return "Sorry, I couldn't send you the config."
+	}
+	return "I sent you the config in a direct message."
+}
+
+// formatConfigValue prints a config value in the form it's configured with
+func formatConfigValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return redactURL(v)
+	case time.Duration:
+		return v.String()
+	case []string:
+		return strings.Join(v, ",")
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(keys))
+		for _, k := range keys {
+			pairs = append(pairs, k+"="+v[k])
+		}
+		return strings.Join(pairs, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// redactURL hides the password of URLs such as proxy addresses; other
+// strings are returned unchanged
+func redactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+// maskSecret hides all but the last four characters of a secret
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	if len(s) <= 8 {
+		return "****"
+	}
+	return "****" + s[len(s)-4:]
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigDumpRuntimeOverrides(t *testing.T) {
+	bot := NewSignalBot()
+	bot.logger = log.New(&bytes.Buffer{}, "", 0)
+	if err := bot.flags.Set(flagTools, "group:abc", "off"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.rateLimits.SetExempt("+15550002", true); err != nil {
+		t.Fatal(err)
+	}
+	bot.floods.mute = time.Hour
+	bot.floods.Check("+15550003", 1, time.Now())
+	bot.floods.Check("+15550003", 1, time.Now())
+	if err := bot.settings.Set("group:abc", "persona", "pirate"); err != nil {
+		t.Fatal(err)
+	}
+
+	dump := bot.configDump()
+	for _, want := range []string{
+		"Feature flags: tools=off (group:abc)",
+		"Rate limit exemptions: +15550002",
+		"Flood mutes: +15550003 (",
+		"Blocked senders: none",
+		"Chat settings:\n  group:abc: persona=pirate",
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("configDump() lacks %q:\n%s", want, dump)
+		}
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		envelope string
+		wantDM   bool
+	}{
+		{name: "in a DM", envelope: `{"source":"+15550001","dataMessage":{"message":"!admin config"}}`},
+		{name: "in a group", envelope: `{"source":"+15550001","dataMessage":{"message":"!admin config","groupInfo":{"groupId":"abc"}}}`, wantDM: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent bytes.Buffer
+			bot := NewSignalBot()
+			bot.config.DryRun = true
+			bot.logger = log.New(&sent, "", 0)
+			var msg Message
+			if err := json.Unmarshal([]byte(`{"envelope":`+tt.envelope+`}`), &msg); err != nil {
+				t.Fatal(err)
+			}
+
+			reply := configCommand(bot, &msg)
+			if tt.wantDM {
+				if strings.Contains(reply, "Runtime overrides:") {
+					t.Errorf("configCommand() posted the dump in the group: %q", reply)
+				}
+				if !strings.Contains(sent.String(), "Reply to +15550001") || !strings.Contains(sent.String(), "Runtime overrides:") {
+					t.Errorf("configCommand() didn't DM the dump, sent:\n%s", sent.String())
+				}
+			} else if !strings.Contains(reply, "Runtime overrides:") || sent.Len() != 0 {
+				t.Errorf("configCommand() = %q, sent %q; want the dump as the reply", reply, sent.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configFileSettings holds the settings read from the config file, keyed by
+// environment variable name. Environment variables override them.
+var configFileSettings = map[string]string{}
+
+// configFileChats holds per-chat setting defaults from the config file's
+// [chats."<chat ID>"] tables
+var configFileChats = map[string]map[string]string{}
+
+// settingsLookedUp records every setting the bot reads, so keys in the
+// config file that nothing reads can be reported as mistakes
+var settingsLookedUp = map[string]bool{}
+
+// loadConfigFile reads a TOML or JSON config file (by extension). Nested
+// tables name settings by their environment variable: agent.retries or
+// [agent] retries is AGENT_RETRIES. Arrays become comma-separated lists and
+// inline tables key=value lists. The [chats] table holds per-chat defaults
+// for the !set keys, e.g. [chats."group:<id>"] persona = "pirate". With a
+// profile, its [profiles.<name>] table is laid over the rest of the file.
+func loadConfigFile(path, profile string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var tree map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(data, &tree)
+	case ".toml", "":
+		tree, err = parseTOML(string(data))
+	default:
+		return fmt.Errorf("%s: unsupported config format %q (use .toml or .json)", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if tree, err = selectConfigProfile(tree, profile); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	settings := make(map[string]string)
+	chats := make(map[string]map[string]string)
+	for key, value := range tree {
+		if strings.EqualFold(key, "chats") {
+			if err := readConfigChats(value, chats); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			continue
+		}
+		if err := flattenConfig(settingName("", key), value, settings); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	configFileSettings = settings
+	configFileChats = chats
+	return nil
+}
+
+// selectConfigProfile removes the [profiles] table from a config file,
+// merging the named profile over the settings outside it. A profile may
+// name another in "inherits", which it is merged over in turn.
+func selectConfigProfile(tree map[string]any, profile string) (map[string]any, error) {
+	profiles, _ := tree["profiles"].(map[string]any)
+	if _, exists := tree["profiles"]; exists && profiles == nil {
+		return nil, fmt.Errorf("profiles must be a table of profile names")
+	}
+	delete(tree, "profiles")
+	if profile == "" {
+		return tree, nil
+	}
+
+	// Collect the chain from the profile up to the base
+	var chain []map[string]any
+	seen := make(map[string]bool)
+	for name := profile; name != ""; {
+		if seen[name] {
+			return nil, fmt.Errorf("profiles inherit in a loop at %q", name)
+		}
+		seen[name] = true
+		table, isTable := profiles[name].(map[string]any)
+		if !isTable {
+			return nil, fmt.Errorf("unknown profile %q", name)
+		}
+		chain = append(chain, table)
+
+		parent, _ := table["inherits"].(string)
+		if _, exists := table["inherits"]; exists && parent == "" {
+			return nil, fmt.Errorf("profiles.%s.inherits must name a profile", name)
+		}
+		name = parent
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		overlay := make(map[string]any, len(chain[i]))
+		for key, value := range chain[i] {
+			if key != "inherits" {
+				overlay[key] = value
+			}
+		}
+		mergeConfigTables(tree, overlay)
+	}
+	return tree, nil
+}
+
+// mergeConfigTables lays overlay over base, merging tables key by key
+func mergeConfigTables(base, overlay map[string]any) {
+	for key, value := range overlay {
+		if table, isTable := value.(map[string]any); isTable {
+			if existing, isTable := base[key].(map[string]any); isTable {
+				mergeConfigTables(existing, table)
+				continue
+			}
+		}
+		base[key] = value
+	}
+}
+
+// checkSettings reports secrets that couldn't be read and config file keys
+// that no setting read, once the configuration has been built
+func checkSettings() error {
+	if len(secretErrors) > 0 {
+		return fmt.Errorf("failed to read secrets: %w", errors.Join(secretErrors...))
+	}
+	var unknown []string
+	for key := range configFileSettings {
+		if !settingsLookedUp[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown settings in the config file: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// settingName appends a config file key to an environment variable name
+func settingName(prefix, key string) string {
+	name := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// flattenConfig stores value under name, descending into tables
+func flattenConfig(name string, value any, settings map[string]string) error {
+	if table, isTable := value.(map[string]any); isTable {
+		for key, v := range table {
+			if err := flattenConfig(settingName(name, key), v, settings); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	s, err := configValueString(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if _, exists := settings[name]; exists {
+		return fmt.Errorf("%s is set twice", name)
+	}
+	settings[name] = s
+	return nil
+}
+
+// configValueString renders a config value the way its environment
+// variable would be written
+func configValueString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configValueString(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case inlineTable:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(v))
+		for _, key := range keys {
+			s, err := configValueString(v[key])
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, key+"="+s)
+		}
+		return strings.Join(pairs, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// readConfigChats validates the [chats] table into per-chat defaults
+func readConfigChats(value any, chats map[string]map[string]string) error {
+	table, isTable := value.(map[string]any)
+	if !isTable {
+		return fmt.Errorf("chats must be a table of chat IDs")
+	}
+	for chatID, v := range table {
+		values, isTable := v.(map[string]any)
+		if !isTable {
+			return fmt.Errorf("chats.%s must be a table of settings", chatID)
+		}
+		if !strings.HasPrefix(chatID, "dm:") && !strings.HasPrefix(chatID, "group:") {
+			return fmt.Errorf("chats.%s: chat IDs look like dm:+15551234567 or group:<id>", chatID)
+		}
+		chats[chatID] = make(map[string]string)
+		for key, raw := range values {
+			key = strings.ToLower(key)
+			def, exists := chatSettingDefs[key]
+			if !exists || def.scope == scopePersonal {
+				return fmt.Errorf("chats.%s: unknown chat setting %q", chatID, key)
+			}
+			s, err := configValueString(raw)
+			if err != nil {
+				return fmt.Errorf("chats.%s.%s: %w", chatID, key, err)
+			}
+			if s, err = def.normalize(s); err != nil {
+				return fmt.Errorf("chats.%s.%s: %w", chatID, key, err)
+			}
+			chats[chatID][key] = s
+		}
+	}
+	return nil
+}
+
+// inlineTable is a TOML inline table, { key = value, ... }, which stays one
+// setting where a [table] names several
+type inlineTable map[string]any
+
+// tomlParser parses the subset of TOML config files need: tables, dotted
+// keys, strings, numbers, booleans, arrays and inline tables
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+// parseTOML parses a TOML document into nested maps
+func parseTOML(src string) (map[string]any, error) {
+	p := &tomlParser{src: src, line: 1}
+	root := make(map[string]any)
+	current := root
+	for {
+		p.skipBlank(true)
+		if p.eof() {
+			return root, nil
+		}
+
+		if p.peek() == '[' {
+			if strings.HasPrefix(p.src[p.pos:], "[[") {
+				return nil, p.errorf("arrays of tables aren't supported")
+			}
+			p.pos++
+			path, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			if !p.consume(']') {
+				return nil, p.errorf("expected ] after table name")
+			}
+			if current, err = p.table(root, path); err != nil {
+				return nil, err
+			}
+		} else {
+			path, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			if !p.consume('=') {
+				return nil, p.errorf("expected = after %s", strings.Join(path, "."))
+			}
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			table, err := p.table(current, path[:len(path)-1])
+			if err != nil {
+				return nil, err
+			}
+			key := path[len(path)-1]
+			if _, exists := table[key]; exists {
+				return nil, p.errorf("%s is set twice", strings.Join(path, "."))
+			}
+			table[key] = value
+		}
+
+		p.skipBlank(false)
+		if !p.eof() && p.peek() != '\n' {
+			return nil, p.errorf("unexpected %q", p.peek())
+		}
+	}
+}
+
+// table returns the table at path below t, creating missing ones
+func (p *tomlParser) table(t map[string]any, path []string) (map[string]any, error) {
+	for _, key := range path {
+		next, exists := t[key]
+		if !exists {
+			child := make(map[string]any)
+			t[key] = child
+			t = child
+			continue
+		}
+		child, isTable := next.(map[string]any)
+		if !isTable {
+			return nil, p.errorf("%s is a value, not a table", key)
+		}
+		t = child
+	}
+	return t, nil
+}
+
+// parseKey reads a possibly dotted, possibly quoted key
+func (p *tomlParser) parseKey() ([]string, error) {
+	var path []string
+	for {
+		p.skipBlank(false)
+		var key string
+		switch {
+		case p.eof():
+			return nil, p.errorf("expected a key")
+		case p.peek() == '"' || p.peek() == '\'':
+			s, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("expected a key")
+			}
+			key = p.src[start:p.pos]
+		}
+		path = append(path, key)
+
+		p.skipBlank(false)
+		if !p.consume('.') {
+			return path, nil
+		}
+	}
+}
+
+// parseValue reads a value after "key ="
+func (p *tomlParser) parseValue() (any, error) {
+	p.skipBlank(false)
+	if p.eof() {
+		return nil, p.errorf("expected a value")
+	}
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.parseString()
+	case c == '[':
+		return p.parseArray()
+	case c == '{':
+		return p.parseInlineTable()
+	}
+
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(" \t\r\n,]}#", rune(p.peek())) {
+		p.pos++
+	}
+	word := p.src[start:p.pos]
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	number := strings.ReplaceAll(word, "_", "")
+	if n, err := strconv.ParseInt(number, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, nil
+	}
+	return nil, p.errorf("invalid value %q (quote strings)", word)
+}
+
+// parseString reads a basic ("...") or literal ('...') string on one line
+func (p *tomlParser) parseString() (string, error) {
+	quote := p.peek()
+	if strings.HasPrefix(p.src[p.pos:], strings.Repeat(string(quote), 3)) {
+		return "", p.errorf("multi-line strings aren't supported")
+	}
+	start := p.pos
+	p.pos++
+	for !p.eof() && p.peek() != quote && p.peek() != '\n' {
+		if quote == '"' && p.peek() == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	if p.eof() || p.peek() != quote {
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+
+	raw := p.src[start:p.pos]
+	if quote == '\'' {
+		return raw[1 : len(raw)-1], nil
+	}
+	s, err := strconv.Unquote(raw)
+	if err != nil {
+		return "", p.errorf("invalid string %s", raw)
+	}
+	return s, nil
+}
+
+// parseArray reads [value, ...], which may span lines
+func (p *tomlParser) parseArray() ([]any, error) {
+	p.pos++
+	items := []any{}
+	for {
+		p.skipBlank(true)
+		if p.consume(']') {
+			return items, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+		p.skipBlank(true)
+		if p.consume(']') {
+			return items, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("expected , or ] in array")
+		}
+	}
+}
+
+// parseInlineTable reads { key = value, ... } on one line
+func (p *tomlParser) parseInlineTable() (inlineTable, error) {
+	p.pos++
+	table := inlineTable{}
+	p.skipBlank(false)
+	if p.consume('}') {
+		return table, nil
+	}
+	for {
+		path, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		if len(path) != 1 {
+			return nil, p.errorf("dotted keys aren't supported in inline tables")
+		}
+		if !p.consume('=') {
+			return nil, p.errorf("expected = after %s", path[0])
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		table[path[0]] = value
+		p.skipBlank(false)
+		if p.consume('}') {
+			return table, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("expected , or } in inline table")
+		}
+	}
+}
+
+// skipBlank skips spaces, tabs and comments, and newlines too if asked
+func (p *tomlParser) skipBlank(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		default:
+			return
+		}
+	}
+}
+
+// consume skips blanks and c, reporting whether c was next
+func (p *tomlParser) consume(c byte) bool {
+	p.skipBlank(false)
+	if !p.eof() && p.peek() == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *tomlParser) eof() bool  { return p.pos >= len(p.src) }
+func (p *tomlParser) peek() byte { return p.src[p.pos] }
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// isBareKeyChar reports whether c may appear in an unquoted key
+func isBareKeyChar(c byte) bool {
+	return c == '_' || c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
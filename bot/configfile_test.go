@@ -0,0 +1,246 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    map[string]any
+		wantErr string // substring of the error, "" when parsing succeeds
+	}{
+		{
+			name: "scalars",
+			src:  "s = \"text\"\nn = 1_000\nf = 0.5\nyes = true\nno = false\n",
+			want: map[string]any{"s": "text", "n": int64(1000), "f": 0.5, "yes": true, "no": false},
+		},
+		{
+			name: "basic string escapes",
+			src:  `s = "say \"hi\"\tthere\n"`,
+			want: map[string]any{"s": "say \"hi\"\tthere\n"},
+		},
+		{
+			name: "literal string keeps backslashes",
+			src:  `path = 'C:\data\bot'`,
+			want: map[string]any{"path": `C:\data\bot`},
+		},
+		{
+			name: "quoted keys",
+			src:  "\"group:abc=\" = 1\n'dm:+1555' = 2\n",
+			want: map[string]any{"group:abc=": int64(1), "dm:+1555": int64(2)},
+		},
+		{
+			name: "comments",
+			src:  "# leading comment\n\na = 1 # trailing comment\n  # indented comment\nb = \"# not a comment\"\n",
+			want: map[string]any{"a": int64(1), "b": "# not a comment"},
+		},
+		{
+			name: "tables and dotted keys",
+			src:  "top = 1\n[agent]\nurl = \"http://agent\"\n[agent.history]\nturns = 3\n[signal]\nretry.backoff = \"1s\"\n",
+			want: map[string]any{
+				"top":    int64(1),
+				"agent":  map[string]any{"url": "http://agent", "history": map[string]any{"turns": int64(3)}},
+				"signal": map[string]any{"retry": map[string]any{"backoff": "1s"}},
+			},
+		},
+		{
+			name: "quoted table name",
+			src:  "[chats.\"group:abc\"]\npersona = \"pirate\"\n",
+			want: map[string]any{"chats": map[string]any{"group:abc": map[string]any{"persona": "pirate"}}},
+		},
+		{
+			name: "reopened table",
+			src:  "[a]\nx = 1\n[b]\ny = 2\n[a]\nz = 3\n",
+			want: map[string]any{"a": map[string]any{"x": int64(1), "z": int64(3)}, "b": map[string]any{"y": int64(2)}},
+		},
+		{
+			name: "arrays across lines",
+			src:  "list = [\n  \"a\", # first\n  \"b\",\n]\nempty = []\n",
+			want: map[string]any{"list": []any{"a", "b"}, "empty": []any{}},
+		},
+		{
+			name: "inline table",
+			src:  `map = { greeting = "hi", count = 2 }`,
+			want: map[string]any{"map": inlineTable{"greeting": "hi", "count": int64(2)}},
+		},
+		{
+			name: "empty document",
+			src:  "\n# nothing here\n",
+			want: map[string]any{},
+		},
+		{name: "duplicate key", src: "a = 1\na = 2\n", wantErr: "a is set twice"},
+		{name: "duplicate key in reopened table", src: "[t]\nx = 1\n[t]\nx = 2\n", wantErr: "line 4: x is set twice"},
+		{name: "duplicate dotted key", src: "a.b = 1\n[a]\nb = 2\n", wantErr: "b is set twice"},
+		{name: "value used as table", src: "a = 1\n[a]\n", wantErr: "a is a value, not a table"},
+		{name: "missing equals", src: "a 1\n", wantErr: "expected = after a"},
+		{name: "missing value", src: "a =\n", wantErr: `invalid value ""`},
+		{name: "bare string", src: "a = hello\n", wantErr: `invalid value "hello"`},
+		{name: "unterminated string", src: "a = \"open\nb = 1\n", wantErr: "line 1: unterminated string"},
+		{name: "multi-line string", src: "a = \"\"\"x\"\"\"\n", wantErr: "multi-line strings aren't supported"},
+		{name: "array of tables", src: "[[items]]\n", wantErr: "arrays of tables aren't supported"},
+		{name: "unclosed table name", src: "[agent\n", wantErr: "expected ] after table name"},
+		{name: "two keys on a line", src: "a = 1 b = 2\n", wantErr: "unexpected 'b'"},
+		{name: "unclosed array", src: "a = [1, 2\n", wantErr: "expected , or ] in array"},
+		{name: "dotted key in inline table", src: "a = { b.c = 1 }\n", wantErr: "dotted keys aren't supported"},
+		{name: "error line number", src: "a = 1\n\n# comment\nb = oops\n", wantErr: "line 4:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML(tt.src)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseTOML() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTOML() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTOML() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string // name of the config file, which sets its format
+		src       string
+		profile   string
+		env       map[string]string
+		want      map[string]string // setting -> value looked up, "<unset>" when missing
+		wantChats map[string]map[string]string
+		wantErr   string
+	}{
+		{
+			name: "tables name settings by variable",
+			file: "bot.toml",
+			src:  "[agent]\nretries = 3\nretry-backoff = \"2s\"\n[signal]\nsend.timeout = \"30s\"\n",
+			want: map[string]string{"AGENT_RETRIES": "3", "AGENT_RETRY_BACKOFF": "2s", "SIGNAL_SEND_TIMEOUT": "30s"},
+		},
+		{
+			name: "environment overrides the file",
+			file: "bot.toml",
+			src:  "agent_retries = 3\nagent_url = \"http://file\"\n",
+			env:  map[string]string{"AGENT_RETRIES": "5"},
+			want: map[string]string{"AGENT_RETRIES": "5", "AGENT_URL": "http://file"},
+		},
+		{
+			name: "an empty environment variable still overrides",
+			file: "bot.toml",
+			src:  "agent_token = \"from-file\"\n",
+			env:  map[string]string{"AGENT_TOKEN": ""},
+			want: map[string]string{"AGENT_TOKEN": ""},
+		},
+		{
+			name: "lists and inline tables",
+			file: "bot.toml",
+			src:  "triggers = [\"qq\", \"!ai\"]\nagent_forward_targets = { me = \"+1555\", family = \"-g abc\" }\n",
+			want: map[string]string{"TRIGGERS": "qq,!ai", "AGENT_FORWARD_TARGETS": "family=-g abc,me=+1555"},
+		},
+		{
+			name: "secrets from a file named in the config",
+			file: "bot.toml",
+			src:  "agent_token_file = \"{dir}/token\"\n",
+			want: map[string]string{"AGENT_TOKEN": "s3cret"},
+		},
+		{
+			name: "JSON",
+			file: "bot.json",
+			src:  `{"agent": {"retries": 4, "url": "http://json"}, "triggers": ["qq"]}`,
+			want: map[string]string{"AGENT_RETRIES": "4", "AGENT_URL": "http://json", "TRIGGERS": "qq"},
+		},
+		{
+			name:    "profile overrides the base",
+			file:    "bot.toml",
+			src:     "agent_retries = 1\nagent_url = \"http://prod\"\n[profiles.staging]\nagent_url = \"http://staging\"\n",
+			profile: "staging",
+			want:    map[string]string{"AGENT_RETRIES": "1", "AGENT_URL": "http://staging"},
+		},
+		{
+			name:    "profiles inherit",
+			file:    "bot.toml",
+			src:     "agent_retries = 1\n[profiles.base]\nagent_retries = 2\nagent_url = \"http://base\"\n[profiles.dev]\ninherits = \"base\"\nagent_url = \"http://dev\"\n",
+			profile: "dev",
+			want:    map[string]string{"AGENT_RETRIES": "2", "AGENT_URL": "http://dev"},
+		},
+		{
+			name: "profiles are ignored without one selected",
+			file: "bot.toml",
+			src:  "agent_retries = 1\n[profiles.dev]\nagent_retries = 9\n",
+			want: map[string]string{"AGENT_RETRIES": "1"},
+		},
+		{
+			name:      "per-chat defaults",
+			file:      "bot.toml",
+			src:       "[chats.\"group:abc\"]\nundo = 10\ntemperature = 0.5\n",
+			want:      map[string]string{"UNDO": "<unset>"},
+			wantChats: map[string]map[string]string{"group:abc": {"undo": "10s", "temperature": "0.5"}},
+		},
+		{name: "unknown profile", file: "bot.toml", src: "a = 1\n", profile: "nope", wantErr: `unknown profile "nope"`},
+		{name: "inheritance loop", file: "bot.toml", src: "[profiles.a]\ninherits = \"b\"\n[profiles.b]\ninherits = \"a\"\n", profile: "a", wantErr: "inherit in a loop"},
+		{name: "a setting set twice", file: "bot.toml", src: "agent_retries = 1\n[agent]\nretries = 2\n", wantErr: "AGENT_RETRIES is set twice"},
+		{name: "bad chat ID", file: "bot.toml", src: "[chats.abc]\nundo = 10\n", wantErr: "chat IDs look like"},
+		{name: "unknown chat setting", file: "bot.toml", src: "[chats.\"dm:+1555\"]\ncolour = \"red\"\n", wantErr: `unknown chat setting "colour"`},
+		{name: "invalid chat setting", file: "bot.toml", src: "[chats.\"dm:+1555\"]\nundo = 600\n", wantErr: "undo must be between"},
+		{name: "unsupported format", file: "bot.yaml", src: "a: 1\n", wantErr: "unsupported config format"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, chats := configFileSettings, configFileChats
+			t.Cleanup(func() { configFileSettings, configFileChats = settings, chats })
+
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "token"), []byte("s3cret\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, []byte(strings.ReplaceAll(tt.src, "{dir}", dir)), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			for key := range tt.want {
+				for _, name := range []string{key, key + "_FILE"} {
+					t.Setenv(name, "") // restored after the test
+					os.Unsetenv(name)
+				}
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			err := loadConfigFile(path, tt.profile)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadConfigFile() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfigFile() error = %v", err)
+			}
+
+			for key, want := range tt.want {
+				got, exists := lookupSetting(key)
+				if !exists {
+					got = "<unset>"
+				}
+				if got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+			if tt.wantChats != nil && !reflect.DeepEqual(configFileChats, tt.wantChats) {
+				t.Errorf("chats = %v, want %v", configFileChats, tt.wantChats)
+			}
+		})
+	}
+}
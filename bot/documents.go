@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	textExtractors["application/pdf"] = extractPDF
+}
+
+// defaultPDFExtractCommand uses poppler's pdftotext, which separates pages
+// with form feeds
+const defaultPDFExtractCommand = "pdftotext -layout {file} -"
+
+// errUnsupportedDocument is returned for attachments without a text extractor
+var errUnsupportedDocument = errors.New("unsupported document type")
+
+// extractPDF pulls the text out of a PDF with PDF_EXTRACT_URL when set,
+// otherwise by running PDF_EXTRACT_COMMAND
+func extractPDF(ctx context.Context, bot *SignalBot, path string) (string, error) {
+	if bot.config.PDFExtractURL != "" {
+		return bot.extractWithService(ctx, bot.config.PDFExtractURL, path, "application/pdf")
+	}
+
+	args := strings.Fields(bot.config.PDFExtractCommand)
+	if len(args) == 0 {
+		return "", fmt.Errorf("no PDF extractor configured")
+	}
+	for i := range args {
+		args[i] = strings.ReplaceAll(args[i], "{file}", path)
+	}
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w", args[0], err)
+	}
+	return string(out), nil
+}
+
+// extractWithService PUTs a file to a text extraction service such as
+// Apache Tika's /tika endpoint and returns the plain text response
+func (bot *SignalBot) extractWithService(ctx context.Context, url, path, contentType string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, file)
+	if err != nil {
+		return "", fmt.Errorf("failed to create extraction request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "text/plain")
+
+	resp, err := bot.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call extraction service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("extraction service returned status %d", resp.StatusCode)
+	}
+	text, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read extracted text: %w", err)
+	}
+	return string(text), nil
+}
+
+// attachmentName is how a document is referred to in replies and sources
+func attachmentName(a Attachment) string {
+	if a.Filename != "" {
+		return a.Filename
+	}
+	return a.ID
+}
+
+// extractAttachment returns the text of a downloaded attachment
+func (bot *SignalBot) extractAttachment(ctx context.Context, a Attachment) (string, error) {
+	extract, supported := textExtractors[a.ContentType]
+	if !supported {
+		return "", errUnsupportedDocument
+	}
+	return extract(ctx, bot, filepath.Join(bot.config.AttachmentsDir, a.ID))
+}
+
+// documentChunk is a passage of an extracted document
+type documentChunk struct {
+	Source string
+	Text   string
+}
+
+// documentChunks splits a document into passages. Pages (separated by form
+// feeds) are chunked separately so each passage can cite its page.
+func documentChunks(name, text string) []documentChunk {
+	pages := strings.Split(strings.TrimRight(text, "\f\n"), "\f")
+
+	var chunks []documentChunk
+	for i, page := range pages {
+		source := name
+		if len(pages) > 1 {
+			source = fmt.Sprintf("%s p.%d", name, i+1)
+		}
+		for _, piece := range chunkText(page, knowledgeChunkSize) {
+			chunks = append(chunks, documentChunk{Source: source, Text: piece})
+		}
+	}
+	return chunks
+}
+
+// attachedDocuments extracts the documents attached to msg so the agent can
+// answer a prompt about them, e.g. "qq summarize this contract" on a PDF.
+// Documents that don't fit DOCUMENT_MAX_TOKENS are cut down to the passages
+// that best match the prompt.
+func (bot *SignalBot) attachedDocuments(ctx context.Context, msg *Message, prompt string) []AgentDocument {
+	if !bot.config.DocumentsEnabled {
+		return nil
+	}
+
+	var chunks []documentChunk
+	for _, a := range msg.extractAttachments() {
+		text, err := bot.extractAttachment(ctx, a)
+		if errors.Is(err, errUnsupportedDocument) {
+			continue
+		}
+		if err != nil {
+			bot.logger.Printf("Error extracting text from %s: %v", attachmentName(a), err)
+			continue
+		}
+		chunks = append(chunks, documentChunks(attachmentName(a), text)...)
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	docs := make([]AgentDocument, len(chunks))
+	for i, chunk := range chunks {
+		docs[i] = AgentDocument{Source: chunk.Source, Text: chunk.Text}
+	}
+	if bot.config.DocumentMaxTokens <= 0 || estimateDocumentTokens(docs) <= bot.config.DocumentMaxTokens {
+		return docs
+	}
+
+	selected := bot.selectPassages(ctx, prompt, docs, bot.config.DocumentMaxTokens)
+	bot.logger.Printf("Attached documents exceed %d tokens, sending %d of %d passages", bot.config.DocumentMaxTokens, len(selected), len(docs))
+	return selected
+}
+
+// estimateDocumentTokens sums the estimated tokens of documents' text
+func estimateDocumentTokens(docs []AgentDocument) int {
+	total := 0
+	for _, doc := range docs {
+		total += estimateTokens(doc.Text)
+	}
+	return total
+}
+
+// selectPassages keeps the passages most similar to prompt that fit within
+// budget tokens, in document order. Without embeddings it keeps the leading
+// passages.
+func (bot *SignalBot) selectPassages(ctx context.Context, prompt string, docs []AgentDocument, budget int) []AgentDocument {
+	texts := make([]string, 0, len(docs)+1)
+	texts = append(texts, prompt)
+	for _, doc := range docs {
+		texts = append(texts, doc.Text)
+	}
+
+	order := make([]int, len(docs))
+	for i := range order {
+		order[i] = i
+	}
+	if vectors, err := bot.embedder.Embed(ctx, texts); err != nil {
+		bot.logger.Printf("Error embedding document passages: %v", err)
+	} else {
+		for i := range docs {
+			docs[i].Score = cosine(vectors[0], vectors[i+1])
+		}
+		sort.SliceStable(order, func(a, b int) bool { return docs[order[a]].Score > docs[order[b]].Score })
+	}
+
+	var picked []int
+	used := 0
+	for _, i := range order {
+		tokens := estimateTokens(docs[i].Text)
+		if used+tokens > budget {
+			continue
+		}
+		picked = append(picked, i)
+		used += tokens
+	}
+	sort.Ints(picked)
+
+	selected := make([]AgentDocument, len(picked))
+	for n, i := range picked {
+		selected[n] = docs[i]
+	}
+	return selected
+}
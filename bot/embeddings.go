@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// embedder turns texts into vectors whose cosine similarity reflects how
+// related the texts are
+type embedder interface {
+	// Name identifies the embedding space; vectors from embedders with
+	// different names can't be compared
+	Name() string
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// newEmbedder returns the configured embedder: an OpenAI-compatible
+// endpoint when EMBEDDINGS_URL is set, the local hashing embedder otherwise
+func (bot *SignalBot) newEmbedder() embedder {
+	if bot.config.EmbeddingsURL == "" {
+		return hashEmbedder{dims: 512}
+	}
+	return &remoteEmbedder{
+		url:    bot.config.EmbeddingsURL,
+		model:  bot.config.EmbeddingsModel,
+		apiKey: bot.config.EmbeddingsAPIKey,
+		client: bot.httpClient,
+	}
+}
+
+// hashEmbedder is a dependency-free local embedder: word and word-pair
+// counts hashed into a fixed number of dimensions. It captures lexical
+// overlap only, which is enough to find the passage a question is about.
+type hashEmbedder struct {
+	dims int
+}
+
+// Name implements embedder
+func (e hashEmbedder) Name() string {
+	return fmt.Sprintf("hash-%d", e.dims)
+}
+
+// Embed implements embedder
+func (e hashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, e.dims)
+		words := embeddingWords(text)
+		for j, word := range words {
+			vector[hashDim(word, e.dims)]++
+			if j > 0 {
+				vector[hashDim(words[j-1]+" "+word, e.dims)] += 0.5
+			}
+		}
+		normalize(vector)
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// embeddingWords lowercases text, folds accents and splits it into words
+// of at least two characters
+func embeddingWords(text string) []string {
+	fields := strings.FieldsFunc(foldDiacritics(strings.ToLower(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	words := fields[:0]
+	for _, f := range fields {
+		if len(f) >= 2 {
+			words = append(words, f)
+		}
+	}
+	return words
+}
+
+func hashDim(s string, dims int) int {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return int(h.Sum32() % uint32(dims))
+}
+
+// remoteEmbedder calls an OpenAI-compatible /embeddings endpoint (OpenAI,
+// Ollama, LocalAI, llama.cpp server, ...)
+type remoteEmbedder struct {
+	url    string
+	model  string
+	apiKey string
+	client *http.Client
+}
+
+// Name implements embedder
+func (e *remoteEmbedder) Name() string {
+	return "remote:" + e.model
+}
+
+// Embed implements embedder
+func (e *remoteEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	payload, err := json.Marshal(map[string]any{"model": e.model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", e.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call embeddings service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embeddings service returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings service returned %d vectors for %d texts", len(result.Data), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embeddings service returned index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+// normalize scales v to unit length in place
+func normalize(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+}
+
+// cosine returns the cosine similarity of two vectors of equal length
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Classes of user-facing error messages, each with its own template
+const (
+	errorTimeout      = "timeout"      // the agent took too long
+	errorAgentDown    = "agent-down"   // the circuit breaker is open
+	errorGeneric      = "error"        // any other agent failure
+	errorRateLimited  = "rate-limited" // the sender is over their rate limit ({{wait}})
+	errorUnauthorized = "unauthorized" // the sender may not use the bot
+	errorModerated    = "moderated"    // moderation refused the prompt
+	errorProfanity    = "profanity"    // the chat's profanity filter refused the prompt
+)
+
+// defaultErrorTemplates are the English messages used unless configured.
+// They are translated like the bot's other messages.
+var defaultErrorTemplates = map[string]string{
+	errorTimeout:      "Sorry, that took too long to answer. Please try again.",
+	errorAgentDown:    "The assistant is temporarily unavailable. Please try again in a few minutes.",
+	errorGeneric:      "Sorry, I encountered an error processing your request.",
+	errorRateLimited:  "Slow down a little, you've asked a lot in a short time. Try again in {{wait}}.",
+	errorUnauthorized: "Sorry, I only answer people my owner has allowed.",
+	errorModerated:    "Sorry, I can't help with that.",
+	errorProfanity:    "Let's keep it friendly in here, please ask that without the language.",
+}
+
+// errorTemplateLegacyKeys are older settings read for a class when its
+// ERROR_<CLASS> is unset
+var errorTemplateLegacyKeys = map[string]string{
+	errorRateLimited:  "RATE_LIMIT_MESSAGE",
+	errorUnauthorized: "ACCESS_REFUSAL",
+}
+
+// readErrorTemplates reads ERROR_<CLASS> and ERROR_<LANG>_<CLASS>, keyed
+// "<class>" and "<lang>.<class>". An empty template means no reply.
+func readErrorTemplates() map[string]string {
+	templates := make(map[string]string)
+	for class, text := range defaultErrorTemplates {
+		name := strings.ToUpper(strings.ReplaceAll(class, "-", "_"))
+		if legacy := errorTemplateLegacyKeys[class]; legacy != "" {
+			text = getEnv(legacy, text)
+		}
+		templates[class] = getEnv("ERROR_"+name, text)
+		for code := range languageNames {
+			if translated := getEnv("ERROR_"+strings.ToUpper(code)+"_"+name, ""); translated != "" {
+				templates[code+"."+class] = translated
+			}
+		}
+	}
+	return templates
+}
+
+// classifyAgentError returns the error class of a failed agent call
+func classifyAgentError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errCircuitOpen):
+		return errorAgentDown
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errorTimeout
+	default:
+		return errorGeneric
+	}
+}
+
+// errorMessage renders the error template of class for a chat: the one
+// configured for the chat's language, else the default one translated when
+// a translation exists. vars fill its placeholders.
+func (bot *SignalBot) errorMessage(chatID, class string, vars map[string]string) string {
+	text, exists := bot.config.ErrorTemplates[bot.chatLanguage(chatID)+"."+class]
+	if !exists {
+		text = bot.localize(chatID, bot.config.ErrorTemplates[class])
+	}
+	return expandPlaceholders(text, vars)
+}
+
+// errorAnswer is the answer to a prompt that failed with err (nil when
+// refused rather than failed), with no replies when the template is empty
+func (bot *SignalBot) errorAnswer(chatID, class string, err error) agentAnswer {
+	result := agentAnswer{Err: err}
+	if text := bot.errorMessage(chatID, class, nil); text != "" {
+		result.Replies = []string{text}
+	}
+	return result
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exportedTurn is one prompt or reply in a JSON export
+type exportedTurn struct {
+	Time string `json:"time"` // RFC 3339 in the asker's timezone
+	Role string `json:"role"` // "user" or "assistant"
+	Text string `json:"text"`
+	at   time.Time
+}
+
+// exportConversation renders who's prompts in a chat and the bot's replies
+// to them, as JSON or as plain text
+func exportConversation(entries []archiveEntry, who AgentSender, asJSON bool, loc *time.Location) ([]byte, int) {
+	var turns []exportedTurn
+	for _, entry := range entries {
+		if (entry.Kind != archiveKindPrompt && entry.Kind != archiveKindReply) || !entry.from(who) {
+			continue
+		}
+		role := "user"
+		if entry.Kind == archiveKindReply {
+			role = "assistant"
+		}
+		at := time.UnixMilli(entry.Timestamp).In(loc)
+		turns = append(turns, exportedTurn{Time: at.Format(time.RFC3339), Role: role, Text: entry.Text, at: at})
+	}
+
+	if asJSON {
+		data, _ := json.MarshalIndent(turns, "", "  ")
+		return data, len(turns)
+	}
+	var b strings.Builder
+	for _, turn := range turns {
+		name := "You"
+		if turn.Role == "assistant" {
+			name = "Bot"
+		}
+		fmt.Fprintf(&b, "[%s] %s: %s\n\n", turn.at.Format("2006-01-02 15:04"), name, turn.Text)
+	}
+	return []byte(b.String()), len(turns)
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "export",
+		usage:   "!export | !export json",
+		handler: exportCommand,
+	})
+}
+
+// exportCommand sends the sender their prompts in this chat and the bot's
+// replies to them as a file from the archive. Exports from groups go to the
+// sender's DM, so nobody else receives them.
+func exportCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	chatID := msg.chatID()
+	if chatID == "" {
+		return "Sorry, I can't tell which chat this is."
+	}
+	if !bot.archives(chatID) {
+		return "Exports come from the archive, which is off for this chat."
+	}
+	asJSON := len(args) > 0 && strings.EqualFold(args[0], "json")
+	if len(args) > 0 && !asJSON && !strings.EqualFold(args[0], "text") {
+		return "Usage: " + commands["export"].usage
+	}
+
+	sender := msg.sender()
+	recipient := msg.replyRecipient()
+	if msg.extractGroupId() != "" {
+		recipient = sender.Number
+	}
+	if recipient == "" {
+		return "Sorry, I can't tell who to send the export to."
+	}
+	loc := bot.userLocation(chatID, &sender)
+
+	bot.replyInBackground(msg, func() string {
+		entries, err := bot.archive.Entries(ctx, chatID, time.Time{})
+		if err != nil {
+			bot.logger.Printf("Error reading the archive for an export: %v", err)
+			return "Sorry, I couldn't read the archive right now."
+		}
+		data, n := exportConversation(entries, sender, asJSON, loc)
+		if n == 0 {
+			return "There's nothing of yours in this chat's archive yet."
+		}
+
+		ext := ".txt"
+		if asJSON {
+			ext = ".json"
+		}
+		dir, err := os.MkdirTemp("", "signalbot-export-")
+		if err != nil {
+			bot.logger.Printf("Error creating export: %v", err)
+			return "Sorry, I couldn't create the export."
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "conversation-"+time.Now().In(loc).Format("2006-01-02")+ext)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			bot.logger.Printf("Error creating export: %v", err)
+			return "Sorry, I couldn't create the export."
+		}
+
+		if err := bot.sendAttachment(recipient, path, fmt.Sprintf("📦 Your conversation with me: %d messages.", n)); err != nil {
+			bot.logger.Printf("Error sending export: %v", err)
+			return "Sorry, I couldn't send the export."
+		}
+		if recipient != msg.replyRecipient() {
+			return "I sent you the export in a direct message."
+		}
+		return ""
+	})
+	return ""
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Feature flags gating risky behaviour per chat
+const (
+	flagDMAutorespond = "dm-autorespond" // answer every DM, trigger or not
+	flagAttachments   = "attachments"    // read attachments: documents, images, voice notes
+	flagTools         = "tools"          // let the agent run bot tools (with AGENT_TOOLS_ENABLED)
+)
+
+// featureFlagDefs lists the flags with their defaults, used unless
+// FLAGS_<NAME> says otherwise
+var featureFlagDefs = map[string]struct {
+	description string
+	enabled     bool
+}{
+	flagDMAutorespond: {"answer every DM without a trigger", false},
+	flagAttachments:   {"read documents, images and voice notes", true},
+	flagTools:         {"let the agent run bot tools", true},
+}
+
+// flagsEverywhere is the override ID that applies to every chat
+const flagsEverywhere = "all"
+
+// flagRollout is the configured state of one flag: on or off by default,
+// and on in the chats listed in FLAGS_<NAME>_CHATS
+type flagRollout struct {
+	enabled bool
+	chats   map[string]bool
+}
+
+// featureFlags decides which flags are on in a chat. Runtime overrides
+// from "!admin flag" are persisted and win over the configuration; a chat's
+// own override wins over one for all chats.
+type featureFlags struct {
+	mu        sync.RWMutex
+	rollouts  map[string]flagRollout
+	overrides *chatSettings // chat ID or flagsEverywhere -> flag -> "on"/"off"
+}
+
+func newFeatureFlags() *featureFlags {
+	return &featureFlags{rollouts: readFlagRollouts(), overrides: newChatSettings("feature_flags")}
+}
+
+// readFlagRollouts reads FLAGS_<NAME> and FLAGS_<NAME>_CHATS for every flag
+func readFlagRollouts() map[string]flagRollout {
+	rollouts := make(map[string]flagRollout)
+	for name, def := range featureFlagDefs {
+		key := "FLAGS_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		rollout := flagRollout{enabled: getEnvBool(key, def.enabled), chats: make(map[string]bool)}
+		for _, chatID := range getEnvList(key+"_CHATS", nil) {
+			rollout.chats[chatID] = true
+		}
+		rollouts[name] = rollout
+	}
+	return rollouts
+}
+
+// SetRollouts replaces the configured flag states after a reload
+func (f *featureFlags) SetRollouts(rollouts map[string]flagRollout) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rollouts = rollouts
+}
+
+// Enabled reports whether a flag is on in a chat
+func (f *featureFlags) Enabled(name, chatID string) bool {
+	for _, id := range []string{chatID, flagsEverywhere} {
+		switch f.overrides.Get(id, name) {
+		case "on":
+			return true
+		case "off":
+			return false
+		}
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	rollout := f.rollouts[name]
+	return rollout.enabled || rollout.chats[chatID]
+}
+
+// Set overrides a flag for a chat or flagsEverywhere; "" removes the
+// override
+func (f *featureFlags) Set(name, chatID, value string) error {
+	if _, known := featureFlagDefs[name]; !known {
+		return fmt.Errorf("unknown flag %q", name)
+	}
+	return f.overrides.Set(chatID, name, value)
+}
+
+// Describe lists every flag with its state in a chat
+func (f *featureFlags) Describe(chatID string) string {
+	names := make([]string, 0, len(featureFlagDefs))
+	for name := range featureFlagDefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		state := "off"
+		if f.Enabled(name, chatID) {
+			state = "on"
+		}
+		source := "config"
+		if f.overrides.Get(chatID, name) != "" {
+			source = "set for this chat"
+		} else if f.overrides.Get(flagsEverywhere, name) != "" {
+			source = "set for all chats"
+		}
+		lines = append(lines, fmt.Sprintf("%s = %s (%s; %s)", name, state, source, featureFlagDefs[name].description))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Overrides lists the runtime overrides as "<flag>=<on|off> (<chat|all>)"
+func (f *featureFlags) Overrides() []string {
+	var overrides []string
+	for name := range featureFlagDefs {
+		for _, id := range f.overrides.IDsWith(name) {
+			overrides = append(overrides, fmt.Sprintf("%s=%s (%s)", name, f.overrides.Get(id, name), id))
+		}
+	}
+	sort.Strings(overrides)
+	return overrides
+}
+
+// flagCommand shows or overrides feature flags; it backs "!admin flags"
+// and "!admin flag". Without a target, the chat the command was sent in is
+// changed.
+func flagCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	usage := "Usage: !admin flags [chat] | !admin flag <name> on|off|default [chat|all]"
+	chatID := msg.chatID()
+	if len(args) == 0 || strings.EqualFold(args[0], "flags") {
+		if len(args) > 1 {
+			chatID = args[1]
+		}
+		return "Feature flags for " + chatID + ":\n" + bot.flags.Describe(chatID)
+	}
+	if len(args) < 3 {
+		return usage
+	}
+
+	name, value := strings.ToLower(args[1]), strings.ToLower(args[2])
+	switch value {
+	case "on", "off":
+	case "default":
+		value = ""
+	default:
+		return usage
+	}
+	if len(args) > 3 {
+		chatID = args[3]
+	}
+	if chatID == "" {
+		return usage
+	}
+
+	if err := bot.flags.Set(name, chatID, value); err != nil {
+		return "Error: " + err.Error()
+	}
+	bot.logger.Printf("Feature flag %s set to %q for %s", name, value, chatID)
+	if value == "" {
+		return fmt.Sprintf("Flag %s back to its default for %s.", name, chatID)
+	}
+	return fmt.Sprintf("Flag %s turned %s for %s.", name, value, chatID)
+}
+
+// chatOf returns the conversation ID of an agent request, "" if unknown
+func chatOf(request AgentRequest) string {
+	if request.Chat == nil {
+		return ""
+	}
+	return conversationID(*request.Chat)
+}
+
+// toolsEnabled reports whether the agent may run tools for a request
+func (bot *SignalBot) toolsEnabled(request AgentRequest) bool {
+	return bot.config.AgentToolsEnabled && bot.flags.Enabled(flagTools, chatOf(request)) &&
+		roleAtLeast(bot.requestRole(request), bot.live.Load().ToolsRole)
+}
+
+// autorespondsTo reports whether a DM someone sent should be answered
+// without a trigger
+func (bot *SignalBot) autorespondsTo(msg *Message) bool {
+	return msg.Envelope.DataMessage.Message != "" && msg.extractGroupId() == "" &&
+		bot.flags.Enabled(flagDMAutorespond, msg.chatID())
+}
+
+// dropAttachments strips the attachments of a message from a chat where
+// the attachments flag is off, so nothing downstream reads them
+func (bot *SignalBot) dropAttachments(msg *Message) {
+	if !bot.flags.Enabled(flagAttachments, msg.chatID()) {
+		msg.Envelope.DataMessage.Attachments = nil
+		msg.Envelope.SyncMessage.SentMessage.Attachments = nil
+	}
+}
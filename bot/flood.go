@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// floodGuard mutes a sender or a group that sends prompts faster than
+// FLOOD_SENDER_THRESHOLD or FLOOD_GROUP_THRESHOLD per FLOOD_WINDOW, for
+// FLOOD_MUTE. Mutes live in memory only; a restart lifts them.
+type floodGuard struct {
+	mu      sync.Mutex
+	window  time.Duration
+	mute    time.Duration
+	prompts map[string][]time.Time // source -> prompts within window
+	muted   map[string]time.Time   // source -> end of its mute
+}
+
+func newFloodGuard(config Config) *floodGuard {
+	return &floodGuard{
+		window:  config.FloodWindow,
+		mute:    config.FloodMute,
+		prompts: make(map[string][]time.Time),
+		muted:   make(map[string]time.Time),
+	}
+}
+
+// Check counts a prompt from source at now against limit (0 = unlimited).
+// It returns when the source's mute ends, zero when it isn't muted, and
+// whether this prompt started the mute.
+func (f *floodGuard) Check(source string, limit int, now time.Time) (until time.Time, started bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if until, muted := f.muted[source]; muted {
+		if now.Before(until) {
+			return until, false
+		}
+		delete(f.muted, source)
+	}
+	if limit <= 0 {
+		return time.Time{}, false
+	}
+
+	cutoff := now.Add(-f.window)
+	kept := f.prompts[source][:0]
+	for _, t := range f.prompts[source] {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	kept = append(kept, now)
+	if len(kept) <= limit {
+		f.prompts[source] = kept
+		return time.Time{}, false
+	}
+
+	delete(f.prompts, source)
+	f.muted[source] = now.Add(f.mute)
+	return f.muted[source], true
+}
+
+// Unmute lifts the mute of source, reporting whether it was muted
+func (f *floodGuard) Unmute(source string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, muted := f.muted[source]
+	delete(f.muted, source)
+	return muted
+}
+
+// Muted lists the sources muted at now with the end of their mutes
+func (f *floodGuard) Muted(now time.Time) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var muted []string
+	for source, until := range f.muted {
+		if now.Before(until) {
+			muted = append(muted, fmt.Sprintf("%s (%s left)", source, formatWait(until.Sub(now))))
+		}
+	}
+	sort.Strings(muted)
+	return muted
+}
+
+// floodSource is a sender or group whose prompts are counted
+type floodSource struct {
+	id           string
+	limit        int
+	announcement string // told to the chat when the source is muted
+}
+
+// flooding reports whether a prompt in msg is dropped because its sender,
+// or the group it was sent in, is muted for flooding. The prompt starting
+// a mute is reported to the admin and, when notify is set, answered with
+// an announcement. Admins are neither counted nor muted.
+func (bot *SignalBot) flooding(msg *Message, notify bool) bool {
+	if bot.isAdmin(msg) {
+		return false
+	}
+
+	now := time.Now()
+	sources := []floodSource{{msg.Envelope.Source, bot.config.FloodSenderThreshold, "You're sending prompts too fast, so I'll ignore yours for %s."}}
+	if groupID := msg.extractGroupId(); groupID != "" {
+		sources = append(sources, floodSource{"group:" + groupID, bot.config.FloodGroupThreshold, "This group is sending prompts too fast, so I'll pause here for %s."})
+	}
+
+	for _, source := range sources {
+		until, started := bot.floods.Check(source.id, source.limit, now)
+		if until.IsZero() {
+			continue
+		}
+		if !started {
+			return true
+		}
+
+		bot.logger.Printf("Flood from %s, muted until %s", source.id, until.Format(time.RFC3339))
+		bot.notifyAdmin(adminEventFlood, fmt.Sprintf("Muted %s for %s after %d prompts within %s. Lift it with !admin unmute %s",
+			source.id, bot.config.FloodMute, source.limit, bot.config.FloodWindow, source.id))
+		if !notify {
+			return true
+		}
+		announcement := bot.localizef(msg.chatID(), source.announcement, formatWait(until.Sub(now)))
+		if err := bot.sendReply(msg.replyRecipient(), announcement, msg.extractTimestamp(), msg.Envelope.Source); err != nil {
+			bot.logger.Printf("Error sending flood announcement: %v", err)
+		}
+		return true
+	}
+	return false
+}
+
+// unmuteCommand lists muted sources or lifts a mute; it backs
+// "!admin unmute [<number|uuid|group:<id>>]"
+func unmuteCommand(bot *SignalBot, args []string) string {
+	if len(args) == 0 {
+		muted := bot.floods.Muted(time.Now())
+		if len(muted) == 0 {
+			return "No one is muted for flooding."
+		}
+		return "Muted for flooding: " + strings.Join(muted, ", ")
+	}
+	if !bot.floods.Unmute(args[0]) {
+		return args[0] + " isn't muted."
+	}
+	bot.logger.Printf("Flood mute of %s lifted", args[0])
+	return args[0] + " is no longer muted."
+}
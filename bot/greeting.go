@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultGreeting introduces the bot to a contact's first DM
+const defaultGreeting = `Hi {{sender}}! I'm an AI assistant. Start a message with "{{trigger}}" to ask me something.
+
+Only messages that start with a trigger are sent to the AI service; everything else stays private. Ask nothing and nothing is shared.`
+
+// rateWindow is a sliding-window rate limiter allowing at most limit
+// events per window
+type rateWindow struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	events []time.Time
+}
+
+func newRateWindow(limit int, window time.Duration) *rateWindow {
+	return &rateWindow{limit: limit, window: window}
+}
+
+// Allow records an event at now and reports whether it is within the limit
+func (w *rateWindow) Allow(now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cutoff := now.Add(-w.window)
+	kept := w.events[:0]
+	for _, t := range w.events {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	w.events = kept
+
+	if len(w.events) >= w.limit {
+		return false
+	}
+	w.events = append(w.events, now)
+	return true
+}
+
+// greetNewContact welcomes a number messaging the bot for the first time.
+// Contacts are remembered in the chat settings whether or not a greeting
+// went out, and greetings are rate limited so number scanners can't turn
+// the bot into a spammer.
+func (bot *SignalBot) greetNewContact(msg *Message) {
+	if !bot.config.GreetingEnabled || msg.Envelope.SyncMessage.SentMessage.Timestamp != 0 {
+		return
+	}
+	if msg.Envelope.DataMessage.Timestamp == 0 || msg.extractGroupId() != "" {
+		return
+	}
+
+	chatID := msg.chatID()
+	if bot.settings.Get(chatID, "greeted") != "" {
+		return
+	}
+
+	now := time.Now()
+	if err := bot.settings.Set(chatID, "greeted", now.Format(time.RFC3339)); err != nil {
+		bot.logger.Printf("Error remembering contact: %v", err)
+	}
+
+	if !bot.greetings.Allow(now) {
+		bot.logger.Printf("Greeting rate limit reached, not greeting %s", msg.Envelope.Source)
+		return
+	}
+
+	sender := msg.sender()
+	vars := promptVars(AgentRequest{Sender: &sender}, now.In(bot.userLocation(chatID, &sender)))
+	vars["trigger"] = bot.config.AIPrefix
+	greeting := expandPlaceholders(bot.config.GreetingMessage, vars)
+
+	bot.logger.Printf("Greeting new contact %s", msg.Envelope.Source)
+	if err := bot.sendReply(msg.replyRecipient(), greeting, 0, ""); err != nil {
+		bot.logger.Printf("Error sending greeting: %v", err)
+	}
+}
+
+func init() {
+	chatSettingDefs["welcome"] = chatSetting{
+		description: "message greeting people who join this group, with {{sender}}, {{group}} and {{trigger}}; off for none",
+		groupAdmin:  true,
+		normalize: func(value string) (string, error) {
+			if strings.EqualFold(value, "off") {
+				return "", nil
+			}
+			return value, nil
+		},
+	}
+
+	registerGroupEventHook("welcome", welcomeMember)
+}
+
+// welcomeMember greets someone who joined a group that set
+// "!set welcome <text>"
+func welcomeMember(ctx context.Context, bot *SignalBot, event GroupEvent) {
+	if event.Type != groupMemberJoined || event.IsSelf {
+		return
+	}
+	chat := AgentChat{GroupID: event.GroupID, GroupName: event.GroupName}
+	chatID := conversationID(chat)
+	welcome := bot.settings.Get(chatID, "welcome")
+	if welcome == "" {
+		return
+	}
+
+	vars := promptVars(AgentRequest{Sender: &event.Member, Chat: &chat}, time.Now().In(bot.userLocation(chatID, &event.Member)))
+	vars["trigger"] = bot.config.AIPrefix
+	bot.logger.Printf("Welcoming %s to group %s", memberKey(event.Member), event.GroupID)
+	if err := bot.sendReply("-g "+event.GroupID, expandPlaceholders(welcome, vars), 0, ""); err != nil {
+		bot.logger.Printf("Error sending welcome: %v", err)
+	}
+}
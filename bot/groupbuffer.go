@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCatchupMessages is how many messages !summarize covers by default
+const defaultCatchupMessages = 50
+
+// catchupPrompt asks the agent for a summary of the buffered messages
+const catchupPrompt = "Give me a short catch-up summary of the group conversation in the document: the main topics, decisions, questions still open and anything addressed to me. Refer to people by name."
+
+// threadPrompt asks for a summary of the conversation after a message
+const threadPrompt = "Summarize the group conversation in the document, which starts at the message I reacted to: what was discussed, what was decided and what is still open. Refer to people by name."
+
+// summaryEmoji asks for a summary from the message it reacts to until now
+const summaryEmoji = "📝"
+
+// bufferedMessage is a group message kept for catch-up summaries
+type bufferedMessage struct {
+	Timestamp int64
+	Sender    AgentSender
+	Text      string
+}
+
+// groupBuffer keeps the latest messages of groups that opted in with
+// "!set summarize on". It lives in memory only, so nothing said in a group
+// is written to disk for it.
+type groupBuffer struct {
+	mu       sync.Mutex
+	size     int
+	messages map[string][]bufferedMessage // chat ID -> messages, oldest first
+}
+
+func newGroupBuffer(size int) *groupBuffer {
+	return &groupBuffer{size: size, messages: make(map[string][]bufferedMessage)}
+}
+
+// Add appends a message to a chat's buffer, dropping the oldest beyond size
+func (b *groupBuffer) Add(chatID string, m bufferedMessage) {
+	if b.size <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	messages := append(b.messages[chatID], m)
+	if len(messages) > b.size {
+		messages = messages[len(messages)-b.size:]
+	}
+	b.messages[chatID] = messages
+}
+
+// Last returns up to n of a chat's most recent messages, oldest first
+func (b *groupBuffer) Last(chatID string, n int) []bufferedMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	messages := b.messages[chatID]
+	if n < len(messages) {
+		messages = messages[len(messages)-n:]
+	}
+	return append([]bufferedMessage(nil), messages...)
+}
+
+// Since returns a chat's messages from timestamp on, oldest first, and
+// whether the message at timestamp is still buffered
+func (b *groupBuffer) Since(chatID string, timestamp int64) ([]bufferedMessage, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	messages := b.messages[chatID]
+	for i, m := range messages {
+		if m.Timestamp >= timestamp {
+			return append([]bufferedMessage(nil), messages[i:]...), m.Timestamp == timestamp
+		}
+	}
+	return nil, false
+}
+
+// Clear drops a chat's buffer
+func (b *groupBuffer) Clear(chatID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.messages, chatID)
+}
+
+// CountBySender returns the number of buffered messages sent by who
+func (b *groupBuffer) CountBySender(who AgentSender) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	count := 0
+	for _, messages := range b.messages {
+		for _, m := range messages {
+			if sameSender(m.Sender, who) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// ForgetSender drops every buffered message sent by who
+func (b *groupBuffer) ForgetSender(who AgentSender) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for chatID, messages := range b.messages {
+		kept := messages[:0]
+		for _, m := range messages {
+			if !sameSender(m.Sender, who) {
+				kept = append(kept, m)
+			}
+		}
+		b.messages[chatID] = kept
+	}
+}
+
+// bufferGroupMessage records a group message for !summarize when the group
+// opted in; commands aren't part of the conversation and are skipped
+func (bot *SignalBot) bufferGroupMessage(msg *Message) {
+	if msg.extractGroupId() == "" {
+		return
+	}
+	content := msg.extractContent()
+	if content == "" || strings.HasPrefix(content, "!") {
+		return
+	}
+	chatID := msg.chatID()
+	if bot.settings.Get(chatID, "summarize") != "on" {
+		bot.groupBuffer.Clear(chatID)
+		return
+	}
+	bot.groupBuffer.Add(chatID, bufferedMessage{Timestamp: msg.extractTimestamp(), Sender: msg.sender(), Text: content})
+}
+
+// transcript renders buffered messages as "[15:04] Name: text" lines, with
+// times in loc
+func transcript(messages []bufferedMessage, loc *time.Location) string {
+	var b strings.Builder
+	for _, m := range messages {
+		name := m.Sender.Name
+		if name == "" {
+			name = m.Sender.Number
+		}
+		fmt.Fprintf(&b, "[%s] %s: %s\n", time.UnixMilli(m.Timestamp).In(loc).Format("15:04"), name, m.Text)
+	}
+	return b.String()
+}
+
+// summarizeMessages asks the agent, in the background, for a catch-up
+// summary of messages and sends it to target. The messages are passed as a
+// document so follow-up questions can refer to the summary without the
+// whole transcript landing in the conversation history.
+func (bot *SignalBot) summarizeMessages(ctx context.Context, msg *Message, prompt, source string, messages []bufferedMessage, target replyTarget) {
+	if !bot.promptAllowed(msg, true) {
+		return
+	}
+	request := msg.newAgentRequest(prompt)
+	request.quota = bot.hasQuota(msg)
+	loc := bot.userLocation(msg.chatID(), request.Sender)
+	request.Documents = []AgentDocument{{Source: source, Text: transcript(messages, loc)}}
+	bot.answerAsync(ctx, request, target)
+}
+
+func init() {
+	chatSettingDefs["summarize"] = chatSetting{
+		description: "on/off: keep this group's recent messages in memory for !summarize",
+		normalize: func(value string) (string, error) {
+			switch strings.ToLower(value) {
+			case "on", "off":
+				return strings.ToLower(value), nil
+			}
+			return "", fmt.Errorf("summarize must be on or off")
+		},
+	}
+
+	registerCommand(&command{
+		name:    "summarize",
+		usage:   "!summarize | !summarize <number of messages>",
+		handler: summarizeCommand,
+	})
+
+	registerReactionHandler(summaryEmoji, summaryReaction)
+
+	userDataCategories["messages"] = userDataCategory{
+		description: "your recent group messages kept in memory for !summarize",
+		count: func(bot *SignalBot, who AgentSender) int {
+			return bot.groupBuffer.CountBySender(who)
+		},
+		forget: func(bot *SignalBot, who AgentSender) error {
+			bot.groupBuffer.ForgetSender(who)
+			return nil
+		},
+	}
+}
+
+// summarizeCommand sends a catch-up summary of a group's recent messages
+func summarizeCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	if msg.extractGroupId() == "" {
+		return "!summarize works in groups."
+	}
+	chatID := msg.chatID()
+	if bot.settings.Get(chatID, "summarize") != "on" {
+		return "I'm not keeping this group's messages. Turn it on with \"!set summarize on\" and I'll be able to catch you up from then on."
+	}
+
+	n := defaultCatchupMessages
+	if len(args) > 0 {
+		parsed, err := strconv.Atoi(args[0])
+		if err != nil || parsed < 1 {
+			return "Usage: " + commands["summarize"].usage
+		}
+		n = parsed
+	}
+
+	messages := bot.groupBuffer.Last(chatID, n)
+	if len(messages) == 0 {
+		return "Nothing to summarize yet."
+	}
+	source := fmt.Sprintf("last %d messages of this group", len(messages))
+	bot.summarizeMessages(ctx, msg, catchupPrompt, source, messages, replyTarget{
+		Recipient:      msg.replyRecipient(),
+		QuoteTimestamp: msg.extractTimestamp(),
+		QuoteAuthor:    msg.Envelope.Source,
+	})
+	return ""
+}
+
+// summaryReaction summarizes a group's conversation from the message that
+// got the reaction until now, as a reply quoting that message or, with
+// SUMMARY_REACTION_DELIVERY=dm, as a DM to whoever reacted
+func summaryReaction(ctx context.Context, bot *SignalBot, msg *Message, reaction *Reaction) {
+	chatID := msg.chatID()
+	if msg.extractGroupId() == "" || bot.settings.Get(chatID, "summarize") != "on" {
+		return
+	}
+
+	target := replyTarget{
+		Recipient:      msg.replyRecipient(),
+		QuoteTimestamp: reaction.TargetSentTimestamp,
+		QuoteAuthor:    reaction.TargetAuthor,
+	}
+	if bot.config.SummaryReactionDelivery == "dm" {
+		target = replyTarget{Recipient: msg.sender().Number}
+	}
+
+	messages, found := bot.groupBuffer.Since(chatID, reaction.TargetSentTimestamp)
+	if !found {
+		if err := bot.sendReply(target.Recipient, "I don't have that message anymore, so I can't summarize from there. Try !summarize instead.", target.QuoteTimestamp, target.QuoteAuthor); err != nil {
+			bot.logger.Printf("Error sending reply: %v", err)
+		}
+		return
+	}
+
+	sender := msg.sender()
+	since := time.UnixMilli(reaction.TargetSentTimestamp).In(bot.userLocation(chatID, &sender))
+	source := fmt.Sprintf("messages of this group since %s", since.Format("15:04"))
+	bot.summarizeMessages(ctx, msg, threadPrompt, source, messages, target)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Group membership event types
+const (
+	groupMemberJoined = "member_joined"
+	groupMemberLeft   = "member_left"
+	groupAdminAdded   = "admin_added"
+	groupAdminRemoved = "admin_removed"
+)
+
+// GroupEvent is a membership change in a group the bot is in
+type GroupEvent struct {
+	Type      string      `json:"type"`
+	GroupID   string      `json:"group_id"`
+	GroupName string      `json:"group_name,omitempty"`
+	Member    AgentSender `json:"member"`
+	IsSelf    bool        `json:"is_self"` // the change concerns the bot's own account
+}
+
+// groupEventHook reacts to membership changes; hooks run in name order on
+// the receiving goroutine
+type groupEventHook func(ctx context.Context, bot *SignalBot, event GroupEvent)
+
+// groupEventHooks holds every registered hook by name. Features that care
+// about membership register from init(), like the group welcome:
+//
+//	func init() { registerGroupEventHook("welcome", welcomeMember) }
+var groupEventHooks = map[string]groupEventHook{}
+
+// registerGroupEventHook adds a hook that sees every membership change
+func registerGroupEventHook(name string, hook groupEventHook) {
+	groupEventHooks[name] = hook
+}
+
+// signalGroup is a group as listed by "signal-cli listGroups -d"
+type signalGroup struct {
+	ID      string        `json:"id"`
+	Name    string        `json:"name"`
+	Members []AgentSender `json:"members"`
+	Admins  []AgentSender `json:"admins"`
+}
+
+// groupRoster is the last known membership of each group, used to turn
+// signal-cli's "group updated" envelopes into join/leave events
+type groupRoster struct {
+	mu     sync.Mutex
+	groups map[string]signalGroup
+}
+
+func newGroupRoster() *groupRoster {
+	return &groupRoster{groups: make(map[string]signalGroup)}
+}
+
+// Get returns the last known membership of a group
+func (r *groupRoster) Get(groupID string) (signalGroup, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	group, known := r.groups[groupID]
+	return group, known
+}
+
+// Update stores the current membership of a group and returns the events
+// since the previous one. The first sighting of a group yields no events.
+func (r *groupRoster) Update(group signalGroup, self string) []GroupEvent {
+	r.mu.Lock()
+	previous, known := r.groups[group.ID]
+	r.groups[group.ID] = group
+	r.mu.Unlock()
+
+	if !known {
+		return nil
+	}
+
+	var events []GroupEvent
+	add := func(eventType string, member AgentSender) {
+		events = append(events, GroupEvent{
+			Type:      eventType,
+			GroupID:   group.ID,
+			GroupName: group.Name,
+			Member:    member,
+			IsSelf:    self != "" && member.Number == self,
+		})
+	}
+	for _, m := range diffMembers(group.Members, previous.Members) {
+		add(groupMemberJoined, m)
+	}
+	for _, m := range diffMembers(previous.Members, group.Members) {
+		add(groupMemberLeft, m)
+	}
+	for _, m := range diffMembers(group.Admins, previous.Admins) {
+		add(groupAdminAdded, m)
+	}
+	for _, m := range diffMembers(previous.Admins, group.Admins) {
+		add(groupAdminRemoved, m)
+	}
+	return events
+}
+
+// memberKey identifies a member by UUID, falling back to the number
+func memberKey(m AgentSender) string {
+	if m.UUID != "" {
+		return m.UUID
+	}
+	return m.Number
+}
+
+// diffMembers returns the members of a that aren't in b
+func diffMembers(a, b []AgentSender) []AgentSender {
+	inB := make(map[string]bool, len(b))
+	for _, m := range b {
+		inB[memberKey(m)] = true
+	}
+	var diff []AgentSender
+	for _, m := range a {
+		if !inB[memberKey(m)] {
+			diff = append(diff, m)
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool { return memberKey(diff[i]) < memberKey(diff[j]) })
+	return diff
+}
+
+// listGroupDetails runs "signal-cli listGroups -d", optionally for one
+// group, within SIGNAL_SEND_TIMEOUT
+func (bot *SignalBot) listGroupDetails(ctx context.Context, groupID string) ([]signalGroup, error) {
+	args := []string{"--output=json", "listGroups", "-d"}
+	if groupID != "" {
+		args = append(args, "-g", groupID)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := runSignalCLI(ctx, bot.config.SignalSendTimeout, &stdout, &stderr, args...); err != nil {
+		return nil, fmt.Errorf("failed to list groups: %w (stderr: %s)", err, stderr.String())
+	}
+
+	var groups []signalGroup
+	if err := json.Unmarshal(stdout.Bytes(), &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse group list: %w", err)
+	}
+	return groups, nil
+}
+
+// loadGroupRoster records the current membership of every group, so the
+// first update after startup can already be diffed
+func (bot *SignalBot) loadGroupRoster(ctx context.Context) error {
+	groups, err := bot.listGroupDetails(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		bot.roster.Update(group, bot.config.SignalAccount)
+	}
+	return nil
+}
+
+// handleGroupUpdate refreshes a group's membership after signal-cli
+// reported a change and dispatches the resulting events to every hook
+func (bot *SignalBot) handleGroupUpdate(ctx context.Context, groupID string) {
+	groups, err := bot.listGroupDetails(ctx, groupID)
+	if err != nil {
+		bot.logger.Printf("Error refreshing group membership: %v", err)
+		return
+	}
+
+	for _, group := range groups {
+		if group.ID != groupID {
+			continue
+		}
+		for _, event := range bot.roster.Update(group, bot.config.SignalAccount) {
+			bot.logger.Printf("Group event %s in %s: %s", event.Type, event.GroupID, memberKey(event.Member))
+
+			names := make([]string, 0, len(groupEventHooks))
+			for name := range groupEventHooks {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				groupEventHooks[name](ctx, bot, event)
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// agentHealth records the outcome of the latest agent health probe
+type agentHealth struct {
+	mu        sync.RWMutex
+	probed    bool
+	healthy   bool
+	lastCheck time.Time
+	lastError string
+}
+
+// set stores a probe result
+func (h *agentHealth) set(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.probed = true
+	h.healthy = err == nil
+	h.lastCheck = time.Now()
+	h.lastError = ""
+	if err != nil {
+		h.lastError = err.Error()
+	}
+}
+
+// Healthy reports the last probe result; true until the first probe runs
+func (h *agentHealth) Healthy() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return !h.probed || h.healthy
+}
+
+// String summarizes the probe state for status output
+func (h *agentHealth) String() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	switch {
+	case !h.probed:
+		return "not probed"
+	case h.healthy:
+		return fmt.Sprintf("healthy (checked %s ago)", time.Since(h.lastCheck).Round(time.Second))
+	default:
+		return fmt.Sprintf("unhealthy (checked %s ago: %s)", time.Since(h.lastCheck).Round(time.Second), h.lastError)
+	}
+}
+
+// probeAgent performs a single GET against AGENT_HEALTH_URL
+func (bot *SignalBot) probeAgent(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", bot.config.AgentHealthURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create health request: %w", err)
+	}
+	if bot.config.AgentToken != "" {
+		req.Header.Set("Authorization", "Bearer "+bot.config.AgentToken)
+	}
+
+	resp, err := bot.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("health probe failed: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return &agentStatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// runHealthProbe polls the agent health endpoint and feeds the results into
+// the circuit breaker, so an outage opens it before users hit it
+func (bot *SignalBot) runHealthProbe(ctx context.Context) error {
+	ticker := time.NewTicker(bot.config.AgentHealthInterval)
+	defer ticker.Stop()
+
+	for {
+		err := bot.probeAgent(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		wasHealthy := bot.health.Healthy()
+		bot.health.set(err)
+		if err != nil {
+			bot.breaker.Trip()
+			if wasHealthy {
+				bot.logger.Printf("Agent health probe failing: %v", err)
+			}
+		} else {
+			if !wasHealthy {
+				bot.logger.Printf("Agent health probe recovered")
+				bot.breaker.Success()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ready reports whether the bot can currently answer prompts
+func (bot *SignalBot) ready() bool {
+	return bot.health.Healthy() && bot.breaker.State() != breakerOpen
+}
+
+// runHealthServer serves /healthz (liveness), /readyz (agent reachable) and
+// /metrics (queue depths in the Prometheus text format)
+func (bot *SignalBot) runHealthServer(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		if !bot.ready() {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"agent":   bot.health.String(),
+			"breaker": bot.breaker.State(),
+		})
+	})
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		snapshot := bot.snapshotState()
+		names := make([]string, 0, len(snapshot.QueueDepths))
+		for name := range snapshot.QueueDepths {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintln(w, "# TYPE signalbot_queue_depth gauge")
+		for _, name := range names {
+			fmt.Fprintf(w, "signalbot_queue_depth{queue=%q} %d\n", name, snapshot.QueueDepths[name])
+		}
+		fmt.Fprintln(w, "# TYPE signalbot_work_queue_dropped_total counter")
+		fmt.Fprintf(w, "signalbot_work_queue_dropped_total %d\n", bot.work.Dropped())
+	})
+
+	server := &http.Server{Addr: bot.config.HealthAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	bot.logger.Printf("Health server listening on %s", bot.config.HealthAddr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "status",
+		usage:   "!status",
+		handler: statusCommand,
+	})
+}
+
+// statusCommand reports agent health and bot internals
+func statusCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	snapshot := bot.snapshotState()
+	lines := []string{
+		"Agent: " + bot.health.String(),
+		"Circuit breaker: " + bot.breaker.State(),
+		fmt.Sprintf("In-flight calls: %d (%d waiting)", snapshot.InFlightCalls, snapshot.QueueDepths["agent_wait"]),
+		fmt.Sprintf("Pending DM prompts: %d", snapshot.QueueDepths["pending_dm"]),
+		fmt.Sprintf("Scheduled messages: %d", snapshot.QueueDepths["scheduled"]),
+		"Throttled sends: " + bot.outbox.String(),
+		"Outbox: " + bot.outboxStatus(),
+		"Work queue: " + bot.workQueueStatus(),
+		"signal-cli: " + bot.watch.String(),
+		"Subsystems: " + bot.switches.String(),
+		"Restarts: " + bot.supervisor.String(),
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+)
+
+// inboxKey is the state value holding the envelopes received but not yet
+// processed: the work queue and the message being worked on. signal-cli
+// acknowledges envelopes as it hands them over, so a crash would otherwise
+// lose them.
+const inboxKey = "inbox"
+
+// highWaterKey names the state value holding the newest envelope timestamp
+// processed from one sender device. Timestamps come from the sender's
+// clock, so each device gets its own mark rather than one global one.
+func highWaterKey(msg *Message) string {
+	source := msg.Envelope.SourceUuid
+	if source == "" {
+		source = msg.Envelope.Source
+	}
+	return "hwm:" + source + "." + strconv.Itoa(msg.Envelope.SourceDevice)
+}
+
+// highWater returns the newest envelope timestamp processed from a
+// message's sender device, or 0 before the first one
+func (bot *SignalBot) highWater(msg *Message) int64 {
+	value, err := bot.state.Get(highWaterKey(msg))
+	if err != nil {
+		bot.logger.Printf("Error reading high-water mark: %v", err)
+		return 0
+	}
+	mark, _ := strconv.ParseInt(value, 10, 64)
+	return mark
+}
+
+// advanceHighWater raises the sender device's mark to a processed message
+func (bot *SignalBot) advanceHighWater(msg *Message) {
+	if msg.dedupKey() == "" || msg.Envelope.Timestamp <= bot.highWater(msg) {
+		return
+	}
+	if err := bot.state.Set(highWaterKey(msg), strconv.FormatInt(msg.Envelope.Timestamp, 10)); err != nil {
+		bot.logger.Printf("Error saving high-water mark: %v", err)
+	}
+}
+
+// handleMessage processes a received message unless it was processed
+// before, then advances the high-water mark past it
+func (bot *SignalBot) handleMessage(ctx context.Context, msg Message) {
+	if bot.alreadyProcessed(&msg) {
+		bot.logger.Printf("Skipping already processed message %s", msg.dedupKey())
+		return
+	}
+	bot.processMessage(ctx, msg)
+	bot.advanceHighWater(&msg)
+}
+
+// saveInbox persists what is left to process, see inboxMessages
+func (bot *SignalBot) saveInbox() {
+	bot.inboxMu.Lock()
+	defer bot.inboxMu.Unlock()
+
+	messages := bot.inboxMessages()
+	value := ""
+	if len(messages) > 0 {
+		data, err := json.Marshal(messages)
+		if err != nil {
+			bot.logger.Printf("Error encoding inbox: %v", err)
+			return
+		}
+		value = string(data)
+	}
+	if err := bot.state.Set(inboxKey, value); err != nil {
+		bot.logger.Printf("Error saving inbox: %v", err)
+	}
+}
+
+// replayInbox processes what a previous run had received but not finished
+// when it stopped. Messages at or below their sender's
+// high-water mark were finished and are skipped; the others are processed
+// even if that run had started on them, as their replies may not have gone
+// out.
+func (bot *SignalBot) replayInbox(ctx context.Context) {
+	value, err := bot.state.Get(inboxKey)
+	if err != nil {
+		bot.logger.Printf("Error reading inbox: %v", err)
+		return
+	}
+	if value == "" {
+		return
+	}
+
+	var messages []Message
+	if err := json.Unmarshal([]byte(value), &messages); err != nil {
+		bot.logger.Printf("Discarding unreadable inbox: %v", err)
+		bot.saveInbox()
+		return
+	}
+
+	// A message moving into the work queue can be saved twice
+	seen := make(map[string]bool)
+	replayed, skipped := 0, 0
+	for _, msg := range messages {
+		if ctx.Err() != nil {
+			return
+		}
+		key := msg.dedupKey()
+		if key != "" && (seen[key] || msg.Envelope.Timestamp <= bot.highWater(&msg)) {
+			skipped++
+			continue
+		}
+		seen[key] = true
+		err := runGuarded(ctx, func(ctx context.Context) error {
+			bot.processMessage(ctx, msg)
+			return nil
+		})
+		if err != nil {
+			bot.logger.Printf("Error processing replayed message: %v", err)
+		}
+		bot.advanceHighWater(&msg)
+		replayed++
+	}
+	bot.logger.Printf("Resumed the interrupted inbox: %d messages processed, %d already done", replayed, skipped)
+	bot.saveInbox()
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// conversationHistory keeps the most recent turns of each conversation so
+// they can be sent to v2 agents. Turns are persisted in the state store, so
+// follow-up questions keep working across restarts.
+type conversationHistory struct {
+	mu        sync.Mutex
+	store     stateStore // nil keeps history in memory only
+	retention historyRetention
+	turns     map[string][]AgentTurn // conversation ID -> turns, oldest first
+	locks     map[string]*conversationLock
+}
+
+// historyRetention bounds what is stored per conversation. Zero MaxTurns
+// disables history; zero MaxAge or MaxTokens means no limit of that kind.
+// MaxAgeOf, when set, returns a shorter MaxAge of one conversation.
+type historyRetention struct {
+	MaxTurns  int
+	MaxAge    time.Duration
+	MaxTokens int
+	MaxAgeOf  func(id string) time.Duration
+}
+
+// conversationChatID returns the chat a conversation or group thread
+// ("group:<id>:<asker>") belongs to
+func conversationChatID(id string) string {
+	if rest, isGroup := strings.CutPrefix(id, "group:"); isGroup {
+		groupID, _, _ := strings.Cut(rest, ":")
+		return "group:" + groupID
+	}
+	return id
+}
+
+// historyMaxAge returns the "!set history_retention" of a conversation's
+// chat, 0 when unset
+func (bot *SignalBot) historyMaxAge(id string) time.Duration {
+	value := bot.settings.Get(conversationChatID(id), "history_retention")
+	if value == "" {
+		return 0
+	}
+	d, _ := parseRetention(value)
+	return d
+}
+
+// conversationLock serializes one conversation's read-call-record cycle
+type conversationLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// newConversationHistory creates a history that keeps each conversation
+// within retention
+func newConversationHistory(retention historyRetention) *conversationHistory {
+	return &conversationHistory{
+		retention: retention,
+		turns:     make(map[string][]AgentTurn),
+		locks:     make(map[string]*conversationLock),
+	}
+}
+
+// Lock holds a conversation's context from reading its history until its
+// new turns are recorded, so simultaneous prompts in one chat can't build
+// on the same stale history and interleave their updates. It returns the
+// unlock function and is a no-op while history is disabled.
+func (h *conversationHistory) Lock(id string) func() {
+	if id == "" || h.retention.MaxTurns <= 0 {
+		return func() {}
+	}
+
+	h.mu.Lock()
+	lock, exists := h.locks[id]
+	if !exists {
+		lock = &conversationLock{}
+		h.locks[id] = lock
+	}
+	lock.refs++
+	h.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		h.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(h.locks, id)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// Load reads the history from store, which later changes are saved to,
+// dropping turns that have expired meanwhile
+func (h *conversationHistory) Load(store stateStore) error {
+	if h.retention.MaxTurns <= 0 {
+		return nil
+	}
+
+	turns, err := store.Conversations()
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.store = store
+	h.turns = turns
+	for id := range h.turns {
+		if h.trim(id, time.Now()) {
+			if err := h.save(id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Recent returns a copy of the current turns for a conversation
+func (h *conversationHistory) Recent(id string) []AgentTurn {
+	if id == "" || h.retention.MaxTurns <= 0 {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.trim(id, time.Now())
+	return append([]AgentTurn(nil), h.turns[id]...)
+}
+
+// Record appends a prompt and its replies to a conversation and persists
+// the history
+func (h *conversationHistory) Record(id, prompt string, replies []string) error {
+	if id == "" || h.retention.MaxTurns <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.turns[id] = append(h.turns[id],
+		AgentTurn{Role: "user", Content: prompt, Timestamp: now.UnixMilli()},
+		AgentTurn{Role: "assistant", Content: strings.Join(replies, "\n"), Timestamp: now.UnixMilli()},
+	)
+	h.trim(id, now)
+	return h.save(id)
+}
+
+// Compact replaces the oldest n turns of a conversation with summary and
+// persists the history
+func (h *conversationHistory) Compact(id string, n int, summary AgentTurn) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	turns := h.turns[id]
+	if n > len(turns) {
+		n = len(turns)
+	}
+	h.turns[id] = append([]AgentTurn{summary}, turns[n:]...)
+	return h.save(id)
+}
+
+// trim drops a conversation's oldest turns until it fits the retention
+// policy, reporting whether anything was dropped; callers must hold h.mu
+func (h *conversationHistory) trim(id string, now time.Time) bool {
+	turns := h.turns[id]
+	before := len(turns)
+	maxAge := h.retention.MaxAge
+	if h.retention.MaxAgeOf != nil {
+		if chat := h.retention.MaxAgeOf(id); chat > 0 && (maxAge <= 0 || chat < maxAge) {
+			maxAge = chat
+		}
+	}
+	if maxAge > 0 {
+		cutoff := now.Add(-maxAge).UnixMilli()
+		for len(turns) > 0 && turns[0].Timestamp < cutoff {
+			turns = turns[1:]
+		}
+	}
+	if len(turns) > h.retention.MaxTurns {
+		turns = turns[len(turns)-h.retention.MaxTurns:]
+	}
+	if h.retention.MaxTokens > 0 {
+		for len(turns) > 0 && estimateTurnTokens(turns) > h.retention.MaxTokens {
+			turns = turns[1:]
+		}
+	}
+
+	if len(turns) == 0 {
+		delete(h.turns, id)
+	} else {
+		h.turns[id] = turns
+	}
+	return len(turns) != before
+}
+
+// Prune applies the retention policy to every conversation, persisting
+// those that shrank, and returns how many turns each of them lost
+func (h *conversationHistory) Prune(now time.Time) (map[string]int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pruned := make(map[string]int)
+	for id := range h.turns {
+		before := len(h.turns[id])
+		if h.trim(id, now) {
+			pruned[id] = before - len(h.turns[id])
+			if err := h.save(id); err != nil {
+				return pruned, err
+			}
+		}
+	}
+	return pruned, nil
+}
+
+// save persists one conversation; callers must hold h.mu
+func (h *conversationHistory) save(id string) error {
+	if h.store == nil {
+		return nil
+	}
+	return h.store.SaveConversation(id, h.turns[id])
+}
+
+// Count returns the number of stored turns in conversations matching match
+func (h *conversationHistory) Count(match func(id string) bool) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	count := 0
+	for id, turns := range h.turns {
+		if match(id) {
+			count += len(turns)
+		}
+	}
+	return count
+}
+
+// Matching returns copies of the turns of every conversation matching
+// match, oldest first
+func (h *conversationHistory) Matching(match func(id string) bool) []AgentTurn {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var turns []AgentTurn
+	for id, conversation := range h.turns {
+		if match(id) {
+			turns = append(turns, conversation...)
+		}
+	}
+	sort.SliceStable(turns, func(i, j int) bool { return turns[i].Timestamp < turns[j].Timestamp })
+	return turns
+}
+
+// Forget drops every conversation matching match and persists the change
+func (h *conversationHistory) Forget(match func(id string) bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for id := range h.turns {
+		if match(id) {
+			delete(h.turns, id)
+			if err := h.save(id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runHistoryPruner applies the retention policy every HISTORY_PRUNE_INTERVAL,
+// so idle conversations expire without waiting for their next message.
+// What it drops is recorded in the audit trail per chat.
+func (bot *SignalBot) runHistoryPruner(ctx context.Context) error {
+	if bot.config.HistoryPruneInterval <= 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	ticker := time.NewTicker(bot.config.HistoryPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			pruned, err := bot.history.Prune(now)
+			if err != nil {
+				bot.logger.Printf("Error saving pruned history: %v", err)
+			}
+			if len(pruned) > 0 {
+				bot.logger.Printf("Pruned history of %d conversations", len(pruned))
+			}
+			turns := make(map[string]int)
+			for id, n := range pruned {
+				turns[conversationChatID(id)] += n
+			}
+			for chatID, n := range turns {
+				bot.audit(auditEntry{Event: auditPurge, Kind: "history", ChatID: chatID, Count: n})
+			}
+		}
+	}
+}
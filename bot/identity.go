@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Trust policies for new identity keys
+const (
+	trustAlways       = "always"         // trust every new key, even a changed one
+	trustFirstUseOnly = "first-use-only" // trust new contacts, hold changed keys for the owner
+	trustNever        = "never"          // the owner trusts every key by hand
+)
+
+// signalTrustModes maps trust policies to signal-cli's --trust-new-identities
+var signalTrustModes = map[string]string{
+	trustAlways:       "always",
+	trustFirstUseOnly: "on-first-use",
+	trustNever:        "never",
+}
+
+// trustArgs returns the signal-cli options applying SIGNAL_TRUST_POLICY,
+// followed by args
+func (bot *SignalBot) trustArgs(args ...string) []string {
+	return append([]string{"--trust-new-identities", signalTrustModes[bot.config.SignalTrustPolicy]}, args...)
+}
+
+// identityChanges remembers the contacts whose safety number change was
+// already reported, so a burst of undecryptable messages means one alert
+type identityChanges struct {
+	mu       sync.Mutex
+	reported map[string]bool
+}
+
+// Report records a change for contact, reporting whether it is news
+func (c *identityChanges) Report(contact string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reported[contact] {
+		return false
+	}
+	if c.reported == nil {
+		c.reported = make(map[string]bool)
+	}
+	c.reported[contact] = true
+	return true
+}
+
+// Clear forgets a contact once messages from it decrypt again
+func (c *identityChanges) Clear(contact string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.reported, contact)
+}
+
+// identityChanged reports whether signal-cli couldn't take msg because the
+// sender's identity key changed
+func (msg *Message) identityChanged() bool {
+	return msg.Exception != nil && strings.Contains(strings.ToLower(msg.Exception.Type), "untrustedidentity")
+}
+
+// watchIdentities tells the owner, and with IDENTITY_CHANGE_NOTIFY_CHAT the
+// contact too, when a received envelope shows a contact's safety number
+// changed: the bot has no Signal UI to show the warning in. Under the
+// always trust policy the new key is trusted instead.
+func (bot *SignalBot) watchIdentities(messages []Message) {
+	for i := range messages {
+		msg := &messages[i]
+		contact := msg.Envelope.Source
+		if contact == "" {
+			continue
+		}
+		if !msg.identityChanged() {
+			if msg.Exception == nil {
+				bot.identities.Clear(contact)
+			}
+			continue
+		}
+		if !bot.identities.Report(contact) {
+			continue
+		}
+
+		name := contact
+		if msg.Envelope.SourceName != "" {
+			name = fmt.Sprintf("%s (%s)", msg.Envelope.SourceName, contact)
+		}
+		bot.logger.Printf("Safety number of %s changed: %s", contact, msg.Exception.Message)
+		if bot.config.SignalTrustPolicy == trustAlways && bot.trustIdentity(contact) {
+			bot.identities.Clear(contact)
+			bot.notifyAdmin(adminEventIdentity, fmt.Sprintf("🔐 The safety number of %s changed; I trusted the new one (SIGNAL_TRUST_POLICY=always).", name))
+			continue
+		}
+		bot.notifyAdmin(adminEventIdentity, fmt.Sprintf("🔐 The safety number of %s changed, so I can't read their messages until it is trusted. "+
+			"Once you've verified it, run \"signal-cli trust -a %s\".", name, contact))
+		if bot.config.IdentityChangeNotifyChat {
+			if err := bot.sendReply(contact, "🔐 Your safety number with me changed. I'll be able to read your messages again once my owner has verified it.", 0, ""); err != nil {
+				bot.logger.Printf("Error telling %s about the safety number change: %v", contact, err)
+			}
+		}
+	}
+}
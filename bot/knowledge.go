@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// AgentDocument is a snippet of a previously shared file that is relevant
+// to the prompt
+type AgentDocument struct {
+	Source string  `json:"source"`
+	Text   string  `json:"text"`
+	Score  float64 `json:"score,omitempty"`
+}
+
+// KnowledgeChunk is a piece of a remembered document with its embedding
+type KnowledgeChunk struct {
+	Source      string    `json:"source"`
+	Text        string    `json:"text"`
+	Vector      []float32 `json:"vector"`
+	AddedBy     string    `json:"added_by,omitempty"`
+	AddedByUUID string    `json:"added_by_uuid,omitempty"`
+	AddedAt     time.Time `json:"added_at"`
+}
+
+// addedBy reports whether who asked for the chunk to be remembered
+func (c KnowledgeChunk) addedBy(who AgentSender) bool {
+	return (who.UUID != "" && c.AddedByUUID == who.UUID) || (who.Number != "" && c.AddedBy == who.Number)
+}
+
+// textExtractor pulls plain text out of a downloaded attachment
+type textExtractor func(ctx context.Context, bot *SignalBot, path string) (string, error)
+
+// textExtractors maps attachment content types to their extractor
+var textExtractors = map[string]textExtractor{
+	"text/plain":       readTextFile,
+	"text/markdown":    readTextFile,
+	"text/csv":         readTextFile,
+	"text/html":        readTextFile,
+	"application/json": readTextFile,
+}
+
+func readTextFile(ctx context.Context, bot *SignalBot, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// chunkText splits text into pieces of about size characters, breaking at
+// paragraph and then word boundaries
+func chunkText(text string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(current.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		current.Reset()
+	}
+
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		for _, word := range strings.Fields(paragraph) {
+			if current.Len()+len(word)+1 > size {
+				flush()
+			}
+			if current.Len() > 0 {
+				current.WriteByte(' ')
+			}
+			current.WriteString(word)
+		}
+		if current.Len() >= size/2 {
+			flush()
+		} else if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+	}
+	flush()
+	return chunks
+}
+
+// knowledgeChunkSize is the approximate length of a stored chunk in characters
+const knowledgeChunkSize = 1000
+
+// isRememberPrompt reports whether a prompt asks the bot to store the
+// attached documents
+func isRememberPrompt(prompt string) bool {
+	p := strings.ToLower(strings.TrimRight(strings.TrimSpace(prompt), ".!"))
+	return p == "remember this" || p == "remember these" || p == "remember"
+}
+
+// rememberAttachments extracts, chunks and embeds the documents attached
+// to msg into its chat's knowledge store and returns the reply for the user
+func (bot *SignalBot) rememberAttachments(ctx context.Context, msg *Message) string {
+	chatID := msg.chatID()
+	attachments := msg.extractAttachments()
+	if chatID == "" || len(attachments) == 0 {
+		return "Send the document together with \"remember this\" and I'll keep it for later questions."
+	}
+
+	var stored, skipped []string
+	total := 0
+	for _, a := range attachments {
+		name := attachmentName(a)
+		text, err := bot.extractAttachment(ctx, a)
+		if err != nil {
+			if !errors.Is(err, errUnsupportedDocument) {
+				bot.logger.Printf("Error extracting text from %s: %v", name, err)
+			}
+			skipped = append(skipped, name)
+			continue
+		}
+
+		passages := documentChunks(name, text)
+		if len(passages) == 0 {
+			skipped = append(skipped, name)
+			continue
+		}
+		pieces := make([]string, len(passages))
+		for i, passage := range passages {
+			pieces[i] = passage.Text
+		}
+		vectors, err := bot.embedder.Embed(ctx, pieces)
+		if err != nil {
+			bot.logger.Printf("Error embedding %s: %v", name, err)
+			skipped = append(skipped, name)
+			continue
+		}
+
+		chunks := make([]KnowledgeChunk, len(pieces))
+		for i, piece := range pieces {
+			chunks[i] = KnowledgeChunk{Source: passages[i].Source, Text: piece, Vector: vectors[i], AddedBy: msg.sender().Number, AddedByUUID: msg.sender().UUID, AddedAt: time.Now()}
+		}
+		if err := bot.knowledge.Add(chatID, chunks...); err != nil {
+			bot.logger.Printf("Error saving knowledge: %v", err)
+			return "Sorry, I couldn't save that document."
+		}
+		stored = append(stored, name)
+		total += len(chunks)
+	}
+
+	if len(stored) == 0 {
+		return "Sorry, I can't read that kind of file yet."
+	}
+	reply := fmt.Sprintf("Got it, I'll remember %s (%d passages). Ask me about it any time.", strings.Join(stored, ", "), total)
+	if len(skipped) > 0 {
+		reply += "\nI couldn't read: " + strings.Join(skipped, ", ")
+	}
+	return reply
+}
+
+// relevantDocuments returns the remembered passages of a chat that best
+// match prompt
+func (bot *SignalBot) relevantDocuments(ctx context.Context, chatID, prompt string) []AgentDocument {
+	if !bot.config.KnowledgeEnabled || chatID == "" {
+		return nil
+	}
+
+	vectors, err := bot.embedder.Embed(ctx, []string{prompt})
+	if err != nil {
+		bot.logger.Printf("Error embedding prompt: %v", err)
+		return nil
+	}
+
+	var docs []AgentDocument
+	for _, doc := range bot.knowledge.Search(chatID, vectors[0], bot.config.KnowledgeTopK) {
+		if doc.Score >= knowledgeMinScore {
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
+
+// knowledgeMinScore filters out passages unrelated to the prompt
+const knowledgeMinScore = 0.1
+
+// inlineDocuments prefixes a prompt with documents, for agents that only
+// read the prompt
+func inlineDocuments(prompt string, docs []AgentDocument) string {
+	if len(docs) == 0 {
+		return prompt
+	}
+	var b strings.Builder
+	b.WriteString("Relevant excerpts from documents shared earlier:\n")
+	for _, doc := range docs {
+		fmt.Fprintf(&b, "\n[%s]\n%s\n", doc.Source, doc.Text)
+	}
+	b.WriteString("\n")
+	b.WriteString(prompt)
+	return b.String()
+}
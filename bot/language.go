@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// stopwords are frequent short words that give away a Latin-script language
+var stopwords = map[string][]string{
+	"en": {"the", "and", "is", "are", "you", "what", "how", "of", "to", "in", "it", "this", "that", "for", "with", "can"},
+	"de": {"der", "die", "das", "und", "ist", "ich", "nicht", "du", "wie", "was", "mit", "ein", "eine", "zu", "auf", "für"},
+	"fr": {"le", "la", "les", "et", "est", "je", "tu", "vous", "une", "des", "que", "qui", "pour", "pas", "dans", "avec"},
+	"es": {"el", "la", "los", "las", "y", "es", "que", "de", "por", "para", "una", "qué", "cómo", "con", "está", "yo"},
+	"pt": {"o", "os", "as", "e", "é", "que", "não", "uma", "um", "para", "com", "você", "eu", "como", "está", "do"},
+	"it": {"il", "lo", "gli", "e", "è", "che", "non", "una", "per", "con", "sono", "come", "cosa", "della", "io", "mi"},
+	"nl": {"de", "het", "een", "en", "is", "niet", "ik", "je", "wat", "hoe", "van", "met", "voor", "dat", "zijn", "op"},
+	"pl": {"i", "w", "nie", "jest", "się", "na", "to", "że", "co", "jak", "z", "do", "czy", "ja", "ty", "dla"},
+	"tr": {"ve", "bir", "bu", "ne", "için", "mi", "da", "de", "ben", "sen", "nasıl", "var", "yok", "çok", "ile", "gibi"},
+}
+
+// detectLanguage guesses the language of text: by script for non-Latin
+// alphabets, otherwise by counting stopwords. It returns a language code,
+// or "" when unsure.
+func detectLanguage(text string) string {
+	scripts := map[string]int{}
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			scripts["ja"] += 2 // kana decides between Japanese and Chinese
+		case unicode.Is(unicode.Han, r):
+			scripts["zh"]++
+		case unicode.Is(unicode.Hangul, r):
+			scripts["ko"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			if strings.ContainsRune("іїєґІЇЄҐ", r) {
+				scripts["uk"] += 10
+			}
+			scripts["ru"]++
+		case unicode.Is(unicode.Arabic, r):
+			scripts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["he"]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["el"]++
+		case unicode.Is(unicode.Thai, r):
+			scripts["th"]++
+		case unicode.Is(unicode.Devanagari, r):
+			scripts["hi"]++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	if best, count := topScore(scripts); count*2 >= letters {
+		return best
+	}
+
+	counts := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		for lang, words := range stopwords {
+			for _, w := range words {
+				if word == w {
+					counts[lang]++
+				}
+			}
+		}
+	}
+	best, count := topScore(counts)
+	for lang, c := range counts {
+		if lang != best && c == count {
+			return "" // tie
+		}
+	}
+	return best
+}
+
+// topScore returns the key with the highest count
+func topScore(counts map[string]int) (string, int) {
+	best, top := "", 0
+	for key, count := range counts {
+		if count > top || (count == top && key < best) {
+			best, top = key, count
+		}
+	}
+	return best, top
+}
+
+// detectedLanguage returns the language to answer a prompt in when its chat
+// has "!set autolang on", or ""
+func (bot *SignalBot) detectedLanguage(chatID, prompt string) string {
+	if chatID == "" || bot.settings.Get(chatID, "autolang") != "on" {
+		return ""
+	}
+	return detectLanguage(prompt)
+}
+
+// mirrorGroupMessage posts a translation of a group message when the group
+// set "!set mirror <language>" and the message is in another language.
+// Commands and prompts for the bot aren't mirrored.
+func (bot *SignalBot) mirrorGroupMessage(ctx context.Context, msg *Message) {
+	if msg.extractGroupId() == "" {
+		return
+	}
+	target := bot.settings.Get(msg.chatID(), "mirror")
+	content := msg.extractContent()
+	if target == "" || content == "" || strings.HasPrefix(content, "!") || bot.isTriggered(content) {
+		return
+	}
+	if detected := detectLanguage(content); detected == target {
+		return
+	}
+	if bot.config.TranslateURL == "" && !bot.promptAllowed(msg, false) {
+		return
+	}
+
+	name := msg.sender().Name
+	if name == "" {
+		name = msg.sender().Number
+	}
+	bot.replyInBackground(msg, func() string {
+		result, err := bot.translate(ctx, msg, content, target)
+		if err != nil {
+			bot.logger.Printf("Error mirroring message: %v", err)
+			return ""
+		}
+		if result.Source == target || strings.EqualFold(strings.TrimSpace(result.Text), strings.TrimSpace(content)) {
+			return ""
+		}
+		return fmt.Sprintf("🌐 %s: %s", name, bot.redactReply(msg, result.Text))
+	})
+}
+
+func init() {
+	chatSettingDefs["autolang"] = chatSetting{
+		description: "on/off: answer every prompt in the language it was written in",
+		normalize: func(value string) (string, error) {
+			switch strings.ToLower(value) {
+			case "on", "off":
+				return strings.ToLower(value), nil
+			}
+			return "", fmt.Errorf("autolang must be on or off")
+		},
+	}
+	chatSettingDefs["mirror"] = chatSetting{
+		description: "language code or off: repost every group message in another language translated into it",
+		normalize: func(value string) (string, error) {
+			if strings.EqualFold(value, "off") {
+				return "", nil
+			}
+			code, ok := parseLanguage(value)
+			if !ok {
+				return "", fmt.Errorf("mirror must be a language code like en or de, or off")
+			}
+			return code, nil
+		},
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// errLeadershipLost ends Run when another instance took over, so the
+// process exits and its supervisor can restart it as a standby
+var errLeadershipLost = errors.New("lost leadership to another instance")
+
+// leaderElector decides which of several instances for one account is the
+// leader: only the leader polls signal-cli and sends messages, the others
+// wait to take over when it dies. It is selected with COORDINATION.
+type leaderElector interface {
+	// TryAcquire takes leadership if nobody holds it, reporting whether it did
+	TryAcquire(ctx context.Context) (bool, error)
+	// Renew extends the lease, reporting false once another instance leads
+	Renew(ctx context.Context) (bool, error)
+	Release()
+}
+
+// newLeaderElector creates the elector selected by COORDINATION
+func (bot *SignalBot) newLeaderElector() (leaderElector, error) {
+	switch bot.config.Coordination {
+	case "file":
+		return &fileLeader{path: bot.accountLockPath()}, nil
+	case "redis":
+		if bot.config.RedisURL == "" {
+			return nil, fmt.Errorf("COORDINATION=redis needs REDIS_URL")
+		}
+		client, err := newRedisClient(bot.config.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		return &redisLeader{
+			client: client,
+			key:    bot.config.RedisPrefix + "leader:" + bot.accountLockName(),
+			id:     instanceID(),
+			lease:  bot.config.LeaderLease,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown COORDINATION %q (want file or redis)", bot.config.Coordination)
+}
+
+// awaitLeadership blocks until this instance leads, retrying every third
+// of the lease so a standby takes over soon after the leader dies
+func (bot *SignalBot) awaitLeadership(ctx context.Context, elector leaderElector) error {
+	standing := false
+	for {
+		acquired, err := elector.TryAcquire(ctx)
+		if err != nil {
+			bot.logger.Printf("Error acquiring leadership: %v", err)
+		}
+		if acquired {
+			bot.logger.Printf("Acquired leadership (%s coordination)", bot.config.Coordination)
+			return nil
+		}
+		if !standing && err == nil {
+			bot.logger.Printf("Another instance leads this account; standing by")
+			standing = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(bot.config.LeaderLease / 3):
+		}
+	}
+}
+
+// holdLeadership renews the lease every third of its length. When another
+// instance took over, or renewals kept failing for two thirds of the lease
+// so it may be about to, it calls stop with errLeadershipLost.
+func (bot *SignalBot) holdLeadership(ctx context.Context, elector leaderElector, stop context.CancelCauseFunc) error {
+	lease := bot.config.LeaderLease
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			held, err := elector.Renew(ctx)
+			if err != nil {
+				bot.logger.Printf("Error renewing leadership: %v", err)
+				if time.Since(renewed) < lease*2/3 {
+					continue
+				}
+			}
+			if !held {
+				bot.logger.Printf("Lost leadership, stopping")
+				stop(errLeadershipLost)
+				return nil
+			}
+			renewed = time.Now()
+		}
+	}
+}
+
+// instanceID identifies this process in a Redis lease
+func instanceID() string {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return hostname + ":" + strconv.Itoa(os.Getpid()) + ":" + hex.EncodeToString(suffix)
+}
+
+// fileLeader leads while holding the account lock file. The kernel drops
+// the lock when the process dies, so standbys on the same host, or sharing
+// LOCK_DIR over a filesystem with working flock, take over immediately.
+type fileLeader struct {
+	path string
+	lock *accountLock
+}
+
+// TryAcquire implements leaderElector
+func (l *fileLeader) TryAcquire(ctx context.Context) (bool, error) {
+	lock, err := acquireAccountLock(l.path)
+	if errors.Is(err, errLockHeld) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	l.lock = lock
+	return true, nil
+}
+
+// Renew implements leaderElector; a held flock can't be lost
+func (l *fileLeader) Renew(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// Release implements leaderElector
+func (l *fileLeader) Release() {
+	l.lock.Release()
+}
+
+// redisLeader leads while holding a Redis key with an expiring lease. A
+// dead leader stops renewing, so its lease runs out within LEADER_LEASE.
+type redisLeader struct {
+	client *redisClient
+	key    string
+	id     string
+	lease  time.Duration
+}
+
+// Renewing and releasing must only touch the lease while it's still ours
+const (
+	redisRenewScript   = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+	redisReleaseScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+)
+
+// TryAcquire implements leaderElector
+func (l *redisLeader) TryAcquire(ctx context.Context) (bool, error) {
+	_, err := l.client.String("SET", l.key, l.id, "NX", "PX", strconv.FormatInt(l.lease.Milliseconds(), 10))
+	if errors.Is(err, errRedisNil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Renew implements leaderElector
+func (l *redisLeader) Renew(ctx context.Context) (bool, error) {
+	renewed, err := l.client.String("EVAL", redisRenewScript, "1", l.key, l.id, strconv.FormatInt(l.lease.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	return renewed == "1", nil
+}
+
+// Release implements leaderElector, letting a standby take over at once
+func (l *redisLeader) Release() {
+	l.client.Do("EVAL", redisReleaseScript, "1", l.key, l.id)
+	l.client.Close()
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxLinksPerPrompt bounds how many pages one prompt can make the bot fetch
+const maxLinksPerPrompt = 2
+
+// linkFetchTimeout bounds fetching a single page
+const linkFetchTimeout = 15 * time.Second
+
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// promptLinks returns the URLs mentioned in a prompt, without trailing
+// punctuation
+func promptLinks(prompt string) []string {
+	var links []string
+	for _, link := range linkPattern.FindAllString(prompt, -1) {
+		link = strings.TrimRight(link, ".,;:!?)]}'")
+		if len(links) == maxLinksPerPrompt {
+			break
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// linkAllowed applies LINK_DENY_DOMAINS and then LINK_ALLOW_DOMAINS (when
+// set) to a host; a domain also matches its subdomains
+func (bot *SignalBot) linkAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	matches := func(domains []string) bool {
+		for _, domain := range domains {
+			domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+		return false
+	}
+	if matches(bot.config.LinkDenyDomains) {
+		return false
+	}
+	return len(bot.config.LinkAllowDomains) == 0 || matches(bot.config.LinkAllowDomains)
+}
+
+var errPrivateAddress = errors.New("refusing to fetch a private address")
+
+// maxLinkRedirects bounds the redirects followed for one link
+const maxLinkRedirects = 5
+
+// sharedAddressSpace is 100.64.0.0/10, the carrier-grade NAT range that
+// also holds the addresses of some VPNs
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// privateAddress reports whether ip must not be fetched from: loopback,
+// private, shared and link-local addresses, also written as IPv4-mapped
+// IPv6 addresses
+func privateAddress(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// newLinkClient returns the client used for shared links. It refuses to
+// connect to private addresses, so a prompt can't make the bot probe its
+// own network, and follows only redirects to hosts allowed accepts.
+func newLinkClient(allowed func(host string) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || privateAddress(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout:   linkFetchTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxLinkRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxLinkRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirected to an invalid URL")
+			}
+			if !allowed(req.URL.Hostname()) {
+				return fmt.Errorf("redirected to %s, which is not allowed", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+}
+
+// linkedPages fetches the pages linked in the prompt of request, e.g. "qq
+// summarize https://...", and returns their readable text as documents so
+// the agent doesn't have to guess at their content. Pages that can't be
+// fetched are returned as a note saying so. Pages are moderated before
+// anything is sent to the agent, and false is returned when moderation
+// refuses one.
+func (bot *SignalBot) linkedPages(ctx context.Context, request AgentRequest) ([]AgentDocument, bool) {
+	if !bot.config.LinkFetchEnabled {
+		return nil, true
+	}
+
+	var docs []AgentDocument
+	for _, link := range promptLinks(request.Prompt) {
+		title, text, err := bot.fetchLink(ctx, link)
+		if err != nil {
+			bot.logger.Printf("Error fetching %s: %v", link, err)
+			docs = append(docs, AgentDocument{Source: link, Text: fmt.Sprintf("(This page couldn't be fetched: %v)", err)})
+			continue
+		}
+
+		source := link
+		if title != "" {
+			source = title + " (" + link + ")"
+		}
+		var pages []AgentDocument
+		for _, chunk := range documentChunks(source, text) {
+			pages = append(pages, AgentDocument{Source: chunk.Source, Text: chunk.Text})
+		}
+		pages, ok := bot.screenDocuments(ctx, request, pages)
+		if !ok {
+			return nil, false
+		}
+		if bot.config.DocumentMaxTokens > 0 && estimateDocumentTokens(pages) > bot.config.DocumentMaxTokens {
+			if youtubeVideoID(link) != "" {
+				// A transcript only makes sense as a whole, so condense it
+				// rather than picking passages
+				pages = bot.summarizeSections(ctx, source, pages, bot.config.DocumentMaxTokens)
+			} else {
+				pages = bot.selectPassages(ctx, request.Prompt, pages, bot.config.DocumentMaxTokens)
+			}
+		}
+		docs = append(docs, pages...)
+	}
+	return docs, true
+}
+
+// download GETs an allowed link, reading at most LINK_MAX_BYTES, and
+// returns the body and its media type
+func (bot *SignalBot) download(ctx context.Context, link string) ([]byte, string, error) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, "", fmt.Errorf("invalid URL")
+	}
+	if !bot.linkAllowed(u.Hostname()) {
+		return nil, "", fmt.Errorf("%s is not on the list of allowed sites", u.Hostname())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", "signalbot/1.0 (+link summaries)")
+	req.Header.Set("Accept", "text/html,text/plain,application/pdf;q=0.9,*/*;q=0.1")
+
+	resp, err := bot.linkClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, bot.config.LinkMaxBytes))
+	if err != nil {
+		return nil, "", err
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return body, contentType, nil
+}
+
+// fetchLink downloads a page and extracts its title and readable text.
+// HTML, plain text and PDFs are supported, and YouTube videos are read
+// from their captions.
+func (bot *SignalBot) fetchLink(ctx context.Context, link string) (string, string, error) {
+	if id := youtubeVideoID(link); id != "" {
+		return bot.fetchTranscript(ctx, id)
+	}
+
+	body, contentType, err := bot.download(ctx, link)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case contentType == "text/html" || contentType == "application/xhtml+xml":
+		title, text := readableText(string(body))
+		return title, text, nil
+	case strings.HasPrefix(contentType, "text/"):
+		return "", string(body), nil
+	case contentType == "application/pdf":
+		text, err := bot.extractDownloadedPDF(ctx, body)
+		return "", text, err
+	default:
+		return "", "", fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
+
+// extractDownloadedPDF runs the PDF extractor on a fetched document
+func (bot *SignalBot) extractDownloadedPDF(ctx context.Context, data []byte) (string, error) {
+	tmp, err := os.CreateTemp("", "signalbot-link-*.pdf")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return extractPDF(ctx, bot, tmp.Name())
+}
+
+// rawTextElements contain code rather than markup
+var rawTextElements = map[string]bool{"script": true, "style": true, "textarea": true}
+
+// rawTextEnd finds the closing tag of each raw text element
+var rawTextEnd = map[string]*regexp.Regexp{
+	"script":   regexp.MustCompile(`(?i)</script`),
+	"style":    regexp.MustCompile(`(?i)</style`),
+	"textarea": regexp.MustCompile(`(?i)</textarea`),
+}
+
+// skippedElements hold page furniture rather than article text
+var skippedElements = map[string]bool{
+	"noscript": true, "svg": true, "template": true,
+	"nav": true, "header": true, "footer": true, "aside": true, "form": true, "iframe": true, "button": true,
+}
+
+// blockElements end a line of text
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "section": true, "article": true, "main": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "blockquote": true, "pre": true,
+}
+
+var tagPattern = regexp.MustCompile(`(?s)<!--.*?-->|<(/?)([a-zA-Z][a-zA-Z0-9]*)[^>]*>`)
+
+// readableText is a small readability pass over HTML: it drops scripts,
+// navigation and other page furniture, prefers the <article> or <main>
+// element when the page has one with real content, and returns the page
+// title and its text one block per line
+func readableText(page string) (string, string) {
+	var all, article, title strings.Builder
+	skipDepth, articleDepth := 0, 0
+	inTitle := false
+
+	write := func(s string) {
+		if skipDepth > 0 {
+			return
+		}
+		if inTitle {
+			title.WriteString(s)
+			return
+		}
+		all.WriteString(s)
+		if articleDepth > 0 {
+			article.WriteString(s)
+		}
+	}
+
+	last := 0
+	for {
+		m := tagPattern.FindStringSubmatchIndex(page[last:])
+		if m == nil {
+			break
+		}
+		for i := range m {
+			if m[i] >= 0 {
+				m[i] += last
+			}
+		}
+		write(html.UnescapeString(page[last:m[0]]))
+		last = m[1]
+		if m[4] < 0 {
+			continue // comment
+		}
+
+		closing := m[3] > m[2]
+		name := strings.ToLower(page[m[4]:m[5]])
+		if rawTextElements[name] && !closing {
+			// Skip to the closing tag, the content isn't HTML
+			if end := rawTextEnd[name].FindStringIndex(page[last:]); end != nil {
+				last += end[0]
+			} else {
+				last = len(page)
+			}
+			continue
+		}
+		switch {
+		case name == "title":
+			inTitle = !closing
+		case skippedElements[name]:
+			if closing && skipDepth > 0 {
+				skipDepth--
+			} else if !closing && !strings.HasSuffix(page[m[0]:m[1]], "/>") {
+				skipDepth++
+			}
+		case name == "article" || name == "main":
+			if closing && articleDepth > 0 {
+				articleDepth--
+			} else if !closing {
+				articleDepth++
+			}
+		}
+		if blockElements[name] {
+			write("\n")
+		}
+	}
+	write(html.UnescapeString(page[last:]))
+
+	text := all.String()
+	if a := article.String(); len(strings.TrimSpace(a)) >= 500 {
+		text = a
+	}
+	return strings.Join(strings.Fields(title.String()), " "), collapseLines(text)
+}
+
+// collapseLines normalizes whitespace within lines and drops empty ones
+func collapseLines(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
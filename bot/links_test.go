@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestPrivateAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+		{"100.63.255.255", false},
+		{"100.128.0.0", false},
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"100.64.0.1", true},
+		{"100.127.255.254", true},
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:100.64.0.1", true},
+		{"::ffff:93.184.216.34", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := privateAddress(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("privateAddress(%s) = %t, want %t", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkClientRedirects(t *testing.T) {
+	bot := &SignalBot{config: Config{LinkDenyDomains: []string{"internal.example"}}}
+	client := newLinkClient(bot.linkAllowed)
+
+	tests := []struct {
+		name    string
+		to      string
+		hops    int // requests made before this redirect
+		wantErr bool
+	}{
+		{name: "allowed host", to: "https://news.example/article", hops: 1},
+		{name: "denied host", to: "https://internal.example/admin", hops: 1, wantErr: true},
+		{name: "denied subdomain on a later hop", to: "http://wiki.internal.example/", hops: 3, wantErr: true},
+		{name: "non-HTTP scheme", to: "file:///etc/passwd", hops: 1, wantErr: true},
+		{name: "too many redirects", to: "https://news.example/loop", hops: maxLinkRedirects + 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tt.to, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			via := make([]*http.Request, tt.hops)
+			if err := client.CheckRedirect(req, via); (err != nil) != tt.wantErr {
+				t.Errorf("CheckRedirect(%s) error = %v, want error %t", tt.to, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// usagePrefix starts the usage hints of commands
+const usagePrefix = "Usage: "
+
+// localizedMessages translates the bot's own messages, by language code and
+// then English text. Format strings are translated before substitution.
+var localizedMessages = map[string]map[string]string{
+	"pt": {
+		"The assistant is currently disabled.":                                                                  "O assistente está desativado no momento.",
+		"The assistant is temporarily unavailable. Please try again in a few minutes.":                          "O assistente está temporariamente indisponível. Tente novamente em alguns minutos.",
+		"Sorry, I encountered an error processing your request.":                                                "Desculpe, ocorreu um erro ao processar o seu pedido.",
+		"Sorry, that took too long to answer. Please try again.":                                                "Desculpe, demorei demasiado a responder. Tente novamente.",
+		"Sorry, I only answer people my owner has allowed.":                                                     "Desculpe, só respondo a pessoas autorizadas pelo meu dono.",
+		"Sorry, I can't help with that.":                                                                        "Desculpe, não posso ajudar com isso.",
+		"Let's keep it friendly in here, please ask that without the language.":                                 "Vamos manter a conversa simpática; pergunte isso sem essas palavras.",
+		"You're sending prompts too fast, so I'll ignore yours for %s.":                                         "Você está enviando pedidos rápido demais, então vou ignorar os seus por %s.",
+		"This group is sending prompts too fast, so I'll pause here for %s.":                                    "Este grupo está enviando pedidos rápido demais, então vou pausar aqui por %s.",
+		"That message is too long for me (about %d tokens, the limit is %d). Please shorten it or split it up.": "Essa mensagem é longa demais para mim (cerca de %d tokens, o limite é %d). Encurte-a ou divida-a.",
+		queuedNote:         "⏳ Na fila atrás de uma pergunta anterior nesta conversa, vou responder por ordem.",
+		"Reply cancelled.": "Resposta cancelada.",
+		"Sorry, I can't tell which chat this is.": "Desculpe, não consigo identificar esta conversa.",
+		"Sorry, I couldn't save that setting.":    "Desculpe, não consegui guardar essa configuração.",
+		"Only group admins can change %s.":        "Só os administradores do grupo podem alterar %s.",
+		"Nothing to summarize yet.":               "Ainda não há nada para resumir.",
+		"Commands:":                               "Comandos:",
+		usagePrefix:                               "Uso: ",
+	},
+	"es": {
+		"The assistant is currently disabled.":                                                                  "El asistente está desactivado en este momento.",
+		"The assistant is temporarily unavailable. Please try again in a few minutes.":                          "El asistente no está disponible temporalmente. Inténtalo de nuevo en unos minutos.",
+		"Sorry, I encountered an error processing your request.":                                                "Lo siento, hubo un error al procesar tu solicitud.",
+		"Sorry, that took too long to answer. Please try again.":                                                "Lo siento, tardé demasiado en responder. Inténtalo de nuevo.",
+		"Sorry, I only answer people my owner has allowed.":                                                     "Lo siento, solo respondo a personas autorizadas por mi dueño.",
+		"Sorry, I can't help with that.":                                                                        "Lo siento, no puedo ayudar con eso.",
+		"Let's keep it friendly in here, please ask that without the language.":                                 "Mantengamos un tono amable; pregúntalo sin esas palabras.",
+		"You're sending prompts too fast, so I'll ignore yours for %s.":                                         "Estás enviando peticiones demasiado rápido, así que ignoraré las tuyas durante %s.",
+		"This group is sending prompts too fast, so I'll pause here for %s.":                                    "Este grupo está enviando peticiones demasiado rápido, así que haré una pausa aquí durante %s.",
+		"That message is too long for me (about %d tokens, the limit is %d). Please shorten it or split it up.": "Ese mensaje es demasiado largo para mí (unos %d tokens, el límite es %d). Acórtalo o divídelo.",
+		queuedNote:         "⏳ En cola detrás de una pregunta anterior en este chat, responderé en orden.",
+		"Reply cancelled.": "Respuesta cancelada.",
+		"Sorry, I can't tell which chat this is.": "Lo siento, no sé qué chat es este.",
+		"Sorry, I couldn't save that setting.":    "Lo siento, no pude guardar ese ajuste.",
+		"Only group admins can change %s.":        "Solo los administradores del grupo pueden cambiar %s.",
+		"Nothing to summarize yet.":               "Todavía no hay nada que resumir.",
+		"Commands:":                               "Comandos:",
+		usagePrefix:                               "Uso: ",
+	},
+	"de": {
+		"The assistant is currently disabled.":                                                                  "Der Assistent ist derzeit deaktiviert.",
+		"The assistant is temporarily unavailable. Please try again in a few minutes.":                          "Der Assistent ist vorübergehend nicht erreichbar. Bitte versuche es in ein paar Minuten erneut.",
+		"Sorry, I encountered an error processing your request.":                                                "Entschuldigung, bei deiner Anfrage ist ein Fehler aufgetreten.",
+		"Sorry, that took too long to answer. Please try again.":                                                "Entschuldigung, die Antwort hat zu lange gedauert. Bitte versuche es erneut.",
+		"Sorry, I only answer people my owner has allowed.":                                                     "Entschuldigung, ich antworte nur Personen, die mein Besitzer freigegeben hat.",
+		"Sorry, I can't help with that.":                                                                        "Entschuldigung, dabei kann ich nicht helfen.",
+		"Let's keep it friendly in here, please ask that without the language.":                                 "Lass uns freundlich bleiben, frag das bitte ohne diese Wörter.",
+		"You're sending prompts too fast, so I'll ignore yours for %s.":                                         "Du schickst zu schnell Anfragen, deshalb ignoriere ich deine für %s.",
+		"This group is sending prompts too fast, so I'll pause here for %s.":                                    "Diese Gruppe schickt zu schnell Anfragen, deshalb pausiere ich hier für %s.",
+		"That message is too long for me (about %d tokens, the limit is %d). Please shorten it or split it up.": "Diese Nachricht ist mir zu lang (etwa %d Tokens, das Limit ist %d). Bitte kürze oder teile sie.",
+		queuedNote:         "⏳ Wartet hinter einer früheren Frage in diesem Chat, ich antworte der Reihe nach.",
+		"Reply cancelled.": "Antwort abgebrochen.",
+		"Sorry, I can't tell which chat this is.": "Entschuldigung, ich kann diesen Chat nicht zuordnen.",
+		"Sorry, I couldn't save that setting.":    "Entschuldigung, ich konnte die Einstellung nicht speichern.",
+		"Only group admins can change %s.":        "Nur Gruppenadmins können %s ändern.",
+		"Nothing to summarize yet.":               "Noch nichts zum Zusammenfassen.",
+		"Commands:":                               "Befehle:",
+		usagePrefix:                               "Verwendung: ",
+	},
+	"fr": {
+		"The assistant is currently disabled.":                                                                  "L'assistant est actuellement désactivé.",
+		"The assistant is temporarily unavailable. Please try again in a few minutes.":                          "L'assistant est temporairement indisponible. Réessayez dans quelques minutes.",
+		"Sorry, I encountered an error processing your request.":                                                "Désolé, une erreur s'est produite lors du traitement de votre demande.",
+		"Sorry, that took too long to answer. Please try again.":                                                "Désolé, la réponse a pris trop de temps. Veuillez réessayer.",
+		"Sorry, I only answer people my owner has allowed.":                                                     "Désolé, je ne réponds qu'aux personnes autorisées par mon propriétaire.",
+		"Sorry, I can't help with that.":                                                                        "Désolé, je ne peux pas vous aider avec ça.",
+		"Let's keep it friendly in here, please ask that without the language.":                                 "Restons aimables, posez la question sans ces mots.",
+		"You're sending prompts too fast, so I'll ignore yours for %s.":                                         "Vous envoyez des demandes trop vite, je vais donc ignorer les vôtres pendant %s.",
+		"This group is sending prompts too fast, so I'll pause here for %s.":                                    "Ce groupe envoie des demandes trop vite, je fais donc une pause ici pendant %s.",
+		"That message is too long for me (about %d tokens, the limit is %d). Please shorten it or split it up.": "Ce message est trop long pour moi (environ %d tokens, la limite est %d). Raccourcissez-le ou découpez-le.",
+		queuedNote:         "⏳ En attente derrière une question précédente dans cette discussion, je réponds dans l'ordre.",
+		"Reply cancelled.": "Réponse annulée.",
+		"Sorry, I can't tell which chat this is.": "Désolé, je n'arrive pas à identifier cette conversation.",
+		"Sorry, I couldn't save that setting.":    "Désolé, je n'ai pas pu enregistrer ce réglage.",
+		"Only group admins can change %s.":        "Seuls les administrateurs du groupe peuvent modifier %s.",
+		"Nothing to summarize yet.":               "Rien à résumer pour l'instant.",
+		"Commands:":                               "Commandes :",
+		usagePrefix:                               "Utilisation : ",
+	},
+}
+
+// chatLanguage returns the language a chat set with "!set language", or ""
+func (bot *SignalBot) chatLanguage(chatID string) string {
+	if chatID == "" {
+		return ""
+	}
+	return bot.settings.Get(chatID, "language")
+}
+
+// localize translates one of the bot's messages into its chat's language,
+// returning text unchanged when there is no translation. Usage hints keep
+// their command syntax and only have the prefix translated.
+func (bot *SignalBot) localize(chatID, text string) string {
+	messages := localizedMessages[bot.chatLanguage(chatID)]
+	if messages == nil {
+		return text
+	}
+	if translated, exists := messages[text]; exists {
+		return translated
+	}
+	if strings.HasPrefix(text, usagePrefix) {
+		return messages[usagePrefix] + strings.TrimPrefix(text, usagePrefix)
+	}
+	return text
+}
+
+// localizef formats a localized message
+func (bot *SignalBot) localizef(chatID, format string, args ...any) string {
+	return fmt.Sprintf(bot.localize(chatID, format), args...)
+}
+
+// isGroupAdmin reports whether who administers a group, looking the group
+// up with signal-cli when the roster doesn't know it yet
+func (bot *SignalBot) isGroupAdmin(ctx context.Context, groupID string, who AgentSender) bool {
+	group, known := bot.roster.Get(groupID)
+	if !known {
+		groups, err := bot.listGroupDetails(ctx, groupID)
+		if err != nil || len(groups) == 0 {
+			bot.logger.Printf("Error looking up admins of group %s: %v", groupID, err)
+			return false
+		}
+		group = groups[0]
+		bot.roster.Update(group, bot.config.SignalAccount)
+	}
+	for _, admin := range group.Admins {
+		if (who.UUID != "" && admin.UUID == who.UUID) || (who.Number != "" && admin.Number == who.Number) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	chatSettingDefs["language"] = chatSetting{
+		description: "language code the assistant replies in and my messages use (group admins only; \"!set my language\" for just you)",
+		groupAdmin:  true,
+		scope:       scopeEither,
+		normalize: func(value string) (string, error) {
+			code, ok := parseLanguage(value)
+			if !ok {
+				return "", fmt.Errorf("language must be a language code like en, pt or de")
+			}
+			return code, nil
+		},
+	}
+
+	registerCommand(&command{
+		name:    "help",
+		usage:   "!help",
+		handler: helpCommand,
+	})
+}
+
+// helpCommand lists the commands available to the sender
+func helpCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	names := make([]string, 0, len(commands))
+	for name, cmd := range commands {
+		if bot.hasRole(msg, bot.commandRole(name, cmd.role)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	lines := []string{bot.localize(msg.chatID(), "Commands:")}
+	for _, name := range names {
+		lines = append(lines, commands[name].usage)
+	}
+	return strings.Join(lines, "\n")
+}
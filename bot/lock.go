@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// errLockHeld is returned when another instance holds the account lock
+var errLockHeld = errors.New("account lock is held by another instance")
+
+// unsafeLockChars are replaced when deriving a lock file name from an account
+var unsafeLockChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// accountLock is an exclusive lock preventing two bots from polling the
+// same Signal account at once
+type accountLock struct {
+	file *os.File
+	path string
+}
+
+// accountLockName identifies the configured account in lock names
+func (bot *SignalBot) accountLockName() string {
+	account := bot.config.SignalAccount
+	if account == "" {
+		account = "default"
+	}
+	return "signalbot-" + strings.Trim(unsafeLockChars.ReplaceAllString(account, "_"), "_")
+}
+
+// accountLockPath returns the lock file path for the configured account
+func (bot *SignalBot) accountLockPath() string {
+	return filepath.Join(bot.config.LockDir, bot.accountLockName()+".lock")
+}
+
+// acquireAccountLock takes the account lock, failing with errLockHeld if
+// another process holds it
+func acquireAccountLock(path string) (*accountLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := lockFile(file); err != nil {
+		holder, _ := os.ReadFile(path)
+		file.Close()
+		if errors.Is(err, errLockHeld) {
+			return nil, fmt.Errorf("%w (%s)", errLockHeld, strings.TrimSpace(string(holder)))
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	// Record who holds the lock to help whoever hits it next
+	hostname, _ := os.Hostname()
+	file.Truncate(0)
+	file.WriteAt([]byte(fmt.Sprintf("pid %d on %s since %s\n", os.Getpid(), hostname, time.Now().Format(time.RFC3339))), 0)
+
+	return &accountLock{file: file, path: path}, nil
+}
+
+// Release drops the lock
+func (l *accountLock) Release() {
+	if l == nil {
+		return
+	}
+	l.file.Truncate(0)
+	unlockFile(l.file)
+	l.file.Close()
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// lockFile is a no-op where flock isn't available
+func lockFile(file *os.File) error {
+	return nil
+}
+
+// unlockFile is a no-op where flock isn't available
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxMacroSteps caps how many agent calls one macro run makes
+const maxMacroSteps = 6
+
+// macroStepSeparator separates steps in "!macro add"
+const macroStepSeparator = "|"
+
+// macroStore holds macros: named sequences of prompts run one after another,
+// each seeing the previous step's output. Macros from MACROS_FILE are
+// read-only; admins add and remove the others at runtime, which persist in
+// path.
+type macroStore struct {
+	mu         sync.RWMutex
+	path       string
+	configured map[string][]string // from MACROS_FILE
+	values     map[string][]string // added with !macro add
+}
+
+// newMacroStore creates a macro store backed by path
+func newMacroStore(path string) *macroStore {
+	return &macroStore{path: path, configured: make(map[string][]string), values: make(map[string][]string)}
+}
+
+// Load reads the configured macros file, if any, and the macros added at
+// runtime; a missing runtime file means none were added yet
+func (ms *macroStore) Load(configPath string) error {
+	configured := make(map[string][]string)
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return fmt.Errorf("failed to read macros file: %w", err)
+		}
+		var custom map[string][]string
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return fmt.Errorf("failed to parse macros file %s: %w", configPath, err)
+		}
+		for name, steps := range custom {
+			if len(steps) == 0 || len(steps) > maxMacroSteps {
+				return fmt.Errorf("macro %q in %s needs 1 to %d steps", name, configPath, maxMacroSteps)
+			}
+			configured[strings.ToLower(name)] = steps
+		}
+	}
+
+	values := make(map[string][]string)
+	data, err := os.ReadFile(ms.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", ms.path, err)
+		}
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.configured = configured
+	ms.values = values
+	return nil
+}
+
+// Get returns a macro's steps; runtime macros override configured ones
+func (ms *macroStore) Get(name string) ([]string, bool) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if steps, exists := ms.values[name]; exists {
+		return steps, true
+	}
+	steps, exists := ms.configured[name]
+	return steps, exists
+}
+
+// Configured reports whether a macro comes from MACROS_FILE and can't be
+// removed at runtime
+func (ms *macroStore) Configured(name string) bool {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	_, runtime := ms.values[name]
+	_, configured := ms.configured[name]
+	return configured && !runtime
+}
+
+// Set stores a runtime macro and persists the change. No steps removes it.
+func (ms *macroStore) Set(name string, steps []string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if len(steps) == 0 {
+		delete(ms.values, name)
+	} else {
+		ms.values[name] = steps
+	}
+
+	data, err := json.MarshalIndent(ms.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ms.path, data)
+}
+
+// Names returns the names of all macros, sorted
+func (ms *macroStore) Names() []string {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	seen := make(map[string]bool, len(ms.configured)+len(ms.values))
+	for name := range ms.configured {
+		seen[name] = true
+	}
+	for name := range ms.values {
+		seen[name] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runMacro calls the agent once per step. A step's {{input}} is the text
+// the macro was run with, {{previous}} the output of the step before (the
+// input for the first step) and {{stepN}} the output of step N; steps
+// without any of those get the previous output appended. Every step is
+// moderated and counts against the quota of msg's sender. It returns the
+// last step's output.
+func (bot *SignalBot) runMacro(ctx context.Context, msg *Message, steps []string, input string, vars map[string]string) (string, error) {
+	previous := input
+	for i, step := range steps {
+		stepVars := make(map[string]string, len(vars)+len(steps)+2)
+		for name, value := range vars {
+			stepVars[name] = value
+		}
+		stepVars["input"] = input
+		stepVars["previous"] = previous
+
+		prompt := expandPlaceholders(step, stepVars)
+		if !usesStepOutput(step) && previous != "" {
+			prompt += "\n\n" + previous
+		}
+
+		response, err := bot.promptAgent(ctx, msg, AgentRequest{Prompt: prompt})
+		if err != nil {
+			return "", fmt.Errorf("step %d: %w", i+1, err)
+		}
+		previous = strings.TrimSpace(strings.Join(response.replies(), "\n"))
+		vars[fmt.Sprintf("step%d", i+1)] = previous
+	}
+	return previous, nil
+}
+
+// usesStepOutput reports whether a step refers to the macro's input or an
+// earlier step's output
+func usesStepOutput(step string) bool {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(step, -1) {
+		name := strings.ToLower(match[1])
+		if name == "input" || name == "previous" || strings.HasPrefix(name, "step") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMacroSteps splits "step one | step two" into its steps
+func parseMacroSteps(text string) []string {
+	var steps []string
+	for _, step := range strings.Split(text, macroStepSeparator) {
+		if step = strings.TrimSpace(step); step != "" {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "macro",
+		usage:   "!macro | !macro <name> <text> | !macro show <name> | !macro add <name> <step> | <step> ... | !macro remove <name>",
+		handler: macroCommand,
+	})
+}
+
+// macroCommand lists, shows and manages macros, and runs one on the text
+// after its name. Only admins may add or remove them.
+func macroCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	usage := "Usage: " + commands["macro"].usage
+	if len(args) == 0 || strings.EqualFold(args[0], "list") {
+		names := bot.macros.Names()
+		if len(names) == 0 {
+			return "No macros yet."
+		}
+		lines := []string{"Macros:"}
+		for _, name := range names {
+			steps, _ := bot.macros.Get(name)
+			lines = append(lines, fmt.Sprintf("!macro %s (%d steps)", name, len(steps)))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	switch sub := strings.ToLower(args[0]); sub {
+	case "show":
+		if len(args) < 2 {
+			return usage
+		}
+		steps, exists := bot.macros.Get(strings.ToLower(args[1]))
+		if !exists {
+			return fmt.Sprintf("No macro %q.", args[1])
+		}
+		lines := make([]string, len(steps))
+		for i, step := range steps {
+			lines[i] = fmt.Sprintf("%d. %s", i+1, step)
+		}
+		return strings.Join(lines, "\n")
+
+	case "add", "remove":
+		if !bot.isAdmin(msg) {
+			return "Only admins can change macros."
+		}
+		if len(args) < 2 {
+			return usage
+		}
+		name := strings.ToLower(args[1])
+		if sub == "remove" {
+			if _, exists := bot.macros.Get(name); !exists {
+				return fmt.Sprintf("No macro %q.", args[1])
+			}
+			if bot.macros.Configured(name) {
+				return fmt.Sprintf("Macro %q comes from MACROS_FILE and can only be removed there.", name)
+			}
+			if err := bot.macros.Set(name, nil); err != nil {
+				bot.logger.Printf("Error removing macro: %v", err)
+				return "Sorry, I couldn't remove that macro."
+			}
+			return fmt.Sprintf("Macro %q removed.", name)
+		}
+
+		steps := parseMacroSteps(commandText(msg.extractContent(), 3))
+		if len(steps) == 0 {
+			return usage
+		}
+		if len(steps) > maxMacroSteps {
+			return fmt.Sprintf("Macros can have at most %d steps.", maxMacroSteps)
+		}
+		if !aliasNamePattern.MatchString(name) || contains(templateSubcommands, name) {
+			return "Macro names are one short word of letters, digits, - or _, other than add, remove, show and list."
+		}
+		if err := bot.macros.Set(name, steps); err != nil {
+			bot.logger.Printf("Error saving macro: %v", err)
+			return "Sorry, I couldn't save that macro."
+		}
+		return fmt.Sprintf("Macro %q saved with %d steps. Run it with \"!macro %s <text>\".", name, len(steps), name)
+	}
+
+	name := strings.ToLower(args[0])
+	steps, exists := bot.macros.Get(name)
+	if !exists {
+		return fmt.Sprintf("No macro %q. Send !macro to list them.", args[0])
+	}
+	input := commandText(msg.extractContent(), 2)
+	if input == "" {
+		if q := msg.quote(); q != nil {
+			input = q.Text
+		}
+	}
+
+	if !bot.promptAllowed(msg, true) {
+		return ""
+	}
+
+	sender := msg.sender()
+	vars := promptVars(msg.newAgentRequest(input), time.Now().In(bot.userLocation(msg.chatID(), &sender)))
+	bot.replyInBackground(msg, func() string {
+		output, err := bot.runMacro(ctx, msg, steps, input, vars)
+		if err != nil {
+			bot.logger.Printf("Error running macro %s: %v", name, err)
+			if errors.Is(err, errModerated) {
+				return bot.errorMessage(msg.chatID(), errorModerated, nil)
+			}
+			return fmt.Sprintf("Sorry, macro %q failed. Please try again later.", name)
+		}
+		return bot.redactReply(msg, output)
+	})
+	return ""
+}
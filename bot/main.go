@@ -5,110 +5,797 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 // Config holds the bot configuration
 type Config struct {
+	SignalAccount string
+	LockDir       string
+	Coordination  string
+	LeaderLease   time.Duration
+	ForceStart    bool
+	DryRun        bool // log sends instead of running signal-cli, see replayMessages
+
+	ConfigFile          string
+	ConfigProfile       string // profile of ConfigFile in use, see selectConfigProfile
+	ConfigWatchInterval time.Duration
+
+	SignalReceiveTimeout     time.Duration
+	SignalSendTimeout        time.Duration
+	SignalTrustPolicy        string
+	ChallengeChannel         string
+	ChallengeAccount         string
+	ChallengeWebhookURL      string `secret:"true"` // may carry a token in its path or query
+	IdentityChangeNotifyChat bool
+	WatchdogFailures         int
+	AdminNotify              string // number, "group:<id>" or noteToSelf
+	AdminNotifyEvents        []string
+	SilentFailures           string            // silentFailuresOff, silentFailuresGroups or silentFailuresAll
+	ErrorTemplates           map[string]string // "<class>" or "<lang>.<class>" -> template
+
 	AIPrefix string
-	AgentURL string
+	Triggers []string // replace the built-in triggers when set
+
+	AccessDefault   string // accessAllow or accessDeny for senders on neither list
+	AllowSenders    []string
+	DenySenders     []string
+	AllowGroups     []string
+	DenyGroups      []string
+	AccessAutoBlock int // refused messages after which a stranger is blocked, 0 = never
+
+	RolesOwners  []string          // numbers and UUIDs with the owner role
+	RolesAdmins  []string          // numbers and UUIDs with the admin role
+	CommandRoles map[string]string // command (or "admin.<subcommand>") -> least role
+	ToolsRole    string            // least role whose prompts may run agent tools
+	AgentURL     string
+	AgentToken   string `secret:"true"`
+	AgentProxy   string
+
+	AgentRetries          int
+	AgentRetryBackoff     time.Duration
+	AgentBreakerThreshold int
+	AgentBreakerCooldown  time.Duration
+	AgentMaxConcurrency   int
+
+	DataDir     string
+	StateFile   string
+	StateReload bool
+	StateStore  string
+	RedisURL    string
+	RedisPrefix string
+
+	AgentMinimalRequest     bool
+	AgentProtocol           int
+	AgentHistoryTurns       int
+	AgentHistoryMaxAge      time.Duration
+	AgentHistoryMaxTokens   int
+	HistoryPruneInterval    time.Duration
+	AgentHistoryTokenBudget int
+	AgentContextTokens      int
+	MaxPromptTokens         int
+	GroupThreadPerUser      bool
+	ResponseCacheSize       int
+	ResponseCacheTTL        time.Duration
+	AgentToolsEnabled       bool
+	AgentMaxToolSteps       int
+	AgentModels             []string // models selectable with !model
+	AgentDefaultModel       string
+	AgentActions            []string          // allowlisted action types
+	AgentForwardTargets     map[string]string // forward target name -> recipient
+
+	PromptTemplate string
+	PersonasFile   string
+	AliasesFile    string
+	TemplatesFile  string
+	MacrosFile     string
+
+	AttachmentsEnabled bool
+	AttachmentsDir     string
+	AttachmentLogSize  int
+
+	KnowledgeEnabled   bool
+	KnowledgeTopK      int
+	KnowledgeMaxChunks int
+	VectorStore        string
+	EmbeddingsURL      string
+	EmbeddingsModel    string
+	EmbeddingsAPIKey   string `secret:"true"`
+	SearchEmbeddings   bool
+
+	ArchiveEnabled   bool
+	ArchiveStore     string
+	ArchiveRetention time.Duration
+	AuditLog         string // JSON Lines file of data deletions, "off" = none
+
+	DocumentsEnabled  bool
+	DocumentMaxTokens int
+	PDFExtractCommand string
+	PDFExtractURL     string
+	OCRURL            string
+	VisionEnabled     bool
+
+	LinkFetchEnabled    bool
+	LinkMaxBytes        int64
+	LinkAllowDomains    []string
+	LinkDenyDomains     []string
+	TranscriptLanguages []string
+
+	GroupBufferSize         int
+	SummaryReactionDelivery string // "reply" or "dm"
+
+	TranslateURL    string
+	TranslateAPIKey string `secret:"true"`
+
+	ModerationBlock      []string // regexes refusing a prompt
+	ModerationSanitize   []string // regexes removed from prompts
+	ModerationURL        string
+	ModerationAPIKey     string `secret:"true"`
+	ModerationModel      string
+	ModerationFailClosed bool
+
+	RedactPatterns []string // built-in patterns: email, phone, api-key
+	RedactCustom   []string // extra regexes
+	RedactScope    string   // redactScopeGroups or redactScopeAll
+	RedactText     string
+
+	ProfanityLevel      string   // default filter level of chats
+	ProfanityWords      []string // extra words blocked at every level, "word*" for stems
+	ProfanityBypassRole string   // least role skipping the filter
+
+	GreetingEnabled   bool
+	GreetingMessage   string
+	GreetingRateLimit int
+
+	RateLimitPerMinute int // prompts per sender, 0 = unlimited
+	RateLimitPerDay    int
+	RateLimitExempt    []string
+
+	FloodSenderThreshold int // prompts per FloodWindow muting a sender, 0 = off
+	FloodGroupThreshold  int // prompts per FloodWindow muting a group, 0 = off
+	FloodWindow          time.Duration
+	FloodMute            time.Duration
+
+	TokenQuotaDaily       int // estimated tokens per sender and day, 0 = unlimited
+	TokenQuotaWarnPercent int
+	UsageSummaryInterval  time.Duration // 0 = no periodic usage summary
+
+	RemindersPerSender int // pending !remind reminders per sender, 0 = unlimited
+
+	VoiceTranscribeURL   string
+	VoiceTranscribeModel string
+	VoiceTTSURL          string
+	VoiceTTSModel        string
+	VoiceTTSVoice        string
+	VoiceAPIKey          string `secret:"true"`
+
+	SendIntervalDM        time.Duration
+	SendIntervalGroup     time.Duration
+	SendIntervalBroadcast time.Duration
+	SendRateGlobal        int // per minute
+	SendRateRecipient     int // per minute
+	SendBurst             int
+	SendRateLimitCooldown time.Duration
+	OutboxMaxAttempts     int
+	OutboxRetryBackoff    time.Duration
+	QueuePositionFeedback bool
+
+	BackfillEnabled bool
+	BackfillMaxAge  time.Duration
+	BackfillChats   []string
+
+	ProcessWorkers      int
+	WorkQueueSize       int
+	WorkQueuePolicy     string
+	WorkQueueDMPriority bool
+
+	AgentHealthURL      string
+	AgentHealthInterval time.Duration
+	HealthAddr          string
+
+	PerformanceProfile string
+	PollInterval       time.Duration
+	PollIdleIntervals  []time.Duration // slower steps while idle, see pollInterval
+	ProcessPause       time.Duration   // after each received batch
+	MemoryLimitMB      int
 }
 
 // Message represents a Signal message structure
 type Message struct {
 	Envelope struct {
-		Source         string `json:"source"`
-		Timestamp      int64  `json:"timestamp"`
-		IsReceipt      bool   `json:"isReceipt"`
-		SyncMessage    struct {
+		Source       string `json:"source"`
+		SourceNumber string `json:"sourceNumber"`
+		SourceUuid   string `json:"sourceUuid"`
+		SourceName   string `json:"sourceName"`
+		SourceDevice int    `json:"sourceDevice"`
+		Timestamp    int64  `json:"timestamp"`
+		IsReceipt    bool   `json:"isReceipt"`
+		SyncMessage  struct {
 			SentMessage struct {
-				Destination     string `json:"destination"`
-				DestinationUuid string `json:"destinationUuid"`
-				Message         string `json:"message"`
-				Timestamp       int64  `json:"timestamp"`
-				GroupInfo       struct {
+				Destination       string       `json:"destination"`
+				DestinationNumber string       `json:"destinationNumber"`
+				DestinationUuid   string       `json:"destinationUuid"`
+				Message           string       `json:"message"`
+				Timestamp         int64        `json:"timestamp"`
+				Attachments       []Attachment `json:"attachments"`
+				Reaction          *Reaction    `json:"reaction"`
+				Quote             *Quote       `json:"quote"`
+				GroupInfo         struct {
 					GroupId   string `json:"groupId"`
 					GroupName string `json:"groupName"`
 				} `json:"groupInfo"`
 			} `json:"sentMessage"`
 		} `json:"syncMessage"`
 		DataMessage struct {
-			Message   string `json:"message"`
-			Timestamp int64  `json:"timestamp"`
-			GroupInfo struct {
+			Message     string       `json:"message"`
+			Timestamp   int64        `json:"timestamp"`
+			Attachments []Attachment `json:"attachments"`
+			Reaction    *Reaction    `json:"reaction"`
+			Quote       *Quote       `json:"quote"`
+			GroupInfo   struct {
 				GroupId   string `json:"groupId"`
 				GroupName string `json:"groupName"`
+				Type      string `json:"type"` // "DELIVER", or "UPDATE" for group changes
 			} `json:"groupInfo"`
 		} `json:"dataMessage"`
 		ReceiptMessage struct {
-			When        int64   `json:"when"`
-			IsDelivery  bool    `json:"isDelivery"`
-			IsRead      bool    `json:"isRead"`
-			Timestamps  []int64 `json:"timestamps"`
+			When       int64   `json:"when"`
+			IsDelivery bool    `json:"isDelivery"`
+			IsRead     bool    `json:"isRead"`
+			Timestamps []int64 `json:"timestamps"`
 		} `json:"receiptMessage"`
 	} `json:"envelope"`
+	// Exception is set when signal-cli couldn't decrypt the envelope
+	Exception *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"exception,omitempty"`
+}
+
+// Reaction is an emoji reaction to an earlier message
+type Reaction struct {
+	Emoji               string `json:"emoji"`
+	TargetAuthor        string `json:"targetAuthor"`
+	TargetAuthorNumber  string `json:"targetAuthorNumber"`
+	TargetAuthorUuid    string `json:"targetAuthorUuid"`
+	TargetSentTimestamp int64  `json:"targetSentTimestamp"`
+	IsRemove            bool   `json:"isRemove"`
+}
+
+// Quote is the message a Signal message replies to
+type Quote struct {
+	ID           int64  `json:"id"` // sent timestamp of the quoted message
+	Author       string `json:"author"`
+	AuthorNumber string `json:"authorNumber"`
+	AuthorUuid   string `json:"authorUuid"`
+	Text         string `json:"text"`
+}
+
+// Attachment describes a file attached to a Signal message
+type Attachment struct {
+	ContentType string `json:"contentType"`
+	Filename    string `json:"filename"`
+	ID          string `json:"id"`
+	Size        int64  `json:"size"`
+	VoiceNote   bool   `json:"voiceNote,omitempty"`
 }
 
 // PendingMessage stores a sent AI message waiting for delivery confirmation
 type PendingMessage struct {
-	Timestamp int64
-	Content   string
-	Prompt    string
-	SentTime  time.Time
+	Timestamp int64           `json:"timestamp"`
+	Content   string          `json:"content"`
+	Prompt    string          `json:"prompt"`
+	Sender    AgentSender     `json:"sender"`
+	SentTime  time.Time       `json:"sent_time"`
+	Documents []AgentDocument `json:"documents,omitempty"` // attached to the original message
+	Images    []AgentImage    `json:"images,omitempty"`
 }
 
-// AgentRequest represents the request payload to the agent
+// AgentRequest represents the request payload to the agent. Only Prompt is
+// sent when AGENT_MINIMAL_REQUEST is enabled.
 type AgentRequest struct {
-	Prompt string `json:"prompt"`
+	Prompt    string       `json:"prompt"`
+	Sender    *AgentSender `json:"sender,omitempty"`
+	Chat      *AgentChat   `json:"chat,omitempty"`
+	Timestamp int64        `json:"timestamp,omitempty"`
+
+	// Protocol v2 fields, ignored by v1 agents
+	ConversationID string             `json:"conversation_id,omitempty"`
+	History        []AgentTurn        `json:"history,omitempty"`
+	Capabilities   *AgentCapabilities `json:"capabilities,omitempty"`
+	Persona        *AgentPersona      `json:"persona,omitempty"`
+	Model          string             `json:"model,omitempty"`
+	Parameters     *AgentParameters   `json:"parameters,omitempty"`
+	ToolResults    []AgentToolResult  `json:"tool_results,omitempty"`
+	Documents      []AgentDocument    `json:"documents,omitempty"`
+	Images         []AgentImage       `json:"images,omitempty"`
+	Language       string             `json:"language,omitempty"`      // code of the language to reply in
+	Timezone       string             `json:"timezone,omitempty"`      // IANA name of the asker's timezone
+	SystemPrompt   string             `json:"system_prompt,omitempty"` // set in the chat with !system
+
+	quota bool   // the answer counts against the sender's daily token quota
+	role  string // role of the asker when known from their message
+}
+
+// AgentSender describes who sent a prompt
+type AgentSender struct {
+	Number string `json:"number,omitempty"`
+	UUID   string `json:"uuid,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+// AgentChat describes the conversation a prompt was sent in
+type AgentChat struct {
+	IsDM      bool   `json:"is_dm"`
+	Recipient string `json:"recipient,omitempty"`
+	GroupID   string `json:"group_id,omitempty"`
+	GroupName string `json:"group_name,omitempty"`
 }
 
-// AgentResponse represents the response from the agent
+// AgentResponse represents the response from the agent. v1 agents set
+// Response; v2 agents may return several Messages instead.
 type AgentResponse struct {
-	Response string `json:"response"`
+	Response string         `json:"response"`
+	Messages []AgentMessage `json:"messages,omitempty"`
+
+	// Tool invocation requested by a v2 agent instead of a final answer
+	Tool string `json:"tool,omitempty"`
+	To   string `json:"to,omitempty"`
+	Text string `json:"text,omitempty"`
+
+	// Side effects to perform after sending the reply (v2 only)
+	Actions []AgentAction `json:"actions,omitempty"`
+
+	version string // protocol version the agent answered with
 }
 
 // SignalBot handles Signal message processing
 type SignalBot struct {
 	config          Config
 	logger          *log.Logger
-	triggers        []string
+	pendingMu       sync.Mutex
 	pendingMessages map[int64]*PendingMessage // timestamp -> pending message
+	httpClient      *http.Client
+	linkClient      *http.Client // for shared links, see newLinkClient
+	breaker         *circuitBreaker
+	inFlight        atomic.Int64 // agent calls currently in progress
+	switches        *killSwitches
+	history         *conversationHistory
+	settings        *chatSettings
+	attachments     *attachmentLog
+	personas        map[string]string
+	aliases         *chatSettings     // chat ID -> alias name -> prompt, see !alias
+	operatorAliases map[string]string // from ALIASES_FILE
+	templates       *promptTemplates
+	macros          *macroStore
+	scheduler       *scheduler
+	health          agentHealth
+	cache           *responseCache
+	inflight        *inflightCalls
+	greetings       *rateWindow
+	agentSlots      *fifoSemaphore
+	outbox          *outbox
+	sendQueue       *sendQueue
+	sendPause       sendPause
+	watch           receiveWatch
+	identities      identityChanges
+	refusals        refusalLog
+	autoBlocks      *autoBlocker
+	auditLog        *auditTrail
+	rateLimits      *userRateLimit
+	floods          *floodGuard
+	quotas          *tokenQuota
+	usage           *usageStats
+	adminEvents     map[string]bool // from ADMIN_NOTIFY_EVENTS
+	moderation      moderationRules
+	redactions      []*regexp.Regexp // from REDACT_PATTERNS and REDACT_CUSTOM
+	profanity       profanityFilter
+	queueAlerts     *rateWindow
+	flags           *featureFlags
+	live            atomic.Pointer[liveConfig] // settings a reload can change
+	reloadMu        sync.Mutex                 // serializes config reloads
+	startedAt       time.Time                  // when polling began; messages sent earlier are backfill
+	positions       *queuePositions
+	work            *workQueue
+	processing      []*Message // taken from work, not yet done
+	unqueued        []Message  // received, waiting for room in work
+	inboxMu         sync.Mutex // guards processing and unqueued, serializes saveInbox
+	chatQueues      *chatQueues
+	supervisor      *supervisor
+	roster          *groupRoster
+	groupBuffer     *groupBuffer
+	undo            *undoWindows
+	knowledge       vectorIndex
+	archive         messageArchive // nil unless ARCHIVE_ENABLED
+	state           stateStore
+	embedder        embedder
+	answering       sync.WaitGroup
 }
 
-// NewSignalBot creates a new SignalBot instance
-func NewSignalBot() *SignalBot {
+// loadConfig builds the configuration from the environment and config file
+func loadConfig() Config {
+	beginSettingsLoad()
+	profileName := getEnv("PERFORMANCE_PROFILE", "default")
+	profile, err := lookupProfile(profileName)
+	if err != nil {
+		log.Printf("%v, using default", err)
+		profile = performanceProfiles["default"]
+	}
+
 	config := Config{
+		SignalAccount: getEnv("SIGNAL_ACCOUNT", ""),
+
+		ConfigWatchInterval: getEnvDuration("CONFIG_WATCH_INTERVAL", 10*time.Second),
+
+		SignalReceiveTimeout:     getEnvDuration("SIGNAL_RECEIVE_TIMEOUT", 2*time.Minute),
+		SignalSendTimeout:        getEnvDuration("SIGNAL_SEND_TIMEOUT", time.Minute),
+		SignalTrustPolicy:        getEnv("SIGNAL_TRUST_POLICY", trustFirstUseOnly),
+		ChallengeChannel:         getEnv("CHALLENGE_CHANNEL", challengeChannelOwner),
+		ChallengeAccount:         getEnv("CHALLENGE_ACCOUNT", ""),
+		ChallengeWebhookURL:      getEnv("CHALLENGE_WEBHOOK_URL", ""),
+		IdentityChangeNotifyChat: getEnvBool("IDENTITY_CHANGE_NOTIFY_CHAT", false),
+		WatchdogFailures:         getEnvInt("WATCHDOG_FAILURES", 3),
+		AdminNotify:              getEnv("ADMIN_NOTIFY", getEnv("WATCHDOG_NOTIFY", getEnv("SIGNAL_ACCOUNT", ""))),
+		AdminNotifyEvents:        getEnvList("ADMIN_NOTIFY_EVENTS", adminEvents),
+		SilentFailures:           strings.ToLower(getEnv("SILENT_FAILURES", silentFailuresOff)),
+		ErrorTemplates:           readErrorTemplates(),
+		LockDir:                  getEnv("LOCK_DIR", signalDataDir()),
+		Coordination:             getEnv("COORDINATION", ""),
+		LeaderLease:              getEnvDuration("LEADER_LEASE", 15*time.Second),
+
 		AIPrefix: getEnv("AI_PREFIX", "!ai"),
-		AgentURL: getEnv("AGENT_URL", ""),
+		Triggers: getEnvList("TRIGGERS", nil),
+
+		AccessDefault:   strings.ToLower(getEnv("ACCESS_DEFAULT", accessAllow)),
+		AllowSenders:    getEnvList("ALLOW_SENDERS", nil),
+		DenySenders:     getEnvList("DENY_SENDERS", nil),
+		AllowGroups:     getEnvList("ALLOW_GROUPS", nil),
+		DenyGroups:      getEnvList("DENY_GROUPS", nil),
+		AccessAutoBlock: getEnvInt("ACCESS_AUTO_BLOCK", 0),
+
+		RolesOwners:  getEnvList("ROLES_OWNERS", nil),
+		RolesAdmins:  getEnvList("ROLES_ADMINS", nil),
+		CommandRoles: getEnvMap("COMMAND_ROLES"),
+		ToolsRole:    strings.ToLower(getEnv("TOOLS_ROLE", roleAdmin)),
+		AgentURL:     getEnv("AGENT_URL", ""),
+		AgentToken:   getEnv("AGENT_TOKEN", ""),
+		AgentProxy:   getEnv("AGENT_PROXY", ""),
+
+		AgentRetries:          getEnvInt("AGENT_RETRIES", 2),
+		AgentRetryBackoff:     getEnvDuration("AGENT_RETRY_BACKOFF", 500*time.Millisecond),
+		AgentBreakerThreshold: getEnvInt("AGENT_BREAKER_THRESHOLD", 5),
+		AgentBreakerCooldown:  getEnvDuration("AGENT_BREAKER_COOLDOWN", time.Minute),
+		AgentMaxConcurrency:   getEnvInt("AGENT_MAX_CONCURRENCY", profile.agentSlots),
+
+		DataDir:     getEnv("DATA_DIR", "data"),
+		StateFile:   getEnv("STATE_FILE", ""),
+		StateReload: getEnvBool("STATE_RELOAD", false),
+		StateStore:  getEnv("STATE_STORE", "json"),
+		RedisURL:    getEnv("REDIS_URL", ""),
+		RedisPrefix: getEnv("REDIS_PREFIX", "signalbot:"),
+
+		AgentMinimalRequest:     getEnvBool("AGENT_MINIMAL_REQUEST", false),
+		AgentProtocol:           getEnvInt("AGENT_PROTOCOL", 2),
+		AgentHistoryTurns:       getEnvInt("AGENT_HISTORY_TURNS", profile.historyTurns),
+		AgentHistoryMaxAge:      getEnvDuration("AGENT_HISTORY_MAX_AGE", 24*time.Hour),
+		AgentHistoryMaxTokens:   getEnvInt("AGENT_HISTORY_MAX_TOKENS", 0),
+		HistoryPruneInterval:    getEnvDuration("HISTORY_PRUNE_INTERVAL", 10*time.Minute),
+		AgentHistoryTokenBudget: getEnvInt("AGENT_HISTORY_TOKEN_BUDGET", 0),
+		AgentContextTokens:      getEnvInt("AGENT_CONTEXT_TOKENS", 0),
+		MaxPromptTokens:         getEnvInt("MAX_PROMPT_TOKENS", 4000),
+		GroupThreadPerUser:      getEnvBool("GROUP_THREAD_PER_USER", true),
+		ResponseCacheSize:       getEnvInt("RESPONSE_CACHE_SIZE", 100),
+		ResponseCacheTTL:        getEnvDuration("RESPONSE_CACHE_TTL", 0),
+		AgentToolsEnabled:       getEnvBool("AGENT_TOOLS_ENABLED", false),
+		AgentMaxToolSteps:       getEnvInt("AGENT_MAX_TOOL_STEPS", 5),
+		AgentModels:             getEnvList("AGENT_MODELS", nil),
+		AgentDefaultModel:       getEnv("AGENT_DEFAULT_MODEL", ""),
+		AgentActions:            getEnvList("AGENT_ACTIONS", []string{actionReact}),
+		AgentForwardTargets:     getEnvMap("AGENT_FORWARD_TARGETS"),
+
+		PersonasFile:  getEnv("PERSONAS_FILE", ""),
+		AliasesFile:   getEnv("ALIASES_FILE", ""),
+		TemplatesFile: getEnv("TEMPLATES_FILE", ""),
+		MacrosFile:    getEnv("MACROS_FILE", ""),
+
+		AttachmentsEnabled: getEnvBool("ATTACHMENTS_ENABLED", false),
+		AttachmentsDir:     getEnv("SIGNAL_ATTACHMENTS_DIR", filepath.Join(signalDataDir(), "attachments")),
+		AttachmentLogSize:  getEnvInt("ATTACHMENT_LOG_SIZE", 50),
+
+		KnowledgeEnabled:   getEnvBool("KNOWLEDGE_ENABLED", false),
+		KnowledgeTopK:      getEnvInt("KNOWLEDGE_TOP_K", 3),
+		KnowledgeMaxChunks: getEnvInt("KNOWLEDGE_MAX_CHUNKS", 500),
+		VectorStore:        getEnv("VECTOR_STORE", "json"),
+		EmbeddingsURL:      getEnv("EMBEDDINGS_URL", ""),
+		EmbeddingsModel:    getEnv("EMBEDDINGS_MODEL", "text-embedding-3-small"),
+		EmbeddingsAPIKey:   getEnv("EMBEDDINGS_API_KEY", ""),
+		SearchEmbeddings:   getEnvBool("SEARCH_EMBEDDINGS", false),
+
+		ArchiveEnabled:   getEnvBool("ARCHIVE_ENABLED", false),
+		ArchiveStore:     getEnv("ARCHIVE_STORE", "jsonl"),
+		ArchiveRetention: getEnvDuration("ARCHIVE_RETENTION", 90*24*time.Hour),
+		AuditLog:         getEnv("AUDIT_LOG", ""),
+
+		DocumentsEnabled:  getEnvBool("DOCUMENTS_ENABLED", false),
+		DocumentMaxTokens: getEnvInt("DOCUMENT_MAX_TOKENS", 6000),
+		PDFExtractCommand: getEnv("PDF_EXTRACT_COMMAND", defaultPDFExtractCommand),
+		PDFExtractURL:     getEnv("PDF_EXTRACT_URL", ""),
+		OCRURL:            getEnv("OCR_URL", ""),
+		VisionEnabled:     getEnvBool("VISION_ENABLED", false),
+
+		LinkFetchEnabled:    getEnvBool("LINK_FETCH_ENABLED", false),
+		LinkMaxBytes:        int64(getEnvInt("LINK_MAX_BYTES", 2<<20)),
+		LinkAllowDomains:    getEnvList("LINK_ALLOW_DOMAINS", nil),
+		LinkDenyDomains:     getEnvList("LINK_DENY_DOMAINS", nil),
+		TranscriptLanguages: getEnvList("TRANSCRIPT_LANGUAGES", []string{"en"}),
+
+		GroupBufferSize:         getEnvInt("GROUP_BUFFER_SIZE", 200),
+		SummaryReactionDelivery: getEnv("SUMMARY_REACTION_DELIVERY", "reply"),
+
+		TranslateURL:    getEnv("TRANSLATE_URL", ""),
+		TranslateAPIKey: getEnv("TRANSLATE_API_KEY", ""),
+
+		ModerationBlock:      getEnvList("MODERATION_BLOCK", nil),
+		ModerationSanitize:   getEnvList("MODERATION_SANITIZE", nil),
+		ModerationURL:        getEnv("MODERATION_URL", ""),
+		ModerationAPIKey:     getEnv("MODERATION_API_KEY", ""),
+		ModerationModel:      getEnv("MODERATION_MODEL", ""),
+		ModerationFailClosed: getEnvBool("MODERATION_FAIL_CLOSED", false),
+
+		RedactPatterns: getEnvList("REDACT_PATTERNS", nil),
+		RedactCustom:   getEnvList("REDACT_CUSTOM", nil),
+		RedactScope:    strings.ToLower(getEnv("REDACT_SCOPE", redactScopeGroups)),
+		RedactText:     getEnv("REDACT_TEXT", "[redacted]"),
+
+		ProfanityLevel:      strings.ToLower(getEnv("PROFANITY_LEVEL", profanityOff)),
+		ProfanityWords:      getEnvList("PROFANITY_WORDS", nil),
+		ProfanityBypassRole: strings.ToLower(getEnv("PROFANITY_BYPASS_ROLE", roleOwner)),
+
+		GreetingEnabled:   getEnvBool("GREETING_ENABLED", false),
+		GreetingMessage:   strings.ReplaceAll(getEnv("GREETING_MESSAGE", defaultGreeting), `\n`, "\n"),
+		GreetingRateLimit: getEnvInt("GREETING_RATE_LIMIT", 10),
+
+		RateLimitPerMinute: getEnvInt("RATE_LIMIT_PER_MINUTE", 0),
+		RateLimitPerDay:    getEnvInt("RATE_LIMIT_PER_DAY", 0),
+		RateLimitExempt:    getEnvList("RATE_LIMIT_EXEMPT", nil),
+
+		FloodSenderThreshold: getEnvInt("FLOOD_SENDER_THRESHOLD", 20),
+		FloodGroupThreshold:  getEnvInt("FLOOD_GROUP_THRESHOLD", 40),
+		FloodWindow:          getEnvDuration("FLOOD_WINDOW", time.Minute),
+		FloodMute:            getEnvDuration("FLOOD_MUTE", 10*time.Minute),
+
+		TokenQuotaDaily:       getEnvInt("TOKEN_QUOTA_DAILY", 0),
+		TokenQuotaWarnPercent: getEnvInt("TOKEN_QUOTA_WARN_PERCENT", 80),
+		UsageSummaryInterval:  getEnvDuration("USAGE_SUMMARY_INTERVAL", 0),
+
+		RemindersPerSender: getEnvInt("REMINDERS_PER_SENDER", 20),
+
+		VoiceTranscribeURL:   getEnv("VOICE_TRANSCRIBE_URL", ""),
+		VoiceTranscribeModel: getEnv("VOICE_TRANSCRIBE_MODEL", "whisper-1"),
+		VoiceTTSURL:          getEnv("VOICE_TTS_URL", ""),
+		VoiceTTSModel:        getEnv("VOICE_TTS_MODEL", "tts-1"),
+		VoiceTTSVoice:        getEnv("VOICE_TTS_VOICE", "alloy"),
+		VoiceAPIKey:          getEnv("VOICE_API_KEY", ""),
+
+		PromptTemplate: strings.ReplaceAll(getEnv("PROMPT_TEMPLATE", ""), `\n`, "\n"),
+
+		SendIntervalDM:        getEnvDuration("SEND_INTERVAL_DM", 0),
+		SendIntervalGroup:     getEnvDuration("SEND_INTERVAL_GROUP", time.Second),
+		SendIntervalBroadcast: getEnvDuration("SEND_INTERVAL_BROADCAST", 3*time.Second),
+		SendRateGlobal:        getEnvInt("SEND_RATE_GLOBAL", 60),
+		SendRateRecipient:     getEnvInt("SEND_RATE_RECIPIENT", 20),
+		SendBurst:             getEnvInt("SEND_BURST", 5),
+		SendRateLimitCooldown: getEnvDuration("SEND_RATE_LIMIT_COOLDOWN", 10*time.Minute),
+		OutboxMaxAttempts:     getEnvInt("OUTBOX_MAX_ATTEMPTS", 5),
+		OutboxRetryBackoff:    getEnvDuration("OUTBOX_RETRY_BACKOFF", 30*time.Second),
+		QueuePositionFeedback: getEnvBool("QUEUE_POSITION_FEEDBACK", true),
+
+		BackfillEnabled: getEnvBool("BACKFILL_ENABLED", true),
+		BackfillMaxAge:  getEnvDuration("BACKFILL_MAX_AGE", 0),
+		BackfillChats:   getEnvList("BACKFILL_CHATS", nil),
+
+		ProcessWorkers:      getEnvInt("PROCESS_WORKERS", 1),
+		WorkQueueSize:       getEnvInt("WORK_QUEUE_SIZE", profile.workQueueSize),
+		WorkQueuePolicy:     strings.ToLower(getEnv("WORK_QUEUE_POLICY", queuePolicyBlock)),
+		WorkQueueDMPriority: getEnvBool("WORK_QUEUE_DM_PRIORITY", true),
+
+		AgentHealthURL:      getEnv("AGENT_HEALTH_URL", ""),
+		AgentHealthInterval: getEnvDuration("AGENT_HEALTH_INTERVAL", 30*time.Second),
+		HealthAddr:          getEnv("HEALTH_ADDR", ""),
+
+		PerformanceProfile: profile.name,
+		PollInterval:       getEnvDuration("POLL_INTERVAL", profile.pollInterval),
+		PollIdleIntervals:  getEnvDurationList("POLL_IDLE_INTERVALS", []time.Duration{30 * time.Second, time.Minute}),
+		ProcessPause:       getEnvDuration("PROCESS_PAUSE", time.Second),
+		MemoryLimitMB:      getEnvInt("MEMORY_LIMIT_MB", profile.memoryLimitMB),
 	}
+	return config
+}
 
+// NewSignalBot creates a new SignalBot instance
+func NewSignalBot() *SignalBot {
+	config := loadConfig()
 	logger := log.New(os.Stdout, "[SignalBot] ", log.LstdFlags)
 
-	return &SignalBot{
+	settings := newChatSettings("chat_settings")
+	settings.SetDefaults(configFileChats)
+
+	bot := &SignalBot{
 		config:          config,
 		logger:          logger,
-		triggers:        []string{"🤖 ", "qq ", config.AIPrefix + " "},
 		pendingMessages: make(map[int64]*PendingMessage),
+		breaker:         newCircuitBreaker(config.AgentBreakerThreshold, config.AgentBreakerCooldown),
+		switches:        newKillSwitches(),
+		flags:           newFeatureFlags(),
+		rateLimits:      newUserRateLimit(config),
+		floods:          newFloodGuard(config),
+		autoBlocks:      newAutoBlocker(config),
+		quotas:          newTokenQuota(config),
+		usage:           newUsageStats(),
+		adminEvents:     make(map[string]bool),
+		queueAlerts:     newRateWindow(1, queueAlertInterval),
+		state:           newMemoryStore(),
+		history: newConversationHistory(historyRetention{
+			MaxTurns:  config.AgentHistoryTurns,
+			MaxAge:    config.AgentHistoryMaxAge,
+			MaxTokens: config.AgentHistoryMaxTokens,
+		}),
+		settings:    settings,
+		aliases:     newChatSettings("aliases"),
+		templates:   newPromptTemplates(filepath.Join(config.DataDir, "templates.json")),
+		macros:      newMacroStore(filepath.Join(config.DataDir, "macros.json")),
+		attachments: newAttachmentLog(filepath.Join(config.DataDir, "attachments.json"), config.AttachmentLogSize),
+		scheduler:   newScheduler(),
+		cache:       newResponseCache(config.ResponseCacheSize, config.ResponseCacheTTL),
+		inflight:    newInflightCalls(),
+		chatQueues:  newChatQueues(),
+		supervisor:  newSupervisor(),
+		roster:      newGroupRoster(),
+		groupBuffer: newGroupBuffer(config.GroupBufferSize),
+		greetings:   newRateWindow(config.GreetingRateLimit, time.Hour),
+		agentSlots:  newFIFOSemaphore(config.AgentMaxConcurrency),
+		sendQueue:   newSendQueue(),
+		positions:   newQueuePositions(),
+		work:        newWorkQueue(config.WorkQueueSize, config.WorkQueuePolicy, config.WorkQueueDMPriority),
+		outbox: newOutbox(map[destinationType]time.Duration{
+			destDM:        config.SendIntervalDM,
+			destGroup:     config.SendIntervalGroup,
+			destBroadcast: config.SendIntervalBroadcast,
+		}, config.SendRateGlobal, config.SendRateRecipient, config.SendBurst),
 	}
+	bot.history.retention.MaxAgeOf = bot.historyMaxAge
+	bot.linkClient = newLinkClient(bot.linkAllowed)
+	bot.auditLog = newAuditTrail(config)
+	bot.live.Store(newLiveConfig(config))
+	for _, event := range config.AdminNotifyEvents {
+		bot.adminEvents[event] = true
+	}
+	return bot
+}
+
+// signalDataDir is signal-cli's data directory, which every instance
+// polling the same account necessarily shares
+func signalDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(home, ".local", "share", "signal-cli")
 }
 
-// getEnv returns environment variable value or fallback
+// getEnv returns a setting from the environment or config file, or fallback
 func getEnv(key, fallback string) string {
-	if val, exists := os.LookupEnv(key); exists {
+	if val, exists := lookupSetting(key); exists {
 		return val
 	}
 	return fallback
 }
 
+// getEnvInt returns environment variable value parsed as an int or fallback
+func getEnvInt(key string, fallback int) int {
+	val, exists := lookupSetting(key)
+	if !exists || val == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		log.Printf("Invalid integer for %s (%q), using default %d", key, val, fallback)
+		return fallback
+	}
+	return n
+}
+
+// getEnvList returns a comma-separated environment variable as a list or fallback
+func getEnvList(key string, fallback []string) []string {
+	val, exists := lookupSetting(key)
+	if !exists {
+		return fallback
+	}
+	var list []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvMap returns a comma-separated list of key=value pairs as a map
+func getEnvMap(key string) map[string]string {
+	m := make(map[string]string)
+	for _, pair := range getEnvList(key, nil) {
+		k, v, found := strings.Cut(pair, "=")
+		if !found {
+			log.Printf("Ignoring malformed %s entry %q (expected key=value)", key, pair)
+			continue
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return m
+}
+
+// getEnvBool returns environment variable value parsed as a bool or fallback
+func getEnvBool(key string, fallback bool) bool {
+	val, exists := lookupSetting(key)
+	if !exists || val == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		log.Printf("Invalid boolean for %s (%q), using default %t", key, val, fallback)
+		return fallback
+	}
+	return b
+}
+
+// getEnvDuration returns environment variable value parsed as a duration or fallback
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	val, exists := lookupSetting(key)
+	if !exists || val == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Printf("Invalid duration for %s (%q), using default %s", key, val, fallback)
+		return fallback
+	}
+	return d
+}
+
+// getEnvDurationList returns a comma-separated list of durations or
+// fallback; entries that don't parse are skipped
+func getEnvDurationList(key string, fallback []time.Duration) []time.Duration {
+	if _, exists := lookupSetting(key); !exists {
+		return fallback
+	}
+	var list []time.Duration
+	for _, item := range getEnvList(key, nil) {
+		d, err := time.ParseDuration(item)
+		if err != nil {
+			log.Printf("Ignoring invalid duration %q in %s", item, key)
+			continue
+		}
+		list = append(list, d)
+	}
+	return list
+}
+
 // validateConfig checks if the bot configuration is valid
 func (bot *SignalBot) validateConfig() error {
 	if bot.config.AgentURL == "" {
@@ -120,16 +807,243 @@ func (bot *SignalBot) validateConfig() error {
 		return fmt.Errorf("invalid agent URL: %s (must start with http:// or https://)", bot.config.AgentURL)
 	}
 
+	if bot.config.AgentProxy != "" {
+		if _, err := parseProxyURL(bot.config.AgentProxy); err != nil {
+			return err
+		}
+	}
+
+	if bot.config.AgentRetries < 0 {
+		return fmt.Errorf("AGENT_RETRIES must not be negative")
+	}
+
+	if bot.config.AgentMaxConcurrency < 0 {
+		return fmt.Errorf("AGENT_MAX_CONCURRENCY must not be negative")
+	}
+
+	if bot.config.AgentBreakerThreshold < 1 {
+		return fmt.Errorf("AGENT_BREAKER_THRESHOLD must be at least 1")
+	}
+
+	if bot.config.AgentHealthURL != "" {
+		if !strings.HasPrefix(bot.config.AgentHealthURL, "http://") &&
+			!strings.HasPrefix(bot.config.AgentHealthURL, "https://") {
+			return fmt.Errorf("invalid agent health URL: %s (must start with http:// or https://)", bot.config.AgentHealthURL)
+		}
+		if bot.config.AgentHealthInterval <= 0 {
+			return fmt.Errorf("AGENT_HEALTH_INTERVAL must be positive")
+		}
+	}
+
+	if bot.config.RateLimitPerMinute < 0 || bot.config.RateLimitPerDay < 0 {
+		return fmt.Errorf("RATE_LIMIT_PER_MINUTE and RATE_LIMIT_PER_DAY must not be negative")
+	}
+
+	if bot.config.FloodSenderThreshold < 0 || bot.config.FloodGroupThreshold < 0 {
+		return fmt.Errorf("FLOOD_SENDER_THRESHOLD and FLOOD_GROUP_THRESHOLD must not be negative")
+	}
+	if bot.config.FloodWindow <= 0 || bot.config.FloodMute <= 0 {
+		return fmt.Errorf("FLOOD_WINDOW and FLOOD_MUTE must be positive")
+	}
+
+	if bot.config.TokenQuotaDaily < 0 {
+		return fmt.Errorf("TOKEN_QUOTA_DAILY must not be negative")
+	}
+
+	if bot.config.TokenQuotaWarnPercent < 1 || bot.config.TokenQuotaWarnPercent > 100 {
+		return fmt.Errorf("TOKEN_QUOTA_WARN_PERCENT must be between 1 and 100")
+	}
+
+	if bot.config.UsageSummaryInterval < 0 {
+		return fmt.Errorf("USAGE_SUMMARY_INTERVAL must not be negative")
+	}
+
+	if bot.config.RemindersPerSender < 0 {
+		return fmt.Errorf("REMINDERS_PER_SENDER must not be negative")
+	}
+
+	if bot.config.GreetingRateLimit < 0 {
+		return fmt.Errorf("GREETING_RATE_LIMIT must not be negative")
+	}
+
+	if bot.config.MemoryLimitMB < 0 {
+		return fmt.Errorf("MEMORY_LIMIT_MB must not be negative")
+	}
+
+	if bot.config.AgentProtocol != 1 && bot.config.AgentProtocol != 2 {
+		return fmt.Errorf("AGENT_PROTOCOL must be 1 or 2")
+	}
+
+	if bot.config.AgentDefaultModel != "" && len(bot.config.AgentModels) > 0 &&
+		!contains(bot.config.AgentModels, bot.config.AgentDefaultModel) {
+		return fmt.Errorf("AGENT_DEFAULT_MODEL %q is not listed in AGENT_MODELS", bot.config.AgentDefaultModel)
+	}
+
+	if bot.config.AgentToolsEnabled && bot.config.AgentMaxToolSteps < 1 {
+		return fmt.Errorf("AGENT_MAX_TOOL_STEPS must be at least 1")
+	}
+
+	if d := bot.config.SummaryReactionDelivery; d != "reply" && d != "dm" {
+		return fmt.Errorf("SUMMARY_REACTION_DELIVERY must be reply or dm")
+	}
+
+	if bot.config.BackfillMaxAge < 0 {
+		return fmt.Errorf("BACKFILL_MAX_AGE must not be negative")
+	}
+
+	if bot.config.SendRateGlobal < 0 || bot.config.SendRateRecipient < 0 {
+		return fmt.Errorf("SEND_RATE_GLOBAL and SEND_RATE_RECIPIENT must not be negative")
+	}
+
+	if bot.config.SendRateLimitCooldown <= 0 {
+		return fmt.Errorf("SEND_RATE_LIMIT_COOLDOWN must be positive")
+	}
+
+	if bot.config.SendBurst < 1 {
+		return fmt.Errorf("SEND_BURST must be at least 1")
+	}
+
+	if bot.config.SignalReceiveTimeout <= 0 || bot.config.SignalSendTimeout <= 0 {
+		return fmt.Errorf("SIGNAL_RECEIVE_TIMEOUT and SIGNAL_SEND_TIMEOUT must be positive")
+	}
+
+	if bot.config.PollInterval < 100*time.Millisecond {
+		return fmt.Errorf("POLL_INTERVAL must be at least 100ms")
+	}
+
+	if bot.config.ProcessPause < 0 {
+		return fmt.Errorf("PROCESS_PAUSE must not be negative")
+	}
+
+	for _, interval := range bot.config.PollIdleIntervals {
+		if interval <= 0 {
+			return fmt.Errorf("POLL_IDLE_INTERVALS must be positive durations")
+		}
+	}
+
+	if bot.config.ProcessWorkers < 1 {
+		return fmt.Errorf("PROCESS_WORKERS must be at least 1")
+	}
+
+	if bot.config.WorkQueueSize < 1 {
+		return fmt.Errorf("WORK_QUEUE_SIZE must be at least 1")
+	}
+
+	if !validQueuePolicy(bot.config.WorkQueuePolicy) {
+		return fmt.Errorf("WORK_QUEUE_POLICY must be block, drop-oldest or reject")
+	}
+
+	if _, ok := signalTrustModes[bot.config.SignalTrustPolicy]; !ok {
+		return fmt.Errorf("SIGNAL_TRUST_POLICY must be always, first-use-only or never")
+	}
+
+	if bot.config.AccessDefault != accessAllow && bot.config.AccessDefault != accessDeny {
+		return fmt.Errorf("ACCESS_DEFAULT must be allow or deny")
+	}
+	if bot.config.AccessAutoBlock < 0 {
+		return fmt.Errorf("ACCESS_AUTO_BLOCK must not be negative")
+	}
+
+	if err := validateRoles(bot.config); err != nil {
+		return err
+	}
+
+	if !validChallengeChannel(bot.config.ChallengeChannel) {
+		return fmt.Errorf("CHALLENGE_CHANNEL must be owner, account, webhook or log")
+	}
+
+	if bot.config.ChallengeChannel == challengeChannelAccount && (bot.config.ChallengeAccount == "" || bot.config.AdminNotify == "") {
+		return fmt.Errorf("CHALLENGE_CHANNEL=account requires CHALLENGE_ACCOUNT and ADMIN_NOTIFY")
+	}
+
+	if err := validateAdminEvents(bot.config.AdminNotifyEvents); err != nil {
+		return err
+	}
+
+	if _, err := compileModerationRules(bot.config); err != nil {
+		return err
+	}
+
+	if err := validateRedaction(bot.config); err != nil {
+		return err
+	}
+
+	switch bot.config.ProfanityLevel {
+	case profanityOff, profanityMild, profanityStrict:
+	default:
+		return fmt.Errorf("PROFANITY_LEVEL must be off, mild or strict")
+	}
+	if _, known := roleRanks[bot.config.ProfanityBypassRole]; !known {
+		return fmt.Errorf("PROFANITY_BYPASS_ROLE must be user, admin or owner")
+	}
+
+	switch bot.config.SilentFailures {
+	case silentFailuresOff, silentFailuresGroups, silentFailuresAll:
+	default:
+		return fmt.Errorf("SILENT_FAILURES must be off, groups or all")
+	}
+
+	if bot.config.ChallengeChannel == challengeChannelWebhook && bot.config.ChallengeWebhookURL == "" {
+		return fmt.Errorf("CHALLENGE_CHANNEL=webhook requires CHALLENGE_WEBHOOK_URL")
+	}
+
 	return nil
 }
 
+// parseProxyURL validates an AGENT_PROXY value (http, https, socks5 or socks5h)
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid agent proxy: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid agent proxy: %s (scheme must be http, https, socks5 or socks5h)", u.Redacted())
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid agent proxy: %s (missing host)", u.Redacted())
+	}
+
+	return u, nil
+}
+
+// newAgentClient builds the HTTP client used for agent calls. An explicit
+// AGENT_PROXY takes precedence; otherwise HTTP_PROXY/HTTPS_PROXY/NO_PROXY
+// from the environment are respected.
+func (bot *SignalBot) newAgentClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	if bot.config.AgentProxy != "" {
+		proxyURL, err := parseProxyURL(bot.config.AgentProxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{Timeout: 30 * time.Second, Transport: transport}, nil
+}
+
+// downloadsAttachments reports whether any feature reads attachment files,
+// otherwise signal-cli is told not to download them
+func (bot *SignalBot) downloadsAttachments() bool {
+	return bot.config.AttachmentsEnabled || bot.config.KnowledgeEnabled || bot.config.DocumentsEnabled ||
+		bot.config.OCRURL != "" || bot.config.VisionEnabled || bot.voiceEnabled()
+}
+
 // receiveMessages fetches messages from signal-cli
-func (bot *SignalBot) receiveMessages() ([]Message, error) {
-	cmd := exec.Command("signal-cli", "--output=json", "receive", "--ignore-attachments", "--ignore-stories")
+func (bot *SignalBot) receiveMessages(ctx context.Context) ([]Message, error) {
+	args := []string{"--output=json", "receive", "--ignore-stories"}
+	if !bot.downloadsAttachments() {
+		args = append(args, "--ignore-attachments")
+	}
 	var out bytes.Buffer
-	cmd.Stdout = &out
 
-	if err := cmd.Run(); err != nil {
+	if err := runSignalCLI(ctx, bot.config.SignalReceiveTimeout, &out, nil, bot.trustArgs(args...)...); err != nil {
 		return nil, fmt.Errorf("failed to execute signal-cli: %w", err)
 	}
 
@@ -152,8 +1066,26 @@ func (bot *SignalBot) receiveMessages() ([]Message, error) {
 	return messages, nil
 }
 
-// sendReply sends a reply message via signal-cli with italic formatting using --text-style
+// sendReply sends a reply message, queueing it in the outbox for retries
+// when signal-cli fails
 func (bot *SignalBot) sendReply(recipient, text string, quoteMsgId int64, quoteAuthor string) error {
+	if err := bot.deliverReply(recipient, text, quoteMsgId, quoteAuthor); err != nil {
+		return bot.queueSend(recipient, text, quoteMsgId, quoteAuthor, err)
+	}
+	return nil
+}
+
+// deliverReply sends a reply message via signal-cli with italic formatting using --text-style
+func (bot *SignalBot) deliverReply(recipient, text string, quoteMsgId int64, quoteAuthor string) error {
+	if bot.config.DryRun {
+		bot.logger.Printf("[dry run] Reply to %s (quoting %d): %s", recipient, quoteMsgId, text)
+		return nil
+	}
+	if err := bot.checkSendsPaused(); err != nil {
+		return err
+	}
+	bot.throttle(destinationOf(recipient), recipient)
+
 	var args []string
 
 	// Handle group vs individual messages differently
@@ -178,60 +1110,365 @@ func (bot *SignalBot) sendReply(recipient, text string, quoteMsgId int64, quoteA
 		}
 
 		// Recipient must be the final argument for individual messages
-		args = append(args, recipient)
+		if recipient == noteToSelf {
+			args = append(args, "--note-to-self")
+		} else {
+			args = append(args, recipient)
+		}
 	}
 
 	bot.logger.Printf("Executing: signal-cli %s", strings.Join(args, " "))
 
-	cmd := exec.Command("signal-cli", args...)
-
 	// Capture both stdout and stderr for better debugging
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	var stdout bytes.Buffer
 
-	if err := cmd.Run(); err != nil {
-		bot.logger.Printf("Command failed - stdout: %s, stderr: %s", stdout.String(), stderr.String())
-		return fmt.Errorf("failed to send reply to %s: %w (stderr: %s)", recipient, err, stderr.String())
+	if stderr, err := bot.runSend(recipient, &stdout, args...); err != nil {
+		bot.logger.Printf("Command failed - stdout: %s, stderr: %s", stdout.String(), stderr)
+		return fmt.Errorf("failed to send reply to %s: %w (stderr: %s)", recipient, err, stderr)
 	}
 
 	return nil
 }
 
-// callAgent makes a request to the AI agent
-func (bot *SignalBot) callAgent(ctx context.Context, prompt string) (string, error) {
-	request := AgentRequest{Prompt: prompt}
+// sendAttachment sends a file with an optional caption via signal-cli
+func (bot *SignalBot) sendAttachment(recipient, path, caption string) error {
+	if bot.config.DryRun {
+		bot.logger.Printf("[dry run] Attachment %s to %s: %s", path, recipient, caption)
+		return nil
+	}
+	if err := bot.checkSendsPaused(); err != nil {
+		return err
+	}
+	bot.throttle(destinationOf(recipient), recipient)
+
+	args := []string{"send", "-a", path}
+	if caption != "" {
+		args = append(args, "-m", caption)
+	}
+	if groupId, isGroup := strings.CutPrefix(recipient, "-g "); isGroup {
+		args = append(args, "-g", groupId)
+	} else {
+		args = append(args, recipient)
+	}
+
+	if stderr, err := bot.runSend(recipient, nil, args...); err != nil {
+		return fmt.Errorf("failed to send attachment to %s: %w (stderr: %s)", recipient, err, stderr)
+	}
+	return nil
+}
+
+// sendReaction reacts with emoji to the message identified by targetAuthor
+// and targetTimestamp
+func (bot *SignalBot) sendReaction(recipient, emoji, targetAuthor string, targetTimestamp int64) error {
+	if bot.config.DryRun {
+		bot.logger.Printf("[dry run] Reaction %s to %s on %d by %s", emoji, recipient, targetTimestamp, targetAuthor)
+		return nil
+	}
+	if err := bot.checkSendsPaused(); err != nil {
+		return err
+	}
+	bot.throttle(destinationOf(recipient), recipient)
+
+	args := []string{"sendReaction", "-e", emoji, "-a", targetAuthor, "-t", strconv.FormatInt(targetTimestamp, 10)}
+	if groupId, isGroup := strings.CutPrefix(recipient, "-g "); isGroup {
+		args = append(args, "-g", groupId)
+	} else {
+		args = append(args, recipient)
+	}
+
+	if stderr, err := bot.runSend(recipient, nil, args...); err != nil {
+		return fmt.Errorf("failed to send reaction to %s: %w (stderr: %s)", recipient, err, stderr)
+	}
+	return nil
+}
+
+// sendReplies sends each reply message in order, quoting the prompt on
+// the first one only. In groups every message quotes the prompt so answers
+// to interleaved askers stay attributable. When one fails, it and the rest
+// are queued in the outbox in order.
+func (bot *SignalBot) sendReplies(recipient string, replies []string, quoteMsgId int64, quoteAuthor string) error {
+	isGroup := strings.HasPrefix(recipient, "-g ")
+	var sendErr error
+	for i, reply := range replies {
+		if i > 0 && !isGroup {
+			quoteMsgId, quoteAuthor = 0, ""
+		}
+		if sendErr != nil {
+			bot.queueSend(recipient, reply, quoteMsgId, quoteAuthor, sendErr)
+			continue
+		}
+		if err := bot.deliverReply(recipient, reply, quoteMsgId, quoteAuthor); err != nil {
+			sendErr = bot.queueSend(recipient, reply, quoteMsgId, quoteAuthor, err)
+		}
+	}
+	return sendErr
+}
+
+// callAgent makes a request to the AI agent, retrying transient failures
+// with jittered exponential backoff. Calls are short-circuited while the
+// circuit breaker is open, and refused while the agent is switched off.
+func (bot *SignalBot) callAgent(ctx context.Context, request AgentRequest) (*AgentResponse, error) {
+	if bot.switches.Disabled(switchAgent) {
+		return nil, errAgentDisabled
+	}
+	if !bot.breaker.Allow() {
+		return nil, errCircuitOpen
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= bot.config.AgentRetries; attempt++ {
+		if attempt > 0 {
+			delay := backoffDelay(bot.config.AgentRetryBackoff, attempt)
+			bot.logger.Printf("Retrying agent call in %s (attempt %d/%d): %v", delay, attempt, bot.config.AgentRetries, lastErr)
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		err := bot.agentSlots.AcquireQueued(ctx, func(position int) {
+			bot.reportQueuePosition(request.Timestamp, position)
+		})
+		if err != nil {
+			return nil, err
+		}
+		bot.reportQueueStart(request.Timestamp)
+		bot.inFlight.Add(1)
+		response, err := bot.callAgentOnce(ctx, request)
+		bot.inFlight.Add(-1)
+		bot.agentSlots.Release()
+		if err == nil {
+			if bot.breaker.Success() {
+				bot.notifyAdmin(adminEventAgent, "✅ The agent is answering again.")
+			}
+			return response, nil
+		}
+
+		lastErr = err
+		if ctx.Err() != nil {
+			return nil, lastErr
+		}
+		if !isRetryable(err) {
+			break
+		}
+	}
+
+	if bot.breaker.Failure() {
+		bot.notifyAdmin(adminEventAgent, fmt.Sprintf("⚠️ The agent failed %d times in a row, so I'm not calling it for %s: %v",
+			bot.config.AgentBreakerThreshold, bot.config.AgentBreakerCooldown, lastErr))
+	}
+	return nil, lastErr
+}
+
+// promptAgent calls the agent for a prompt msg's sender asked for outside
+// answer, such as a macro step or a translation: the request is moderated
+// first and the call charged to the sender's quota
+func (bot *SignalBot) promptAgent(ctx context.Context, msg *Message, request AgentRequest) (*AgentResponse, error) {
+	request, ok := bot.screenRequest(ctx, request)
+	if !ok {
+		return nil, errModerated
+	}
+	response, err := bot.callAgent(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	bot.chargePrompt(msg, request, response)
+	return response, nil
+}
+
+// askAgent calls the agent and always returns text suitable for the user,
+// substituting an apology when the call fails. Successful exchanges carry
+// what to record in the conversation history once the reply goes out, and
+// keep the conversation locked until the caller unlocks the answer.
+func (bot *SignalBot) askAgent(ctx context.Context, request AgentRequest) (answer agentAnswer) {
+	chatID := requestChatID(request)
+	if limit := bot.config.MaxPromptTokens; limit > 0 {
+		if tokens := estimateTokens(request.Prompt); tokens > limit {
+			bot.logger.Printf("Rejecting prompt of ~%d tokens (limit %d)", tokens, limit)
+			return textAnswer(bot.localizef(chatID, "That message is too long for me (about %d tokens, the limit is %d). Please shorten it or split it up.", tokens, limit))
+		}
+	}
+
+	if re := bot.profanityFilterFor(request); re != nil && re.MatchString(request.Prompt) {
+		bot.logger.Printf("Profanity filter: refused prompt %d in %s", request.Timestamp, chatID)
+		return bot.errorAnswer(chatID, errorProfanity, nil)
+	}
+	request, ok := bot.screenRequest(ctx, request)
+	if !ok {
+		return bot.errorAnswer(chatID, errorModerated, nil)
+	}
+
+	if request.ConversationID == "" {
+		request.ConversationID = bot.threadID(request)
+	}
+	unlock := bot.history.Lock(request.ConversationID)
+	defer func() {
+		if answer.thread != "" {
+			answer.release = unlock
+		} else {
+			unlock()
+		}
+	}()
+	request.History = bot.compactHistory(ctx, request.ConversationID, bot.history.Recent(request.ConversationID))
+	request.Persona = bot.userPersona(chatID, request.Sender)
+	request.SystemPrompt = bot.chatSystemPrompt(chatID)
+	request.Model = bot.chatModel(chatID)
+	request.Parameters = bot.chatParameters(chatID)
+	request.Language = bot.detectedLanguage(chatID, request.Prompt)
+	if request.Language == "" {
+		request.Language = bot.userSetting(chatID, request.Sender, "language")
+	}
+	request.Timezone = bot.userSetting(chatID, request.Sender, "tz")
+
+	userPrompt := request.Prompt
+	pages, ok := bot.linkedPages(ctx, request)
+	if !ok {
+		return bot.errorAnswer(chatID, errorModerated, nil)
+	}
+	passages, ok := bot.screenDocuments(ctx, request, bot.relevantDocuments(ctx, chatID, request.Prompt))
+	if !ok {
+		return bot.errorAnswer(chatID, errorModerated, nil)
+	}
+	request.Documents = append(append(request.Documents, pages...), passages...)
+	request.Prompt = bot.wrapPrompt(request)
+	request.History = fitHistory(request.Prompt, request.History, bot.config.AgentContextTokens)
+
+	key := cacheKey(request, userPrompt)
+	if cached, hit := bot.cache.Get(key); hit {
+		bot.logger.Printf("Answering from response cache")
+		return agentAnswer{Replies: cached.Replies, thread: request.ConversationID, prompt: userPrompt}
+	}
+
+	result, ok, shared := bot.inflight.Do(key, func() (agentAnswer, bool) {
+		response, err := bot.runAgent(ctx, request)
+		if errors.Is(err, errAgentDisabled) {
+			return textAnswer(bot.localize(chatID, "The assistant is currently disabled.")), false
+		}
+		if err != nil {
+			bot.logger.Printf("Error calling agent: %v", err)
+			return bot.errorAnswer(chatID, classifyAgentError(err), err), false
+		}
+
+		result := agentAnswer{Replies: response.replies(), Actions: response.Actions}
+		bot.cache.Put(key, result)
+		result.Tokens = estimateRequestTokens(request, result.Replies)
+		return result, true
+	})
+	if shared {
+		bot.logger.Printf("Answering from coalesced in-flight request")
+		// Actions target the original asker's message, so only replies fan out
+		result = agentAnswer{Replies: result.Replies, Err: result.Err}
+	}
+	if ok {
+		result.thread, result.prompt = request.ConversationID, userPrompt
+	}
+	return result
+}
+
+// recordHistory adds an exchange to the conversation history, logging
+// failures to persist it
+func (bot *SignalBot) recordHistory(id, prompt string, replies []string) {
+	if err := bot.history.Record(id, prompt, replies); err != nil {
+		bot.logger.Printf("Error saving conversation history: %v", err)
+	}
+}
+
+// agentAnswer is what the bot sends back for a prompt
+type agentAnswer struct {
+	Replies []string
+	Actions []AgentAction
+	Tokens  int   // estimated tokens the agent call used, 0 when none was made
+	Err     error // why the agent call failed; Replies then hold an apology
+
+	thread  string // conversation to record the exchange in, "" for none
+	prompt  string // the prompt as recorded there
+	release func() // unlocks thread, nil when it isn't locked
+}
+
+// unlock releases the conversation held for recording the answer
+func (answer agentAnswer) unlock() {
+	if answer.release != nil {
+		answer.release()
+	}
+}
+
+// requestChatID returns the chat ID of the chat a request came from, or ""
+func requestChatID(request AgentRequest) string {
+	if request.Chat == nil {
+		return ""
+	}
+	return conversationID(*request.Chat)
+}
+
+// textAnswer wraps a single bot-generated message
+func textAnswer(text string) agentAnswer {
+	return agentAnswer{Replies: []string{text}}
+}
+
+// callAgentOnce performs a single request to the AI agent
+func (bot *SignalBot) callAgentOnce(ctx context.Context, request AgentRequest) (*AgentResponse, error) {
+	switch {
+	case bot.config.AgentMinimalRequest:
+		// Minimal agents can't read the system prompt, persona, documents
+		// or language fields, so inline them
+		prompt := inlineDocuments(request.Prompt, request.Documents)
+		if request.Language != "" {
+			prompt = fmt.Sprintf("Reply in %s.\n\n%s", languageName(request.Language), prompt)
+		}
+		if request.Persona != nil {
+			prompt = request.Persona.SystemPrompt + "\n\n" + prompt
+		}
+		if request.SystemPrompt != "" {
+			prompt = request.SystemPrompt + "\n\n" + prompt
+		}
+		request = AgentRequest{Prompt: prompt}
+	case bot.config.AgentProtocol < 2:
+		request.ConversationID = ""
+		request.History = nil
+		request.Capabilities = nil
+	default:
+		request.Capabilities = bot.capabilities(request)
+	}
+
 	body, err := json.Marshal(request)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	url := strings.TrimSuffix(bot.config.AgentURL, "/") + "/signal-bot"
+	url := strings.TrimSuffix(bot.live.Load().AgentURL, "/") + "/signal-bot"
 
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	if !bot.config.AgentMinimalRequest {
+		req.Header.Set(protocolHeader, strconv.Itoa(bot.config.AgentProtocol))
+	}
+	if bot.config.AgentToken != "" {
+		req.Header.Set("Authorization", "Bearer "+bot.config.AgentToken)
+	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := bot.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to call agent: %w", err)
+		return nil, fmt.Errorf("failed to call agent: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("agent returned status %d", resp.StatusCode)
+		return nil, &agentStatusError{StatusCode: resp.StatusCode}
 	}
 
 	var response AgentResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
+	response.version = strings.TrimSpace(resp.Header.Get(protocolHeader))
 
-	return response.Response, nil
+	return &response, nil
 }
 
 // extractContent extracts message content from either sync or data message
@@ -242,6 +1479,14 @@ func (msg *Message) extractContent() string {
 	return msg.Envelope.DataMessage.Message
 }
 
+// quote returns the message msg replies to, or nil
+func (msg *Message) quote() *Quote {
+	if q := msg.Envelope.SyncMessage.SentMessage.Quote; q != nil {
+		return q
+	}
+	return msg.Envelope.DataMessage.Quote
+}
+
 // extractTimestamp extracts message timestamp from either sync or data message
 func (msg *Message) extractTimestamp() int64 {
 	if timestamp := msg.Envelope.SyncMessage.SentMessage.Timestamp; timestamp != 0 {
@@ -261,6 +1506,71 @@ func (msg *Message) extractGroupId() string {
 	return msg.Envelope.DataMessage.GroupInfo.GroupId
 }
 
+// extractAttachments returns the attachments of either sync or data message
+func (msg *Message) extractAttachments() []Attachment {
+	if attachments := msg.Envelope.SyncMessage.SentMessage.Attachments; len(attachments) > 0 {
+		return attachments
+	}
+	return msg.Envelope.DataMessage.Attachments
+}
+
+// extractGroupName extracts group name from either sync or data message
+func (msg *Message) extractGroupName() string {
+	if groupName := msg.Envelope.SyncMessage.SentMessage.GroupInfo.GroupName; groupName != "" {
+		return groupName
+	}
+	return msg.Envelope.DataMessage.GroupInfo.GroupName
+}
+
+// sender returns the sender details of the envelope
+func (msg *Message) sender() AgentSender {
+	number := msg.Envelope.SourceNumber
+	if number == "" {
+		number = msg.Envelope.Source
+	}
+	return AgentSender{
+		Number: number,
+		UUID:   msg.Envelope.SourceUuid,
+		Name:   msg.Envelope.SourceName,
+	}
+}
+
+// chat describes the conversation msg belongs to. The DM partner of a sync
+// message is only known when signal-cli reports its destination.
+func (msg *Message) chat() AgentChat {
+	if groupId := msg.extractGroupId(); groupId != "" {
+		return AgentChat{GroupID: groupId, GroupName: msg.extractGroupName()}
+	}
+	chat := AgentChat{IsDM: true}
+	if sent := msg.Envelope.SyncMessage.SentMessage; sent.Message != "" {
+		chat.Recipient = sent.DestinationNumber
+		if chat.Recipient == "" {
+			chat.Recipient = sent.Destination
+		}
+	} else if msg.Envelope.DataMessage.Message != "" {
+		chat.Recipient = msg.sender().Number
+	}
+	return chat
+}
+
+// chatID returns the stable conversation ID of the chat msg belongs to
+func (msg *Message) chatID() string {
+	return conversationID(msg.chat())
+}
+
+// newAgentRequest builds an agent request for a prompt found in msg
+func (msg *Message) newAgentRequest(prompt string) AgentRequest {
+	sender := msg.sender()
+	chat := msg.chat()
+
+	return AgentRequest{
+		Prompt:    prompt,
+		Sender:    &sender,
+		Chat:      &chat,
+		Timestamp: msg.extractTimestamp(),
+	}
+}
+
 // getRecipient determines the recipient for data messages (messages you received)
 func (msg *Message) getRecipient() string {
 	// For data messages (messages you RECEIVED), reply in the same context
@@ -277,9 +1587,24 @@ func (msg *Message) getRecipient() string {
 	return ""
 }
 
+// replyRecipient determines where to answer a message in the same chat,
+// covering both sync messages (sent from your own account) and data messages
+func (msg *Message) replyRecipient() string {
+	if groupId := msg.extractGroupId(); groupId != "" {
+		return "-g " + groupId
+	}
+	if sent := msg.Envelope.SyncMessage.SentMessage; sent.Message != "" {
+		if sent.DestinationNumber != "" {
+			return sent.DestinationNumber
+		}
+		return sent.Destination
+	}
+	return msg.getRecipient()
+}
+
 // isTriggered checks if the message should trigger the bot (case-insensitive for "qq")
 func (bot *SignalBot) isTriggered(content string) bool {
-	for _, trigger := range bot.triggers {
+	for _, trigger := range bot.live.Load().prefixes {
 		if trigger == "qq " {
 			// Case-insensitive check for "qq " trigger
 			if len(content) >= 3 && strings.ToLower(content[:3]) == "qq " {
@@ -297,7 +1622,7 @@ func (bot *SignalBot) isTriggered(content string) bool {
 
 // extractPrompt removes the trigger prefix from the message content (case-insensitive for "qq")
 func (bot *SignalBot) extractPrompt(content string) string {
-	for _, trigger := range bot.triggers {
+	for _, trigger := range bot.live.Load().prefixes {
 		if trigger == "qq " {
 			// Case-insensitive check for "qq " trigger
 			if len(content) >= 3 && strings.ToLower(content[:3]) == "qq " {
@@ -313,14 +1638,49 @@ func (bot *SignalBot) extractPrompt(content string) string {
 	return content
 }
 
-// cleanupOldPendingMessages removes pending messages older than 5 minutes
+// addPending stores a DM prompt until its delivery receipt arrives
+func (bot *SignalBot) addPending(pending *PendingMessage) {
+	bot.pendingMu.Lock()
+	defer bot.pendingMu.Unlock()
+	bot.pendingMessages[pending.Timestamp] = pending
+	if err := bot.state.PutPending(pending); err != nil {
+		bot.logger.Printf("Error storing pending message: %v", err)
+	}
+}
+
+// takePending removes and returns the pending message for a timestamp
+func (bot *SignalBot) takePending(timestamp int64) (*PendingMessage, bool) {
+	bot.pendingMu.Lock()
+	defer bot.pendingMu.Unlock()
+	pending, exists := bot.pendingMessages[timestamp]
+	if exists {
+		delete(bot.pendingMessages, timestamp)
+		if err := bot.state.DeletePending(timestamp); err != nil {
+			bot.logger.Printf("Error removing pending message: %v", err)
+		}
+	}
+	return pending, exists
+}
+
+// cleanupOldPendingMessages removes pending messages older than 5 minutes,
+// and processed-message keys no longer needed for deduplication
 func (bot *SignalBot) cleanupOldPendingMessages() {
-	cutoff := time.Now().Add(-5 * time.Minute)
+	bot.pendingMu.Lock()
+	defer bot.pendingMu.Unlock()
+
+	cutoff := time.Now().Add(-pendingExpiry)
 	for timestamp, pending := range bot.pendingMessages {
 		if pending.SentTime.Before(cutoff) {
 			delete(bot.pendingMessages, timestamp)
+			if err := bot.state.DeletePending(timestamp); err != nil {
+				bot.logger.Printf("Error removing pending message: %v", err)
+			}
 		}
 	}
+
+	if err := bot.state.PruneProcessed(time.Now().Add(-processedKeyRetention)); err != nil {
+		bot.logger.Printf("Error pruning processed message keys: %v", err)
+	}
 }
 
 // processMessage handles a single message
@@ -331,50 +1691,104 @@ func (bot *SignalBot) processMessage(ctx context.Context, msg Message) {
 
 		// Check if any of the timestamps match our pending AI-triggered messages
 		for _, timestamp := range msg.Envelope.ReceiptMessage.Timestamps {
-			if pending, exists := bot.pendingMessages[timestamp]; exists {
+			if pending, exists := bot.takePending(timestamp); exists {
 				bot.logger.Printf("Found pending AI message for timestamp %d, processing...", timestamp)
 
 				// Now we know where to send the reply - to the person who confirmed delivery
 				recipient := msg.Envelope.Source
 
-				// Call the AI agent with the original prompt
-				reply, err := bot.callAgent(ctx, pending.Prompt)
-				if err != nil {
-					bot.logger.Printf("Error calling agent for pending message: %v", err)
-					reply = "Sorry, I encountered an error processing your request."
-				}
-
-				// Send the reply to the person who received the original message
-				if err := bot.sendReply(recipient, reply, timestamp, msg.Envelope.Source); err != nil {
-					bot.logger.Printf("Error sending reply for pending message: %v", err)
-				} else {
-					bot.logger.Printf("Successfully sent AI reply to %s for pending message", recipient)
-				}
-
-				// Remove from pending messages
-				delete(bot.pendingMessages, timestamp)
+				// Call the AI agent with the original prompt and send the reply
+				// to the person who received the original message
+				bot.answerAsync(ctx, AgentRequest{
+					Prompt:    pending.Prompt,
+					Sender:    &pending.Sender,
+					Chat:      &AgentChat{IsDM: true, Recipient: recipient},
+					Timestamp: pending.Timestamp,
+					Documents: pending.Documents,
+					Images:    pending.Images,
+					role:      roleOwner,
+				}, replyTarget{Recipient: recipient, QuoteTimestamp: timestamp, QuoteAuthor: msg.Envelope.Source})
 				return
 			}
 		}
 		return
 	}
 
+	if !bot.authorized(&msg) {
+		bot.refuseUnauthorized(&msg)
+		return
+	}
+
+	if bot.handleReaction(ctx, &msg) {
+		return
+	}
+
+	// Group changes (members, admins, name) arrive as "UPDATE" messages
+	if info := msg.Envelope.DataMessage.GroupInfo; info.Type == "UPDATE" && info.GroupId != "" {
+		bot.handleGroupUpdate(ctx, info.GroupId)
+	}
+
+	bot.dropAttachments(&msg)
+	if bot.config.AttachmentsEnabled {
+		bot.recordAttachments(&msg)
+	}
+
+	bot.greetNewContact(&msg)
+	bot.bufferGroupMessage(&msg)
+	bot.archiveMessage(ctx, &msg)
+	bot.mirrorGroupMessage(ctx, &msg)
+
+	if bot.handleVoiceNote(ctx, &msg) {
+		return
+	}
+
 	// Handle regular messages
 	content := msg.extractContent()
 	if content == "" {
 		return
 	}
 
-	if !bot.isTriggered(content) {
+	if bot.handleCommand(ctx, &msg, content) {
 		return
 	}
 
-	prompt := bot.extractPrompt(content)
+	prompt, aliased := bot.expandAlias(msg.chatID(), content)
+	if !aliased {
+		switch {
+		case bot.isTriggered(content):
+			prompt = bot.extractPrompt(content)
+		case bot.autorespondsTo(&msg):
+			prompt = strings.TrimSpace(content)
+		default:
+			return
+		}
+	}
 	if prompt == "" {
 		bot.logger.Printf("Empty prompt after removing trigger prefix")
 		return
 	}
 
+	if isResetPrompt(prompt) {
+		bot.handleCommand(ctx, &msg, "!reset")
+		return
+	}
+
+	if !bot.promptAllowed(&msg, true) {
+		return
+	}
+
+	if bot.config.KnowledgeEnabled && isRememberPrompt(prompt) && len(msg.extractAttachments()) > 0 {
+		reply := bot.rememberAttachments(ctx, &msg)
+		if err := bot.sendReply(msg.replyRecipient(), reply, msg.extractTimestamp(), msg.Envelope.Source); err != nil {
+			bot.logger.Printf("Error sending reply: %v", err)
+		}
+		return
+	}
+
+	documents := bot.attachedDocuments(ctx, &msg, prompt)
+	imageTexts, images := bot.attachedImages(ctx, &msg, prompt)
+	documents = append(documents, imageTexts...)
+
 	// Handle sync messages (your own sent messages with AI triggers)
 	if msg.Envelope.SyncMessage.SentMessage.Message != "" {
 		timestamp := msg.extractTimestamp()
@@ -383,31 +1797,29 @@ func (bot *SignalBot) processMessage(ctx context.Context, msg Message) {
 		if groupId := msg.extractGroupId(); groupId != "" {
 			bot.logger.Printf("Processing AI-triggered group message")
 
-			reply, err := bot.callAgent(ctx, prompt)
-			if err != nil {
-				bot.logger.Printf("Error calling agent: %v", err)
-				reply = "Sorry, I encountered an error processing your request."
-			}
-
-			recipient := "-g " + groupId
-			quoteAuthor := msg.Envelope.Source
-
-			if err := bot.sendReply(recipient, reply, timestamp, quoteAuthor); err != nil {
-				bot.logger.Printf("Error sending reply: %v", err)
-			} else {
-				bot.logger.Printf("Successfully sent AI reply to group")
-			}
+			request := msg.newAgentRequest(prompt)
+			request.Documents = documents
+			request.Images = images
+			request.role = roleOwner
+			bot.answerAsync(ctx, request, replyTarget{
+				Recipient:      "-g " + groupId,
+				QuoteTimestamp: timestamp,
+				QuoteAuthor:    msg.Envelope.Source,
+			})
 			return
 		}
 
 		// For individual DMs, store as pending and wait for delivery receipt
 		bot.logger.Printf("Storing AI-triggered DM message as pending (timestamp: %d)", timestamp)
-		bot.pendingMessages[timestamp] = &PendingMessage{
+		bot.addPending(&PendingMessage{
 			Timestamp: timestamp,
 			Content:   content,
 			Prompt:    prompt,
+			Sender:    msg.sender(),
 			SentTime:  time.Now(),
-		}
+			Documents: documents,
+			Images:    images,
+		})
 		return
 	}
 
@@ -421,34 +1833,283 @@ func (bot *SignalBot) processMessage(ctx context.Context, msg Message) {
 
 		bot.logger.Printf("Processing AI-triggered received message from %s", msg.Envelope.Source)
 
-		reply, err := bot.callAgent(ctx, prompt)
-		if err != nil {
-			bot.logger.Printf("Error calling agent: %v", err)
-			reply = "Sorry, I encountered an error processing your request."
-		}
+		request := msg.newAgentRequest(prompt)
+		request.Documents = documents
+		request.Images = images
+		request.quota = bot.hasQuota(&msg)
+		request.role = bot.roleOf(&msg)
+		bot.answerAsync(ctx, request, replyTarget{
+			Recipient:      recipient,
+			QuoteTimestamp: msg.extractTimestamp(),
+			QuoteAuthor:    msg.Envelope.Source,
+		})
+	}
+}
 
-		timestamp := msg.extractTimestamp()
-		quoteAuthor := msg.Envelope.Source
+// replyTarget is where an answer goes and which prompt message it quotes
+type replyTarget struct {
+	Recipient      string
+	QuoteTimestamp int64
+	QuoteAuthor    string
+}
 
-		if err := bot.sendReply(recipient, reply, timestamp, quoteAuthor); err != nil {
-			bot.logger.Printf("Error sending reply: %v", err)
-		} else {
-			bot.logger.Printf("Successfully sent AI reply to %s", recipient)
+// answerAsync runs answer in the background so identical prompts can share
+// one agent call and polling continues meanwhile. The prompt's place in its
+// chat's queue is taken before returning, so follow-ups are still answered
+// in order.
+func (bot *SignalBot) answerAsync(ctx context.Context, request AgentRequest, target replyTarget) {
+	ticket := bot.enterChatQueue(request, target)
+	bot.answering.Add(1)
+	go func() {
+		defer bot.answering.Done()
+		bot.answerInTurn(ctx, ticket, request, target)
+	}()
+}
+
+// answer asks the agent, sends its replies to target and then executes
+// any actions the agent attached. It returns the answer and whether it was
+// delivered.
+func (bot *SignalBot) answer(ctx context.Context, request AgentRequest, target replyTarget) (agentAnswer, bool) {
+	return bot.answerInTurn(ctx, bot.enterChatQueue(request, target), request, target)
+}
+
+// answerInTurn answers once the prompts ahead of ticket in the same chat
+// are done, telling the asker when they have to wait
+func (bot *SignalBot) answerInTurn(ctx context.Context, ticket *chatTicket, request AgentRequest, target replyTarget) (agentAnswer, bool) {
+	defer ticket.Done()
+
+	if ticket.Queued() && bot.wantsNotices(requestChatID(request), request.Sender) {
+		if err := bot.sendReply(target.Recipient, bot.localize(requestChatID(request), queuedNote), target.QuoteTimestamp, target.QuoteAuthor); err != nil {
+			bot.logger.Printf("Error sending queued note: %v", err)
 		}
 	}
+	if err := ticket.Wait(ctx); err != nil {
+		return agentAnswer{}, false
+	}
+
+	if bot.config.QueuePositionFeedback && bot.wantsNotices(requestChatID(request), request.Sender) {
+		bot.positions.Track(request.Timestamp, target)
+		defer bot.positions.Forget(request.Timestamp)
+	}
+	started := time.Now()
+	result := bot.askAgent(ctx, request)
+	bot.recordUsage(request, result, time.Since(started))
+	if !bot.holdForUndo(ctx, request) {
+		result.unlock()
+		return result, false
+	}
+	if !bot.claimReply(request, target) {
+		result.unlock()
+		bot.logger.Printf("Already answered %d in %s, not replying again", request.Timestamp, target.Recipient)
+		return agentAnswer{}, false
+	}
+	if result.thread != "" {
+		bot.recordHistory(result.thread, result.prompt, result.Replies)
+	}
+	result.unlock()
+	if result.Err != nil && bot.failsSilently(request) {
+		bot.reportFailure(request, target, result.Err)
+		return result, false
+	}
+
+	result.Replies = bot.redactReplies(request, result.Replies)
+	if re := bot.profanityFilterFor(request); re != nil {
+		result.Replies = maskProfanity(re, result.Replies)
+	}
+	if len(result.Replies) == 0 {
+		bot.releaseReply(request, target)
+	}
+	if err := bot.sendReplies(target.Recipient, result.Replies, target.QuoteTimestamp, target.QuoteAuthor); err != nil {
+		bot.logger.Printf("Error sending reply: %v", err)
+		return result, false
+	}
+	bot.logger.Printf("Successfully sent AI reply to %s", target.Recipient)
+	bot.archiveAnswer(ctx, request, result.Replies)
+	bot.chargeQuota(request, result, target)
+
+	bot.runActions(ctx, result, target, bot.userLocation(requestChatID(request), request.Sender))
+	return result, true
 }
 
 // Run starts the bot's main processing loop
 func (bot *SignalBot) Run(ctx context.Context) error {
-	if err := bot.validateConfig(); err != nil {
-		return fmt.Errorf("configuration error: %w", err)
+	if err := bot.prepare(); err != nil {
+		return err
 	}
 
-	bot.logger.Printf("Starting Signal bot with triggers: %v", bot.triggers)
+	bot.logger.Printf("Starting Signal bot with triggers: %v", bot.live.Load().prefixes)
 	bot.logger.Printf("Agent URL: %s", bot.config.AgentURL)
+	bot.logger.Printf("Subsystems: %s", bot.switches.String())
+	bot.logger.Printf("Performance profile: %s (poll every %s)", bot.config.PerformanceProfile, bot.config.PollInterval)
+
+	if bot.config.MemoryLimitMB > 0 {
+		debug.SetMemoryLimit(int64(bot.config.MemoryLimitMB) << 20)
+		bot.logger.Printf("Memory limit: %d MiB", bot.config.MemoryLimitMB)
+	}
+
+	if bot.config.AgentProxy != "" {
+		proxyURL, _ := parseProxyURL(bot.config.AgentProxy)
+		bot.logger.Printf("Agent proxy: %s", proxyURL.Redacted())
+	}
+
+	if bot.config.Coordination == "" {
+		lock, err := acquireAccountLock(bot.accountLockPath())
+		if err != nil {
+			if !bot.config.ForceStart {
+				return fmt.Errorf("%w; refusing to start a second instance for this account (use --force to override)", err)
+			}
+			bot.logger.Printf("WARNING: starting despite account lock (--force): %v", err)
+		}
+		defer lock.Release()
+	} else {
+		// Standbys wait here, before loading any state, until the leader dies
+		elector, err := bot.newLeaderElector()
+		if err != nil {
+			return fmt.Errorf("configuration error: %w", err)
+		}
+		if err := bot.awaitLeadership(ctx, elector); err != nil {
+			return err
+		}
+		defer elector.Release()
+
+		var stop context.CancelCauseFunc
+		ctx, stop = context.WithCancelCause(ctx)
+		defer stop(nil)
+		bot.supervise(ctx, "leader-lease", func(ctx context.Context) error {
+			return bot.holdLeadership(ctx, elector, stop)
+		})
+	}
+
+	if err := os.MkdirAll(bot.config.DataDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	state, err := bot.openStateStore(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open state store: %w", err)
+	}
+	defer state.Close()
+	bot.state = state
+	if err := bot.restorePending(); err != nil {
+		return fmt.Errorf("failed to restore pending messages: %w", err)
+	}
+
+	if err := bot.settings.Load(bot.state); err != nil {
+		return fmt.Errorf("failed to load chat settings: %w", err)
+	}
+
+	if err := bot.flags.overrides.Load(bot.state); err != nil {
+		return fmt.Errorf("failed to load feature flags: %w", err)
+	}
+
+	if err := bot.rateLimits.counters.Load(bot.state); err != nil {
+		return fmt.Errorf("failed to load rate limit counters: %w", err)
+	}
+
+	if err := bot.quotas.usage.Load(bot.state); err != nil {
+		return fmt.Errorf("failed to load token usage: %w", err)
+	}
+
+	if err := bot.usage.records.Load(bot.state); err != nil {
+		return fmt.Errorf("failed to load usage: %w", err)
+	}
+
+	if err := bot.autoBlocks.blocked.Load(bot.state); err != nil {
+		return fmt.Errorf("failed to load blocked senders: %w", err)
+	}
+
+	if err := bot.history.Load(bot.state); err != nil {
+		return fmt.Errorf("failed to load conversation history: %w", err)
+	}
+
+	bot.embedder = bot.newEmbedder()
+	knowledge, err := bot.openVectorIndex()
+	if err != nil {
+		return fmt.Errorf("configuration error: %w", err)
+	}
+	if err := knowledge.Load(ctx, bot.embedder); err != nil {
+		return fmt.Errorf("failed to load knowledge store: %w", err)
+	}
+	defer knowledge.Close()
+	bot.knowledge = knowledge
+
+	archive, err := bot.openArchive(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	if archive != nil {
+		defer archive.Close()
+		bot.archive = archive
+	}
+
+	if err := bot.sendQueue.Load(bot.state); err != nil {
+		return fmt.Errorf("failed to load outbox: %w", err)
+	}
+
+	if err := bot.scheduler.Load(bot.state); err != nil {
+		return fmt.Errorf("failed to load scheduled messages: %w", err)
+	}
+
+	if err := bot.attachments.Load(); err != nil {
+		return fmt.Errorf("failed to load attachment log: %w", err)
+	}
+
+	if err := bot.aliases.Load(bot.state); err != nil {
+		return fmt.Errorf("failed to load aliases: %w", err)
+	}
+	if err := bot.loadOperatorFiles(); err != nil {
+		return err
+	}
+
+	if bot.config.StateFile != "" && bot.config.StateReload {
+		if err := bot.loadState(bot.config.StateFile); err != nil {
+			bot.logger.Printf("Could not reload state from %s: %v", bot.config.StateFile, err)
+		}
+	}
+
+	if err := bot.loadGroupRoster(ctx); err != nil {
+		bot.logger.Printf("Could not load group membership, join/leave events start after the first update: %v", err)
+	}
 
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+	bot.replayInbox(ctx)
+
+	bot.supervise(ctx, "scheduler", bot.runScheduler)
+	bot.supervise(ctx, "outbox", bot.runSendQueue)
+	bot.supervise(ctx, "signal-watchdog", bot.runWatchdog)
+	bot.supervise(ctx, "history-pruner", bot.runHistoryPruner)
+	if bot.config.ConfigFile != "" && bot.config.ConfigWatchInterval > 0 {
+		bot.supervise(ctx, "config-watch", bot.runConfigWatch)
+	}
+	if bot.archive != nil {
+		bot.supervise(ctx, "archive-pruner", bot.runArchivePruner)
+	}
+	if bot.config.UsageSummaryInterval > 0 {
+		bot.supervise(ctx, "usage-summary", bot.runUsageSummary)
+	}
+
+	if bot.config.AgentHealthURL != "" {
+		bot.supervise(ctx, "health-probe", bot.runHealthProbe)
+	}
+
+	if bot.config.HealthAddr != "" {
+		bot.supervise(ctx, "health-server", bot.runHealthServer)
+	}
+
+	bot.notifyAdmin(adminEventStartup, fmt.Sprintf("✅ Started with triggers %v; subsystems: %s", bot.live.Load().prefixes, bot.switches.String()))
+
+	// Receiving and processing run apart, so slow processing never holds
+	// up receiving
+	bot.startedAt = time.Now()
+	var pipeline sync.WaitGroup
+	pipeline.Add(2)
+	go func() {
+		defer pipeline.Done()
+		bot.runReceiver(ctx)
+	}()
+	go func() {
+		defer pipeline.Done()
+		bot.runProcessor(ctx)
+	}()
 
 	// Cleanup ticker for old pending messages
 	cleanupTicker := time.NewTicker(1 * time.Minute)
@@ -458,54 +2119,129 @@ func (bot *SignalBot) Run(ctx context.Context) error {
 		select {
 		case <-ctx.Done():
 			bot.logger.Printf("Shutting down bot...")
-			return ctx.Err()
+			pipeline.Wait()
+			bot.answering.Wait()
+			bot.notifyAdmin(adminEventShutdown, fmt.Sprintf("👋 Shutting down: %v", context.Cause(ctx)))
+			return context.Cause(ctx)
 		case <-cleanupTicker.C:
 			bot.cleanupOldPendingMessages()
-		case <-ticker.C:
-			messages, err := bot.receiveMessages()
-			if err != nil {
-				bot.logger.Printf("Error receiving messages: %v", err)
-				continue
-			}
+		}
+	}
+}
 
-			if len(messages) == 0 {
-				continue
-			}
+// prepare validates the configuration and builds what handling messages
+// needs besides stored state: the content filters and the agent client.
+// Run and replayMessages share it.
+func (bot *SignalBot) prepare() error {
+	if err := bot.validateConfig(); err != nil {
+		return fmt.Errorf("configuration error: %w", err)
+	}
+	bot.moderation, _ = compileModerationRules(bot.config)
+	bot.redactions, _ = compileRedactions(bot.config)
+	bot.profanity = newProfanityFilter(bot.config.ProfanityWords)
 
-			bot.logger.Printf("Received %d messages", len(messages))
+	client, err := bot.newAgentClient()
+	if err != nil {
+		return fmt.Errorf("configuration error: %w", err)
+	}
+	bot.httpClient = client
+	return nil
+}
 
-			for _, msg := range messages {
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				default:
-					bot.processMessage(ctx, msg)
-				}
-			}
+// loadOperatorFiles reads the personas, aliases, templates and macros the
+// operator configured as files
+func (bot *SignalBot) loadOperatorFiles() error {
+	personas, err := loadPersonas(bot.config.PersonasFile)
+	if err != nil {
+		return fmt.Errorf("configuration error: %w", err)
+	}
+	bot.personas = personas
 
-			// Brief pause between message processing
-			time.Sleep(1 * time.Second)
-		}
+	operatorAliases, err := loadAliases(bot.config.AliasesFile)
+	if err != nil {
+		return fmt.Errorf("configuration error: %w", err)
+	}
+	bot.operatorAliases = operatorAliases
+
+	if err := bot.templates.Load(bot.config.TemplatesFile); err != nil {
+		return fmt.Errorf("failed to load templates: %w", err)
 	}
+	if err := bot.macros.Load(bot.config.MacrosFile); err != nil {
+		return fmt.Errorf("failed to load macros: %w", err)
+	}
+	return nil
 }
 
 func main() {
+	force := flag.Bool("force", false, "start even if another instance holds the account lock")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "TOML or JSON config file; environment variables override its settings")
+	configProfile := flag.String("profile", os.Getenv("BOT_ENV"), "config file profile to apply, e.g. staging")
+	flag.Parse()
+
+	if *configProfile != "" && *configPath == "" {
+		log.Fatalf("Config file error: profile %q given without a config file", *configProfile)
+	}
+	if *configPath != "" {
+		if err := loadConfigFile(*configPath, *configProfile); err != nil {
+			log.Fatalf("Config file error: %v", err)
+		}
+	}
 	bot := NewSignalBot()
+	bot.config.ForceStart = *force
+	bot.config.ConfigFile = *configPath
+	bot.config.ConfigProfile = *configProfile
+	if err := checkSettings(); err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	// "signalbot config dump" prints the effective configuration and exits
+	if args := flag.Args(); len(args) == 2 && args[0] == "config" && args[1] == "dump" {
+		fmt.Println(bot.configDump())
+		return
+	}
+
+	// "signalbot replay FILE..." runs captured envelopes without sending
+	if args := flag.Args(); len(args) > 0 && args[0] == "replay" {
+		if len(args) < 2 {
+			log.Fatalf("Usage: signalbot replay FILE...")
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err := bot.replayMessages(ctx, args[1:]); err != nil {
+			log.Fatalf("Replay error: %v", err)
+		}
+		return
+	}
 
 	// Setup graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
 
 	go func() {
-		<-sigChan
-		log.Println("Received shutdown signal")
-		cancel()
+		for sig := range sigChan {
+			if sig == syscall.SIGQUIT {
+				// SIGQUIT dumps state for debugging without stopping the bot
+				bot.dumpStateToConfiguredFile()
+				continue
+			}
+			if sig == syscall.SIGHUP {
+				if err := bot.reloadConfig(); err != nil {
+					log.Printf("Error reloading config: %v", err)
+				}
+				continue
+			}
+			log.Println("Received shutdown signal")
+			cancel()
+			return
+		}
 	}()
 
-	if err := bot.Run(ctx); err != nil && err != context.Canceled {
+	err := bot.Run(ctx)
+	bot.dumpStateToConfiguredFile()
+	if err != nil && err != context.Canceled {
 		log.Fatalf("Bot error: %v", err)
 	}
-}
\ No newline at end of file
+}
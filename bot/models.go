@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// chatModel returns the model selected for a conversation, falling back to
+// AGENT_DEFAULT_MODEL. Selections no longer in the allowlist are ignored.
+func (bot *SignalBot) chatModel(chatID string) string {
+	if chatID != "" {
+		if model := bot.settings.Get(chatID, "model"); model != "" && contains(bot.live.Load().AgentModels, model) {
+			return model
+		}
+	}
+	return bot.live.Load().AgentDefaultModel
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "model",
+		usage:   "!model | !model list | !model <name> | !model default",
+		handler: modelCommand,
+	})
+}
+
+// modelCommand shows or switches the model used in the current chat
+func modelCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	models := bot.live.Load().AgentModels
+	if len(models) == 0 {
+		return "Model selection isn't enabled on this bot."
+	}
+
+	chatID := msg.chatID()
+	if chatID == "" {
+		return "Sorry, I can't tell which chat this is."
+	}
+
+	available := strings.Join(models, ", ")
+	if len(args) == 0 || strings.EqualFold(args[0], "list") {
+		current := bot.chatModel(chatID)
+		if current == "" {
+			current = "agent default"
+		}
+		return fmt.Sprintf("Current model: %s\nAvailable: %s", current, available)
+	}
+
+	model := args[0]
+	if strings.EqualFold(model, "default") {
+		model = ""
+	} else if !contains(models, model) {
+		return fmt.Sprintf("Model %q isn't allowed. Available: %s", model, available)
+	}
+
+	if err := bot.settings.Set(chatID, "model", model); err != nil {
+		bot.logger.Printf("Error saving model: %v", err)
+		return "Sorry, I couldn't save that model."
+	}
+	if model == "" {
+		return "Model reset to default."
+	}
+	return fmt.Sprintf("Model set to %s.", model)
+}
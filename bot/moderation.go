@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// sanitizedText replaces the parts of a prompt MODERATION_SANITIZE matches
+const sanitizedText = "[removed]"
+
+// errModerated is returned when moderation refuses a prompt sent outside
+// the answer path
+var errModerated = errors.New("moderation refused the prompt")
+
+// moderationRules are the compiled regex rules screening prompts
+type moderationRules struct {
+	block    []*regexp.Regexp // a match refuses the prompt
+	sanitize []*regexp.Regexp // matches are removed from the prompt
+}
+
+// compileModerationRules compiles MODERATION_BLOCK and MODERATION_SANITIZE.
+// Patterns are case-insensitive.
+func compileModerationRules(config Config) (moderationRules, error) {
+	var rules moderationRules
+	for _, list := range []struct {
+		key      string
+		patterns []string
+		into     *[]*regexp.Regexp
+	}{
+		{"MODERATION_BLOCK", config.ModerationBlock, &rules.block},
+		{"MODERATION_SANITIZE", config.ModerationSanitize, &rules.sanitize},
+	} {
+		for _, pattern := range list.patterns {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				return moderationRules{}, fmt.Errorf("%s: %w", list.key, err)
+			}
+			*list.into = append(*list.into, re)
+		}
+	}
+	return rules, nil
+}
+
+// moderationVerdict is the outcome of screening a prompt
+type moderationVerdict struct {
+	Prompt  string // the prompt to send, possibly sanitized
+	Refused bool
+	Reason  string // why the prompt was refused or changed, "" if neither
+}
+
+// moderate screens a prompt with the regex rules and then MODERATION_URL.
+// When the moderation service fails, the prompt passes unless
+// MODERATION_FAIL_CLOSED is set.
+func (bot *SignalBot) moderate(ctx context.Context, prompt string) moderationVerdict {
+	verdict := moderationVerdict{Prompt: prompt}
+	for _, re := range bot.moderation.block {
+		if re.MatchString(prompt) {
+			return moderationVerdict{Refused: true, Reason: "matches " + re.String()}
+		}
+	}
+	for _, re := range bot.moderation.sanitize {
+		if re.MatchString(verdict.Prompt) {
+			verdict.Prompt = re.ReplaceAllString(verdict.Prompt, sanitizedText)
+			verdict.Reason = "sanitized " + re.String()
+		}
+	}
+
+	if bot.config.ModerationURL == "" {
+		return verdict
+	}
+	categories, err := bot.callModeration(ctx, verdict.Prompt)
+	if err != nil {
+		bot.logger.Printf("Moderation service failed: %v", err)
+		if bot.config.ModerationFailClosed {
+			return moderationVerdict{Refused: true, Reason: "moderation service unavailable"}
+		}
+		return verdict
+	}
+	if len(categories) > 0 {
+		return moderationVerdict{Refused: true, Reason: "flagged for " + strings.Join(categories, ", ")}
+	}
+	return verdict
+}
+
+// screenRequest moderates the prompt of request and the documents sent
+// with it, such as attachments or a chat transcript to summarize. It
+// returns the request to send, or false when moderation refused any of them.
+func (bot *SignalBot) screenRequest(ctx context.Context, request AgentRequest) (AgentRequest, bool) {
+	verdict := bot.moderate(ctx, request.Prompt)
+	if verdict.Reason != "" {
+		bot.logger.Printf("Moderation: prompt %d in %s %s (refused: %t)", request.Timestamp, requestChatID(request), verdict.Reason, verdict.Refused)
+	}
+	if verdict.Refused {
+		return request, false
+	}
+	request.Prompt = verdict.Prompt
+
+	documents, ok := bot.screenDocuments(ctx, request, request.Documents)
+	if !ok {
+		return request, false
+	}
+	request.Documents = documents
+	return request, true
+}
+
+// screenDocuments moderates documents added to request, such as fetched
+// pages or knowledge passages, returning them possibly sanitized, or false
+// when moderation refused any of them
+func (bot *SignalBot) screenDocuments(ctx context.Context, request AgentRequest, documents []AgentDocument) ([]AgentDocument, bool) {
+	if documents == nil {
+		return nil, true
+	}
+	screened := make([]AgentDocument, len(documents))
+	for i, document := range documents {
+		verdict := bot.moderate(ctx, document.Text)
+		if verdict.Reason != "" {
+			bot.logger.Printf("Moderation: document %q of prompt %d in %s %s (refused: %t)", document.Source, request.Timestamp, requestChatID(request), verdict.Reason, verdict.Refused)
+		}
+		if verdict.Refused {
+			return nil, false
+		}
+		document.Text = verdict.Prompt
+		screened[i] = document
+	}
+	return screened, true
+}
+
+// callModeration asks an OpenAI-compatible moderation endpoint (POST
+// {"input"}, response {"results": [{"flagged", "categories"}]}) about text,
+// returning the flagged categories
+func (bot *SignalBot) callModeration(ctx context.Context, text string) ([]string, error) {
+	payload := map[string]string{"input": text}
+	if bot.config.ModerationModel != "" {
+		payload["model"] = bot.config.ModerationModel
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", bot.config.ModerationURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create moderation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if bot.config.ModerationAPIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+bot.config.ModerationAPIKey)
+	}
+
+	resp, err := bot.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call moderation service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("moderation service returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode moderation result: %w", err)
+	}
+
+	var flagged []string
+	for _, r := range result.Results {
+		if !r.Flagged {
+			continue
+		}
+		for category, hit := range r.Categories {
+			if hit {
+				flagged = append(flagged, category)
+			}
+		}
+		if len(flagged) == 0 {
+			flagged = append(flagged, "unspecified")
+		}
+	}
+	sort.Strings(flagged)
+	return flagged, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// moderationBot returns a bot screening with config; a non-empty flagged
+// word makes the fake moderation service flag inputs containing it
+func moderationBot(t *testing.T, config Config, flagged string, serviceUp bool) *SignalBot {
+	t.Helper()
+	rules, err := compileModerationRules(config)
+	if err != nil {
+		t.Fatalf("compileModerationRules() error = %v", err)
+	}
+	if flagged != "" || !serviceUp {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !serviceUp {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			var payload struct {
+				Input string `json:"input"`
+			}
+			json.NewDecoder(r.Body).Decode(&payload)
+			hit := strings.Contains(payload.Input, flagged)
+			json.NewEncoder(w).Encode(map[string]any{
+				"results": []map[string]any{{"flagged": hit, "categories": map[string]bool{"violence": hit}}},
+			})
+		}))
+		t.Cleanup(server.Close)
+		config.ModerationURL = server.URL
+	}
+	return &SignalBot{
+		config:     config,
+		logger:     log.New(io.Discard, "", 0),
+		httpClient: http.DefaultClient,
+		moderation: rules,
+	}
+}
+
+func TestScreenRequest(t *testing.T) {
+	rules := Config{ModerationBlock: []string{`\bforbidden\b`}, ModerationSanitize: []string{`secret-\d+`}}
+	failClosed := rules
+	failClosed.ModerationFailClosed = true
+
+	tests := []struct {
+		name       string
+		config     Config
+		flagged    string // word the moderation service flags, "" for no service
+		serviceUp  bool
+		prompt     string
+		documents  []string
+		wantOK     bool
+		wantPrompt string
+		wantDocs   []string
+	}{
+		{name: "clean prompt passes", config: rules, serviceUp: true, prompt: "hello", wantOK: true, wantPrompt: "hello"},
+		{name: "block rule refuses", config: rules, serviceUp: true, prompt: "a FORBIDDEN word"},
+		{name: "sanitize rule rewrites", config: rules, serviceUp: true, prompt: "code secret-42 ok", wantOK: true, wantPrompt: "code [removed] ok"},
+		{
+			name:       "documents are sanitized too",
+			config:     rules,
+			serviceUp:  true,
+			prompt:     "summarize",
+			documents:  []string{"page with secret-7", "clean page"},
+			wantOK:     true,
+			wantPrompt: "summarize",
+			wantDocs:   []string{"page with [removed]", "clean page"},
+		},
+		{name: "a blocked document refuses the prompt", config: rules, serviceUp: true, prompt: "summarize", documents: []string{"clean", "forbidden text"}},
+		{name: "service flags the prompt", config: Config{}, flagged: "attack", serviceUp: true, prompt: "plan an attack"},
+		{name: "service flags a document", config: Config{}, flagged: "attack", serviceUp: true, prompt: "summarize", documents: []string{"how to attack"}},
+		{name: "service passes clean text", config: Config{}, flagged: "attack", serviceUp: true, prompt: "cake recipe", wantOK: true, wantPrompt: "cake recipe"},
+		{name: "failing service fails open", config: rules, serviceUp: false, prompt: "hello", wantOK: true, wantPrompt: "hello"},
+		{name: "failing service fails closed when configured", config: failClosed, serviceUp: false, prompt: "hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := moderationBot(t, tt.config, tt.flagged, tt.serviceUp)
+			request := AgentRequest{Prompt: tt.prompt}
+			for _, text := range tt.documents {
+				request.Documents = append(request.Documents, AgentDocument{Source: "doc", Text: text})
+			}
+
+			got, ok := bot.screenRequest(context.Background(), request)
+			if ok != tt.wantOK {
+				t.Fatalf("screenRequest(%q) ok = %t, want %t", tt.prompt, ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if got.Prompt != tt.wantPrompt {
+				t.Errorf("screenRequest(%q) prompt = %q, want %q", tt.prompt, got.Prompt, tt.wantPrompt)
+			}
+			var docs []string
+			for _, doc := range got.Documents {
+				docs = append(docs, doc.Text)
+			}
+			if !reflect.DeepEqual(docs, tt.wantDocs) {
+				t.Errorf("screenRequest(%q) documents = %q, want %q", tt.prompt, docs, tt.wantDocs)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// userDataCategory is one kind of data the bot keeps about a person, as
+// listed and deleted by !mydata
+type userDataCategory struct {
+	description string
+	count       func(bot *SignalBot, who AgentSender) int
+	forget      func(bot *SignalBot, who AgentSender) error
+}
+
+// userDataCategories lists everything !mydata reports on, by name
+var userDataCategories = map[string]userDataCategory{
+	"history": {
+		description: "conversation turns remembered for follow-up questions",
+		count: func(bot *SignalBot, who AgentSender) int {
+			return bot.history.Count(ownsConversation(who))
+		},
+		forget: func(bot *SignalBot, who AgentSender) error {
+			return bot.history.Forget(ownsConversation(who))
+		},
+	},
+	"settings": {
+		description: "settings of your direct chat and your own settings in any chat (persona, quiet hours, ...)",
+		count: func(bot *SignalBot, who AgentSender) int {
+			return len(bot.settings.Keys(dmChatID(who))) + bot.settings.CountMatching(func(id string) bool { return isPersonalIDOf(id, who) })
+		},
+		forget: func(bot *SignalBot, who AgentSender) error {
+			if err := bot.settings.Clear(dmChatID(who)); err != nil {
+				return err
+			}
+			return bot.settings.ClearMatching(func(id string) bool { return isPersonalIDOf(id, who) })
+		},
+	},
+	"files": {
+		description: "records of files you shared, used by !files",
+		count: func(bot *SignalBot, who AgentSender) int {
+			return bot.attachments.CountBySender(who)
+		},
+		forget: func(bot *SignalBot, who AgentSender) error {
+			return bot.attachments.ForgetSender(who)
+		},
+	},
+	"documents": {
+		description: "passages of documents you asked me to remember",
+		count: func(bot *SignalBot, who AgentSender) int {
+			return bot.knowledge.CountAddedBy(who)
+		},
+		forget: func(bot *SignalBot, who AgentSender) error {
+			return bot.knowledge.ForgetAddedBy(who)
+		},
+	},
+	"usage": {
+		description: "counts of your requests and their tokens, shown by !usage",
+		count: func(bot *SignalBot, who AgentSender) int {
+			count := 0
+			for _, id := range quotaIDsOf(who) {
+				count += len(bot.usage.records.Keys("user:" + id))
+			}
+			return count
+		},
+		forget: func(bot *SignalBot, who AgentSender) error {
+			for _, id := range quotaIDsOf(who) {
+				if err := bot.usage.records.Clear("user:" + id); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	"quota": {
+		description: "tokens you used today, counted against the daily quota",
+		count: func(bot *SignalBot, who AgentSender) int {
+			count := 0
+			for _, id := range quotaIDsOf(who) {
+				count += len(bot.quotas.usage.Keys(id))
+			}
+			return count
+		},
+		forget: func(bot *SignalBot, who AgentSender) error {
+			for _, id := range quotaIDsOf(who) {
+				if err := bot.quotas.usage.Clear(id); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	"reminders": {
+		description: "pending reminders you created",
+		count: func(bot *SignalBot, who AgentSender) int {
+			return bot.scheduler.CountCreatedBy(who)
+		},
+		forget: func(bot *SignalBot, who AgentSender) error {
+			return bot.scheduler.CancelCreatedBy(who)
+		},
+	},
+}
+
+// dmChatID is the chat ID of a person's direct chat with the bot
+func dmChatID(who AgentSender) string {
+	return conversationID(AgentChat{IsDM: true, Recipient: who.Number})
+}
+
+// quotaIDsOf returns the IDs a person's usage and quota may be counted
+// under: their number, and their UUID from before it was known
+func quotaIDsOf(who AgentSender) []string {
+	var ids []string
+	for _, id := range []string{who.Number, who.UUID} {
+		if id != "" && !contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ownsConversation matches a person's DM conversation and their own
+// threads in group chats
+func ownsConversation(who AgentSender) func(id string) bool {
+	return func(id string) bool {
+		if who.Number != "" && id == dmChatID(who) {
+			return true
+		}
+		if !strings.HasPrefix(id, "group:") {
+			return false
+		}
+		return (who.UUID != "" && strings.HasSuffix(id, ":"+who.UUID)) ||
+			(who.Number != "" && strings.HasSuffix(id, ":"+who.Number))
+	}
+}
+
+// userDataNames returns the names of userDataCategories, sorted
+func userDataNames() []string {
+	names := make([]string, 0, len(userDataCategories))
+	for name := range userDataCategories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// forgetUserData deletes the named categories of who's data, stopping at
+// the first that fails
+func (bot *SignalBot) forgetUserData(who AgentSender, names []string) error {
+	for _, name := range names {
+		if err := userDataCategories[name].forget(bot, who); err != nil {
+			bot.logger.Printf("Error deleting %s for %s: %v", name, quotaID(&who), err)
+			return fmt.Errorf("couldn't delete your %s", name)
+		}
+	}
+	bot.audit(auditEntry{Event: auditForget, Kind: strings.Join(names, ","), Subject: quotaID(&who)})
+	bot.logger.Printf("Deleted %s data for %s", strings.Join(names, ", "), quotaID(&who))
+	return nil
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "mydata",
+		usage:   "!mydata | !mydata delete <category|all>",
+		handler: myDataCommand,
+	})
+	registerCommand(&command{
+		name:    "forgetme",
+		usage:   "!forgetme | !forgetme confirm",
+		handler: forgetMeCommand,
+	})
+}
+
+// myDataCommand shows or deletes what the bot stores about the asker
+func myDataCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	who := msg.sender()
+	if who.Number == "" && who.UUID == "" {
+		return "Sorry, I can't tell who you are."
+	}
+
+	names := userDataNames()
+	if len(args) == 0 {
+		lines := []string{"What I store about you:"}
+		for _, name := range names {
+			category := userDataCategories[name]
+			lines = append(lines, fmt.Sprintf("%s: %d (%s)", name, category.count(bot, who), category.description))
+		}
+		lines = append(lines, "", "Delete with !mydata delete <"+strings.Join(names, "|")+"|all>")
+		return strings.Join(lines, "\n")
+	}
+
+	if !strings.EqualFold(args[0], "delete") || len(args) < 2 {
+		return "Usage: " + commands["mydata"].usage
+	}
+
+	targets := []string{strings.ToLower(args[1])}
+	if targets[0] == "all" {
+		targets = names
+	} else if _, exists := userDataCategories[targets[0]]; !exists {
+		return fmt.Sprintf("Unknown category %q. Choose one of: %s, all", args[1], strings.Join(names, ", "))
+	}
+
+	if err := bot.forgetUserData(who, targets); err != nil {
+		return "Sorry, I " + err.Error() + "."
+	}
+	return "Deleted: " + strings.Join(targets, ", ")
+}
+
+// forgetMeCommand deletes everything the bot stores about the asker, every
+// !mydata category at once. Without "confirm" it only says what would go.
+func forgetMeCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	who := msg.sender()
+	if who.Number == "" && who.UUID == "" {
+		return "Sorry, I can't tell who you are."
+	}
+
+	names := userDataNames()
+	total := 0
+	var stored []string
+	for _, name := range names {
+		if n := userDataCategories[name].count(bot, who); n > 0 {
+			total += n
+			stored = append(stored, fmt.Sprintf("%s: %d", name, n))
+		}
+	}
+
+	if len(args) == 0 || !strings.EqualFold(args[0], "confirm") {
+		if total == 0 {
+			return "I don't store anything about you."
+		}
+		return "This deletes everything I store about you for good (" + strings.Join(stored, ", ") + ").\nSend !forgetme confirm to go ahead."
+	}
+
+	if err := bot.forgetUserData(who, names); err != nil {
+		return "Sorry, I " + err.Error() + ". Please try again."
+	}
+	if total == 0 {
+		return "Done, there was nothing stored about you."
+	}
+	return fmt.Sprintf("Done, I've forgotten you: deleted %d items (%s).", total, strings.Join(stored, ", "))
+}
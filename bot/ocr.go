@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AgentImage is an attached image sent to vision-capable agents
+type AgentImage struct {
+	Source      string `json:"source"`
+	ContentType string `json:"content_type"`
+	Data        string `json:"data"` // base64
+}
+
+// maxVisionImageBytes keeps requests to the agent at a reasonable size
+const maxVisionImageBytes = 5 << 20
+
+// documentImageHints are filename fragments of images that are usually text
+var documentImageHints = []string{"screenshot", "screen shot", "scan", "receipt", "invoice", "document", "page"}
+
+// contentPromptHints are prompt words that ask about what an image says
+var contentPromptHints = []string{"read", "text", "say", "says", "written", "transcribe", "extract", "translate", "summarize", "summarise", "what does", "what's in", "what is in", "error", "document", "screenshot", "receipt"}
+
+// looksLikeDocument reports whether an image is probably a screenshot or a
+// photographed document: screenshots are PNGs, and scans tend to be named so
+func looksLikeDocument(a Attachment) bool {
+	if a.ContentType == "image/png" {
+		return true
+	}
+	name := strings.ToLower(a.Filename)
+	for _, hint := range documentImageHints {
+		if strings.Contains(name, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// asksAboutContent reports whether a prompt is about the text in an image
+func asksAboutContent(prompt string) bool {
+	p := strings.ToLower(prompt)
+	for _, hint := range contentPromptHints {
+		if strings.Contains(p, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachedImages prepares the images attached to msg for the agent. Images
+// of text that the prompt asks about are run through OCR_URL and returned as
+// documents; any image OCR doesn't cover, or where it finds no text, is
+// passed along as is when VISION_ENABLED is set.
+func (bot *SignalBot) attachedImages(ctx context.Context, msg *Message, prompt string) ([]AgentDocument, []AgentImage) {
+	var docs []AgentDocument
+	var images []AgentImage
+	for _, a := range msg.extractAttachments() {
+		if !strings.HasPrefix(a.ContentType, "image/") {
+			continue
+		}
+		path := filepath.Join(bot.config.AttachmentsDir, a.ID)
+
+		if bot.config.OCRURL != "" && looksLikeDocument(a) && asksAboutContent(prompt) {
+			text, err := bot.extractWithService(ctx, bot.config.OCRURL, path, a.ContentType)
+			if err != nil {
+				bot.logger.Printf("Error running OCR on %s: %v", attachmentName(a), err)
+			}
+			if text = strings.TrimSpace(text); text != "" {
+				docs = append(docs, AgentDocument{Source: attachmentName(a), Text: text})
+				continue
+			}
+		}
+
+		if !bot.config.VisionEnabled {
+			continue
+		}
+		image, err := readImage(a, path)
+		if err != nil {
+			bot.logger.Printf("Error reading image %s: %v", attachmentName(a), err)
+			continue
+		}
+		images = append(images, image)
+	}
+	return docs, images
+}
+
+// readImage loads an attachment for the vision path
+func readImage(a Attachment, path string) (AgentImage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return AgentImage{}, err
+	}
+	if len(data) > maxVisionImageBytes {
+		return AgentImage{}, fmt.Errorf("image is %d bytes, limit is %d", len(data), maxVisionImageBytes)
+	}
+	return AgentImage{
+		Source:      attachmentName(a),
+		ContentType: a.ContentType,
+		Data:        base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
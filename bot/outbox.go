@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// destinationType classifies outgoing traffic for shaping
+type destinationType string
+
+const (
+	destDM        destinationType = "dm"
+	destGroup     destinationType = "group"
+	destBroadcast destinationType = "broadcast" // bot-initiated sends: forwards and agent tool messages
+)
+
+// destinationOf returns the type of a signal-cli recipient
+func destinationOf(recipient string) destinationType {
+	if strings.HasPrefix(recipient, "-g ") {
+		return destGroup
+	}
+	return destDM
+}
+
+// throttleStats counts how often sends of one destination type were held back
+type throttleStats struct {
+	Throttled int64         `json:"throttled"`
+	Delayed   time.Duration `json:"delayed_ns"`
+}
+
+// tokenBucket allows bursts of up to burst sends, refilled at rate per
+// second. Tokens may go negative: each send books the next free slot.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(perMinute, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: float64(perMinute) / 60, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// reserve takes a token and returns how long until it is actually available
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refill adds the tokens earned since the last call
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// outbox spaces out consecutive sends to the same recipient by a minimum
+// interval that depends on the destination type, and caps the send rate
+// with token buckets, one for all sends and one per recipient. Sends over
+// a limit are delayed, never dropped.
+type outbox struct {
+	mu            sync.Mutex
+	intervals     map[destinationType]time.Duration
+	next          map[string]time.Time // destination type + recipient -> earliest next send
+	stats         map[destinationType]*throttleStats
+	global        *tokenBucket // nil = unlimited
+	recipientRate int          // per minute, 0 = unlimited
+	burst         int
+	buckets       map[string]*tokenBucket // recipient -> its bucket
+}
+
+// newOutbox creates an outbox with per-destination intervals, and send
+// rates per minute overall and per recipient (0 = unlimited) allowing
+// bursts of burst sends
+func newOutbox(intervals map[destinationType]time.Duration, globalRate, recipientRate, burst int) *outbox {
+	o := &outbox{
+		intervals:     intervals,
+		next:          make(map[string]time.Time),
+		stats:         make(map[destinationType]*throttleStats),
+		recipientRate: recipientRate,
+		burst:         max(burst, 1),
+		buckets:       make(map[string]*tokenBucket),
+	}
+	if globalRate > 0 {
+		o.global = newTokenBucket(globalRate, o.burst, time.Now())
+	}
+	return o
+}
+
+// reserve books the next send slot for recipient and returns how long the
+// caller must wait for it
+func (o *outbox) reserve(dest destinationType, recipient string, now time.Time) time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	wait := max(o.reserveInterval(dest, recipient, now), o.reserveRate(recipient, now))
+	if wait > 0 {
+		if o.stats[dest] == nil {
+			o.stats[dest] = &throttleStats{}
+		}
+		o.stats[dest].Throttled++
+		o.stats[dest].Delayed += wait
+	}
+	return wait
+}
+
+// reserveInterval books recipient's next slot under its destination type's
+// minimum interval; callers must hold o.mu
+func (o *outbox) reserveInterval(dest destinationType, recipient string, now time.Time) time.Duration {
+	interval := o.intervals[dest]
+	if interval <= 0 {
+		return 0
+	}
+
+	for key, t := range o.next {
+		if now.After(t) {
+			delete(o.next, key)
+		}
+	}
+
+	key := string(dest) + "\x00" + recipient
+	slot := now
+	if t, exists := o.next[key]; exists && t.After(now) {
+		slot = t
+	}
+	o.next[key] = slot.Add(interval)
+	return slot.Sub(now)
+}
+
+// reserveRate takes a token from the global and the recipient's bucket;
+// callers must hold o.mu
+func (o *outbox) reserveRate(recipient string, now time.Time) time.Duration {
+	var wait time.Duration
+	if o.global != nil {
+		wait = o.global.reserve(now)
+	}
+	if o.recipientRate <= 0 {
+		return wait
+	}
+
+	// Full buckets are the same as new ones
+	for key, b := range o.buckets {
+		if b.refill(now); b.tokens >= b.burst {
+			delete(o.buckets, key)
+		}
+	}
+	bucket, exists := o.buckets[recipient]
+	if !exists {
+		bucket = newTokenBucket(o.recipientRate, o.burst, now)
+		o.buckets[recipient] = bucket
+	}
+	return max(wait, bucket.reserve(now))
+}
+
+// Stats returns a copy of the throttling counters per destination type
+func (o *outbox) Stats() map[string]throttleStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	stats := make(map[string]throttleStats, len(o.stats))
+	for dest, s := range o.stats {
+		stats[string(dest)] = *s
+	}
+	return stats
+}
+
+// String summarizes throttling for !status
+func (o *outbox) String() string {
+	stats := o.Stats()
+	if len(stats) == 0 {
+		return "none"
+	}
+
+	dests := make([]string, 0, len(stats))
+	for dest := range stats {
+		dests = append(dests, dest)
+	}
+	sort.Strings(dests)
+
+	parts := make([]string, 0, len(dests))
+	for _, dest := range dests {
+		s := stats[dest]
+		parts = append(parts, fmt.Sprintf("%s %d (%s)", dest, s.Throttled, s.Delayed.Round(time.Millisecond)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// spaceBroadcast blocks until SEND_INTERVAL_BROADCAST has passed since the
+// last bot-initiated send to recipient. Unlike throttle it takes no rate
+// tokens, which the send itself takes when deliverReply throttles it.
+func (bot *SignalBot) spaceBroadcast(recipient string) {
+	bot.outbox.mu.Lock()
+	wait := bot.outbox.reserveInterval(destBroadcast, recipient, time.Now())
+	bot.outbox.mu.Unlock()
+	if wait > 0 {
+		bot.logger.Printf("Spacing bot-initiated send to %s by %s", recipient, wait.Round(time.Millisecond))
+		time.Sleep(wait)
+	}
+}
+
+// throttle blocks until the outbox allows another send of type dest to
+// recipient
+func (bot *SignalBot) throttle(dest destinationType, recipient string) {
+	if wait := bot.outbox.reserve(dest, recipient, time.Now()); wait > 0 {
+		bot.logger.Printf("Throttling %s send to %s for %s", dest, recipient, wait.Round(time.Millisecond))
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	type step struct {
+		after time.Duration // since the bucket was created
+		want  time.Duration // wait for the reserved token
+	}
+	tests := []struct {
+		name      string
+		perMinute int
+		burst     int
+		steps     []step
+	}{
+		{
+			name:      "burst is free",
+			perMinute: 60,
+			burst:     3,
+			steps:     []step{{0, 0}, {0, 0}, {0, 0}},
+		},
+		{
+			name:      "sends past the burst book the next free slots",
+			perMinute: 60,
+			burst:     2,
+			steps:     []step{{0, 0}, {0, 0}, {0, time.Second}, {0, 2 * time.Second}},
+		},
+		{
+			name:      "tokens refill over time",
+			perMinute: 60,
+			burst:     2,
+			steps:     []step{{0, 0}, {0, 0}, {time.Second, 0}, {time.Second, time.Second}},
+		},
+		{
+			name:      "refill stops at the burst",
+			perMinute: 60,
+			burst:     2,
+			steps:     []step{{0, 0}, {time.Hour, 0}, {time.Hour, 0}, {time.Hour, time.Second}},
+		},
+		{
+			name:      "booked slots are paid back before new tokens",
+			perMinute: 60,
+			burst:     1,
+			steps:     []step{{0, 0}, {0, time.Second}, {0, 2 * time.Second}, {time.Second, 2 * time.Second}},
+		},
+		{
+			name:      "slow rates wait longer",
+			perMinute: 6,
+			burst:     1,
+			steps:     []step{{0, 0}, {0, 10 * time.Second}, {5 * time.Second, 15 * time.Second}},
+		},
+		{
+			name:      "a clock going backwards earns nothing",
+			perMinute: 60,
+			burst:     1,
+			steps:     []step{{time.Minute, 0}, {0, time.Second}},
+		},
+	}
+
+	start := time.Date(2026, 1, 14, 10, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(tt.perMinute, tt.burst, start)
+			for i, s := range tt.steps {
+				got := b.reserve(start.Add(s.after))
+				if diff := got - s.want; diff < -time.Millisecond || diff > time.Millisecond {
+					t.Errorf("step %d: reserve(+%s) = %s, want %s", i+1, s.after, got, s.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AgentParameters are per-chat generation parameters forwarded to the agent
+type AgentParameters struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+}
+
+// chatSetting describes a value users can change with !set
+type chatSetting struct {
+	description string
+	groupAdmin  bool // in groups, only group admins and the bot owner may change the chat's value
+	scope       settingScope
+	// normalize validates a user-supplied value and returns its stored form
+	normalize func(value string) (string, error)
+	// check optionally validates a normalized value against the bot's state
+	check func(bot *SignalBot, value string) error
+}
+
+// chatSettingDefs lists the keys accepted by !set
+var chatSettingDefs = map[string]chatSetting{
+	"temperature": {
+		description: "sampling temperature between 0 and 2",
+		normalize: func(value string) (string, error) {
+			t, err := strconv.ParseFloat(value, 64)
+			if err != nil || t < 0 || t > 2 {
+				return "", fmt.Errorf("temperature must be a number between 0 and 2")
+			}
+			return strconv.FormatFloat(t, 'f', -1, 64), nil
+		},
+	},
+	"voice": {
+		description: "on/off: answer voice notes in this DM with text and audio",
+		normalize: func(value string) (string, error) {
+			switch strings.ToLower(value) {
+			case "on", "off":
+				return strings.ToLower(value), nil
+			}
+			return "", fmt.Errorf("voice must be on or off")
+		},
+	},
+	"undo": {
+		description: "seconds (1-60) replies wait before sending; react ❌ to your prompt meanwhile to cancel",
+		normalize: func(value string) (string, error) {
+			if strings.EqualFold(value, "off") {
+				return "", nil
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				n, convErr := strconv.Atoi(value)
+				d, err = time.Duration(n)*time.Second, convErr
+			}
+			if err != nil || d < time.Second || d > time.Minute {
+				return "", fmt.Errorf("undo must be between 1 and 60 seconds, or off")
+			}
+			return d.String(), nil
+		},
+	},
+	"maxtokens": {
+		description: "maximum tokens in a reply (1-8192)",
+		normalize: func(value string) (string, error) {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 8192 {
+				return "", fmt.Errorf("maxtokens must be a whole number between 1 and 8192")
+			}
+			return strconv.Itoa(n), nil
+		},
+	},
+}
+
+// chatParameters returns the generation parameters set for a chat, or nil
+func (bot *SignalBot) chatParameters(chatID string) *AgentParameters {
+	if chatID == "" {
+		return nil
+	}
+
+	var params AgentParameters
+	if val := bot.settings.Get(chatID, "temperature"); val != "" {
+		if t, err := strconv.ParseFloat(val, 64); err == nil {
+			params.Temperature = &t
+		}
+	}
+	if val := bot.settings.Get(chatID, "maxtokens"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			params.MaxTokens = &n
+		}
+	}
+
+	if params.Temperature == nil && params.MaxTokens == nil {
+		return nil
+	}
+	return &params
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "set",
+		usage:   "!set <key> <value> | !set my <key> <value> | !set <key> default",
+		handler: setCommand,
+	})
+}
+
+// setCommand changes a per-chat setting
+func setCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	chatID := msg.chatID()
+	if chatID == "" {
+		return "Sorry, I can't tell which chat this is."
+	}
+
+	mine := len(args) > 0 && strings.EqualFold(args[0], "my")
+	if mine {
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		return settingsCommand(ctx, bot, msg, nil)
+	}
+
+	key := strings.ToLower(args[0])
+	def, exists := chatSettingDefs[key]
+	if !exists {
+		return fmt.Sprintf("Unknown setting %q. Send !settings to list them.", args[0])
+	}
+	target, problem := settingTarget(msg, chatID, key, mine)
+	if problem != "" {
+		return problem
+	}
+	if groupID := msg.extractGroupId(); target == chatID && def.groupAdmin && groupID != "" && !bot.isAdmin(msg) && !bot.isGroupAdmin(ctx, groupID, msg.sender()) {
+		return bot.localizef(chatID, "Only group admins can change %s.", key)
+	}
+
+	value := strings.Join(args[1:], " ")
+	if strings.EqualFold(value, "default") {
+		value = ""
+	} else {
+		normalized, err := def.normalize(value)
+		if err == nil && def.check != nil && normalized != "" {
+			err = def.check(bot, normalized)
+		}
+		if err != nil {
+			return err.Error()
+		}
+		value = normalized
+	}
+
+	if err := bot.settings.Set(target, key, value); err != nil {
+		bot.logger.Printf("Error saving setting %s: %v", key, err)
+		return "Sorry, I couldn't save that setting."
+	}
+	switch {
+	case value == "" && target != chatID:
+		return fmt.Sprintf("Your %s reset to default.", key)
+	case value == "":
+		return fmt.Sprintf("%s reset to default.", key)
+	case target != chatID:
+		return fmt.Sprintf("Your %s set to %s.", key, value)
+	}
+	return fmt.Sprintf("%s set to %s.", key, value)
+}
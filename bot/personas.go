@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// AgentPersona is the system-prompt snippet attached to a chat's requests
+type AgentPersona struct {
+	Name         string `json:"name"`
+	SystemPrompt string `json:"system_prompt"`
+}
+
+// defaultPersona means "no persona attached"
+const defaultPersona = "default"
+
+// builtinPersonas ship with the bot; PERSONAS_FILE can add or override
+var builtinPersonas = map[string]string{
+	"pirate":  "Answer in the voice of a cheerful pirate, with plenty of nautical slang.",
+	"concise": "Answer as briefly as possible, ideally in a single sentence, without emojis.",
+	"eli5":    "Explain everything as if talking to a curious five-year-old.",
+	"formal":  "Use a formal, professional tone and avoid emojis and slang.",
+}
+
+// loadPersonas merges the built-in personas with an optional JSON file
+// mapping persona names to system prompts
+func loadPersonas(path string) (map[string]string, error) {
+	personas := make(map[string]string, len(builtinPersonas))
+	for name, prompt := range builtinPersonas {
+		personas[name] = prompt
+	}
+	if path == "" {
+		return personas, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read personas file: %w", err)
+	}
+
+	var custom map[string]string
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("failed to parse personas file %s: %w", path, err)
+	}
+	for name, prompt := range custom {
+		personas[strings.ToLower(name)] = prompt
+	}
+	delete(personas, defaultPersona)
+	return personas, nil
+}
+
+// chatPersona returns the persona selected for a conversation, if any
+func (bot *SignalBot) chatPersona(chatID string) *AgentPersona {
+	return bot.userPersona(chatID, nil)
+}
+
+// userPersona returns the persona the sender picked for themselves in a
+// chat with "!set my persona", falling back to the chat's persona
+func (bot *SignalBot) userPersona(chatID string, who *AgentSender) *AgentPersona {
+	if chatID == "" {
+		return nil
+	}
+	name := bot.userSetting(chatID, who, "persona")
+	prompt, exists := bot.personas[name]
+	if name == "" || !exists {
+		return nil
+	}
+	return &AgentPersona{Name: name, SystemPrompt: prompt}
+}
+
+// personaNames lists the available personas
+func (bot *SignalBot) personaNames() []string {
+	names := []string{defaultPersona}
+	for name := range bot.personas {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+func init() {
+	chatSettingDefs["persona"] = chatSetting{
+		description: "assistant persona, as with !persona (\"!set my persona\" for just you)",
+		scope:       scopeEither,
+		normalize: func(value string) (string, error) {
+			if name := strings.ToLower(value); name != defaultPersona {
+				return name, nil
+			}
+			return "", nil
+		},
+		check: func(bot *SignalBot, value string) error {
+			if _, exists := bot.personas[value]; !exists {
+				return fmt.Errorf("unknown persona %q. Available: %s", value, strings.Join(bot.personaNames(), ", "))
+			}
+			return nil
+		},
+	}
+
+	registerCommand(&command{
+		name:    "persona",
+		usage:   "!persona | !persona list | !persona <name> | !persona default",
+		handler: personaCommand,
+	})
+}
+
+// personaCommand shows or switches the persona used in the current chat
+func personaCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	chatID := msg.chatID()
+	if chatID == "" {
+		return "Sorry, I can't tell which chat this is."
+	}
+
+	if len(args) == 0 || strings.EqualFold(args[0], "list") {
+		current := defaultPersona
+		if persona := bot.chatPersona(chatID); persona != nil {
+			current = persona.Name
+		}
+		return fmt.Sprintf("Current persona: %s\nAvailable: %s", current, strings.Join(bot.personaNames(), ", "))
+	}
+
+	name := strings.ToLower(args[0])
+	if name == defaultPersona {
+		name = ""
+	} else if _, exists := bot.personas[name]; !exists {
+		return fmt.Sprintf("Unknown persona %q. Available: %s", args[0], strings.Join(bot.personaNames(), ", "))
+	}
+
+	if err := bot.settings.Set(chatID, "persona", name); err != nil {
+		bot.logger.Printf("Error saving persona: %v", err)
+		return "Sorry, I couldn't save that persona."
+	}
+	if name == "" {
+		return "Persona reset to default."
+	}
+	return fmt.Sprintf("Persona set to %s.", name)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+)
+
+// pollIdleSteps is how many empty polls in a row move polling on to the
+// next, slower step of POLL_IDLE_INTERVALS
+const pollIdleSteps = 12
+
+// pollInterval is the delay before the next poll after idle empty polls in
+// a row. Every signal-cli receive starts a JVM, so quiet deployments back
+// off through POLL_IDLE_INTERVALS and snap back once messages arrive.
+func (bot *SignalBot) pollInterval(idle int) time.Duration {
+	steps := bot.config.PollIdleIntervals
+	step := idle / pollIdleSteps
+	if step == 0 || len(steps) == 0 {
+		return bot.config.PollInterval
+	}
+	return max(steps[min(step, len(steps))-1], bot.config.PollInterval)
+}
+
+// runReceiver polls signal-cli and hands what it receives to the work
+// queue until ctx ends
+func (bot *SignalBot) runReceiver(ctx context.Context) {
+	idle := 0
+	interval := bot.config.PollInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		// A bad batch must not stop receiving
+		err := runGuarded(ctx, func(ctx context.Context) error {
+			received, err := bot.receiveBatch(ctx)
+			if received > 0 {
+				idle = 0
+			} else if err == nil {
+				idle++
+			}
+			return err
+		})
+		if err != nil {
+			bot.logger.Printf("Error receiving messages: %v", err)
+		}
+
+		if next := bot.pollInterval(idle); next != interval {
+			bot.logger.Printf("Polling every %s", next)
+			interval = next
+		}
+		timer.Reset(interval)
+	}
+}
+
+// receiveBatch receives one batch of messages and queues it, returning how
+// many there were
+func (bot *SignalBot) receiveBatch(ctx context.Context) (int, error) {
+	messages, err := bot.receiveWatched(ctx)
+	if err != nil || len(messages) == 0 {
+		return 0, err
+	}
+	received := len(messages)
+
+	bot.logger.Printf("Received %d messages", len(messages))
+	bot.watchIdentities(messages)
+	messages = bot.filterBackfill(messages)
+	bot.enqueueMessages(ctx, messages)
+
+	// Brief pause between batches
+	select {
+	case <-ctx.Done():
+	case <-time.After(bot.config.ProcessPause):
+	}
+	return received, nil
+}
+
+// runProcessor takes messages off the work queue and hands them to
+// PROCESS_WORKERS workers over channels. Each chat always goes to the same
+// worker, so its messages are processed in the order they arrived.
+func (bot *SignalBot) runProcessor(ctx context.Context) {
+	workers := make([]chan *Message, bot.config.ProcessWorkers)
+	done := make(chan struct{})
+	for i := range workers {
+		workers[i] = make(chan *Message)
+		go func(messages <-chan *Message) {
+			defer func() { done <- struct{}{} }()
+			bot.runWorker(ctx, messages)
+		}(workers[i])
+	}
+	defer func() {
+		for range workers {
+			<-done
+		}
+	}()
+
+	for {
+		msg, err := bot.work.Pop(ctx)
+		if err != nil {
+			return
+		}
+		bot.startProcessing(&msg)
+		select {
+		case workers[workerFor(&msg, len(workers))] <- &msg:
+		case <-ctx.Done():
+			return // the inbox keeps it
+		}
+	}
+}
+
+// runWorker processes the messages it is handed, one at a time
+func (bot *SignalBot) runWorker(ctx context.Context, messages <-chan *Message) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-messages:
+			// A message that crashes its handler must not take the worker down
+			err := runGuarded(ctx, func(ctx context.Context) error {
+				bot.handleMessage(ctx, *msg)
+				return nil
+			})
+			if err != nil {
+				bot.logger.Printf("Error processing message: %v", err)
+			}
+			bot.finishProcessing(msg)
+		}
+	}
+}
+
+// workerFor picks the worker for a message's chat. Delivery receipts go by
+// their sender, the chat of the DM they confirm.
+func workerFor(msg *Message, workers int) int {
+	key := msg.chatID()
+	if key == "" {
+		key = "dm:" + msg.Envelope.Source
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(workers))
+}
+
+// startProcessing records a message taken from the work queue, so the
+// inbox keeps it until it is done
+func (bot *SignalBot) startProcessing(msg *Message) {
+	bot.inboxMu.Lock()
+	bot.processing = append(bot.processing, msg)
+	bot.inboxMu.Unlock()
+}
+
+// finishProcessing forgets a processed message and persists the inbox
+func (bot *SignalBot) finishProcessing(msg *Message) {
+	bot.inboxMu.Lock()
+	for i, current := range bot.processing {
+		if current == msg {
+			bot.processing = append(bot.processing[:i], bot.processing[i+1:]...)
+			break
+		}
+	}
+	bot.inboxMu.Unlock()
+	bot.saveInbox()
+}
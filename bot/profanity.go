@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Profanity filter levels, set per chat with "!set profanity"
+const (
+	profanityOff    = "off"
+	profanityMild   = "mild"   // block strong profanity and sexual content
+	profanityStrict = "strict" // also block milder swearing
+)
+
+// Built-in words of each level. A trailing "*" also matches longer words
+// starting with the rest, as in PROFANITY_WORDS.
+var (
+	strongProfanity = []string{"fuck*", "motherfuck*", "shit*", "cunt*", "cock", "cocks", "bitch*", "bastard*",
+		"whore*", "slut*", "porn*", "nsfw", "nude", "nudes", "dickhead*", "wank*"}
+	mildProfanity = []string{"damn*", "crap", "crappy", "arse", "arsehole*", "asshole*", "piss*", "bollocks",
+		"bloody", "hell", "screw you"}
+)
+
+// profanityFilter matches the words each level blocks
+type profanityFilter struct {
+	mild   *regexp.Regexp // strong words and PROFANITY_WORDS
+	strict *regexp.Regexp // those and the mild words
+}
+
+func newProfanityFilter(extra []string) profanityFilter {
+	compile := func(words []string) *regexp.Regexp {
+		quoted := make([]string, len(words))
+		for i, word := range words {
+			if stem, found := strings.CutSuffix(word, "*"); found {
+				quoted[i] = regexp.QuoteMeta(stem) + `\w*`
+			} else {
+				quoted[i] = regexp.QuoteMeta(word)
+			}
+		}
+		return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+	mild := append(append([]string{}, strongProfanity...), extra...)
+	return profanityFilter{mild: compile(mild), strict: compile(append(mild, mildProfanity...))}
+}
+
+// pattern returns the regexp of a level, nil when off
+func (f profanityFilter) pattern(level string) *regexp.Regexp {
+	switch level {
+	case profanityMild:
+		return f.mild
+	case profanityStrict:
+		return f.strict
+	default:
+		return nil
+	}
+}
+
+// profanityLevel returns the filter level of a chat
+func (bot *SignalBot) profanityLevel(chatID string) string {
+	if level := bot.settings.Get(chatID, "profanity"); level != "" {
+		return level
+	}
+	return bot.config.ProfanityLevel
+}
+
+// profanityFilterFor returns the pattern filtering the prompt and replies
+// of request, nil when the chat has no filter or the asker may bypass it
+func (bot *SignalBot) profanityFilterFor(request AgentRequest) *regexp.Regexp {
+	re := bot.profanity.pattern(bot.profanityLevel(requestChatID(request)))
+	if re == nil || roleAtLeast(bot.requestRole(request), bot.config.ProfanityBypassRole) {
+		return nil
+	}
+	return re
+}
+
+// maskProfanity replaces each filtered word in replies with its first
+// letter followed by asterisks
+func maskProfanity(re *regexp.Regexp, replies []string) []string {
+	masked := make([]string, len(replies))
+	for i, reply := range replies {
+		masked[i] = re.ReplaceAllStringFunc(reply, func(word string) string {
+			first, size := utf8.DecodeRuneInString(word)
+			return string(first) + strings.Repeat("*", utf8.RuneCountInString(word[size:]))
+		})
+	}
+	return masked
+}
+
+func init() {
+	chatSettingDefs["profanity"] = chatSetting{
+		description: "off, mild or strict: refuse prompts with profanity and mask it in my replies (default PROFANITY_LEVEL)",
+		groupAdmin:  true,
+		normalize: func(value string) (string, error) {
+			switch level := strings.ToLower(value); level {
+			case profanityOff, profanityMild, profanityStrict:
+				return level, nil
+			}
+			return "", fmt.Errorf("profanity must be off, mild or strict")
+		},
+	}
+}
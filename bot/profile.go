@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// performanceProfile bundles resource-related defaults. Individual
+// environment variables still override the profile's values.
+type performanceProfile struct {
+	name          string
+	pollInterval  time.Duration
+	historyTurns  int
+	agentSlots    int // simultaneous agent calls
+	workQueueSize int
+	memoryLimitMB int // soft Go heap limit, 0 = unlimited
+}
+
+// performanceProfiles are selectable with PERFORMANCE_PROFILE
+var performanceProfiles = map[string]performanceProfile{
+	"default": {
+		name:          "default",
+		pollInterval:  5 * time.Second,
+		historyTurns:  10,
+		agentSlots:    4,
+		workQueueSize: 100,
+	},
+	// low is tuned for Raspberry Pi Zero-class hardware: fewer signal-cli
+	// JVM launches, one agent call at a time with a short work queue, no
+	// conversation history kept, and a tight heap ceiling
+	"low": {
+		name:          "low",
+		pollInterval:  20 * time.Second,
+		historyTurns:  0,
+		agentSlots:    1,
+		workQueueSize: 20,
+		memoryLimitMB: 48,
+	},
+}
+
+// lookupProfile returns the named performance profile
+func lookupProfile(name string) (performanceProfile, error) {
+	profile, exists := performanceProfiles[name]
+	if !exists {
+		return performanceProfile{}, fmt.Errorf("unknown PERFORMANCE_PROFILE %q (expected default or low)", name)
+	}
+	return profile, nil
+}
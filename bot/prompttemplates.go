@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// templateSubcommands can't be used as template names
+var templateSubcommands = []string{"add", "remove", "show", "list"}
+
+// promptTemplates are named prompts with {{placeholders}} filled in from
+// "!t" messages. Templates from TEMPLATES_FILE are read-only; admins add
+// and remove the others at runtime, which persist in path.
+type promptTemplates struct {
+	mu         sync.RWMutex
+	path       string
+	configured map[string]string // from TEMPLATES_FILE
+	values     map[string]string // added with !t add
+}
+
+// newPromptTemplates creates a template store backed by path
+func newPromptTemplates(path string) *promptTemplates {
+	return &promptTemplates{path: path, configured: make(map[string]string), values: make(map[string]string)}
+}
+
+// Load reads the configured templates file, if any, and the templates
+// added at runtime; a missing runtime file means none were added yet
+func (pt *promptTemplates) Load(configPath string) error {
+	configured := make(map[string]string)
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return fmt.Errorf("failed to read templates file: %w", err)
+		}
+		var custom map[string]string
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return fmt.Errorf("failed to parse templates file %s: %w", configPath, err)
+		}
+		for name, tmpl := range custom {
+			configured[strings.ToLower(name)] = tmpl
+		}
+	}
+
+	values := make(map[string]string)
+	data, err := os.ReadFile(pt.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", pt.path, err)
+		}
+	}
+
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.configured = configured
+	pt.values = values
+	return nil
+}
+
+// Get returns a template by name; runtime templates override configured ones
+func (pt *promptTemplates) Get(name string) (string, bool) {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+
+	if tmpl, exists := pt.values[name]; exists {
+		return tmpl, true
+	}
+	tmpl, exists := pt.configured[name]
+	return tmpl, exists
+}
+
+// Configured reports whether a template comes from TEMPLATES_FILE and
+// can't be removed at runtime
+func (pt *promptTemplates) Configured(name string) bool {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+
+	_, runtime := pt.values[name]
+	_, configured := pt.configured[name]
+	return configured && !runtime
+}
+
+// Set stores a runtime template and persists the change. An empty template
+// removes it.
+func (pt *promptTemplates) Set(name, tmpl string) error {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	if tmpl == "" {
+		delete(pt.values, name)
+	} else {
+		pt.values[name] = tmpl
+	}
+
+	data, err := json.MarshalIndent(pt.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(pt.path, data)
+}
+
+// Names returns the names of all templates, sorted
+func (pt *promptTemplates) Names() []string {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+
+	seen := make(map[string]bool, len(pt.configured)+len(pt.values))
+	for name := range pt.configured {
+		seen[name] = true
+	}
+	for name := range pt.values {
+		seen[name] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// templatePlaceholders returns the placeholders of tmpl the sender has to
+// fill, in order of first appearance. Request variables such as {{sender}}
+// and {{time}} are filled in automatically and aren't included; {{prompt}}
+// is up to the sender as in any other template.
+func templatePlaceholders(tmpl string) []string {
+	automatic := promptVars(AgentRequest{Sender: &AgentSender{}}, time.Time{})
+	delete(automatic, "prompt")
+	seen := make(map[string]bool)
+	var names []string
+	for _, match := range placeholderPattern.FindAllStringSubmatch(tmpl, -1) {
+		name := strings.ToLower(match[1])
+		if _, isAutomatic := automatic[name]; isAutomatic || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// splitTemplateArgs splits a message into words, keeping "quoted phrases"
+// together
+func splitTemplateArgs(text string) []string {
+	var args []string
+	var current strings.Builder
+	quoted, started := false, false
+	for _, r := range text {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			quoted = !quoted
+			started = true
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if started {
+				args = append(args, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// fillTemplate assigns a template's placeholders from args: name=value
+// pairs first, then the remaining words in order, with the last
+// placeholder taking whatever is left. It returns the first placeholder
+// left empty, if any.
+func fillTemplate(placeholders, args []string) (map[string]string, string) {
+	values := make(map[string]string, len(placeholders))
+	var positional []string
+	for _, arg := range args {
+		if name, value, found := strings.Cut(arg, "="); found && contains(placeholders, strings.ToLower(name)) {
+			values[strings.ToLower(name)] = value
+			continue
+		}
+		positional = append(positional, arg)
+	}
+
+	var open []string
+	for _, name := range placeholders {
+		if _, filled := values[name]; !filled {
+			open = append(open, name)
+		}
+	}
+	for i, name := range open {
+		switch {
+		case i >= len(positional):
+			return values, name
+		case i == len(open)-1:
+			values[name] = strings.Join(positional[i:], " ")
+		default:
+			values[name] = positional[i]
+		}
+	}
+	return values, ""
+}
+
+// templateUsage renders how to call a template, e.g. "!t email <recipient> <topic>"
+func templateUsage(name, tmpl string) string {
+	usage := "!t " + name
+	for _, placeholder := range templatePlaceholders(tmpl) {
+		usage += " <" + placeholder + ">"
+	}
+	return usage
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "t",
+		usage:   "!t | !t <name> <values...> | !t show <name> | !t add <name> <template> | !t remove <name>",
+		handler: templateCommand,
+	})
+}
+
+// templateCommand lists, shows and manages prompt templates, and sends a
+// filled-in template to the agent. Only admins may add or remove them.
+func templateCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	usage := "Usage: " + commands["t"].usage
+	if len(args) == 0 || strings.EqualFold(args[0], "list") {
+		names := bot.templates.Names()
+		if len(names) == 0 {
+			return "No templates yet."
+		}
+		lines := []string{"Templates:"}
+		for _, name := range names {
+			tmpl, _ := bot.templates.Get(name)
+			lines = append(lines, templateUsage(name, tmpl))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	switch sub := strings.ToLower(args[0]); sub {
+	case "show":
+		if len(args) < 2 {
+			return usage
+		}
+		tmpl, exists := bot.templates.Get(strings.ToLower(args[1]))
+		if !exists {
+			return fmt.Sprintf("No template %q.", args[1])
+		}
+		return tmpl
+
+	case "add", "remove":
+		if !bot.isAdmin(msg) {
+			return "Only admins can change templates."
+		}
+		if len(args) < 2 {
+			return usage
+		}
+		name := strings.ToLower(args[1])
+		if sub == "remove" {
+			if _, exists := bot.templates.Get(name); !exists {
+				return fmt.Sprintf("No template %q.", args[1])
+			}
+			if bot.templates.Configured(name) {
+				return fmt.Sprintf("Template %q comes from TEMPLATES_FILE and can only be removed there.", name)
+			}
+			if err := bot.templates.Set(name, ""); err != nil {
+				bot.logger.Printf("Error removing template: %v", err)
+				return "Sorry, I couldn't remove that template."
+			}
+			return fmt.Sprintf("Template %q removed.", name)
+		}
+
+		tmpl := commandText(msg.extractContent(), 3)
+		if tmpl == "" {
+			return usage
+		}
+		if !aliasNamePattern.MatchString(name) || contains(templateSubcommands, name) {
+			return "Template names are one short word of letters, digits, - or _, other than add, remove, show and list."
+		}
+		if err := bot.templates.Set(name, tmpl); err != nil {
+			bot.logger.Printf("Error saving template: %v", err)
+			return "Sorry, I couldn't save that template."
+		}
+		return fmt.Sprintf("Template %q saved. Use it with: %s", name, templateUsage(name, tmpl))
+	}
+
+	name := strings.ToLower(args[0])
+	tmpl, exists := bot.templates.Get(name)
+	if !exists {
+		return fmt.Sprintf("No template %q. Send !t to list them.", args[0])
+	}
+	values, missing := fillTemplate(templatePlaceholders(tmpl), splitTemplateArgs(commandText(msg.extractContent(), 2)))
+	if missing != "" {
+		return fmt.Sprintf("Missing {{%s}}. Usage: %s", missing, templateUsage(name, tmpl))
+	}
+	if !bot.promptAllowed(msg, true) {
+		return ""
+	}
+
+	request := msg.newAgentRequest("")
+	request.quota = bot.hasQuota(msg)
+	sender := msg.sender()
+	vars := promptVars(request, time.Now().In(bot.userLocation(msg.chatID(), &sender)))
+	vars["prompt"] = ""
+	for placeholder, value := range values {
+		vars[placeholder] = value
+	}
+	request.Prompt = expandPlaceholders(tmpl, vars)
+	bot.answerAsync(ctx, request, replyTarget{
+		Recipient:      msg.replyRecipient(),
+		QuoteTimestamp: msg.extractTimestamp(),
+		QuoteAuthor:    msg.Envelope.Source,
+	})
+	return ""
+}
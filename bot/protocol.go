@@ -0,0 +1,85 @@
+package main
+
+// protocolHeader carries the agent protocol version. The bot sends the
+// highest version it speaks; v2 agents echo "2" on their response, and a
+// missing header means a v1 agent.
+const protocolHeader = "X-Signal-Bot-Protocol"
+
+// AgentTurn is one entry of the conversation history sent to v2 agents
+type AgentTurn struct {
+	Role      string `json:"role"` // "user", "assistant" or "system" for summaries
+	Content   string `json:"content"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+}
+
+// AgentCapabilities tells a v2 agent what the bot can deliver
+type AgentCapabilities struct {
+	Attachments bool     `json:"attachments"`
+	Streaming   bool     `json:"streaming"`
+	Tools       []string `json:"tools,omitempty"`
+}
+
+// AgentMessage is one outgoing message in a v2 response
+type AgentMessage struct {
+	Text string `json:"text"`
+}
+
+// capabilities returns the capabilities advertised to v2 agents
+func (bot *SignalBot) capabilities(request AgentRequest) *AgentCapabilities {
+	caps := &AgentCapabilities{Attachments: false, Streaming: false}
+	if bot.toolsEnabled(request) {
+		caps.Tools = agentToolNames()
+	}
+	return caps
+}
+
+// conversationID returns a stable identifier for the chat a prompt was sent in
+func conversationID(chat AgentChat) string {
+	if chat.GroupID != "" {
+		return "group:" + chat.GroupID
+	}
+	if chat.Recipient != "" {
+		return "dm:" + chat.Recipient
+	}
+	return ""
+}
+
+// threadID returns the conversation ID used for the agent context of a
+// request. In groups each asker gets their own thread unless
+// GROUP_THREAD_PER_USER is disabled, so interleaved conversations with
+// several members don't bleed into each other.
+func (bot *SignalBot) threadID(request AgentRequest) string {
+	if request.Chat == nil {
+		return ""
+	}
+	id := conversationID(*request.Chat)
+	if request.Chat.GroupID == "" || !bot.config.GroupThreadPerUser || request.Sender == nil {
+		return id
+	}
+
+	asker := request.Sender.UUID
+	if asker == "" {
+		asker = request.Sender.Number
+	}
+	if asker == "" {
+		return id
+	}
+	return id + ":" + asker
+}
+
+// replies extracts the messages to send from a response, based on the
+// protocol version the agent answered with
+func (r *AgentResponse) replies() []string {
+	var replies []string
+	if r.version == "2" {
+		for _, m := range r.Messages {
+			if m.Text != "" {
+				replies = append(replies, m.Text)
+			}
+		}
+	}
+	if len(replies) == 0 {
+		replies = []string{r.Response}
+	}
+	return replies
+}
@@ -0,0 +1,92 @@
+package main
+
+import "sync"
+
+// Reactions showing a prompt's place in the agent queue
+const (
+	queueStartReaction = "👀" // replaces the position once the agent starts on it
+	queueLongReaction  = "⏳" // places beyond positionReactions
+)
+
+// positionReactions number the first places in the agent queue
+var positionReactions = []string{"1️⃣", "2️⃣", "3️⃣", "4️⃣", "5️⃣", "6️⃣", "7️⃣", "8️⃣", "9️⃣", "🔟"}
+
+// queuePositions remembers, by prompt timestamp like pendingMessages, where
+// to show the place in line of prompts waiting for an agent slot
+type queuePositions struct {
+	mu      sync.Mutex
+	targets map[int64]*positionTarget
+}
+
+// positionTarget is a tracked prompt and whether it shows a position
+type positionTarget struct {
+	target  replyTarget
+	reacted bool
+}
+
+func newQueuePositions() *queuePositions {
+	return &queuePositions{targets: make(map[int64]*positionTarget)}
+}
+
+// Track starts reporting the queue position of the prompt at timestamp
+func (p *queuePositions) Track(timestamp int64, target replyTarget) {
+	if timestamp == 0 || target.QuoteTimestamp == 0 || target.QuoteAuthor == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.targets[timestamp] = &positionTarget{target: target}
+}
+
+// Forget stops tracking the prompt at timestamp
+func (p *queuePositions) Forget(timestamp int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.targets, timestamp)
+}
+
+// mark records whether the prompt at timestamp shows a position, returning
+// its target and whether it showed one before
+func (p *queuePositions) mark(timestamp int64, reacted bool) (replyTarget, bool, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tracked, exists := p.targets[timestamp]
+	if !exists {
+		return replyTarget{}, false, false
+	}
+	was := tracked.reacted
+	tracked.reacted = reacted
+	return tracked.target, was, true
+}
+
+// positionReaction is the reaction showing a 1-based queue position
+func positionReaction(position int) string {
+	if position >= 1 && position <= len(positionReactions) {
+		return positionReactions[position-1]
+	}
+	return queueLongReaction
+}
+
+// reportQueuePosition reacts to a prompt that has to wait for an agent slot
+// with its place in line
+func (bot *SignalBot) reportQueuePosition(timestamp int64, position int) {
+	target, _, exists := bot.positions.mark(timestamp, true)
+	if !exists {
+		return
+	}
+	if err := bot.sendReaction(target.Recipient, positionReaction(position), target.QuoteAuthor, target.QuoteTimestamp); err != nil {
+		bot.logger.Printf("Error sending queue position: %v", err)
+	}
+}
+
+// reportQueueStart updates a prompt's position reaction once the agent
+// starts on it
+func (bot *SignalBot) reportQueueStart(timestamp int64) {
+	target, reacted, _ := bot.positions.mark(timestamp, false)
+	if !reacted {
+		return
+	}
+	if err := bot.sendReaction(target.Recipient, queueStartReaction, target.QuoteAuthor, target.QuoteTimestamp); err != nil {
+		bot.logger.Printf("Error sending queue position: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenQuota caps the estimated agent tokens each sender may use per
+// calendar day, so one expensive agent can be shared fairly. Usage is
+// persisted in the state store and survives restarts.
+type tokenQuota struct {
+	mu       sync.Mutex
+	daily    int // 0 = unlimited
+	warnAt   int // percentage of daily after which remaining tokens are reported
+	usage    *chatSettings
+	refusals map[string]string // sender -> day last told the quota is used up
+}
+
+func newTokenQuota(config Config) *tokenQuota {
+	return &tokenQuota{
+		daily:    config.TokenQuotaDaily,
+		warnAt:   config.TokenQuotaWarnPercent,
+		usage:    newChatSettings("token_usage"),
+		refusals: make(map[string]string),
+	}
+}
+
+// Used returns the tokens id used on the day of now
+func (q *tokenQuota) Used(id string, now time.Time) int {
+	day, used, found := strings.Cut(q.usage.Get(id, "day"), "/")
+	if !found || day != now.Format("2006-01-02") {
+		return 0
+	}
+	n, _ := strconv.Atoi(used)
+	return n
+}
+
+// Add charges tokens to id for the day of now, returning the day's total
+// and whether it just crossed the warning threshold
+func (q *tokenQuota) Add(id string, tokens int, now time.Time) (used int, warn bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	before := q.Used(id, now)
+	used = before + tokens
+	threshold := q.daily * q.warnAt / 100
+	warn = q.daily > 0 && before < threshold && used >= threshold
+	return used, warn, q.usage.Set(id, "day", fmt.Sprintf("%s/%d", now.Format("2006-01-02"), used))
+}
+
+// Refuse reports whether id should be told its quota is used up, which
+// happens once per day
+func (q *tokenQuota) Refuse(id string, now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	day := now.Format("2006-01-02")
+	if q.refusals[id] == day {
+		return false
+	}
+	q.refusals[id] = day
+	return true
+}
+
+// quotaID returns the ID usage of sender is counted under
+func quotaID(sender *AgentSender) string {
+	if sender.Number != "" {
+		return sender.Number
+	}
+	return sender.UUID
+}
+
+// estimateRequestTokens approximates the tokens an agent call used: the
+// prompt as sent, its history and documents, and the replies
+func estimateRequestTokens(request AgentRequest, replies []string) int {
+	tokens := estimateTokens(request.Prompt) + estimateTurnTokens(request.History)
+	for _, document := range request.Documents {
+		tokens += estimateTokens(document.Text)
+	}
+	for _, reply := range replies {
+		tokens += estimateTokens(reply)
+	}
+	return tokens
+}
+
+// overQuota reports whether the sender of a prompt in msg has used up
+// today's tokens, telling them so once a day when notify is set. The owner
+// and senders exempt from rate limits have no quota.
+func (bot *SignalBot) overQuota(msg *Message, notify bool) bool {
+	if !bot.hasQuota(msg) {
+		return false
+	}
+	sender := msg.sender()
+	now := time.Now()
+	if bot.quotas.Used(quotaID(&sender), now) < bot.config.TokenQuotaDaily {
+		return false
+	}
+
+	bot.logger.Printf("Daily token quota used up by %s", msg.Envelope.Source)
+	if bot.quotas.Refuse(quotaID(&sender), now) && notify {
+		reply := bot.localizef(msg.chatID(), "You've used today's allowance of about %d tokens. It resets at midnight.", bot.config.TokenQuotaDaily)
+		if err := bot.sendReply(msg.replyRecipient(), reply, msg.extractTimestamp(), msg.Envelope.Source); err != nil {
+			bot.logger.Printf("Error sending quota reply: %v", err)
+		}
+	}
+	return true
+}
+
+// hasQuota reports whether prompts in msg count against a daily quota
+func (bot *SignalBot) hasQuota(msg *Message) bool {
+	return bot.config.TokenQuotaDaily > 0 && !bot.isAdmin(msg) && !bot.rateLimits.Exempt(msg.sender())
+}
+
+// chargePrompt charges the tokens of an agent call made for msg outside
+// answer, such as a macro step or a translation, to its sender's quota
+func (bot *SignalBot) chargePrompt(msg *Message, request AgentRequest, response *AgentResponse) {
+	charged := msg.newAgentRequest("")
+	charged.quota = bot.hasQuota(msg)
+	tokens := estimateRequestTokens(request, response.replies())
+	bot.chargeQuota(charged, agentAnswer{Tokens: tokens}, replyTarget{Recipient: msg.replyRecipient()})
+}
+
+// quotaNoteTarget returns where the note about an asker's quota goes. In
+// groups it quotes their prompt, so the group knows whose quota it is, or
+// goes to their direct chat when there's no prompt to quote.
+func quotaNoteTarget(request AgentRequest, target replyTarget) (recipient string, quote int64, author string) {
+	asker := quotaID(request.Sender)
+	switch {
+	case destinationOf(target.Recipient) != destGroup || asker == "":
+		return target.Recipient, 0, ""
+	case request.Timestamp != 0:
+		return target.Recipient, request.Timestamp, asker
+	default:
+		return asker, 0, ""
+	}
+}
+
+// chargeQuota adds the tokens of an answer to the asker's quota, telling
+// them how much is left once they pass QUOTA_WARN_PERCENT
+func (bot *SignalBot) chargeQuota(request AgentRequest, result agentAnswer, target replyTarget) {
+	if !request.quota || request.Sender == nil || result.Tokens == 0 {
+		return
+	}
+
+	used, warn, err := bot.quotas.Add(quotaID(request.Sender), result.Tokens, time.Now())
+	if err != nil {
+		bot.logger.Printf("Error saving token usage: %v", err)
+	}
+	if !warn {
+		return
+	}
+	left := max(bot.config.TokenQuotaDaily-used, 0)
+	note := bot.localizef(requestChatID(request), "Heads up: about %d of your %d tokens for today are left.", left, bot.config.TokenQuotaDaily)
+	recipient, quote, author := quotaNoteTarget(request, target)
+	if err := bot.sendReply(recipient, note, quote, author); err != nil {
+		bot.logger.Printf("Error sending quota note: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestQuotaNoteTarget(t *testing.T) {
+	asker := &AgentSender{Number: "+15550001", UUID: "u-1"}
+
+	tests := []struct {
+		name          string
+		request       AgentRequest
+		target        replyTarget
+		wantRecipient string
+		wantQuote     int64
+		wantAuthor    string
+	}{
+		{
+			name:          "group prompt is quoted",
+			request:       AgentRequest{Sender: asker, Timestamp: 1000},
+			target:        replyTarget{Recipient: "-g abc", QuoteTimestamp: 900, QuoteAuthor: "+15550002"},
+			wantRecipient: "-g abc", wantQuote: 1000, wantAuthor: "+15550001",
+		},
+		{
+			name:          "asker known by UUID only",
+			request:       AgentRequest{Sender: &AgentSender{UUID: "u-1"}, Timestamp: 1000},
+			target:        replyTarget{Recipient: "-g abc"},
+			wantRecipient: "-g abc", wantQuote: 1000, wantAuthor: "u-1",
+		},
+		{
+			name:          "group request without a prompt goes to the DM",
+			request:       AgentRequest{Sender: asker},
+			target:        replyTarget{Recipient: "-g abc"},
+			wantRecipient: "+15550001",
+		},
+		{
+			name:          "DM needs no quote",
+			request:       AgentRequest{Sender: asker, Timestamp: 1000},
+			target:        replyTarget{Recipient: "+15550001"},
+			wantRecipient: "+15550001",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recipient, quote, author := quotaNoteTarget(tt.request, tt.target)
+			if recipient != tt.wantRecipient || quote != tt.wantQuote || author != tt.wantAuthor {
+				t.Errorf("quotaNoteTarget() = %q, %d, %q, want %q, %d, %q", recipient, quote, author, tt.wantRecipient, tt.wantQuote, tt.wantAuthor)
+			}
+		})
+	}
+}
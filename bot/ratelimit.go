@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userRateLimit caps the prompts each sender may send per minute and per
+// day. Counters and runtime exemptions are persisted in the state store so
+// a restart doesn't hand everyone a fresh allowance.
+type userRateLimit struct {
+	mu        sync.Mutex
+	perMinute int // 0 = unlimited
+	perDay    int
+	exempt    map[string]bool // numbers and UUIDs from RATE_LIMIT_EXEMPT
+	counters  *chatSettings   // sender -> "minute", "day" and "exempt"
+	warned    map[string]string
+}
+
+func newUserRateLimit(config Config) *userRateLimit {
+	l := &userRateLimit{
+		perMinute: config.RateLimitPerMinute,
+		perDay:    config.RateLimitPerDay,
+		exempt:    make(map[string]bool),
+		counters:  newChatSettings("rate_limits"),
+		warned:    make(map[string]string),
+	}
+	for _, id := range config.RateLimitExempt {
+		l.exempt[id] = true
+	}
+	return l
+}
+
+// Exempt reports whether sender is never limited
+func (l *userRateLimit) Exempt(sender AgentSender) bool {
+	for _, id := range []string{sender.Number, sender.UUID} {
+		if id != "" && (l.exempt[id] || l.counters.Get(id, "exempt") != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// SetExempt adds or removes a runtime exemption for a number or UUID
+func (l *userRateLimit) SetExempt(id string, exempt bool) error {
+	value := ""
+	if exempt {
+		value = "on"
+	}
+	return l.counters.Set(id, "exempt", value)
+}
+
+// Allow counts a prompt from id at now. Past a limit it returns how long
+// until the next prompt is allowed, and whether the sender should be told:
+// only the first refusal of each window is answered.
+func (l *userRateLimit) Allow(id string, now time.Time) (wait time.Duration, notify bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	minute := now.Truncate(time.Minute)
+	day := now.Format("2006-01-02")
+	minuteCount := l.count(id, "minute", strconv.FormatInt(minute.Unix(), 10))
+	dayCount := l.count(id, "day", day)
+
+	window := ""
+	switch {
+	case l.perDay > 0 && dayCount >= l.perDay:
+		year, month, date := now.Date()
+		wait, window = time.Date(year, month, date+1, 0, 0, 0, 0, now.Location()).Sub(now), day
+	case l.perMinute > 0 && minuteCount >= l.perMinute:
+		wait, window = minute.Add(time.Minute).Sub(now), strconv.FormatInt(minute.Unix(), 10)
+	}
+	if window != "" {
+		notify = l.warned[id] != window
+		l.warned[id] = window
+		return wait, notify, nil
+	}
+
+	if err := l.counters.Set(id, "minute", fmt.Sprintf("%d/%d", minute.Unix(), minuteCount+1)); err != nil {
+		return 0, false, err
+	}
+	return 0, false, l.counters.Set(id, "day", fmt.Sprintf("%s/%d", day, dayCount+1))
+}
+
+// count returns the stored count of id for key when it belongs to window,
+// stored as "<window>/<count>"; callers must hold l.mu
+func (l *userRateLimit) count(id, key, window string) int {
+	stored, count, found := strings.Cut(l.counters.Get(id, key), "/")
+	if !found || stored != window {
+		return 0
+	}
+	n, _ := strconv.Atoi(count)
+	return n
+}
+
+// RuntimeExemptions lists the exemptions made with "!admin exempt"
+func (l *userRateLimit) RuntimeExemptions() []string {
+	ids := l.counters.IDsWith("exempt")
+	sort.Strings(ids)
+	return ids
+}
+
+// Exemptions lists the configured and runtime exemptions
+func (l *userRateLimit) Exemptions() []string {
+	seen := make(map[string]bool)
+	for id := range l.exempt {
+		seen[id] = true
+	}
+	for _, id := range l.counters.IDsWith("exempt") {
+		seen[id] = true
+	}
+
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// promptAllowed reports whether msg may have the bot call the agent now.
+// Every path prompting the agent for a message checks it, not just
+// triggers: voice notes, !t, !macro, !translate, !summarize, the summary
+// reaction and the mirror. With notify unset the sender isn't told when
+// they're held back.
+func (bot *SignalBot) promptAllowed(msg *Message, notify bool) bool {
+	return !bot.flooding(msg, notify) && !bot.rateLimited(msg, notify) && !bot.overQuota(msg, notify)
+}
+
+// rateLimited reports whether a prompt in msg is over its sender's limit,
+// telling the sender to slow down the first time in each window when
+// notify is set. The owner and exempt senders are never limited.
+func (bot *SignalBot) rateLimited(msg *Message, notify bool) bool {
+	if bot.isAdmin(msg) || (bot.config.RateLimitPerMinute <= 0 && bot.config.RateLimitPerDay <= 0) {
+		return false
+	}
+	sender := msg.sender()
+	if bot.rateLimits.Exempt(sender) {
+		return false
+	}
+
+	id := quotaID(&sender)
+	wait, first, err := bot.rateLimits.Allow(id, time.Now())
+	if err != nil {
+		bot.logger.Printf("Error saving rate limit counters: %v", err)
+	}
+	if wait == 0 {
+		return false
+	}
+
+	bot.logger.Printf("Rate limit reached for %s, next prompt in %s", id, wait.Round(time.Second))
+	if reply := bot.errorMessage(msg.chatID(), errorRateLimited, map[string]string{"wait": formatWait(wait)}); notify && first && reply != "" {
+		if err := bot.sendReply(msg.replyRecipient(), reply, msg.extractTimestamp(), msg.Envelope.Source); err != nil {
+			bot.logger.Printf("Error sending rate limit reply: %v", err)
+		}
+	}
+	return true
+}
+
+// formatWait rounds a wait for people: seconds under a minute, then
+// minutes, then hours
+func formatWait(wait time.Duration) string {
+	switch {
+	case wait < time.Minute:
+		return fmt.Sprintf("%d seconds", int(wait.Seconds())+1)
+	case wait < time.Hour:
+		return fmt.Sprintf("%d minutes", int(wait.Minutes())+1)
+	default:
+		return fmt.Sprintf("%d hours", int(wait.Hours())+1)
+	}
+}
+
+// exemptCommand lists or changes rate limit exemptions; it backs
+// "!admin exempt [<number|uuid> on|off]"
+func exemptCommand(bot *SignalBot, args []string) string {
+	if len(args) == 0 {
+		ids := bot.rateLimits.Exemptions()
+		if len(ids) == 0 {
+			return "No one is exempt from rate limits."
+		}
+		return "Exempt from rate limits: " + strings.Join(ids, ", ")
+	}
+	if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+		return "Usage: !admin exempt [<number|uuid> on|off]"
+	}
+
+	if args[1] == "off" && bot.rateLimits.exempt[args[0]] {
+		return args[0] + " is exempt in RATE_LIMIT_EXEMPT; remove it there."
+	}
+	if err := bot.rateLimits.SetExempt(args[0], args[1] == "on"); err != nil {
+		return "Error: " + err.Error()
+	}
+	bot.logger.Printf("Rate limit exemption for %s turned %s", args[0], args[1])
+	if args[1] == "on" {
+		return args[0] + " is now exempt from rate limits."
+	}
+	return args[0] + " is rate limited again."
+}
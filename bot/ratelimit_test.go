@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"testing"
+)
+
+func TestRateLimitedCountsSenderOnce(t *testing.T) {
+	// The same person, once reported by UUID and once by number
+	envelopes := []string{
+		`{"source":"u-1","sourceNumber":"+15550001","sourceUuid":"u-1","dataMessage":{"message":"qq one"}}`,
+		`{"source":"+15550001","sourceUuid":"u-1","dataMessage":{"message":"qq two"}}`,
+	}
+
+	tests := []struct {
+		name        string
+		exempt      []string
+		wantLimited bool
+	}{
+		{name: "second prompt is limited", wantLimited: true},
+		{name: "exempt by number", exempt: []string{"+15550001"}},
+		{name: "exempt by UUID", exempt: []string{"u-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := NewSignalBot()
+			bot.logger = log.New(io.Discard, "", 0)
+			bot.config.RateLimitPerMinute = 1
+			bot.config.RateLimitExempt = tt.exempt
+			bot.rateLimits = newUserRateLimit(bot.config)
+
+			var limited bool
+			for _, envelope := range envelopes {
+				var msg Message
+				if err := json.Unmarshal([]byte(`{"envelope":`+envelope+`}`), &msg); err != nil {
+					t.Fatal(err)
+				}
+				limited = bot.rateLimited(&msg, false)
+			}
+			if limited != tt.wantLimited {
+				t.Errorf("rateLimited() on the second prompt = %t, want %t", limited, tt.wantLimited)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "context"
+
+// reactionHandler runs when someone reacts to a message with its emoji
+type reactionHandler func(ctx context.Context, bot *SignalBot, msg *Message, reaction *Reaction)
+
+// reactionHandlers maps emoji to what the bot does with such reactions
+var reactionHandlers = map[string]reactionHandler{}
+
+// registerReactionHandler makes the bot act on reactions with emoji
+func registerReactionHandler(emoji string, handler reactionHandler) {
+	reactionHandlers[emoji] = handler
+}
+
+// reaction returns the reaction carried by msg, sent or received, or nil
+func (msg *Message) reaction() *Reaction {
+	if r := msg.Envelope.SyncMessage.SentMessage.Reaction; r != nil {
+		return r
+	}
+	return msg.Envelope.DataMessage.Reaction
+}
+
+// handleReaction dispatches a reaction to its handler, returning false
+// when msg isn't a reaction
+func (bot *SignalBot) handleReaction(ctx context.Context, msg *Message) bool {
+	reaction := msg.reaction()
+	if reaction == nil {
+		return false
+	}
+	if reaction.IsRemove {
+		return true
+	}
+	if handler, exists := reactionHandlers[reaction.Emoji]; exists {
+		handler(ctx, bot, msg, reaction)
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Scopes of REDACT_SCOPE
+const (
+	redactScopeGroups = "groups" // replies in group chats
+	redactScopeAll    = "all"    // every reply
+)
+
+// redactionPatterns are the built-in patterns REDACT_PATTERNS can name
+var redactionPatterns = map[string]*regexp.Regexp{
+	"email": regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	"phone": regexp.MustCompile(`(?:\+|\b00)\d[\d ().-]{6,16}\d\b|\(?\b\d{3}\)?[ .-]\d{3}[ .-]\d{4}\b`),
+	"api-key": regexp.MustCompile(`\b(?:sk|pk|rk)-[A-Za-z0-9_-]{16,}|\bAKIA[0-9A-Z]{16}\b|\bgh[pousr]_[A-Za-z0-9]{30,}\b|` +
+		`\bxox[abprs]-[A-Za-z0-9-]{10,}\b|\bAIza[0-9A-Za-z_-]{35}\b|\bBearer [A-Za-z0-9._~+/-]{20,}=*`),
+}
+
+// compileRedactions returns the patterns scrubbed from replies: the
+// built-in ones in REDACT_PATTERNS and the regexes in REDACT_CUSTOM
+func compileRedactions(config Config) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, name := range config.RedactPatterns {
+		re, known := redactionPatterns[name]
+		if !known {
+			return nil, fmt.Errorf("REDACT_PATTERNS: unknown pattern %q (use email, phone or api-key)", name)
+		}
+		patterns = append(patterns, re)
+	}
+	for _, pattern := range config.RedactCustom {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("REDACT_CUSTOM: %w", err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// redactReplies replaces what the redaction patterns match in replies to
+// request with REDACT_TEXT, so the agent can't echo sensitive data into a
+// chat. Only group replies are scrubbed unless REDACT_SCOPE is all.
+func (bot *SignalBot) redactReplies(request AgentRequest, replies []string) []string {
+	inGroup := request.Chat != nil && request.Chat.GroupID != ""
+	redacted, count := bot.scrub(inGroup, replies)
+	if count > 0 {
+		bot.logger.Printf("Redacted %d matches from the reply to %d in %s", count, request.Timestamp, requestChatID(request))
+	}
+	return redacted
+}
+
+// redactTo scrubs agent-written text bound for recipient rather than for
+// the chat of the prompt, such as a forwarded answer or a send_message
+// call, so the scope follows where the text ends up
+func (bot *SignalBot) redactTo(recipient string, replies []string) []string {
+	redacted, count := bot.scrub(destinationOf(recipient) == destGroup, replies)
+	if count > 0 {
+		bot.logger.Printf("Redacted %d matches from agent text to %s", count, recipient)
+	}
+	return redacted
+}
+
+// scrub applies the redaction patterns to replies going to a group or a
+// DM, returning them and the number of matches replaced
+func (bot *SignalBot) scrub(inGroup bool, replies []string) ([]string, int) {
+	if len(bot.redactions) == 0 || (!inGroup && bot.config.RedactScope != redactScopeAll) {
+		return replies, 0
+	}
+
+	redacted := make([]string, len(replies))
+	count := 0
+	for i, reply := range replies {
+		for _, re := range bot.redactions {
+			reply = re.ReplaceAllStringFunc(reply, func(string) string {
+				count++
+				return bot.config.RedactText
+			})
+		}
+		redacted[i] = reply
+	}
+	return redacted, count
+}
+
+// redactReply scrubs a command's reply to msg that carries agent output,
+// such as a macro's result or a translation, like redactReplies does for
+// answers
+func (bot *SignalBot) redactReply(msg *Message, reply string) string {
+	return bot.redactReplies(msg.newAgentRequest(""), []string{reply})[0]
+}
+
+// validateRedaction checks the REDACT_* settings
+func validateRedaction(config Config) error {
+	if _, err := compileRedactions(config); err != nil {
+		return err
+	}
+	if config.RedactScope != redactScopeGroups && config.RedactScope != redactScopeAll {
+		return fmt.Errorf("REDACT_SCOPE must be groups or all")
+	}
+	if strings.TrimSpace(config.RedactText) == "" {
+		return fmt.Errorf("REDACT_TEXT must not be empty")
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// liveConfig holds the settings a config reload applies to the running
+// bot. Everything else in Config takes effect on the next restart.
+type liveConfig struct {
+	AIPrefix          string
+	Triggers          []string
+	AgentURL          string
+	AgentModels       []string
+	AgentDefaultModel string
+	AccessDefault     string
+	AllowSenders      []string
+	DenySenders       []string
+	AllowGroups       []string
+	DenyGroups        []string
+	RolesOwners       []string
+	RolesAdmins       []string
+	CommandRoles      map[string]string
+	ToolsRole         string
+
+	prefixes []string        // the triggers as matched against messages
+	access   accessPolicy    // built from the access settings
+	owners   map[string]bool // from RolesOwners
+	admins   map[string]bool // from RolesAdmins
+}
+
+// newLiveConfig takes the reloadable settings from config
+func newLiveConfig(config Config) *liveConfig {
+	live := &liveConfig{
+		AIPrefix:          config.AIPrefix,
+		Triggers:          config.Triggers,
+		AgentURL:          config.AgentURL,
+		AgentModels:       config.AgentModels,
+		AgentDefaultModel: config.AgentDefaultModel,
+		AccessDefault:     config.AccessDefault,
+		AllowSenders:      config.AllowSenders,
+		DenySenders:       config.DenySenders,
+		AllowGroups:       config.AllowGroups,
+		DenyGroups:        config.DenyGroups,
+		RolesOwners:       config.RolesOwners,
+		RolesAdmins:       config.RolesAdmins,
+		CommandRoles:      config.CommandRoles,
+		ToolsRole:         config.ToolsRole,
+		prefixes:          []string{"🤖 ", "qq ", config.AIPrefix + " "},
+		access:            newAccessPolicy(config),
+		owners:            make(map[string]bool),
+		admins:            make(map[string]bool),
+	}
+	for _, id := range config.RolesOwners {
+		live.owners[id] = true
+	}
+	for _, id := range config.RolesAdmins {
+		live.admins[id] = true
+	}
+	if len(config.Triggers) > 0 {
+		live.prefixes = nil
+		for _, trigger := range config.Triggers {
+			live.prefixes = append(live.prefixes, trigger+" ")
+		}
+	}
+	return live
+}
+
+// apply overwrites the reloadable settings of config
+func (l *liveConfig) apply(config *Config) {
+	config.AIPrefix = l.AIPrefix
+	config.Triggers = l.Triggers
+	config.AgentURL = l.AgentURL
+	config.AgentModels = l.AgentModels
+	config.AgentDefaultModel = l.AgentDefaultModel
+	config.AccessDefault = l.AccessDefault
+	config.AllowSenders = l.AllowSenders
+	config.DenySenders = l.DenySenders
+	config.AllowGroups = l.AllowGroups
+	config.DenyGroups = l.DenyGroups
+	config.RolesOwners = l.RolesOwners
+	config.RolesAdmins = l.RolesAdmins
+	config.CommandRoles = l.CommandRoles
+	config.ToolsRole = l.ToolsRole
+}
+
+// reloadConfig re-reads the config file and environment. Triggers, the
+// agent URL and models, access lists, roles, per-chat defaults and feature flags
+// change right away; the receive
+// loop and queued messages are left alone. A file that doesn't validate
+// leaves the running configuration untouched.
+func (bot *SignalBot) reloadConfig() error {
+	path := bot.config.ConfigFile
+	if path == "" {
+		return fmt.Errorf("no config file to reload (set CONFIG_FILE or --config)")
+	}
+	bot.reloadMu.Lock()
+	defer bot.reloadMu.Unlock()
+
+	oldSettings, oldChats := configFileSettings, configFileChats
+	if err := loadConfigFile(path, bot.config.ConfigProfile); err != nil {
+		return err
+	}
+	config := loadConfig()
+	config.ConfigFile, config.ConfigProfile = path, bot.config.ConfigProfile
+	config.ForceStart, config.DryRun = bot.config.ForceStart, bot.config.DryRun
+	rollouts := readFlagRollouts()
+	err := checkSettings()
+	if err == nil {
+		err = (&SignalBot{config: config}).validateConfig()
+	}
+	if err != nil {
+		configFileSettings, configFileChats = oldSettings, oldChats
+		return err
+	}
+
+	live := newLiveConfig(config)
+	bot.live.Store(live)
+	bot.settings.SetDefaults(configFileChats)
+	bot.flags.SetRollouts(rollouts)
+	bot.logger.Printf("Reloaded %s (triggers: %v, agent: %s)", path, live.prefixes, redactURL(live.AgentURL))
+
+	running := bot.config
+	live.apply(&running)
+	if changed := changedConfigFields(running, config); len(changed) > 0 {
+		bot.logger.Printf("Changed settings that take effect after a restart: %s", strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// changedConfigFields names the fields that differ between two configs
+func changedConfigFields(a, b Config) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var changed []string
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, va.Type().Field(i).Name)
+		}
+	}
+	return changed
+}
+
+// runConfigWatch reloads the config file whenever its modification time
+// changes, checking every CONFIG_WATCH_INTERVAL
+func (bot *SignalBot) runConfigWatch(ctx context.Context) error {
+	modTime := func() time.Time {
+		info, err := os.Stat(bot.config.ConfigFile)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+
+	ticker := time.NewTicker(bot.config.ConfigWatchInterval)
+	defer ticker.Stop()
+	last := modTime()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if current := modTime(); !current.Equal(last) {
+			last = current
+			if err := bot.reloadConfig(); err != nil {
+				bot.logger.Printf("Error reloading config: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// replayMessages runs captured envelopes through processMessage with
+// DryRun set, so replies, reactions and attachments are logged instead of
+// sent. It backs "signalbot replay FILE...". Files hold signal-cli
+// "--output=json receive" lines, JSON arrays of them (like the saved
+// inbox) or archive JSONL, whose message entries carry their envelope; "-"
+// reads standard input. State, the knowledge index and the archive stay in
+// memory, so nothing is recorded as processed and replaying the same
+// capture twice behaves the same. The agent is called as configured.
+func (bot *SignalBot) replayMessages(ctx context.Context, paths []string) error {
+	bot.config.DryRun = true
+	if err := bot.prepare(); err != nil {
+		return err
+	}
+	if err := bot.loadOperatorFiles(); err != nil {
+		return err
+	}
+	bot.embedder = bot.newEmbedder()
+	bot.knowledge = newJSONVectorIndex("", bot.config.KnowledgeMaxChunks)
+	if bot.config.ArchiveEnabled {
+		bot.archive = &memoryArchive{}
+	}
+
+	replayed, skipped := 0, 0
+	for _, path := range paths {
+		messages, n, err := readCapturedMessages(path)
+		if err != nil {
+			return err
+		}
+		skipped += n
+		for _, msg := range messages {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			bot.logger.Printf("Replaying envelope %d from %s", msg.Envelope.Timestamp, msg.Envelope.Source)
+			err := runGuarded(ctx, func(ctx context.Context) error {
+				bot.processMessage(ctx, msg)
+				return nil
+			})
+			if err != nil {
+				bot.logger.Printf("Error processing replayed message: %v", err)
+			}
+			replayed++
+		}
+	}
+	bot.answering.Wait()
+	bot.logger.Printf("Replayed %d envelopes, skipped %d archive entries without one", replayed, skipped)
+	return nil
+}
+
+// readCapturedMessages decodes every envelope in a capture file, returning
+// them with the number of archive entries that had none (prompts, replies)
+func readCapturedMessages(path string) ([]Message, int, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var messages []Message
+	skipped := 0
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+
+		values := []json.RawMessage{value}
+		if bytes.HasPrefix(bytes.TrimSpace(value), []byte("[")) {
+			values = nil
+			if err := json.Unmarshal(value, &values); err != nil {
+				return nil, 0, fmt.Errorf("failed to parse %s: %w", path, err)
+			}
+		}
+		for _, value := range values {
+			msg, ok, err := decodeCapturedMessage(value)
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to parse %s: %w", path, err)
+			}
+			if !ok {
+				skipped++
+				continue
+			}
+			messages = append(messages, msg)
+		}
+	}
+	return messages, skipped, nil
+}
+
+// decodeCapturedMessage decodes a signal-cli message or an archive entry,
+// reporting false for archive entries without an envelope
+func decodeCapturedMessage(value json.RawMessage) (Message, bool, error) {
+	var entry struct {
+		Kind     *string         `json:"kind"`
+		Envelope json.RawMessage `json:"envelope"`
+	}
+	if err := json.Unmarshal(value, &entry); err != nil {
+		return Message{}, false, err
+	}
+
+	var msg Message
+	if entry.Kind == nil {
+		err := json.Unmarshal(value, &msg)
+		return msg, err == nil, err
+	}
+	if *entry.Kind != archiveKindMessage || len(entry.Envelope) == 0 {
+		return Message{}, false, nil
+	}
+	err := json.Unmarshal(entry.Envelope, &msg.Envelope)
+	return msg, err == nil, err
+}
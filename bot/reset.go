@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+func init() {
+	registerCommand(&command{
+		name:    "reset",
+		usage:   "!reset",
+		handler: resetCommand,
+	})
+}
+
+// resetCommand wipes the asker's conversation history in this chat and the
+// chat's persona, so the next prompt starts from a clean context
+func resetCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	chatID := msg.chatID()
+	if chatID == "" {
+		return "Sorry, I can't tell which chat this is."
+	}
+
+	id := bot.threadID(msg.newAgentRequest(""))
+	unlock := bot.history.Lock(id)
+	err := bot.history.Forget(func(other string) bool { return other == id })
+	unlock()
+	if err != nil {
+		bot.logger.Printf("Error clearing history: %v", err)
+		return "Sorry, I couldn't clear the conversation."
+	}
+
+	if err := bot.settings.Set(chatID, "persona", ""); err != nil {
+		bot.logger.Printf("Error resetting persona: %v", err)
+		return "Conversation cleared, but I couldn't reset the persona."
+	}
+	return "Conversation cleared. I've forgotten our earlier messages and I'm back to the default persona."
+}
+
+// isResetPrompt reports whether a triggered prompt is "reset", so that
+// "qq reset" works like !reset
+func isResetPrompt(prompt string) bool {
+	return strings.EqualFold(strings.TrimSpace(prompt), "reset")
+}
@@ -0,0 +1,92 @@
+package main
+
+import "fmt"
+
+// Roles, from least to most trusted. The bot's own account is always an
+// owner; ROLES_OWNERS and ROLES_ADMINS add others.
+const (
+	roleUser  = "user"
+	roleAdmin = "admin"
+	roleOwner = "owner"
+)
+
+// roleRanks orders the roles for permission checks
+var roleRanks = map[string]int{roleUser: 0, roleAdmin: 1, roleOwner: 2}
+
+// adminSubcommandRoles lists the "!admin" subcommands needing more than
+// the admin command itself
+var adminSubcommandRoles = map[string]string{
+	"config":            roleOwner,
+	"resolve-challenge": roleOwner,
+}
+
+// roleAtLeast reports whether role grants everything required does
+func roleAtLeast(role, required string) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+// senderRole returns the role configured for a sender
+func (bot *SignalBot) senderRole(sender AgentSender) string {
+	live := bot.live.Load()
+	for _, id := range []string{sender.Number, sender.UUID} {
+		switch {
+		case id == "":
+		case id == bot.config.SignalAccount || live.owners[id]:
+			return roleOwner
+		case live.admins[id]:
+			return roleAdmin
+		}
+	}
+	return roleUser
+}
+
+// roleOf returns the role of the sender of msg. Messages the account
+// sent itself come from its owner.
+func (bot *SignalBot) roleOf(msg *Message) string {
+	if msg.Envelope.SyncMessage.SentMessage.Message != "" {
+		return roleOwner
+	}
+	return bot.senderRole(msg.sender())
+}
+
+// hasRole reports whether the sender of msg has at least role
+func (bot *SignalBot) hasRole(msg *Message, role string) bool {
+	return roleAtLeast(bot.roleOf(msg), role)
+}
+
+// requestRole returns the role of whoever asked an agent request; requests
+// not made from a message get the role of their sender
+func (bot *SignalBot) requestRole(request AgentRequest) string {
+	if request.role != "" {
+		return request.role
+	}
+	if request.Sender == nil {
+		return roleUser
+	}
+	return bot.senderRole(*request.Sender)
+}
+
+// commandRole returns the least role that may run a command, or an admin
+// subcommand named "admin.<subcommand>", honouring COMMAND_ROLES
+func (bot *SignalBot) commandRole(name, defaultRole string) string {
+	if role, exists := bot.live.Load().CommandRoles[name]; exists {
+		return role
+	}
+	if defaultRole == "" {
+		return roleUser
+	}
+	return defaultRole
+}
+
+// validateRoles checks the role settings of config
+func validateRoles(config Config) error {
+	for name, role := range config.CommandRoles {
+		if _, known := roleRanks[role]; !known {
+			return fmt.Errorf("COMMAND_ROLES: unknown role %q for %s (use user, admin or owner)", role, name)
+		}
+	}
+	if _, known := roleRanks[config.ToolsRole]; !known {
+		return fmt.Errorf("TOOLS_ROLE must be user, admin or owner")
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScheduledMessage is a message queued for delivery at a later time
+type ScheduledMessage struct {
+	ID        int       `json:"id"`
+	At        time.Time `json:"at"`
+	Recipient string    `json:"recipient"`
+	Text      string    `json:"text"`
+	CreatedBy string    `json:"created_by"`
+	// CreatorUUID and ChatID locate the creator's personal settings, such
+	// as quiet hours; both are empty for messages the agent scheduled
+	CreatorUUID string `json:"creator_uuid,omitempty"`
+	ChatID      string `json:"chat_id,omitempty"`
+}
+
+// createdBy reports whether who scheduled the message
+func (item *ScheduledMessage) createdBy(who AgentSender) bool {
+	if who.Number != "" && item.CreatedBy != "" {
+		return who.Number == item.CreatedBy
+	}
+	return who.UUID != "" && who.UUID == item.CreatorUUID
+}
+
+// schedulerKey is the state value scheduled messages are persisted under
+const schedulerKey = "scheduled"
+
+// scheduler delivers scheduled messages once they're due. They are
+// persisted in the state store so reminders survive a restart.
+type scheduler struct {
+	mu     sync.Mutex
+	store  stateStore // nil until loaded
+	nextID int
+	items  map[int]*ScheduledMessage
+}
+
+// newScheduler creates an empty scheduler
+func newScheduler() *scheduler {
+	return &scheduler{nextID: 1, items: make(map[int]*ScheduledMessage)}
+}
+
+// Load reads the scheduled messages from store, which later changes are
+// saved to
+func (s *scheduler) Load(store stateStore) error {
+	value, err := store.Get(schedulerKey)
+	if err != nil {
+		return err
+	}
+	var items []*ScheduledMessage
+	if value != "" {
+		if err := json.Unmarshal([]byte(value), &items); err != nil {
+			return fmt.Errorf("failed to parse scheduled messages: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = store
+	for _, item := range items {
+		s.items[item.ID] = item
+		s.nextID = max(s.nextID, item.ID+1)
+	}
+	return nil
+}
+
+// save persists the scheduled messages; callers must hold s.mu
+func (s *scheduler) save() error {
+	if s.store == nil {
+		return nil
+	}
+	value := ""
+	if len(s.items) > 0 {
+		items := make([]*ScheduledMessage, 0, len(s.items))
+		for _, item := range s.items {
+			items = append(items, item)
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+		data, err := json.Marshal(items)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	return s.store.Set(schedulerKey, value)
+}
+
+// Add queues a message created by creator in a chat and returns it with its
+// assigned ID
+func (s *scheduler) Add(at time.Time, recipient, text string, creator AgentSender, chatID string) (*ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := &ScheduledMessage{ID: s.nextID, At: at, Recipient: recipient, Text: text, CreatedBy: creator.Number, CreatorUUID: creator.UUID, ChatID: chatID}
+	s.items[item.ID] = item
+	s.nextID++
+	return item, s.save()
+}
+
+// postpone puts a taken message back to be delivered at a later time
+func (s *scheduler) postpone(item *ScheduledMessage, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item.At = at
+	s.items[item.ID] = item
+	return s.save()
+}
+
+// Get returns a copy of the scheduled message with id for a recipient
+func (s *scheduler) Get(id int, recipient string) (ScheduledMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, exists := s.items[id]
+	if !exists || item.Recipient != recipient {
+		return ScheduledMessage{}, false
+	}
+	return *item, true
+}
+
+// Cancel removes a scheduled message for a recipient
+func (s *scheduler) Cancel(id int, recipient string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, exists := s.items[id]
+	if !exists || item.Recipient != recipient {
+		return false, nil
+	}
+	delete(s.items, id)
+	return true, s.save()
+}
+
+// List returns the scheduled messages for a recipient ordered by time
+func (s *scheduler) List(recipient string) []ScheduledMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var items []ScheduledMessage
+	for _, item := range s.items {
+		if item.Recipient == recipient {
+			items = append(items, *item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].At.Before(items[j].At) })
+	return items
+}
+
+// CountCreatedBy returns the number of messages scheduled by who
+func (s *scheduler) CountCreatedBy(who AgentSender) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, item := range s.items {
+		if item.createdBy(who) {
+			count++
+		}
+	}
+	return count
+}
+
+// CancelCreatedBy removes every message scheduled by who
+func (s *scheduler) CancelCreatedBy(who AgentSender) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, item := range s.items {
+		if item.createdBy(who) {
+			delete(s.items, id)
+		}
+	}
+	return s.save()
+}
+
+// Len returns the number of scheduled messages
+func (s *scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+// takeDue removes and returns the messages due at or before now
+func (s *scheduler) takeDue(now time.Time) ([]*ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*ScheduledMessage
+	for id, item := range s.items {
+		if !item.At.After(now) {
+			due = append(due, item)
+			delete(s.items, id)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].At.Before(due[j].At) })
+	if len(due) == 0 {
+		return nil, nil
+	}
+	return due, s.save()
+}
+
+// runScheduler delivers due messages until ctx is cancelled. While the
+// scheduler kill switch is on, due messages are held back, and messages due
+// during their creator's quiet hours wait until those end.
+func (bot *SignalBot) runScheduler(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if bot.switches.Disabled(switchScheduler) {
+				continue
+			}
+			now := time.Now()
+			due, err := bot.scheduler.takeDue(now)
+			if err != nil {
+				bot.logger.Printf("Error saving scheduled messages: %v", err)
+			}
+			for _, item := range due {
+				if until := bot.creatorQuietUntil(item, now); !until.IsZero() {
+					if err := bot.scheduler.postpone(item, until); err != nil {
+						bot.logger.Printf("Error saving scheduled messages: %v", err)
+					}
+					bot.logger.Printf("Postponed scheduled message %d to %s for quiet hours", item.ID, until.Format(time.RFC3339))
+					continue
+				}
+				if err := bot.sendReply(item.Recipient, item.Text, 0, ""); err != nil {
+					bot.logger.Printf("Error sending scheduled message %d: %v", item.ID, err)
+					continue
+				}
+				bot.logger.Printf("Sent scheduled message %d to %s", item.ID, item.Recipient)
+			}
+		}
+	}
+}
+
+// creatorQuietUntil returns when the quiet hours its creator set in its chat
+// end, or the zero time when they aren't in them
+func (bot *SignalBot) creatorQuietUntil(item *ScheduledMessage, now time.Time) time.Time {
+	if item.ChatID == "" {
+		return time.Time{}
+	}
+	creator := AgentSender{Number: item.CreatedBy, UUID: item.CreatorUUID}
+	return quietUntil(bot.userSetting(item.ChatID, &creator, "quiet"), now.In(bot.userLocation(item.ChatID, &creator)))
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "remind",
+		usage:   "!remind <when> <text> | !remind list | !remind cancel <id>",
+		handler: remindCommand,
+	})
+}
+
+// remindCommand schedules a reminder in the current chat
+func remindCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	usage := "Usage: " + commands["remind"].usage
+	if len(args) == 0 {
+		return usage
+	}
+
+	recipient := msg.replyRecipient()
+	sender := msg.sender()
+	loc := bot.userLocation(msg.chatID(), &sender)
+	switch strings.ToLower(args[0]) {
+	case "list":
+		items := bot.scheduler.List(recipient)
+		if len(items) == 0 {
+			return "No reminders scheduled."
+		}
+		lines := []string{"Scheduled reminders:"}
+		for _, item := range items {
+			lines = append(lines, fmt.Sprintf("#%d %s: %s", item.ID, formatReminderTime(item.At.In(loc)), item.Text))
+		}
+		return strings.Join(lines, "\n")
+	case "cancel":
+		if len(args) < 2 {
+			return usage
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+		if err != nil {
+			return "No such reminder."
+		}
+		item, exists := bot.scheduler.Get(id, recipient)
+		if !exists {
+			return "No such reminder."
+		}
+		if !item.createdBy(sender) && !bot.isAdmin(msg) {
+			return fmt.Sprintf("Only whoever set reminder #%d, or an admin, can cancel it.", id)
+		}
+		if _, err := bot.scheduler.Cancel(id, recipient); err != nil {
+			bot.logger.Printf("Error saving scheduled messages: %v", err)
+		}
+		return fmt.Sprintf("Reminder #%d cancelled.", id)
+	}
+
+	at, text, err := parseNaturalTime(strings.Join(args, " "), time.Now(), loc)
+	if err != nil {
+		return "Sorry, I couldn't understand when. Try \"!remind in 10 minutes stretch\" or \"!remind tomorrow at 9 call mum\"."
+	}
+	if text == "" {
+		return usage
+	}
+	if !at.After(time.Now()) {
+		return "That time is in the past."
+	}
+
+	if limit := bot.config.RemindersPerSender; limit > 0 && !bot.isAdmin(msg) && bot.scheduler.CountCreatedBy(sender) >= limit {
+		return fmt.Sprintf("You already have %d reminders pending, the most I'll keep. Cancel one first.", limit)
+	}
+
+	item, err := bot.scheduler.Add(at, recipient, "⏰ Reminder: "+text, sender, msg.chatID())
+	if err != nil {
+		bot.logger.Printf("Error saving scheduled messages: %v", err)
+	}
+	return fmt.Sprintf("Reminder #%d set for %s.", item.ID, formatReminderTime(at.In(loc)))
+}
+
+// formatReminderTime renders a reminder time for chat replies
+func formatReminderTime(t time.Time) string {
+	return t.Format("Mon 2 Jan 15:04")
+}
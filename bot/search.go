@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSearchResults is how many matches !search returns
+const maxSearchResults = 5
+
+// searchSnippetLength is about how many characters of each match are shown
+const searchSnippetLength = 160
+
+// minSearchSimilarity is the embedding similarity a message needs to match
+// a query without sharing any of its words, with SEARCH_EMBEDDINGS
+const minSearchSimilarity = 0.35
+
+// searchRecord is one searchable message of a chat
+type searchRecord struct {
+	Timestamp int64
+	Author    string // who wrote it, as shown in results
+	Text      string
+	score     float64
+}
+
+// searchRecords returns the messages !search looks through in a chat: its
+// archived messages and replies when the chat is archived, otherwise the
+// conversation history the bot keeps for it, from every thread
+func (bot *SignalBot) searchRecords(ctx context.Context, chatID string) []searchRecord {
+	if bot.archives(chatID) {
+		entries, err := bot.archive.Entries(ctx, chatID, time.Time{})
+		if err != nil {
+			bot.logger.Printf("Error reading the archive of %s: %v", chatID, err)
+		}
+		records := make([]searchRecord, 0, len(entries))
+		for _, entry := range entries {
+			author := entry.SenderName
+			switch {
+			case entry.Kind == archiveKindPrompt:
+				continue // already archived as the message that asked it
+			case entry.Kind == archiveKindReply:
+				author = "Bot"
+			case author == "":
+				author = entry.Sender
+			}
+			records = append(records, searchRecord{Timestamp: entry.Timestamp, Author: author, Text: entry.Text})
+		}
+		return records
+	}
+
+	turns := bot.history.Matching(func(id string) bool {
+		return id == chatID || strings.HasPrefix(id, chatID+":")
+	})
+	records := make([]searchRecord, 0, len(turns))
+	for _, turn := range turns {
+		author := "You"
+		switch turn.Role {
+		case "assistant":
+			author = "Bot"
+		case "system":
+			continue // summaries of compacted turns
+		}
+		records = append(records, searchRecord{Timestamp: turn.Timestamp, Author: author, Text: turn.Content})
+	}
+	return records
+}
+
+// searchMessages ranks records by how many of the query's words they
+// contain, newest first among equals. With SEARCH_EMBEDDINGS, messages are
+// also ranked by embedding similarity, so related wording matches too.
+func (bot *SignalBot) searchMessages(ctx context.Context, query string, records []searchRecord) []searchRecord {
+	terms := embeddingWords(query)
+	var matches []searchRecord
+	for _, record := range records {
+		words := make(map[string]bool)
+		for _, word := range embeddingWords(record.Text) {
+			words[word] = true
+		}
+		matched := 0
+		for _, term := range terms {
+			if words[term] {
+				matched++
+			}
+		}
+		if matched > 0 || bot.config.SearchEmbeddings {
+			record.score = float64(matched)
+			matches = append(matches, record)
+		}
+	}
+
+	if bot.config.SearchEmbeddings && len(matches) > 0 {
+		texts := make([]string, 0, len(matches)+1)
+		texts = append(texts, query)
+		for _, match := range matches {
+			texts = append(texts, match.Text)
+		}
+		vectors, err := bot.embedder.Embed(ctx, texts)
+		if err != nil {
+			bot.logger.Printf("Error embedding search query: %v", err)
+		}
+		kept := matches[:0]
+		for i, match := range matches {
+			similarity := 0.0
+			if err == nil {
+				similarity = cosine(vectors[0], vectors[i+1])
+			}
+			if match.score == 0 && similarity < minSearchSimilarity {
+				continue
+			}
+			match.score += similarity
+			kept = append(kept, match)
+		}
+		matches = kept
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].Timestamp > matches[j].Timestamp
+	})
+	if len(matches) > maxSearchResults {
+		matches = matches[:maxSearchResults]
+	}
+	return matches
+}
+
+// searchSnippet cuts text down to the part around the first query word it
+// contains
+func searchSnippet(text, query string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= searchSnippetLength {
+		return text
+	}
+
+	start := 0
+	lower := strings.ToLower(text)
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		if i := strings.Index(lower, term); i >= 0 {
+			start = len([]rune(text[:i])) - searchSnippetLength/4
+			break
+		}
+	}
+	start = max(0, min(start, len(runes)-searchSnippetLength))
+	snippet := string(runes[start : start+searchSnippetLength])
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if start+searchSnippetLength < len(runes) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "search",
+		usage:   "!search <query>",
+		handler: searchCommand,
+	})
+}
+
+// searchCommand finds earlier messages of this chat matching a query
+func searchCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	if len(args) == 0 {
+		return "Usage: " + commands["search"].usage
+	}
+	chatID := msg.chatID()
+	if chatID == "" {
+		return "Sorry, I can't tell which chat this is."
+	}
+
+	query := strings.Join(args, " ")
+	matches := bot.searchMessages(ctx, query, bot.searchRecords(ctx, chatID))
+	if len(matches) == 0 {
+		return fmt.Sprintf("Nothing found for %q.", query)
+	}
+
+	sender := msg.sender()
+	loc := bot.userLocation(chatID, &sender)
+	lines := []string{fmt.Sprintf("🔎 %q:", query)}
+	for _, match := range matches {
+		when := time.UnixMilli(match.Timestamp).In(loc).Format("2 Jan 2006 15:04")
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", when, match.Author, searchSnippet(match.Text, query)))
+	}
+	return strings.Join(lines, "\n")
+}
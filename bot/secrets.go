@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// secretErrors collects the secrets that couldn't be read while building
+// the configuration, reported by checkSettings
+var secretErrors []error
+
+// vaultCache holds the Vault secrets read while building the configuration,
+// so settings sharing a secret fetch it once
+var vaultCache = map[string]map[string]any{}
+
+// beginSettingsLoad starts building a configuration: secrets are read
+// afresh, so a reload picks up rotated ones
+func beginSettingsLoad() {
+	secretErrors = nil
+	vaultCache = map[string]map[string]any{}
+	// Vault's own settings are only read when something refers to Vault
+	for _, key := range []string{"VAULT_ADDR", "VAULT_TOKEN"} {
+		settingsLookedUp[key], settingsLookedUp[key+"_FILE"] = true, true
+	}
+}
+
+// lookupSetting returns a setting from the environment, falling back to
+// the config file. KEY_FILE names a file holding the value (Docker and
+// Kubernetes secrets), and a "vault:<path>#<field>" value is read from
+// Vault.
+func lookupSetting(key string) (string, bool) {
+	val, exists := lookupPlainSetting(key)
+	if !exists {
+		return "", false
+	}
+	if ref, isVault := strings.CutPrefix(val, "vault:"); isVault {
+		secret, err := readVaultSecret(ref)
+		if err != nil {
+			secretErrors = append(secretErrors, fmt.Errorf("%s: %w", key, err))
+			return "", false
+		}
+		return secret, true
+	}
+	return val, true
+}
+
+// lookupPlainSetting is lookupSetting without Vault references
+func lookupPlainSetting(key string) (string, bool) {
+	settingsLookedUp[key] = true
+	settingsLookedUp[key+"_FILE"] = true
+	if val, exists := os.LookupEnv(key); exists {
+		return val, true
+	}
+	if val, exists := configFileSettings[key]; exists {
+		return val, true
+	}
+
+	path, exists := os.LookupEnv(key + "_FILE")
+	if !exists {
+		path, exists = configFileSettings[key+"_FILE"]
+	}
+	if !exists || path == "" {
+		return "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		secretErrors = append(secretErrors, fmt.Errorf("%s_FILE: %w", key, err))
+		return "", false
+	}
+	return strings.TrimRight(string(data), "\r\n"), true
+}
+
+// readVaultSecret reads field of the secret at path from VAULT_ADDR with
+// VAULT_TOKEN, from KV version 1 or 2 engines
+func readVaultSecret(ref string) (string, error) {
+	path, field, found := strings.Cut(ref, "#")
+	if !found || path == "" || field == "" {
+		return "", fmt.Errorf("vault reference %q should look like vault:<path>#<field>", ref)
+	}
+
+	data, cached := vaultCache[path]
+	if !cached {
+		var err error
+		if data, err = fetchVaultSecret(path); err != nil {
+			return "", err
+		}
+		vaultCache[path] = data
+	}
+
+	value, exists := data[field]
+	if !exists {
+		return "", fmt.Errorf("vault secret %s has no field %q", path, field)
+	}
+	s, isString := value.(string)
+	if !isString {
+		return "", fmt.Errorf("vault secret %s field %q isn't a string", path, field)
+	}
+	return s, nil
+}
+
+// fetchVaultSecret GETs a secret from Vault's HTTP API
+func fetchVaultSecret(path string) (map[string]any, error) {
+	addr, _ := lookupPlainSetting("VAULT_ADDR")
+	token, _ := lookupPlainSetting("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return nil, fmt.Errorf("vault references need VAULT_ADDR and VAULT_TOKEN")
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned status %d for %s", resp.StatusCode, path)
+	}
+
+	var result struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode vault secret: %w", err)
+	}
+	// KV version 2 nests the secret's fields in data.data
+	if inner, isMap := result.Data["data"].(map[string]any); isMap {
+		if _, hasMetadata := result.Data["metadata"]; hasMetadata {
+			return inner, nil
+		}
+	}
+	return result.Data, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// fifoSemaphore limits concurrency to a fixed number of slots and hands
+// freed slots to waiters in arrival order. A limit of zero or less means
+// unlimited.
+type fifoSemaphore struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	waiters *list.List // of chan struct{}, front = longest waiting
+}
+
+func newFIFOSemaphore(limit int) *fifoSemaphore {
+	return &fifoSemaphore{limit: limit, waiters: list.New()}
+}
+
+// Acquire blocks until a slot is free or ctx is done
+func (s *fifoSemaphore) Acquire(ctx context.Context) error {
+	return s.AcquireQueued(ctx, nil)
+}
+
+// AcquireQueued is Acquire, calling queued with the caller's 1-based place
+// in line when it has to wait
+func (s *fifoSemaphore) AcquireQueued(ctx context.Context, queued func(position int)) error {
+	s.mu.Lock()
+	if s.limit <= 0 || (s.active < s.limit && s.waiters.Len() == 0) {
+		s.active++
+		s.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	elem := s.waiters.PushBack(ready)
+	position := s.waiters.Len()
+	s.mu.Unlock()
+	if queued != nil {
+		queued(position)
+	}
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-ready:
+			// Granted while giving up: pass the slot on
+			s.mu.Unlock()
+			s.Release()
+		default:
+			s.waiters.Remove(elem)
+			s.mu.Unlock()
+		}
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot, handing it straight to the next waiter if any
+func (s *fifoSemaphore) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if front := s.waiters.Front(); front != nil {
+		s.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	s.active--
+}
+
+// Waiting returns the number of callers queued for a slot
+func (s *fifoSemaphore) Waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.waiters.Len()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of signal-cli send failures that need more than a retry
+const (
+	failureRateLimit         = "rate-limit"         // Signal refused sends for a while
+	failureProofRequired     = "proof-required"     // Signal wants a captcha solved first
+	failureUntrustedIdentity = "untrusted-identity" // a recipient's safety number changed
+)
+
+var (
+	// errSendsPaused is returned for sends attempted during a rate-limit pause
+	errSendsPaused = errors.New("sends paused after a Signal rate limit")
+	// errUntrustedIdentity marks sends refused until a changed identity
+	// key is trusted
+	errUntrustedIdentity = errors.New("untrusted identity")
+)
+
+// identityPattern finds the number or UUID of the contact whose identity
+// signal-cli doesn't trust
+var identityPattern = regexp.MustCompile(`\+[0-9]{6,15}|[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// challengeTokenPattern finds the challenge token in a proof-required error
+var challengeTokenPattern = regexp.MustCompile(`(?i)token[=: ]+"?([0-9a-f-]{8,})`)
+
+// captchaURL is where the owner solves a proof-required challenge
+const captchaURL = "https://signalcaptchas.org/challenge/generate.html"
+
+// classifySendFailure recognizes rate-limit and proof-required failures in
+// signal-cli's error output, returning "" for other failures
+func classifySendFailure(stderr string) string {
+	lower := strings.ToLower(stderr)
+	switch {
+	case strings.Contains(lower, "untrusted identity") || strings.Contains(lower, "untrustedidentity"):
+		return failureUntrustedIdentity
+	case strings.Contains(lower, "proofrequired") || strings.Contains(lower, "proof required"):
+		return failureProofRequired
+	case strings.Contains(lower, "ratelimit") || strings.Contains(lower, "rate limit") || strings.Contains(lower, "[429]"):
+		return failureRateLimit
+	}
+	return ""
+}
+
+// sendPause holds back every send after Signal rate-limits the account,
+// so retries don't dig the hole deeper
+type sendPause struct {
+	mu        sync.Mutex
+	until     time.Time
+	reason    string
+	challenge string // token of the proof-required challenge to solve, if any
+}
+
+// Start pauses sends until until, reporting whether they were running
+func (p *sendPause) Start(until time.Time, reason string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	started := !time.Now().Before(p.until)
+	if until.After(p.until) {
+		p.until, p.reason = until, reason
+	}
+	return started
+}
+
+// Lift ends the pause early, once its challenge was solved
+func (p *sendPause) Lift() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.until, p.reason, p.challenge = time.Time{}, "", ""
+}
+
+// SetChallenge records the token of the challenge blocking sends
+func (p *sendPause) SetChallenge(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.challenge = token
+}
+
+// Challenge returns the token of the last proof-required challenge, "" if
+// there is none
+func (p *sendPause) Challenge() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.challenge
+}
+
+// Until returns when the current pause ends, or the zero time if sends
+// are running
+func (p *sendPause) Until(now time.Time) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Before(p.until) {
+		return p.until
+	}
+	return time.Time{}
+}
+
+// String summarizes the pause for !status, "" when sends are running
+func (p *sendPause) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !time.Now().Before(p.until) {
+		return ""
+	}
+	return fmt.Sprintf("paused until %s (%s)", p.until.Format("15:04:05"), p.reason)
+}
+
+// checkSendsPaused returns errSendsPaused while a rate-limit pause lasts
+func (bot *SignalBot) checkSendsPaused() error {
+	if until := bot.sendPause.Until(time.Now()); !until.IsZero() {
+		return fmt.Errorf("%w until %s", errSendsPaused, until.Format("15:04:05"))
+	}
+	return nil
+}
+
+// runSend runs a signal-cli send to recipient, handling the failures that
+// need more than a retry: rate limits pause all sends, and an untrusted
+// identity is trusted and the send retried once under the always trust
+// policy, or reported as errUntrustedIdentity. It returns signal-cli's error
+// output with the error.
+func (bot *SignalBot) runSend(recipient string, stdout io.Writer, args ...string) (string, error) {
+	for attempt := 0; ; attempt++ {
+		var stderr bytes.Buffer
+		err := runSignalCLI(context.Background(), bot.config.SignalSendTimeout, stdout, &stderr, bot.trustArgs(args...)...)
+		if err == nil {
+			return "", nil
+		}
+
+		switch classifySendFailure(stderr.String()) {
+		case failureRateLimit, failureProofRequired:
+			bot.pauseSends(stderr.String())
+		case failureUntrustedIdentity:
+			identity := untrustedIdentity(recipient, stderr.String())
+			if identity == "" {
+				identity = "a group member"
+			} else if attempt == 0 && bot.config.SignalTrustPolicy == trustAlways && bot.trustIdentity(identity) {
+				continue
+			}
+			err = fmt.Errorf("%w of %s: %v", errUntrustedIdentity, identity, err)
+		}
+		return stderr.String(), err
+	}
+}
+
+// untrustedIdentity returns the contact named in an untrusted-identity
+// failure, or the recipient of a DM; "" when it is an unnamed group member
+func untrustedIdentity(recipient, stderr string) string {
+	if identity := identityPattern.FindString(stderr); identity != "" {
+		return identity
+	}
+	if strings.HasPrefix(recipient, "-g ") {
+		return ""
+	}
+	return recipient
+}
+
+// trustIdentity trusts every known identity key of a contact, reporting
+// whether signal-cli did
+func (bot *SignalBot) trustIdentity(identity string) bool {
+	var stderr bytes.Buffer
+	if err := runSignalCLI(context.Background(), bot.config.SignalSendTimeout, nil, &stderr, "trust", "-a", identity); err != nil {
+		bot.logger.Printf("Error trusting the new identity of %s: %v (stderr: %s)", identity, err, stderr.String())
+		return false
+	}
+	bot.logger.Printf("Trusted the new identity key of %s", identity)
+	return true
+}
+
+// parkUntrusted tells the owner about a send held in the outbox until an
+// identity is trusted
+func (bot *SignalBot) parkUntrusted(id int64, sendErr error) {
+	bot.logger.Printf("Parked message %d in the outbox: %v", id, sendErr)
+	bot.notifyAdmin(adminEventIdentity, fmt.Sprintf("⚠️ Message #%d wasn't sent: %v. The contact's safety number changed; once you've verified it, run "+
+		"\"signal-cli trust -a <number>\" and \"!admin outbox retry %d\".", id, sendErr, id))
+}
+
+// pauseSends pauses all sends for SEND_RATE_LIMIT_COOLDOWN after a send was
+// rate limited, telling the owner how to get going again
+func (bot *SignalBot) pauseSends(stderr string) {
+	kind := classifySendFailure(stderr)
+	cooldown := bot.config.SendRateLimitCooldown
+	if !bot.sendPause.Start(time.Now().Add(cooldown), kind) {
+		return
+	}
+	bot.logger.Printf("Signal refused a send (%s), pausing all sends for %s", kind, cooldown)
+
+	if kind == failureProofRequired {
+		token := "<token>"
+		if m := challengeTokenPattern.FindStringSubmatch(stderr); m != nil {
+			token = m[1]
+			bot.sendPause.SetChallenge(token)
+		}
+		bot.deliverChallenge(fmt.Sprintf("⚠️ Signal wants a captcha solved before this account can send again. I've paused sending for %s.\n"+
+			"Solve it at %s, copy the signalcaptcha:// link and send \"!admin resolve-challenge <link>\" from your phone, or run:\n"+
+			"signal-cli submitRateLimitChallenge --challenge %s --captcha <link>", cooldown, captchaURL, token), token)
+		return
+	}
+	bot.notifyAdmin(adminEventRateLimit, fmt.Sprintf("⚠️ Signal is rate limiting this account. I've paused sending for %s; queued replies go out after that.", cooldown))
+}
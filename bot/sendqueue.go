@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sendQueueKey is the state value the send queue is persisted under
+const sendQueueKey = "outbox"
+
+// maxSendRetryDelay caps the backoff between attempts of a queued send
+const maxSendRetryDelay = time.Hour
+
+// queuedSend is a message signal-cli failed to send, waiting for a retry.
+// After OUTBOX_MAX_ATTEMPTS failures it is dead: kept for the owner to
+// inspect with "!admin outbox" but no longer retried.
+type queuedSend struct {
+	ID             int64     `json:"id"`
+	Recipient      string    `json:"recipient"`
+	Text           string    `json:"text"`
+	QuoteTimestamp int64     `json:"quote_timestamp,omitempty"`
+	QuoteAuthor    string    `json:"quote_author,omitempty"`
+	QueuedAt       time.Time `json:"queued_at"`
+	Attempts       int       `json:"attempts"`
+	NextAttempt    time.Time `json:"next_attempt"`
+	LastError      string    `json:"last_error,omitempty"`
+	Dead           bool      `json:"dead,omitempty"`
+}
+
+// sendQueue is the outbox of failed sends, persisted in the state store so
+// queued replies survive a restart
+type sendQueue struct {
+	mu     sync.Mutex
+	store  stateStore // nil until loaded
+	items  []*queuedSend
+	nextID int64
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{nextID: 1}
+}
+
+// Load reads the queue from store, which later changes are saved to
+func (q *sendQueue) Load(store stateStore) error {
+	value, err := store.Get(sendQueueKey)
+	if err != nil {
+		return err
+	}
+	var items []*queuedSend
+	if value != "" {
+		if err := json.Unmarshal([]byte(value), &items); err != nil {
+			return fmt.Errorf("failed to parse outbox: %w", err)
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.store = store
+	q.items = items
+	for _, item := range items {
+		q.nextID = max(q.nextID, item.ID+1)
+	}
+	return nil
+}
+
+// Add queues a send for its first retry at next, returning its ID
+func (q *sendQueue) Add(item queuedSend, next time.Time) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item.ID = q.nextID
+	q.nextID++
+	item.QueuedAt = time.Now()
+	item.NextAttempt = next
+	q.items = append(q.items, &item)
+	return item.ID, q.save()
+}
+
+// Due returns copies of the live sends whose retry is due, oldest first
+func (q *sendQueue) Due(now time.Time) []queuedSend {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var due []queuedSend
+	for _, item := range q.items {
+		if !item.Dead && !item.NextAttempt.After(now) {
+			due = append(due, *item)
+		}
+	}
+	return due
+}
+
+// Done removes a send, reporting whether it was queued
+func (q *sendQueue) Done(id int64) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, item := range q.items {
+		if item.ID == id {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return true, q.save()
+		}
+	}
+	return false, nil
+}
+
+// Failed records a failed attempt, scheduling the next one after next or,
+// once maxAttempts were made, marking the send dead. It reports whether the
+// send died.
+func (q *sendQueue) Failed(id int64, sendErr error, maxAttempts int, next time.Time) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, item := range q.items {
+		if item.ID == id {
+			item.Attempts++
+			item.LastError = sendErr.Error()
+			item.NextAttempt = next
+			item.Dead = item.Attempts >= maxAttempts
+			return item.Dead, q.save()
+		}
+	}
+	return false, nil
+}
+
+// Revive gives a dead send another round of attempts, starting now
+func (q *sendQueue) Revive(id int64) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, item := range q.items {
+		if item.ID == id {
+			item.Dead = false
+			item.Attempts = 0
+			item.NextAttempt = time.Now()
+			return true, q.save()
+		}
+	}
+	return false, nil
+}
+
+// List returns copies of every queued send, live and dead, oldest first
+func (q *sendQueue) List() []queuedSend {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := make([]queuedSend, len(q.items))
+	for i, item := range q.items {
+		items[i] = *item
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items
+}
+
+// Len returns how many sends are waiting for a retry and how many are dead
+func (q *sendQueue) Len() (live, dead int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, item := range q.items {
+		if item.Dead {
+			dead++
+		} else {
+			live++
+		}
+	}
+	return live, dead
+}
+
+// save persists the queue; callers must hold q.mu
+func (q *sendQueue) save() error {
+	if q.store == nil {
+		return nil
+	}
+	value := ""
+	if len(q.items) > 0 {
+		data, err := json.Marshal(q.items)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	return q.store.Set(sendQueueKey, value)
+}
+
+// sendRetryDelay is how long to wait before attempt n+1 of a queued send
+func (bot *SignalBot) sendRetryDelay(attempts int) time.Duration {
+	return min(backoffDelay(bot.config.OutboxRetryBackoff, min(attempts+1, 16)), maxSendRetryDelay)
+}
+
+// queueSend puts a message signal-cli failed to send into the outbox. It
+// returns sendErr annotated with what happened to the message. Sends to an
+// untrusted identity are parked: kept, but not retried until the owner
+// says so.
+func (bot *SignalBot) queueSend(recipient, text string, quoteMsgId int64, quoteAuthor string, sendErr error) error {
+	if bot.config.OutboxMaxAttempts <= 0 {
+		return sendErr
+	}
+	untrusted := errors.Is(sendErr, errUntrustedIdentity)
+	item := queuedSend{Recipient: recipient, Text: text, QuoteTimestamp: quoteMsgId, QuoteAuthor: quoteAuthor, LastError: sendErr.Error(), Dead: untrusted}
+	id, err := bot.sendQueue.Add(item, time.Now().Add(bot.sendRetryDelay(0)))
+	if err != nil {
+		bot.logger.Printf("Error saving outbox: %v", err)
+	}
+	if untrusted {
+		bot.parkUntrusted(id, sendErr)
+		return fmt.Errorf("%w (parked in the outbox)", sendErr)
+	}
+	return fmt.Errorf("%w (queued for retry)", sendErr)
+}
+
+// runSendQueue retries queued sends as they come due
+func (bot *SignalBot) runSendQueue(ctx context.Context) error {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			if !bot.sendPause.Until(now).IsZero() {
+				continue // retrying would only extend the rate limit
+			}
+			for _, item := range bot.sendQueue.Due(now) {
+				bot.retrySend(item)
+			}
+		}
+	}
+}
+
+// retrySend makes one more attempt at a queued send
+func (bot *SignalBot) retrySend(item queuedSend) {
+	err := bot.deliverReply(item.Recipient, item.Text, item.QuoteTimestamp, item.QuoteAuthor)
+	if err == nil {
+		if _, err := bot.sendQueue.Done(item.ID); err != nil {
+			bot.logger.Printf("Error saving outbox: %v", err)
+		}
+		bot.logger.Printf("Sent queued message %d to %s after %d retries", item.ID, item.Recipient, item.Attempts+1)
+		return
+	}
+
+	maxAttempts := bot.config.OutboxMaxAttempts
+	if errors.Is(err, errUntrustedIdentity) {
+		maxAttempts = 0 // park it again
+	}
+	dead, saveErr := bot.sendQueue.Failed(item.ID, err, maxAttempts, time.Now().Add(bot.sendRetryDelay(item.Attempts+1)))
+	if saveErr != nil {
+		bot.logger.Printf("Error saving outbox: %v", saveErr)
+	}
+	if maxAttempts == 0 {
+		bot.parkUntrusted(item.ID, err)
+	} else if dead {
+		bot.logger.Printf("Giving up on queued message %d to %s after %d attempts: %v", item.ID, item.Recipient, item.Attempts+1, err)
+	}
+}
+
+// outboxStatus summarizes the outbox for !status
+func (bot *SignalBot) outboxStatus() string {
+	live, dead := bot.sendQueue.Len()
+	status := fmt.Sprintf("%d queued, %d dead", live, dead)
+	if pause := bot.sendPause.String(); pause != "" {
+		status += ", sends " + pause
+	}
+	return status
+}
+
+// outboxCommand lists the outbox and retries or drops its sends; it backs
+// "!admin outbox"
+func outboxCommand(bot *SignalBot, args []string) string {
+	usage := "Usage: !admin outbox | !admin outbox retry <id> | !admin outbox drop <id>"
+	if len(args) == 0 {
+		items := bot.sendQueue.List()
+		if len(items) == 0 {
+			return "The outbox is empty."
+		}
+		lines := []string{"Outbox:"}
+		for _, item := range items {
+			state := fmt.Sprintf("next try %s", item.NextAttempt.Format("15:04:05"))
+			if item.Dead {
+				state = "dead"
+			}
+			lines = append(lines, fmt.Sprintf("#%d to %s, %d attempts, %s: %s (%s)", item.ID, item.Recipient, item.Attempts, state, preview(item.Text, 40), item.LastError))
+		}
+		return strings.Join(lines, "\n")
+	}
+	if len(args) < 2 {
+		return usage
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(args[1], "#"), 10, 64)
+	if err != nil {
+		return usage
+	}
+	var found bool
+	switch strings.ToLower(args[0]) {
+	case "retry":
+		found, err = bot.sendQueue.Revive(id)
+	case "drop":
+		found, err = bot.sendQueue.Done(id)
+	default:
+		return usage
+	}
+	if err != nil {
+		bot.logger.Printf("Error saving outbox: %v", err)
+		return "Sorry, I couldn't update the outbox."
+	}
+	if !found {
+		return fmt.Sprintf("No queued message #%d.", id)
+	}
+	if strings.EqualFold(args[0], "retry") {
+		return fmt.Sprintf("Retrying message #%d.", id)
+	}
+	return fmt.Sprintf("Dropped message #%d.", id)
+}
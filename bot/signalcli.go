@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// signalKillDelay is how long signal-cli gets to exit after an interrupt
+// before it is killed
+const signalKillDelay = 5 * time.Second
+
+// runSignalCLI runs signal-cli with args until it exits, ctx ends or timeout
+// passes. When stopped early, signal-cli is interrupted so the JVM can
+// release the account cleanly, and killed if it hasn't exited within
+// signalKillDelay. stdout and stderr may be nil.
+func runSignalCLI(ctx context.Context, timeout time.Duration, stdout, stderr io.Writer, args ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "signal-cli", args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = signalKillDelay
+
+	err := cmd.Run()
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("signal-cli timed out after %s: %w", timeout, err)
+	}
+	return err
+}
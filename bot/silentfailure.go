@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SILENT_FAILURES modes
+const (
+	silentFailuresOff    = "off"    // apologize in the chat
+	silentFailuresGroups = "groups" // react in groups, apologize in DMs
+	silentFailuresAll    = "all"    // react everywhere
+)
+
+// failureReaction marks a prompt the agent couldn't answer
+const failureReaction = "❌"
+
+// failsSilently reports whether a failed answer to request should be a
+// reaction instead of an apology
+func (bot *SignalBot) failsSilently(request AgentRequest) bool {
+	switch bot.config.SilentFailures {
+	case silentFailuresAll:
+		return true
+	case silentFailuresGroups:
+		return request.Chat != nil && request.Chat.GroupID != ""
+	default:
+		return false
+	}
+}
+
+// reportFailure reacts to the prompt with failureReaction and sends the
+// full error to ADMIN_NOTIFY, in place of an apology in the chat
+func (bot *SignalBot) reportFailure(request AgentRequest, target replyTarget, err error) {
+	if target.QuoteTimestamp != 0 {
+		if err := bot.sendReaction(target.Recipient, failureReaction, target.QuoteAuthor, target.QuoteTimestamp); err != nil {
+			bot.logger.Printf("Error reacting to failed prompt: %v", err)
+		}
+	}
+
+	asker := "someone"
+	if request.Sender != nil {
+		asker = quotaID(request.Sender)
+	}
+	prompt := request.Prompt
+	if len(prompt) > 200 {
+		prompt = strings.ToValidUTF8(prompt[:200], "") + "…"
+	}
+	bot.notifyAdmin(adminEventErrors, fmt.Sprintf("❌ Couldn't answer %s in %s: %v\nPrompt: %s", asker, requestChatID(request), err, prompt))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StateSnapshot is the JSON document written on shutdown or SIGQUIT
+type StateSnapshot struct {
+	DumpedAt        time.Time                `json:"dumped_at"`
+	QueueDepths     map[string]int           `json:"queue_depths"`
+	PendingMessages []*PendingMessage        `json:"pending_messages"`
+	InFlightCalls   int64                    `json:"in_flight_calls"`
+	CircuitBreakers map[string]string        `json:"circuit_breakers"`
+	SendThrottling  map[string]throttleStats `json:"send_throttling,omitempty"`
+	Restarts        map[string]int           `json:"restarts,omitempty"`
+}
+
+// snapshotState captures the bot's current runtime state
+func (bot *SignalBot) snapshotState() StateSnapshot {
+	bot.pendingMu.Lock()
+	pending := make([]*PendingMessage, 0, len(bot.pendingMessages))
+	for _, p := range bot.pendingMessages {
+		copied := *p
+		pending = append(pending, &copied)
+	}
+	bot.pendingMu.Unlock()
+	live, dead := bot.sendQueue.Len()
+
+	return StateSnapshot{
+		DumpedAt: time.Now(),
+		QueueDepths: map[string]int{
+			"pending_dm": len(pending),
+			"scheduled":  bot.scheduler.Len(),
+			"cached":     bot.cache.Len(),
+			"coalescing": bot.inflight.Len(),
+			"agent_wait": bot.agentSlots.Waiting(),
+			"busy_chats": bot.chatQueues.Len(),
+			"outbox":     live,
+			"dead_sends": dead,
+			"work_queue": bot.work.Len(),
+		},
+		PendingMessages: pending,
+		InFlightCalls:   bot.inFlight.Load(),
+		CircuitBreakers: map[string]string{
+			"agent": bot.breaker.State(),
+		},
+		SendThrottling: bot.outbox.Stats(),
+		Restarts:       bot.supervisor.Restarts(),
+	}
+}
+
+// dumpState writes the current state snapshot to path atomically
+func (bot *SignalBot) dumpState(path string) error {
+	data, err := json.MarshalIndent(bot.snapshotState(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data via a temporary file and rename,
+// so readers never observe a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// dumpStateToConfiguredFile dumps state to STATE_FILE if one is configured
+func (bot *SignalBot) dumpStateToConfiguredFile() {
+	if bot.config.StateFile == "" {
+		return
+	}
+	if err := bot.dumpState(bot.config.StateFile); err != nil {
+		bot.logger.Printf("Error dumping state: %v", err)
+		return
+	}
+	bot.logger.Printf("State dumped to %s", bot.config.StateFile)
+}
+
+// loadState restores pending messages from a previous state dump. Entries
+// that would already have expired are skipped.
+func (bot *SignalBot) loadState(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var snapshot StateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	cutoff := time.Now().Add(-pendingExpiry)
+	restored := 0
+	for _, pending := range snapshot.PendingMessages {
+		if pending == nil || pending.SentTime.Before(cutoff) {
+			continue
+		}
+		bot.addPending(pending)
+		restored++
+	}
+
+	bot.logger.Printf("Restored %d pending messages from state dumped at %s", restored, snapshot.DumpedAt.Format(time.RFC3339))
+	return nil
+}
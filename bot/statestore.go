@@ -0,0 +1,556 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pendingExpiry is how long a DM prompt waits for its delivery receipt
+const pendingExpiry = 5 * time.Minute
+
+// processedKeyRetention is how long processed-message keys are remembered;
+// signal-cli doesn't deliver an envelope again after this long
+const processedKeyRetention = 24 * time.Hour
+
+// Files of the JSON store in the data directory
+const (
+	historyFile     = "history.json"
+	stateValuesFile = "state_values.json"
+	pendingFile     = "pending.json"
+	processedFile   = "processed.json"
+)
+
+// stateStore persists the bot's runtime state: DM prompts awaiting their
+// delivery receipt, processed-message keys, conversation history, chat
+// settings and small state values. Bot logic only goes through this
+// interface, so backends are interchangeable; they are selected with
+// STATE_STORE.
+type stateStore interface {
+	Open(ctx context.Context) error
+	// PutPending stores a DM prompt awaiting its delivery receipt
+	PutPending(pending *PendingMessage) error
+	// DeletePending removes the pending message sent at timestamp
+	DeletePending(timestamp int64) error
+	// Pending returns every stored pending message
+	Pending() ([]*PendingMessage, error)
+	// MarkProcessed records a message key and reports whether it was new
+	MarkProcessed(key string, at time.Time) (bool, error)
+	// UnmarkProcessed forgets a message key again
+	UnmarkProcessed(key string) error
+	// PruneProcessed forgets message keys recorded before cutoff
+	PruneProcessed(cutoff time.Time) error
+	// Conversations returns the stored turns of every conversation
+	Conversations() (map[string][]AgentTurn, error)
+	// SaveConversation replaces a conversation's turns; none removes it
+	SaveConversation(id string, turns []AgentTurn) error
+	// Settings returns the values stored in a settings namespace by ID
+	Settings(namespace string) (map[string]map[string]string, error)
+	// SaveSettings replaces one ID's values in a namespace; none removes them
+	SaveSettings(namespace, id string, values map[string]string) error
+	// Get returns a small state value, or "" when unset
+	Get(key string) (string, error)
+	Set(key, value string) error
+	Close() error
+}
+
+// stateStores maps STATE_STORE values to store constructors. The JSON and
+// memory stores are always available; others register from build-tagged
+// files.
+var stateStores = map[string]func(bot *SignalBot) (stateStore, error){
+	"json": func(bot *SignalBot) (stateStore, error) {
+		return &jsonStore{memoryStore: newMemoryStore(), dir: bot.config.DataDir}, nil
+	},
+	"memory": func(bot *SignalBot) (stateStore, error) {
+		return newMemoryStore(), nil
+	},
+}
+
+// registerStateStore makes a state backend selectable with STATE_STORE
+func registerStateStore(name string, open func(bot *SignalBot) (stateStore, error)) {
+	stateStores[name] = open
+}
+
+// openStateStore creates and opens the store selected by STATE_STORE
+func (bot *SignalBot) openStateStore(ctx context.Context) (stateStore, error) {
+	open, exists := stateStores[bot.config.StateStore]
+	if !exists {
+		names := make([]string, 0, len(stateStores))
+		for name := range stateStores {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown STATE_STORE %q (available in this build: %s)", bot.config.StateStore, strings.Join(names, ", "))
+	}
+	store, err := open(bot)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Open(ctx); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// restorePending loads the pending messages a previous run stored, dropping
+// those whose delivery receipt would already have expired
+func (bot *SignalBot) restorePending() error {
+	pending, err := bot.state.Pending()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-pendingExpiry)
+	restored := 0
+	for _, p := range pending {
+		if p.SentTime.Before(cutoff) {
+			if err := bot.state.DeletePending(p.Timestamp); err != nil {
+				bot.logger.Printf("Error removing expired pending message: %v", err)
+			}
+			continue
+		}
+		bot.pendingMu.Lock()
+		bot.pendingMessages[p.Timestamp] = p
+		bot.pendingMu.Unlock()
+		restored++
+	}
+	if restored > 0 {
+		bot.logger.Printf("Restored %d pending messages from %s state", restored, bot.config.StateStore)
+	}
+	return nil
+}
+
+// dedupKey identifies a message across restarts: its sender and envelope
+// timestamp. Receipts have none, as handling one twice is harmless.
+func (msg *Message) dedupKey() string {
+	if msg.Envelope.IsReceipt || len(msg.Envelope.ReceiptMessage.Timestamps) > 0 || msg.Envelope.Timestamp == 0 {
+		return ""
+	}
+	source := msg.Envelope.SourceUuid
+	if source == "" {
+		source = msg.Envelope.Source
+	}
+	return source + ":" + strconv.FormatInt(msg.Envelope.Timestamp, 10)
+}
+
+// alreadyProcessed reports whether a message was handled before, recording
+// it otherwise. Messages at or below their sender's high-water mark were
+// handled however long ago; newer ones are checked by key, which also
+// catches an envelope delivered twice while it's being processed.
+func (bot *SignalBot) alreadyProcessed(msg *Message) bool {
+	key := msg.dedupKey()
+	if key == "" {
+		return false
+	}
+	if msg.Envelope.Timestamp <= bot.highWater(msg) {
+		return true
+	}
+	isNew, err := bot.state.MarkProcessed(key, time.Now())
+	if err != nil {
+		bot.logger.Printf("Error recording processed message: %v", err)
+		return false
+	}
+	return !isNew
+}
+
+// replyKey is the idempotency key of the answer to a prompt: the prompt's
+// timestamp and where the answer goes. Requests without a timestamp, such
+// as scheduled prompts, have none.
+func replyKey(request AgentRequest, target replyTarget) string {
+	if request.Timestamp == 0 {
+		return ""
+	}
+	return "reply:" + target.Recipient + ":" + strconv.FormatInt(request.Timestamp, 10)
+}
+
+// claimReply records that a prompt's answer is about to be sent, reporting
+// false when it already was, so retries, restarts and duplicate sync and
+// data envelopes never answer the same prompt twice. It is claimed only
+// once the answer is ready, so a prompt interrupted earlier is answered
+// when its envelope is processed again.
+func (bot *SignalBot) claimReply(request AgentRequest, target replyTarget) bool {
+	key := replyKey(request, target)
+	if key == "" {
+		return true
+	}
+	isNew, err := bot.state.MarkProcessed(key, time.Now())
+	if err != nil {
+		bot.logger.Printf("Error recording reply key: %v", err)
+		return true
+	}
+	return isNew
+}
+
+// releaseReply forgets the claim of a prompt whose answer turned out to be
+// empty, so reprocessing it may still answer
+func (bot *SignalBot) releaseReply(request AgentRequest, target replyTarget) {
+	if key := replyKey(request, target); key != "" {
+		if err := bot.state.UnmarkProcessed(key); err != nil {
+			bot.logger.Printf("Error releasing reply key: %v", err)
+		}
+	}
+}
+
+// readJSONFile decodes path into v, reporting false when it doesn't exist
+func readJSONFile(path string, v any) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// importJSONOnce reads one of the JSON store's files into v the first time
+// another backend loads it, then calls save to store what was read, so
+// switching from STATE_STORE=json keeps history and settings. Later loads
+// skip the file even when everything imported has since been deleted.
+func importJSONOnce(store stateStore, dir, file string, v any, save func() error) error {
+	imported, err := store.Get("imported:" + file)
+	if err != nil || imported != "" {
+		return err
+	}
+	if _, err := readJSONFile(filepath.Join(dir, file), v); err != nil {
+		return err
+	}
+	if err := save(); err != nil {
+		return err
+	}
+	return store.Set("imported:"+file, time.Now().Format(time.RFC3339))
+}
+
+// memoryStore keeps all state in memory, so nothing survives a restart.
+// It backs the other stores' caches and suits tests and throwaway bots.
+type memoryStore struct {
+	mu            sync.Mutex
+	pending       map[int64]*PendingMessage
+	processed     map[string]time.Time
+	conversations map[string][]AgentTurn
+	settings      map[string]map[string]map[string]string // namespace -> ID -> key -> value
+	values        map[string]string
+}
+
+// newMemoryStore creates an empty memory store
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		pending:       make(map[int64]*PendingMessage),
+		processed:     make(map[string]time.Time),
+		conversations: make(map[string][]AgentTurn),
+		settings:      make(map[string]map[string]map[string]string),
+		values:        make(map[string]string),
+	}
+}
+
+// Open implements stateStore
+func (s *memoryStore) Open(ctx context.Context) error {
+	return nil
+}
+
+// PutPending implements stateStore
+func (s *memoryStore) PutPending(pending *PendingMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *pending
+	s.pending[pending.Timestamp] = &copied
+	return nil
+}
+
+// DeletePending implements stateStore
+func (s *memoryStore) DeletePending(timestamp int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, timestamp)
+	return nil
+}
+
+// Pending implements stateStore
+func (s *memoryStore) Pending() ([]*PendingMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := make([]*PendingMessage, 0, len(s.pending))
+	for _, p := range s.pending {
+		copied := *p
+		pending = append(pending, &copied)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Timestamp < pending[j].Timestamp })
+	return pending, nil
+}
+
+// MarkProcessed implements stateStore
+func (s *memoryStore) MarkProcessed(key string, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, seen := s.processed[key]; seen {
+		return false, nil
+	}
+	s.processed[key] = at
+	return true, nil
+}
+
+// UnmarkProcessed implements stateStore
+func (s *memoryStore) UnmarkProcessed(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.processed, key)
+	return nil
+}
+
+// PruneProcessed implements stateStore
+func (s *memoryStore) PruneProcessed(cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, at := range s.processed {
+		if at.Before(cutoff) {
+			delete(s.processed, key)
+		}
+	}
+	return nil
+}
+
+// Conversations implements stateStore
+func (s *memoryStore) Conversations() (map[string][]AgentTurn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversations := make(map[string][]AgentTurn, len(s.conversations))
+	for id, turns := range s.conversations {
+		conversations[id] = append([]AgentTurn(nil), turns...)
+	}
+	return conversations, nil
+}
+
+// SaveConversation implements stateStore
+func (s *memoryStore) SaveConversation(id string, turns []AgentTurn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(turns) == 0 {
+		delete(s.conversations, id)
+	} else {
+		s.conversations[id] = append([]AgentTurn(nil), turns...)
+	}
+	return nil
+}
+
+// Settings implements stateStore
+func (s *memoryStore) Settings(namespace string) (map[string]map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings := make(map[string]map[string]string, len(s.settings[namespace]))
+	for id, values := range s.settings[namespace] {
+		settings[id] = copyValues(values)
+	}
+	return settings, nil
+}
+
+// SaveSettings implements stateStore
+func (s *memoryStore) SaveSettings(namespace, id string, values map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saveSettings(namespace, id, values)
+	return nil
+}
+
+// saveSettings updates one ID's values; callers must hold s.mu
+func (s *memoryStore) saveSettings(namespace, id string, values map[string]string) {
+	if s.settings[namespace] == nil {
+		s.settings[namespace] = make(map[string]map[string]string)
+	}
+	if len(values) == 0 {
+		delete(s.settings[namespace], id)
+	} else {
+		s.settings[namespace][id] = copyValues(values)
+	}
+}
+
+// Get implements stateStore
+func (s *memoryStore) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key], nil
+}
+
+// Set implements stateStore
+func (s *memoryStore) Set(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value == "" {
+		delete(s.values, key)
+	} else {
+		s.values[key] = value
+	}
+	return nil
+}
+
+// Close implements stateStore
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// copyValues copies one ID's settings
+func copyValues(values map[string]string) map[string]string {
+	copied := make(map[string]string, len(values))
+	for key, value := range values {
+		copied[key] = value
+	}
+	return copied
+}
+
+// jsonStore persists the state as JSON documents in the data directory:
+// history.json, state_values.json, pending.json, processed.json and one
+// file per settings namespace such as chat_settings.json
+type jsonStore struct {
+	*memoryStore
+	dir string
+}
+
+// Open implements stateStore, reading the history, state values, pending
+// and processed files; settings files are read when their namespace is
+// first loaded
+func (s *jsonStore) Open(ctx context.Context) error {
+	conversations := make(map[string][]AgentTurn)
+	if _, err := readJSONFile(filepath.Join(s.dir, historyFile), &conversations); err != nil {
+		return err
+	}
+	values := make(map[string]string)
+	if _, err := readJSONFile(filepath.Join(s.dir, stateValuesFile), &values); err != nil {
+		return err
+	}
+	pending := make(map[int64]*PendingMessage)
+	if _, err := readJSONFile(filepath.Join(s.dir, pendingFile), &pending); err != nil {
+		return err
+	}
+	processed := make(map[string]time.Time)
+	if _, err := readJSONFile(filepath.Join(s.dir, processedFile), &processed); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conversations = conversations
+	s.values = values
+	s.pending = pending
+	s.processed = processed
+	return nil
+}
+
+// writeFile rewrites one of the store's files with v; callers must hold s.mu
+func (s *jsonStore) writeFile(file string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, file), data)
+}
+
+// PutPending implements stateStore, rewriting the pending file
+func (s *jsonStore) PutPending(pending *PendingMessage) error {
+	s.memoryStore.PutPending(pending)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeFile(pendingFile, s.pending)
+}
+
+// DeletePending implements stateStore, rewriting the pending file
+func (s *jsonStore) DeletePending(timestamp int64) error {
+	s.memoryStore.DeletePending(timestamp)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeFile(pendingFile, s.pending)
+}
+
+// MarkProcessed implements stateStore, rewriting the processed file when
+// the key is new
+func (s *jsonStore) MarkProcessed(key string, at time.Time) (bool, error) {
+	isNew, _ := s.memoryStore.MarkProcessed(key, at)
+	if !isNew {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return true, s.writeFile(processedFile, s.processed)
+}
+
+// UnmarkProcessed implements stateStore, rewriting the processed file
+func (s *jsonStore) UnmarkProcessed(key string) error {
+	s.memoryStore.UnmarkProcessed(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeFile(processedFile, s.processed)
+}
+
+// PruneProcessed implements stateStore, rewriting the processed file
+func (s *jsonStore) PruneProcessed(cutoff time.Time) error {
+	s.memoryStore.PruneProcessed(cutoff)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeFile(processedFile, s.processed)
+}
+
+// Set implements stateStore, rewriting the state values file
+func (s *jsonStore) Set(key, value string) error {
+	s.memoryStore.Set(key, value)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(s.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, stateValuesFile), data)
+}
+
+// SaveConversation implements stateStore, rewriting the history file
+func (s *jsonStore) SaveConversation(id string, turns []AgentTurn) error {
+	s.memoryStore.SaveConversation(id, turns)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.Marshal(s.conversations)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, historyFile), data)
+}
+
+// Settings implements stateStore, reading the namespace's file
+func (s *jsonStore) Settings(namespace string) (map[string]map[string]string, error) {
+	settings := make(map[string]map[string]string)
+	if _, err := readJSONFile(filepath.Join(s.dir, namespace+".json"), &settings); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.settings[namespace] = settings
+	s.mu.Unlock()
+	return s.memoryStore.Settings(namespace)
+}
+
+// SaveSettings implements stateStore, rewriting the namespace's file
+func (s *jsonStore) SaveSettings(namespace, id string, values map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.saveSettings(namespace, id, values)
+	data, err := json.MarshalIndent(s.settings[namespace], "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, namespace+".json"), data)
+}
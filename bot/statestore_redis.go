@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerStateStore("redis", func(bot *SignalBot) (stateStore, error) {
+		if bot.config.RedisURL == "" {
+			return nil, fmt.Errorf("STATE_STORE=redis needs REDIS_URL")
+		}
+		client, err := newRedisClient(bot.config.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		return &redisState{client: client, prefix: bot.config.RedisPrefix, dir: bot.config.DataDir}, nil
+	})
+}
+
+// redisState keeps all state in Redis under prefix, so it survives restarts
+// and is shared by every replica using the same server. Pending messages
+// and processed-message keys expire on their own: pending ones after
+// pendingExpiry, mirroring cleanupOldPendingMessages, and processed keys
+// after processedKeyRetention. History and settings are imported from the
+// JSON store's files in dir when first loaded.
+type redisState struct {
+	client *redisClient
+	prefix string
+	dir    string
+}
+
+// key namespaces a Redis key under the configured prefix
+func (s *redisState) key(parts ...string) string {
+	return s.prefix + strings.Join(parts, ":")
+}
+
+// Open implements stateStore, checking the server is reachable
+func (s *redisState) Open(ctx context.Context) error {
+	if _, err := s.client.String("PING"); err != nil {
+		return fmt.Errorf("redis unreachable: %w", err)
+	}
+	return nil
+}
+
+// PutPending implements stateStore
+func (s *redisState) PutPending(pending *PendingMessage) error {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(pending.SentTime.Add(pendingExpiry))
+	if ttl <= 0 {
+		return nil
+	}
+	_, err = s.client.Do("SET", s.key("pending", strconv.FormatInt(pending.Timestamp, 10)), string(data),
+		"PX", strconv.FormatInt(ttl.Milliseconds()+1, 10))
+	return err
+}
+
+// DeletePending implements stateStore
+func (s *redisState) DeletePending(timestamp int64) error {
+	_, err := s.client.Do("DEL", s.key("pending", strconv.FormatInt(timestamp, 10)))
+	return err
+}
+
+// Pending implements stateStore
+func (s *redisState) Pending() ([]*PendingMessage, error) {
+	keys, err := s.client.Scan(s.key("pending", "*"))
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	values, err := s.client.Strings(append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []*PendingMessage
+	for _, data := range values {
+		if data == "" {
+			continue // expired since the scan
+		}
+		var p PendingMessage
+		if err := json.Unmarshal([]byte(data), &p); err != nil {
+			return nil, fmt.Errorf("failed to parse pending message: %w", err)
+		}
+		pending = append(pending, &p)
+	}
+	return pending, nil
+}
+
+// MarkProcessed implements stateStore
+func (s *redisState) MarkProcessed(key string, at time.Time) (bool, error) {
+	_, err := s.client.String("SET", s.key("processed", key), strconv.FormatInt(at.UnixMilli(), 10),
+		"NX", "PX", strconv.FormatInt(processedKeyRetention.Milliseconds(), 10))
+	if errors.Is(err, errRedisNil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// UnmarkProcessed implements stateStore
+func (s *redisState) UnmarkProcessed(key string) error {
+	_, err := s.client.Do("DEL", s.key("processed", key))
+	return err
+}
+
+// PruneProcessed implements stateStore; Redis expires the keys itself
+func (s *redisState) PruneProcessed(cutoff time.Time) error {
+	return nil
+}
+
+// Conversations implements stateStore
+func (s *redisState) Conversations() (map[string][]AgentTurn, error) {
+	fields, err := s.client.Strings("HGETALL", s.key("conversations"))
+	if err != nil {
+		return nil, err
+	}
+
+	conversations := make(map[string][]AgentTurn, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		var turns []AgentTurn
+		if err := json.Unmarshal([]byte(fields[i+1]), &turns); err != nil {
+			return nil, fmt.Errorf("failed to parse conversation %s: %w", fields[i], err)
+		}
+		conversations[fields[i]] = turns
+	}
+
+	err = importJSONOnce(s, s.dir, historyFile, &conversations, func() error {
+		for id, turns := range conversations {
+			if err := s.SaveConversation(id, turns); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return conversations, err
+}
+
+// SaveConversation implements stateStore
+func (s *redisState) SaveConversation(id string, turns []AgentTurn) error {
+	if len(turns) == 0 {
+		_, err := s.client.Do("HDEL", s.key("conversations"), id)
+		return err
+	}
+	data, err := json.Marshal(turns)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do("HSET", s.key("conversations"), id, string(data))
+	return err
+}
+
+// Settings implements stateStore
+func (s *redisState) Settings(namespace string) (map[string]map[string]string, error) {
+	fields, err := s.client.Strings("HGETALL", s.key("settings", namespace))
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]map[string]string, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		var values map[string]string
+		if err := json.Unmarshal([]byte(fields[i+1]), &values); err != nil {
+			return nil, fmt.Errorf("failed to parse %s settings of %s: %w", namespace, fields[i], err)
+		}
+		settings[fields[i]] = values
+	}
+
+	err = importJSONOnce(s, s.dir, namespace+".json", &settings, func() error {
+		for id, values := range settings {
+			if err := s.SaveSettings(namespace, id, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return settings, err
+}
+
+// SaveSettings implements stateStore
+func (s *redisState) SaveSettings(namespace, id string, values map[string]string) error {
+	if len(values) == 0 {
+		_, err := s.client.Do("HDEL", s.key("settings", namespace), id)
+		return err
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do("HSET", s.key("settings", namespace), id, string(data))
+	return err
+}
+
+// Get implements stateStore
+func (s *redisState) Get(key string) (string, error) {
+	value, err := s.client.String("HGET", s.key("state"), key)
+	if errors.Is(err, errRedisNil) {
+		return "", nil
+	}
+	return value, err
+}
+
+// Set implements stateStore
+func (s *redisState) Set(key, value string) error {
+	if value == "" {
+		_, err := s.client.Do("HDEL", s.key("state"), key)
+		return err
+	}
+	_, err := s.client.Do("HSET", s.key("state"), key, value)
+	return err
+}
+
+// Close implements stateStore
+func (s *redisState) Close() error {
+	return s.client.Close()
+}
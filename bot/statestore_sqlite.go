@@ -0,0 +1,254 @@
+//go:build statestore
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	registerStateStore("sqlite", func(bot *SignalBot) (stateStore, error) {
+		return &sqliteState{dir: bot.config.DataDir, path: filepath.Join(bot.config.DataDir, "state.db")}, nil
+	})
+}
+
+// sqliteState keeps all state in a SQLite database. History and settings
+// are imported from the JSON store's files in dir when first loaded.
+type sqliteState struct {
+	dir  string
+	path string
+	db   *sql.DB
+}
+
+// Open implements stateStore, creating the schema
+func (s *sqliteState) Open(ctx context.Context) error {
+	db, err := sql.Open("sqlite", s.path)
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(1)
+	s.db = db
+
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS pending (
+			timestamp INTEGER PRIMARY KEY,
+			message TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS processed (
+			key TEXT PRIMARY KEY,
+			at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS processed_at ON processed (at);
+		CREATE TABLE IF NOT EXISTS conversations (
+			id TEXT PRIMARY KEY,
+			turns TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS settings (
+			namespace TEXT NOT NULL,
+			id TEXT NOT NULL,
+			settings_values TEXT NOT NULL,
+			PRIMARY KEY (namespace, id)
+		);
+		CREATE TABLE IF NOT EXISTS kv (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		);
+	`); err != nil {
+		return fmt.Errorf("failed to create state schema: %w", err)
+	}
+	return nil
+}
+
+// PutPending implements stateStore
+func (s *sqliteState) PutPending(pending *PendingMessage) error {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO pending (timestamp, message) VALUES (?, ?)`, pending.Timestamp, string(data))
+	return err
+}
+
+// DeletePending implements stateStore
+func (s *sqliteState) DeletePending(timestamp int64) error {
+	_, err := s.db.Exec(`DELETE FROM pending WHERE timestamp = ?`, timestamp)
+	return err
+}
+
+// Pending implements stateStore
+func (s *sqliteState) Pending() ([]*PendingMessage, error) {
+	rows, err := s.db.Query(`SELECT message FROM pending ORDER BY timestamp`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []*PendingMessage
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var p PendingMessage
+		if err := json.Unmarshal([]byte(data), &p); err != nil {
+			return nil, fmt.Errorf("failed to parse pending message: %w", err)
+		}
+		pending = append(pending, &p)
+	}
+	return pending, rows.Err()
+}
+
+// MarkProcessed implements stateStore
+func (s *sqliteState) MarkProcessed(key string, at time.Time) (bool, error) {
+	result, err := s.db.Exec(`INSERT OR IGNORE INTO processed (key, at) VALUES (?, ?)`, key, at.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// UnmarkProcessed implements stateStore
+func (s *sqliteState) UnmarkProcessed(key string) error {
+	_, err := s.db.Exec(`DELETE FROM processed WHERE key = ?`, key)
+	return err
+}
+
+// PruneProcessed implements stateStore
+func (s *sqliteState) PruneProcessed(cutoff time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM processed WHERE at < ?`, cutoff.UnixMilli())
+	return err
+}
+
+// Conversations implements stateStore
+func (s *sqliteState) Conversations() (map[string][]AgentTurn, error) {
+	rows, err := s.db.Query(`SELECT id, turns FROM conversations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := make(map[string][]AgentTurn)
+	for rows.Next() {
+		var id, data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		var turns []AgentTurn
+		if err := json.Unmarshal([]byte(data), &turns); err != nil {
+			return nil, fmt.Errorf("failed to parse conversation %s: %w", id, err)
+		}
+		conversations[id] = turns
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = importJSONOnce(s, s.dir, historyFile, &conversations, func() error {
+		for id, turns := range conversations {
+			if err := s.SaveConversation(id, turns); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return conversations, err
+}
+
+// SaveConversation implements stateStore
+func (s *sqliteState) SaveConversation(id string, turns []AgentTurn) error {
+	if len(turns) == 0 {
+		_, err := s.db.Exec(`DELETE FROM conversations WHERE id = ?`, id)
+		return err
+	}
+	data, err := json.Marshal(turns)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO conversations (id, turns) VALUES (?, ?)`, id, string(data))
+	return err
+}
+
+// Settings implements stateStore
+func (s *sqliteState) Settings(namespace string) (map[string]map[string]string, error) {
+	rows, err := s.db.Query(`SELECT id, settings_values FROM settings WHERE namespace = ?`, namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := make(map[string]map[string]string)
+	for rows.Next() {
+		var id, data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		var values map[string]string
+		if err := json.Unmarshal([]byte(data), &values); err != nil {
+			return nil, fmt.Errorf("failed to parse %s settings of %s: %w", namespace, id, err)
+		}
+		settings[id] = values
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = importJSONOnce(s, s.dir, namespace+".json", &settings, func() error {
+		for id, values := range settings {
+			if err := s.SaveSettings(namespace, id, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return settings, err
+}
+
+// SaveSettings implements stateStore
+func (s *sqliteState) SaveSettings(namespace, id string, values map[string]string) error {
+	if len(values) == 0 {
+		_, err := s.db.Exec(`DELETE FROM settings WHERE namespace = ? AND id = ?`, namespace, id)
+		return err
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO settings (namespace, id, settings_values) VALUES (?, ?, ?)`, namespace, id, string(data))
+	return err
+}
+
+// Get implements stateStore
+func (s *sqliteState) Get(key string) (string, error) {
+	var value string
+	err := s.db.QueryRow(`SELECT value FROM kv WHERE key = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// Set implements stateStore
+func (s *sqliteState) Set(key, value string) error {
+	if value == "" {
+		_, err := s.db.Exec(`DELETE FROM kv WHERE key = ?`, key)
+		return err
+	}
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO kv (key, value) VALUES (?, ?)`, key, value)
+	return err
+}
+
+// Close implements stateStore
+func (s *sqliteState) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
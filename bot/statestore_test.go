@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJSONStoreSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *jsonStore {
+		t.Helper()
+		store := &jsonStore{memoryStore: newMemoryStore(), dir: dir}
+		if err := store.Open(context.Background()); err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		return store
+	}
+
+	now := time.Now()
+	store := open()
+	for _, pending := range []*PendingMessage{
+		{Timestamp: 1000, Prompt: "first", Sender: AgentSender{Number: "+15550001"}, SentTime: now},
+		{Timestamp: 2000, Prompt: "second", SentTime: now},
+	} {
+		if err := store.PutPending(pending); err != nil {
+			t.Fatalf("PutPending() error = %v", err)
+		}
+	}
+	if err := store.DeletePending(2000); err != nil {
+		t.Fatalf("DeletePending() error = %v", err)
+	}
+	for _, key := range []string{"u-1:1000", "u-1:2000", "reply:+15550001:1000"} {
+		if _, err := store.MarkProcessed(key, now); err != nil {
+			t.Fatalf("MarkProcessed() error = %v", err)
+		}
+	}
+	if _, err := store.MarkProcessed("u-1:500", now.Add(-2*processedKeyRetention)); err != nil {
+		t.Fatalf("MarkProcessed() error = %v", err)
+	}
+	if err := store.UnmarkProcessed("reply:+15550001:1000"); err != nil {
+		t.Fatalf("UnmarkProcessed() error = %v", err)
+	}
+	if err := store.PruneProcessed(now.Add(-processedKeyRetention)); err != nil {
+		t.Fatalf("PruneProcessed() error = %v", err)
+	}
+
+	restarted := open()
+	pending, err := restarted.Pending()
+	if err != nil {
+		t.Fatalf("Pending() error = %v", err)
+	}
+	if len(pending) != 1 || pending[0].Timestamp != 1000 || pending[0].Prompt != "first" || pending[0].Sender.Number != "+15550001" {
+		t.Errorf("Pending() after restart = %+v, want only the first message", pending)
+	}
+
+	tests := []struct {
+		key     string
+		wantNew bool
+	}{
+		{"u-1:1000", false},
+		{"u-1:2000", false},
+		{"reply:+15550001:1000", true}, // unmarked
+		{"u-1:500", true},              // pruned
+		{"u-1:3000", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			isNew, err := restarted.MarkProcessed(tt.key, now)
+			if err != nil {
+				t.Fatalf("MarkProcessed() error = %v", err)
+			}
+			if isNew != tt.wantNew {
+				t.Errorf("MarkProcessed(%s) after restart = %t, want %t", tt.key, isNew, tt.wantNew)
+			}
+		})
+	}
+}
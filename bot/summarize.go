@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// summaryKeepTurns is how many of the latest turns stay verbatim when a
+// conversation is summarized, i.e. the last exchange
+const summaryKeepTurns = 2
+
+// summaryPrompt asks the agent to condense earlier turns
+const summaryPrompt = `Summarize the following conversation in a few sentences, keeping names, facts, decisions and open questions needed to continue it. Reply with the summary only.
+
+`
+
+// compactHistory replaces a conversation's older turns with an agent-written
+// summary once they exceed AGENT_HISTORY_TOKEN_BUDGET, returning the
+// history to send. Callers must hold the conversation's lock.
+func (bot *SignalBot) compactHistory(ctx context.Context, id string, history []AgentTurn) []AgentTurn {
+	budget := bot.config.AgentHistoryTokenBudget
+	if budget <= 0 || len(history) <= summaryKeepTurns || estimateTurnTokens(history) <= budget {
+		return history
+	}
+
+	older := history[:len(history)-summaryKeepTurns]
+	var transcript strings.Builder
+	for _, turn := range older {
+		fmt.Fprintf(&transcript, "%s: %s\n", turn.Role, turn.Content)
+	}
+
+	response, err := bot.callAgent(ctx, AgentRequest{Prompt: summaryPrompt + transcript.String()})
+	if err != nil {
+		bot.logger.Printf("Error summarizing conversation history: %v", err)
+		return history
+	}
+	summary := strings.TrimSpace(strings.Join(response.replies(), "\n"))
+	if summary == "" {
+		return history
+	}
+
+	// The summary is as old as the newest turn it replaces, so retention
+	// expires it no later than those turns would have
+	turn := AgentTurn{Role: "system", Content: "Summary of the earlier conversation: " + summary}
+	for _, summarized := range older {
+		turn.Timestamp = max(turn.Timestamp, summarized.Timestamp)
+	}
+	if turn.Timestamp == 0 {
+		turn.Timestamp = time.Now().UnixMilli()
+	}
+	if err := bot.history.Compact(id, len(older), turn); err != nil {
+		bot.logger.Printf("Error saving conversation history: %v", err)
+	}
+	bot.logger.Printf("Summarized %d turns of %s (~%d tokens)", len(older), id, estimateTurnTokens(older))
+	return bot.history.Recent(id)
+}
+
+// maxSummarySections bounds the agent calls spent condensing one document
+const maxSummarySections = 8
+
+// sectionSummaryPrompt asks the agent to condense part of a long document
+const sectionSummaryPrompt = `Summarize this part of %s in a short paragraph, keeping names, numbers, claims and timestamps. Reply with the summary only.
+
+`
+
+// summarizeSections condenses a document that doesn't fit budget tokens:
+// its passages are grouped into sections, each section is summarized by the
+// agent, and the summaries are returned in order in place of the passages.
+// Sections that fail to summarize are dropped.
+func (bot *SignalBot) summarizeSections(ctx context.Context, source string, docs []AgentDocument, budget int) []AgentDocument {
+	size := budget
+	if total := estimateDocumentTokens(docs); total/size >= maxSummarySections {
+		size = total/maxSummarySections + 1
+	}
+
+	var sections []string
+	var current strings.Builder
+	for _, doc := range docs {
+		if current.Len() > 0 && estimateTokens(current.String())+estimateTokens(doc.Text) > size {
+			sections = append(sections, current.String())
+			current.Reset()
+		}
+		current.WriteString(doc.Text)
+		current.WriteString("\n\n")
+	}
+	if current.Len() > 0 {
+		sections = append(sections, current.String())
+	}
+
+	var summaries []AgentDocument
+	for i, section := range sections {
+		response, err := bot.callAgent(ctx, AgentRequest{Prompt: fmt.Sprintf(sectionSummaryPrompt, source) + section})
+		if err != nil {
+			bot.logger.Printf("Error summarizing part %d of %s: %v", i+1, source, err)
+			continue
+		}
+		summary := strings.TrimSpace(strings.Join(response.replies(), "\n"))
+		if summary == "" {
+			continue
+		}
+		summaries = append(summaries, AgentDocument{
+			Source: fmt.Sprintf("%s, summary of part %d/%d", source, i+1, len(sections)),
+			Text:   summary,
+		})
+	}
+	bot.logger.Printf("Summarized %s in %d parts (~%d tokens)", source, len(sections), estimateDocumentTokens(docs))
+	return summaries
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supervisor restart backoff: doubling from supervisorBackoff up to
+// supervisorMaxBackoff, reset once a subsystem stays up for supervisorStable
+const (
+	supervisorBackoff    = time.Second
+	supervisorMaxBackoff = time.Minute
+	supervisorStable     = 5 * time.Minute
+)
+
+// supervisor keeps long-running goroutines alive, restarting each one
+// independently when it fails or panics
+type supervisor struct {
+	mu       sync.Mutex
+	restarts map[string]int
+}
+
+func newSupervisor() *supervisor {
+	return &supervisor{restarts: make(map[string]int)}
+}
+
+// Restarts returns how often each supervised goroutine was restarted
+func (s *supervisor) Restarts() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	restarts := make(map[string]int, len(s.restarts))
+	for name, n := range s.restarts {
+		restarts[name] = n
+	}
+	return restarts
+}
+
+// String summarizes restarts for !status
+func (s *supervisor) String() string {
+	restarts := s.Restarts()
+	if len(restarts) == 0 {
+		return "none"
+	}
+
+	names := make([]string, 0, len(restarts))
+	for name := range restarts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s %d", name, restarts[name]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// supervise runs fn in its own goroutine until ctx is cancelled, restarting
+// it with backoff whenever it returns or panics
+func (bot *SignalBot) supervise(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	go func() {
+		attempt := 0
+		for {
+			started := time.Now()
+			err := runGuarded(ctx, fn)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if time.Since(started) >= supervisorStable {
+				attempt = 0
+			}
+			attempt++
+			delay := backoffDelay(supervisorBackoff, min(attempt, 7))
+			if delay > supervisorMaxBackoff {
+				delay = supervisorMaxBackoff
+			}
+
+			bot.supervisor.mu.Lock()
+			bot.supervisor.restarts[name]++
+			bot.supervisor.mu.Unlock()
+			bot.logger.Printf("Subsystem %s stopped: %v; restarting in %s", name, err, delay.Round(time.Millisecond))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+	}()
+}
+
+// runGuarded calls fn, turning a panic into an error
+func runGuarded(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
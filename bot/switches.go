@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Subsystems that can be turned off with a kill switch
+const (
+	switchAgent     = "agent"
+	switchCommands  = "commands"
+	switchWebhooks  = "webhooks"
+	switchScheduler = "scheduler"
+)
+
+// errAgentDisabled is returned for agent calls while the agent kill switch
+// is on
+var errAgentDisabled = errors.New("the agent is switched off")
+
+// killSwitches tracks which subsystems are disabled. Initial values come
+// from DISABLE_* environment variables and can be flipped at runtime with
+// the !admin command.
+type killSwitches struct {
+	mu       sync.RWMutex
+	disabled map[string]bool
+}
+
+// newKillSwitches reads the DISABLE_* environment variables
+func newKillSwitches() *killSwitches {
+	ks := &killSwitches{disabled: make(map[string]bool)}
+	for _, name := range []string{switchAgent, switchCommands, switchWebhooks, switchScheduler} {
+		ks.disabled[name] = getEnvBool("DISABLE_"+strings.ToUpper(name), false)
+	}
+	return ks
+}
+
+// Disabled reports whether the named subsystem is switched off
+func (ks *killSwitches) Disabled(name string) bool {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.disabled[name]
+}
+
+// Set turns the named subsystem off (disabled=true) or back on
+func (ks *killSwitches) Set(name string, disabled bool) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if _, known := ks.disabled[name]; !known {
+		return fmt.Errorf("unknown subsystem %q", name)
+	}
+	ks.disabled[name] = disabled
+	return nil
+}
+
+// String renders the switch states, e.g. "agent=on, commands=off"
+func (ks *killSwitches) String() string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	names := make([]string, 0, len(ks.disabled))
+	for name := range ks.disabled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		state := "on"
+		if ks.disabled[name] {
+			state = "off"
+		}
+		parts = append(parts, name+"="+state)
+	}
+	return strings.Join(parts, ", ")
+}
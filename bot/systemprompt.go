@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSystemPromptLength caps a chat's !system prompt, in characters
+const maxSystemPromptLength = 2000
+
+// chatSystemPrompt returns the system prompt a chat set with !system, or ""
+func (bot *SignalBot) chatSystemPrompt(chatID string) string {
+	if chatID == "" {
+		return ""
+	}
+	return bot.settings.Get(chatID, "system")
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "system",
+		usage:   "!system <prompt> | !system show | !system clear",
+		handler: systemCommand,
+	})
+}
+
+// systemCommand sets, shows or clears the chat's system prompt. In groups
+// only group admins and the bot owner may change it.
+func systemCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	chatID := msg.chatID()
+	if chatID == "" {
+		return "Sorry, I can't tell which chat this is."
+	}
+	if len(args) == 0 || (len(args) == 1 && strings.EqualFold(args[0], "show")) {
+		if prompt := bot.chatSystemPrompt(chatID); prompt != "" {
+			return "System prompt for this chat:\n" + prompt
+		}
+		return "This chat has no system prompt. Set one with \"!system <prompt>\"."
+	}
+	if groupID := msg.extractGroupId(); groupID != "" && !bot.isAdmin(msg) && !bot.isGroupAdmin(ctx, groupID, msg.sender()) {
+		return bot.localizef(chatID, "Only group admins can change %s.", "the system prompt")
+	}
+
+	prompt := commandText(msg.extractContent(), 1)
+	reply := "System prompt saved. It applies to every question asked here from now on."
+	if len(args) == 1 && strings.EqualFold(args[0], "clear") {
+		prompt, reply = "", "System prompt cleared."
+	} else if n := utf8.RuneCountInString(prompt); n > maxSystemPromptLength {
+		return fmt.Sprintf("That system prompt is %d characters long; the limit is %d.", n, maxSystemPromptLength)
+	}
+
+	if err := bot.settings.Set(chatID, "system", prompt); err != nil {
+		bot.logger.Printf("Error saving system prompt: %v", err)
+		return "Sorry, I couldn't save that setting."
+	}
+	return reply
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// placeholderPattern matches {{name}} with optional inner spaces
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([a-zA-Z0-9_]+)\s*\}\}`)
+
+// expandPlaceholders replaces {{name}} occurrences with values from vars.
+// Unknown placeholders are left untouched.
+func expandPlaceholders(tmpl string, vars map[string]string) string {
+	return placeholderPattern.ReplaceAllStringFunc(tmpl, func(match string) string {
+		name := placeholderPattern.FindStringSubmatch(match)[1]
+		if val, exists := vars[strings.ToLower(name)]; exists {
+			return val
+		}
+		return match
+	})
+}
+
+// hasPlaceholder reports whether tmpl contains {{name}}
+func hasPlaceholder(tmpl, name string) bool {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(tmpl, -1) {
+		if strings.EqualFold(match[1], name) {
+			return true
+		}
+	}
+	return false
+}
+
+// promptVars returns the template variables describing a request
+func promptVars(request AgentRequest, now time.Time) map[string]string {
+	vars := map[string]string{
+		"prompt": request.Prompt,
+		"time":   now.Format("Mon 2 Jan 2006 15:04 MST"),
+		"date":   now.Format("2006-01-02"),
+		"sender": "someone",
+		"group":  "a direct message",
+	}
+	if s := request.Sender; s != nil {
+		vars["sender_number"] = s.Number
+		switch {
+		case s.Name != "":
+			vars["sender"] = s.Name
+		case s.Number != "":
+			vars["sender"] = s.Number
+		}
+	}
+	if c := request.Chat; c != nil && c.GroupID != "" {
+		vars["group"] = c.GroupName
+		if c.GroupName == "" {
+			vars["group"] = "a group chat"
+		}
+	}
+	return vars
+}
+
+// wrapPrompt applies PROMPT_TEMPLATE around the user's prompt. Templates
+// without a {{prompt}} placeholder get the prompt appended.
+func (bot *SignalBot) wrapPrompt(request AgentRequest) string {
+	tmpl := bot.config.PromptTemplate
+	if tmpl == "" {
+		return request.Prompt
+	}
+	if !hasPlaceholder(tmpl, "prompt") {
+		tmpl += "\n\n{{prompt}}"
+	}
+	now := time.Now().In(bot.userLocation(requestChatID(request), request.Sender))
+	return expandPlaceholders(tmpl, promptVars(request, now))
+}
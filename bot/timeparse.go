@@ -0,0 +1,330 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errNoTime is returned when no time expression starts the input
+var errNoTime = errors.New("no time expression found")
+
+// timeLocale holds the vocabulary of one language for parseNaturalTime.
+// All words are lowercase without diacritics (see foldDiacritics).
+type timeLocale struct {
+	name     string
+	in       [][]string // "in", "dentro de"
+	one      []string   // "a", "an", "um"
+	units    map[string]time.Duration
+	today    [][]string
+	tomorrow [][]string
+	dayAfter [][]string // day after tomorrow
+	next     [][]string
+	at       [][]string
+	noon     [][]string
+	midnight [][]string
+	weekdays map[string]time.Weekday
+}
+
+// timeLocales are tried in order; their vocabularies don't overlap in
+// ways that matter, so the expression's language is detected implicitly
+var timeLocales = []timeLocale{
+	{
+		name: "en",
+		in:   [][]string{{"in"}},
+		one:  []string{"a", "an", "one"},
+		units: map[string]time.Duration{
+			"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+			"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+			"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+			"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+			"week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+		},
+		today:    [][]string{{"today"}, {"tonight"}},
+		tomorrow: [][]string{{"tomorrow"}},
+		dayAfter: [][]string{{"day", "after", "tomorrow"}},
+		next:     [][]string{{"next"}, {"this"}, {"on"}},
+		at:       [][]string{{"at"}},
+		noon:     [][]string{{"noon"}},
+		midnight: [][]string{{"midnight"}},
+		weekdays: map[string]time.Weekday{
+			"monday": time.Monday, "mon": time.Monday,
+			"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+			"wednesday": time.Wednesday, "wed": time.Wednesday,
+			"thursday": time.Thursday, "thu": time.Thursday, "thurs": time.Thursday,
+			"friday": time.Friday, "fri": time.Friday,
+			"saturday": time.Saturday, "sat": time.Saturday,
+			"sunday": time.Sunday, "sun": time.Sunday,
+		},
+	},
+	{
+		name: "pt",
+		in:   [][]string{{"em"}, {"daqui", "a"}, {"dentro", "de"}},
+		one:  []string{"um", "uma"},
+		units: map[string]time.Duration{
+			"segundo": time.Second, "segundos": time.Second,
+			"minuto": time.Minute, "minutos": time.Minute,
+			"hora": time.Hour, "horas": time.Hour,
+			"dia": 24 * time.Hour, "dias": 24 * time.Hour,
+			"semana": 7 * 24 * time.Hour, "semanas": 7 * 24 * time.Hour,
+		},
+		today:    [][]string{{"hoje"}},
+		tomorrow: [][]string{{"amanha"}},
+		dayAfter: [][]string{{"depois", "de", "amanha"}},
+		next:     [][]string{{"proxima"}, {"proximo"}, {"na"}, {"no"}},
+		at:       [][]string{{"as"}, {"ao"}, {"a"}},
+		noon:     [][]string{{"meio", "dia"}, {"meio-dia"}},
+		midnight: [][]string{{"meia", "noite"}, {"meia-noite"}},
+		weekdays: map[string]time.Weekday{
+			"segunda": time.Monday, "terca": time.Tuesday, "quarta": time.Wednesday,
+			"quinta": time.Thursday, "sexta": time.Friday, "sabado": time.Saturday,
+			"domingo": time.Sunday,
+		},
+	},
+	{
+		name: "es",
+		in:   [][]string{{"en"}, {"dentro", "de"}},
+		one:  []string{"un", "una"},
+		units: map[string]time.Duration{
+			"segundo": time.Second, "segundos": time.Second,
+			"minuto": time.Minute, "minutos": time.Minute,
+			"hora": time.Hour, "horas": time.Hour,
+			"dia": 24 * time.Hour, "dias": 24 * time.Hour,
+			"semana": 7 * 24 * time.Hour, "semanas": 7 * 24 * time.Hour,
+		},
+		today:    [][]string{{"hoy"}},
+		tomorrow: [][]string{{"manana"}},
+		dayAfter: [][]string{{"pasado", "manana"}},
+		next:     [][]string{{"el", "proximo"}, {"la", "proxima"}, {"proximo"}, {"proxima"}, {"el"}},
+		at:       [][]string{{"a", "las"}, {"a", "la"}},
+		noon:     [][]string{{"mediodia"}},
+		midnight: [][]string{{"medianoche"}},
+		weekdays: map[string]time.Weekday{
+			"lunes": time.Monday, "martes": time.Tuesday, "miercoles": time.Wednesday,
+			"jueves": time.Thursday, "viernes": time.Friday, "sabado": time.Saturday,
+			"domingo": time.Sunday,
+		},
+	},
+}
+
+// defaultHour is used when an expression names a day but no time of day
+const defaultHour = 9
+
+var (
+	clockPattern   = regexp.MustCompile(`^(\d{1,2})(?::|h)?(\d{2})?(am|pm|h)?$`)
+	isoDatePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	diacritics     = strings.NewReplacer(
+		"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a",
+		"é", "e", "ê", "e", "è", "e", "ë", "e",
+		"í", "i", "ì", "i", "ï", "i",
+		"ó", "o", "ô", "o", "õ", "o", "ò", "o", "ö", "o",
+		"ú", "u", "ù", "u", "ü", "u",
+		"ç", "c", "ñ", "n",
+	)
+)
+
+// foldDiacritics lowercases s and strips common Latin diacritics
+func foldDiacritics(s string) string {
+	return diacritics.Replace(strings.ToLower(s))
+}
+
+// parseNaturalTime parses a time expression at the start of input, such as
+// "in 10 minutes", "tomorrow at 9pm", "next tuesday 18:30", "próxima terça
+// às 9", "mañana a las 8" or "2026-01-31 14:00". It returns the resolved
+// time in loc and the remainder of the input after the expression.
+func parseNaturalTime(input string, now time.Time, loc *time.Location) (time.Time, string, error) {
+	now = now.In(loc)
+	original := strings.Fields(input)
+	tokens := make([]string, len(original))
+	for i, word := range original {
+		tokens[i] = strings.Trim(foldDiacritics(word), ",.")
+	}
+
+	for _, l := range timeLocales {
+		if t, n, ok := l.parse(tokens, now, loc); ok {
+			return t, strings.Join(original[n:], " "), nil
+		}
+	}
+	return time.Time{}, input, errNoTime
+}
+
+// parse tries this locale's grammar, returning the time and the number of
+// tokens consumed
+func (l *timeLocale) parse(tokens []string, now time.Time, loc *time.Location) (time.Time, int, bool) {
+	// Relative offsets: "in 10 minutes", "em 2 horas"
+	if n := matchAny(tokens, 0, l.in); n > 0 {
+		if t, m, ok := l.parseOffset(tokens[n:], now); ok {
+			return t, n + m, true
+		}
+	}
+
+	// ISO date with optional clock time: "2026-01-31 14:00"
+	if len(tokens) > 0 && isoDatePattern.MatchString(tokens[0]) {
+		day, err := time.ParseInLocation("2006-01-02", tokens[0], loc)
+		if err != nil {
+			return time.Time{}, 0, false
+		}
+		hour, min, m := l.parseClockAfter(tokens[1:])
+		if m == 0 {
+			hour, min = defaultHour, 0
+		}
+		return atClock(day, hour, min), 1 + m, true
+	}
+
+	// Day words: "today", "tomorrow", "next friday", with optional time
+	if day, n, ok := l.parseDay(tokens, now); ok {
+		hour, min, m := l.parseClockAfter(tokens[n:])
+		if m == 0 {
+			hour, min = defaultHour, 0
+		}
+		return atClock(day, hour, min), n + m, true
+	}
+
+	// Time of day only: "at 18:30", "às 9" - today, or tomorrow if passed
+	if hour, min, n := l.parseClockAfter(tokens); n > 0 {
+		t := atClock(now, hour, min)
+		if !t.After(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, n, true
+	}
+
+	return time.Time{}, 0, false
+}
+
+// parseOffset parses "<count> <unit>" such as "10 minutes" or "an hour"
+func (l *timeLocale) parseOffset(tokens []string, now time.Time) (time.Time, int, bool) {
+	if len(tokens) < 2 {
+		return time.Time{}, 0, false
+	}
+
+	count, err := strconv.Atoi(tokens[0])
+	if err != nil {
+		if !contains(l.one, tokens[0]) {
+			return time.Time{}, 0, false
+		}
+		count = 1
+	}
+
+	unit, exists := l.units[tokens[1]]
+	if !exists || count <= 0 {
+		return time.Time{}, 0, false
+	}
+	return now.Add(time.Duration(count) * unit), 2, true
+}
+
+// parseDay parses a day expression, returning midnight of that day
+func (l *timeLocale) parseDay(tokens []string, now time.Time) (time.Time, int, bool) {
+	midnight := atClock(now, 0, 0)
+
+	if n := matchAny(tokens, 0, l.dayAfter); n > 0 {
+		return midnight.AddDate(0, 0, 2), n, true
+	}
+	if n := matchAny(tokens, 0, l.tomorrow); n > 0 {
+		return midnight.AddDate(0, 0, 1), n, true
+	}
+	if n := matchAny(tokens, 0, l.today); n > 0 {
+		return midnight, n, true
+	}
+
+	n := matchAny(tokens, 0, l.next)
+	if n < len(tokens) {
+		name := strings.TrimSuffix(tokens[n], "-feira")
+		if weekday, exists := l.weekdays[name]; exists {
+			days := (int(weekday) - int(now.Weekday()) + 7) % 7
+			if days == 0 {
+				days = 7
+			}
+			return midnight.AddDate(0, 0, days), n + 1, true
+		}
+	}
+	return time.Time{}, 0, false
+}
+
+// parseClockAfter parses an optional "at" word followed by a time of day,
+// returning the hour, minute and number of tokens consumed (0 if none)
+func (l *timeLocale) parseClockAfter(tokens []string) (int, int, int) {
+	n := matchAny(tokens, 0, l.at)
+
+	if m := matchAny(tokens, n, l.noon); m > 0 {
+		return 12, 0, n + m
+	}
+	if m := matchAny(tokens, n, l.midnight); m > 0 {
+		return 0, 0, n + m
+	}
+	if n >= len(tokens) {
+		return 0, 0, 0
+	}
+
+	word := tokens[n]
+	consumed := n + 1
+	// "9 pm" written as two words
+	if consumed < len(tokens) && (tokens[consumed] == "am" || tokens[consumed] == "pm") {
+		word += tokens[consumed]
+		consumed++
+	}
+
+	match := clockPattern.FindStringSubmatch(word)
+	if match == nil {
+		return 0, 0, 0
+	}
+	// A bare number only counts as a time when introduced by an "at" word
+	if _, err := strconv.Atoi(word); err == nil && n == 0 {
+		return 0, 0, 0
+	}
+
+	hour, _ := strconv.Atoi(match[1])
+	min, _ := strconv.Atoi(match[2])
+	switch match[3] {
+	case "am":
+		if hour == 12 {
+			hour = 0
+		}
+	case "pm":
+		if hour < 12 {
+			hour += 12
+		}
+	}
+	if hour > 23 || min > 59 {
+		return 0, 0, 0
+	}
+	return hour, min, consumed
+}
+
+// matchAny returns the length of the first phrase matching tokens at
+// offset i, or 0 when none does
+func matchAny(tokens []string, i int, phrases [][]string) int {
+	for _, phrase := range phrases {
+		if i+len(phrase) > len(tokens) {
+			continue
+		}
+		matched := true
+		for j, word := range phrase {
+			if tokens[i+j] != word {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return len(phrase)
+		}
+	}
+	return 0
+}
+
+// contains reports whether list includes s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// atClock returns t's date at the given hour and minute
+func atClock(t time.Time, hour, min int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), hour, min, 0, 0, t.Location())
+}
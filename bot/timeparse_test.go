@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseNaturalTime(t *testing.T) {
+	// A Wednesday morning
+	now := time.Date(2026, 1, 14, 10, 30, 0, 0, time.UTC)
+	saoPaulo := time.FixedZone("UTC-3", -3*60*60)
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		loc      *time.Location // UTC when nil
+		want     time.Time
+		wantRest string
+		wantErr  bool
+	}{
+		{name: "minutes from now", input: "in 10 minutes stretch", want: now.Add(10 * time.Minute), wantRest: "stretch"},
+		{name: "an hour from now", input: "in an hour call back", want: now.Add(time.Hour), wantRest: "call back"},
+		{name: "short unit", input: "in 2 h check oven", want: now.Add(2 * time.Hour), wantRest: "check oven"},
+		{name: "tomorrow with time", input: "tomorrow at 9pm call mum", want: at(1, 15, 21, 0), wantRest: "call mum"},
+		{name: "tomorrow without time", input: "tomorrow water plants", want: at(1, 15, defaultHour, 0), wantRest: "water plants"},
+		{name: "punctuation and case", input: "Tomorrow, at 9am call", want: at(1, 15, 9, 0), wantRest: "call"},
+		{name: "day after tomorrow at noon", input: "day after tomorrow at noon lunch", want: at(1, 16, 12, 0), wantRest: "lunch"},
+		{name: "next weekday", input: "next tuesday 18:30 gym", want: at(1, 20, 18, 30), wantRest: "gym"},
+		{name: "bare weekday", input: "friday pay rent", want: at(1, 16, defaultHour, 0), wantRest: "pay rent"},
+		{name: "same weekday is next week", input: "wednesday standup", want: at(1, 21, defaultHour, 0), wantRest: "standup"},
+		{name: "clock time still today", input: "at 18:30 dinner", want: at(1, 14, 18, 30), wantRest: "dinner"},
+		{name: "passed clock time is tomorrow", input: "at 9 standup", want: at(1, 15, 9, 0), wantRest: "standup"},
+		{name: "am/pm as a separate word", input: "9 pm news", want: at(1, 14, 21, 0), wantRest: "news"},
+		{name: "midnight as 12am", input: "12am backup", want: at(1, 15, 0, 0), wantRest: "backup"},
+		{name: "ISO date and time", input: "2026-01-31 14:00 pay rent", want: at(1, 31, 14, 0), wantRest: "pay rent"},
+		{name: "ISO date only", input: "2026-01-31 rent", want: at(1, 31, defaultHour, 0), wantRest: "rent"},
+		{name: "whole input is the time", input: "in 5 minutes", want: now.Add(5 * time.Minute), wantRest: ""},
+		{name: "in another timezone", input: "at 8 coffee", loc: saoPaulo, want: time.Date(2026, 1, 14, 8, 0, 0, 0, saoPaulo), wantRest: "coffee"},
+
+		{name: "Portuguese offset", input: "em 2 horas beber água", want: now.Add(2 * time.Hour), wantRest: "beber água"},
+		{name: "Portuguese multi-word offset", input: "dentro de 3 dias viagem", want: now.Add(72 * time.Hour), wantRest: "viagem"},
+		{name: "Portuguese weekday with diacritics", input: "próxima terça às 9 reunião", want: at(1, 20, 9, 0), wantRest: "reunião"},
+		{name: "Portuguese -feira weekday", input: "segunda-feira às 14h dentista", want: at(1, 19, 14, 0), wantRest: "dentista"},
+		{name: "Portuguese tomorrow at midday", input: "amanhã ao meio-dia almoço", want: at(1, 15, 12, 0), wantRest: "almoço"},
+		{name: "Spanish tomorrow", input: "mañana a las 8 correr", want: at(1, 15, 8, 0), wantRest: "correr"},
+		{name: "Spanish day after tomorrow", input: "pasado mañana a las 7 vuelo", want: at(1, 16, 7, 0), wantRest: "vuelo"},
+		{name: "Spanish weekday", input: "el próximo viernes cena", want: at(1, 16, defaultHour, 0), wantRest: "cena"},
+
+		{name: "no time at all", input: "call mum", wantErr: true},
+		{name: "number word count", input: "in ten minutes", wantErr: true},
+		{name: "zero offset", input: "in 0 minutes", wantErr: true},
+		{name: "unknown unit", input: "in 3 fortnights", wantErr: true},
+		{name: "bare number needs an at word", input: "9 call", wantErr: true},
+		{name: "hour out of range", input: "at 25:00 wake", wantErr: true},
+		{name: "minute out of range", input: "at 10:75 wake", wantErr: true},
+		{name: "empty input", input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := tt.loc
+			if loc == nil {
+				loc = time.UTC
+			}
+			got, rest, err := parseNaturalTime(tt.input, now, loc)
+			if tt.wantErr {
+				if !errors.Is(err, errNoTime) {
+					t.Fatalf("parseNaturalTime(%q) error = %v, want errNoTime", tt.input, err)
+				}
+				if rest != tt.input {
+					t.Errorf("parseNaturalTime(%q) rest = %q, want the input back", tt.input, rest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseNaturalTime(%q) error = %v", tt.input, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseNaturalTime(%q) = %s, want %s", tt.input, got, tt.want)
+			}
+			if rest != tt.wantRest {
+				t.Errorf("parseNaturalTime(%q) rest = %q, want %q", tt.input, rest, tt.wantRest)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // the runtime image may not ship a zoneinfo database
+)
+
+// parseTimezone accepts an IANA zone name ("Europe/Lisbon", case-insensitive
+// for the common forms) or "UTC" and returns its canonical name
+func parseTimezone(value string) (string, error) {
+	candidates := []string{value}
+	if strings.EqualFold(value, "utc") {
+		candidates = []string{"UTC"}
+	} else if parts := strings.Split(value, "/"); len(parts) > 1 {
+		for i, part := range parts {
+			words := strings.Split(strings.ToLower(part), "_")
+			for j, word := range words {
+				if word != "" {
+					words[j] = strings.ToUpper(word[:1]) + word[1:]
+				}
+			}
+			parts[i] = strings.Join(words, "_")
+		}
+		candidates = append(candidates, strings.Join(parts, "/"))
+	}
+	for _, name := range candidates {
+		if loc, err := time.LoadLocation(name); err == nil && name != "" && name != "Local" {
+			return loc.String(), nil
+		}
+	}
+	return "", fmt.Errorf("tz must be a timezone like Europe/Lisbon or America/New_York")
+}
+
+// userLocation returns the timezone that applies to who in a chat, set with
+// "!set tz" or "!set my tz", falling back to the server's local time
+func (bot *SignalBot) userLocation(chatID string, who *AgentSender) *time.Location {
+	name := bot.userSetting(chatID, who, "tz")
+	if name == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		bot.logger.Printf("Ignoring unknown timezone %q of chat %s: %v", name, chatID, err)
+		return time.Local
+	}
+	return loc
+}
+
+func init() {
+	chatSettingDefs["tz"] = chatSetting{
+		description: "timezone for reminders and times in replies, e.g. Europe/Lisbon (\"!set my tz\" for just you)",
+		scope:       scopeEither,
+		normalize:   parseTimezone,
+	}
+}
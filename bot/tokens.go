@@ -0,0 +1,31 @@
+package main
+
+import "unicode/utf8"
+
+// estimateTokens approximates how many model tokens text uses, at roughly
+// four characters per token
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// estimateTurnTokens approximates the tokens used by a list of turns
+func estimateTurnTokens(turns []AgentTurn) int {
+	total := 0
+	for _, turn := range turns {
+		total += estimateTokens(turn.Content) + 4 // role and framing overhead
+	}
+	return total
+}
+
+// fitHistory drops the oldest turns until prompt and history together fit
+// within budget tokens; a budget of zero or less keeps everything
+func fitHistory(prompt string, history []AgentTurn, budget int) []AgentTurn {
+	if budget <= 0 {
+		return history
+	}
+	remaining := budget - estimateTokens(prompt)
+	for len(history) > 0 && estimateTurnTokens(history) > remaining {
+		history = history[1:]
+	}
+	return history
+}
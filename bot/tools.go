@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AgentToolResult reports the outcome of a tool the agent asked for
+type AgentToolResult struct {
+	Tool   string `json:"tool"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// agentTool executes one tool invocation of request against signal-cli
+type agentTool func(ctx context.Context, bot *SignalBot, request AgentRequest, call *AgentResponse) (string, error)
+
+// agentTools are the tools a v2 agent may invoke when AGENT_TOOLS_ENABLED is set
+var agentTools = map[string]agentTool{
+	"list_groups":  listGroupsTool,
+	"send_message": sendMessageTool,
+}
+
+// agentToolNames lists the available tools
+func agentToolNames() []string {
+	names := make([]string, 0, len(agentTools))
+	for name := range agentTools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runAgent calls the agent and executes any tool invocations it responds
+// with, feeding the results back until it produces a final text answer
+func (bot *SignalBot) runAgent(ctx context.Context, request AgentRequest) (*AgentResponse, error) {
+	for step := 0; ; step++ {
+		response, err := bot.callAgent(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		if response.Tool == "" || !bot.toolsEnabled(request) {
+			return response, nil
+		}
+
+		if step >= bot.config.AgentMaxToolSteps {
+			return nil, fmt.Errorf("agent exceeded %d tool steps", bot.config.AgentMaxToolSteps)
+		}
+
+		result := AgentToolResult{Tool: response.Tool}
+		tool, exists := agentTools[response.Tool]
+		if !exists {
+			result.Error = "unknown tool"
+		} else {
+			bot.logger.Printf("Agent requested tool %s", response.Tool)
+			output, err := tool(ctx, bot, request, response)
+			if err != nil {
+				result.Error = err.Error()
+			}
+			result.Output = output
+		}
+		request.ToolResults = append(request.ToolResults, result)
+	}
+}
+
+// listGroupsTool returns the groups the account is a member of as JSON
+func listGroupsTool(ctx context.Context, bot *SignalBot, request AgentRequest, call *AgentResponse) (string, error) {
+	var stdout, stderr bytes.Buffer
+	if err := runSignalCLI(ctx, bot.config.SignalSendTimeout, &stdout, &stderr, "--output=json", "listGroups"); err != nil {
+		return "", fmt.Errorf("failed to list groups: %w (stderr: %s)", err, stderr.String())
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// sendMessageTool sends text to the chat of the request or to one of the
+// AGENT_FORWARD_TARGETS names, never anywhere else: the account is a
+// personal number, and prompts can carry injected instructions
+func sendMessageTool(ctx context.Context, bot *SignalBot, request AgentRequest, call *AgentResponse) (string, error) {
+	if call.To == "" || call.Text == "" {
+		return "", errors.New("send_message requires \"to\" and \"text\"")
+	}
+
+	recipient, exists := bot.config.AgentForwardTargets[call.To]
+	if !exists {
+		if request.Chat == nil || call.To != requestChatTarget(*request.Chat) {
+			return "", fmt.Errorf("send_message can only reach this chat or an AGENT_FORWARD_TARGETS name, not %q", call.To)
+		}
+		recipient = request.Chat.Recipient
+		if request.Chat.GroupID != "" {
+			recipient = "-g " + request.Chat.GroupID
+		}
+	}
+
+	bot.spaceBroadcast(recipient)
+	if err := bot.sendReply(recipient, bot.redactTo(recipient, []string{call.Text})[0], 0, ""); err != nil {
+		return "", err
+	}
+	return "sent", nil
+}
+
+// requestChatTarget is how the send_message tool names the chat of a
+// request: "group:<id>" or the DM's number
+func requestChatTarget(chat AgentChat) string {
+	if chat.GroupID != "" {
+		return "group:" + chat.GroupID
+	}
+	return chat.Recipient
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// languageNames maps the language codes the bot understands to their
+// English names
+var languageNames = map[string]string{
+	"ar": "Arabic", "bn": "Bengali", "ca": "Catalan", "cs": "Czech", "da": "Danish",
+	"de": "German", "el": "Greek", "en": "English", "es": "Spanish", "fa": "Persian",
+	"fi": "Finnish", "fr": "French", "he": "Hebrew", "hi": "Hindi", "hu": "Hungarian",
+	"id": "Indonesian", "it": "Italian", "ja": "Japanese", "ko": "Korean", "nl": "Dutch",
+	"no": "Norwegian", "pl": "Polish", "pt": "Portuguese", "ro": "Romanian", "ru": "Russian",
+	"sk": "Slovak", "sv": "Swedish", "th": "Thai", "tr": "Turkish", "uk": "Ukrainian",
+	"ur": "Urdu", "vi": "Vietnamese", "zh": "Chinese",
+}
+
+// parseLanguage accepts a language code ("de", "pt-BR") or English name
+// ("german") and returns its code
+func parseLanguage(value string) (string, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	code := strings.SplitN(strings.ReplaceAll(value, "_", "-"), "-", 2)[0]
+	if _, known := languageNames[code]; known {
+		return code, true
+	}
+	for code, name := range languageNames {
+		if strings.ToLower(name) == value {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// languageName returns the English name of a language code
+func languageName(code string) string {
+	if name, known := languageNames[code]; known {
+		return name
+	}
+	return code
+}
+
+// translatePrompt asks the agent for a translation and the detected
+// source language
+const translatePrompt = `Translate the text below into %s. Reply with the English name of the language it is written in on the first line, then the translation, and nothing else.
+
+`
+
+// translation is a translated text and the language it was detected in
+type translation struct {
+	Text   string
+	Source string // language code or name, empty if unknown
+}
+
+// translate translates text into the target language with TRANSLATE_URL
+// when set, otherwise with the agent through promptAgent
+func (bot *SignalBot) translate(ctx context.Context, msg *Message, text, target string) (translation, error) {
+	if bot.config.TranslateURL != "" {
+		return bot.translateWithService(ctx, text, target)
+	}
+
+	response, err := bot.promptAgent(ctx, msg, AgentRequest{Prompt: fmt.Sprintf(translatePrompt, languageName(target)) + text})
+	if err != nil {
+		return translation{}, err
+	}
+	reply := strings.TrimSpace(strings.Join(response.replies(), "\n"))
+	source, translated, found := strings.Cut(reply, "\n")
+	if !found {
+		return translation{Text: reply}, nil
+	}
+	if code, ok := parseLanguage(strings.Trim(source, " .:*")); ok {
+		source = code
+	}
+	return translation{Text: strings.TrimSpace(translated), Source: source}, nil
+}
+
+// translateWithService calls a LibreTranslate-compatible endpoint
+// (POST {"q", "source": "auto", "target"}, response {"translatedText",
+// "detectedLanguage": {"language"}})
+func (bot *SignalBot) translateWithService(ctx context.Context, text, target string) (translation, error) {
+	payload := map[string]string{"q": text, "source": "auto", "target": target, "format": "text"}
+	if bot.config.TranslateAPIKey != "" {
+		payload["api_key"] = bot.config.TranslateAPIKey
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return translation{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", bot.config.TranslateURL, bytes.NewReader(body))
+	if err != nil {
+		return translation{}, fmt.Errorf("failed to create translation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := bot.httpClient.Do(req)
+	if err != nil {
+		return translation{}, fmt.Errorf("failed to call translation service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return translation{}, fmt.Errorf("translation service returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return translation{}, fmt.Errorf("failed to decode translation: %w", err)
+	}
+	return translation{Text: result.TranslatedText, Source: result.DetectedLanguage.Language}, nil
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "translate",
+		usage:   "!translate <language> <text> | reply to a message with !translate <language>",
+		handler: translateCommand,
+	})
+}
+
+// translateCommand translates the given text, or the quoted message when
+// there is none
+func translateCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	if len(args) == 0 {
+		return "Usage: " + commands["translate"].usage
+	}
+	target, ok := parseLanguage(args[0])
+	if !ok {
+		return fmt.Sprintf("I don't know the language %q. Use a code like de, pt or ja.", args[0])
+	}
+
+	text := commandText(msg.extractContent(), 2)
+	if text == "" {
+		if q := msg.quote(); q != nil {
+			text = q.Text
+		}
+	}
+	if text == "" {
+		return "Usage: " + commands["translate"].usage
+	}
+	if bot.config.TranslateURL == "" && !bot.promptAllowed(msg, true) {
+		return ""
+	}
+
+	bot.replyInBackground(msg, func() string {
+		result, err := bot.translate(ctx, msg, text, target)
+		if err != nil {
+			bot.logger.Printf("Error translating: %v", err)
+			if errors.Is(err, errModerated) {
+				return bot.errorMessage(msg.chatID(), errorModerated, nil)
+			}
+			return "Sorry, I couldn't translate that right now."
+		}
+		text := bot.redactReply(msg, result.Text)
+		if result.Source == "" || result.Source == target {
+			return "🌐 " + text
+		}
+		return fmt.Sprintf("🌐 (from %s) %s", languageName(result.Source), text)
+	})
+	return ""
+}
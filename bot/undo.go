@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// undoEmoji cancels a reply during its chat's undo window
+const undoEmoji = "❌"
+
+// undoWindows tracks replies held back by a chat's "undo" setting, keyed by
+// the timestamp of the prompt they answer
+type undoWindows struct {
+	mu      sync.Mutex
+	pending map[int64]*undoEntry
+}
+
+type undoEntry struct {
+	asker     AgentSender
+	cancelled chan struct{}
+}
+
+func newUndoWindows() *undoWindows {
+	return &undoWindows{pending: make(map[int64]*undoEntry)}
+}
+
+// open starts an undo window for the reply to the prompt sent at timestamp
+func (u *undoWindows) open(timestamp int64, asker AgentSender) *undoEntry {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	entry := &undoEntry{asker: asker, cancelled: make(chan struct{})}
+	u.pending[timestamp] = entry
+	return entry
+}
+
+// close ends the window for timestamp
+func (u *undoWindows) close(timestamp int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.pending, timestamp)
+}
+
+// Cancel drops the held reply to the prompt sent at timestamp if reactor
+// wrote that prompt, reporting whether a reply was cancelled
+func (u *undoWindows) Cancel(timestamp int64, reactor AgentSender) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	entry, exists := u.pending[timestamp]
+	if !exists || !sameSender(entry.asker, reactor) {
+		return false
+	}
+	delete(u.pending, timestamp)
+	close(entry.cancelled)
+	return true
+}
+
+// sameSender reports whether a and b are the same person
+func sameSender(a, b AgentSender) bool {
+	return (a.UUID != "" && a.UUID == b.UUID) || (a.Number != "" && a.Number == b.Number)
+}
+
+// undoDelay returns how long replies in a chat wait before being sent
+func (bot *SignalBot) undoDelay(chatID string) time.Duration {
+	d, err := time.ParseDuration(bot.settings.Get(chatID, "undo"))
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// holdForUndo waits out the chat's undo window before a reply is sent and
+// reports whether the reply should still go out
+func (bot *SignalBot) holdForUndo(ctx context.Context, request AgentRequest) bool {
+	if request.Chat == nil || request.Sender == nil || request.Timestamp == 0 {
+		return true
+	}
+	delay := bot.undoDelay(conversationID(*request.Chat))
+	if delay <= 0 {
+		return true
+	}
+
+	entry := bot.undo.open(request.Timestamp, *request.Sender)
+	defer bot.undo.close(request.Timestamp)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-entry.cancelled:
+		bot.logger.Printf("Reply to prompt %d cancelled by its author", request.Timestamp)
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func init() {
+	registerReactionHandler(undoEmoji, func(ctx context.Context, bot *SignalBot, msg *Message, reaction *Reaction) {
+		if !bot.undo.Cancel(reaction.TargetSentTimestamp, msg.sender()) {
+			return
+		}
+		if err := bot.sendReply(msg.replyRecipient(), bot.localize(msg.chatID(), "Reply cancelled."), reaction.TargetSentTimestamp, reaction.TargetAuthor); err != nil {
+			bot.logger.Printf("Error confirming cancellation: %v", err)
+		}
+	})
+}
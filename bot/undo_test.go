@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestHoldForUndo(t *testing.T) {
+	asker := AgentSender{Number: "+15550001", UUID: "u-1"}
+	chat := &AgentChat{GroupID: "abc"}
+
+	tests := []struct {
+		name     string
+		undo     string // the chat's "undo" setting
+		reactor  *AgentSender
+		cancel   bool // cancel the context instead of reacting
+		wantSend bool
+	}{
+		{name: "no undo window", undo: "", wantSend: true},
+		{name: "window passes", undo: "30ms", wantSend: true},
+		{name: "asker cancels", undo: "5s", reactor: &asker},
+		{name: "asker cancels by UUID", undo: "5s", reactor: &AgentSender{UUID: "u-1"}},
+		{name: "someone else can't cancel", undo: "200ms", reactor: &AgentSender{Number: "+15550002"}, wantSend: true},
+		{name: "shutdown drops the reply", undo: "5s", cancel: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := &SignalBot{logger: log.New(io.Discard, "", 0), settings: newChatSettings("chat"), undo: newUndoWindows()}
+			if err := bot.settings.Set(conversationID(*chat), "undo", tt.undo); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			request := AgentRequest{Chat: chat, Sender: &asker, Timestamp: 1000}
+			isOpen := func() bool {
+				bot.undo.mu.Lock()
+				defer bot.undo.mu.Unlock()
+				_, open := bot.undo.pending[request.Timestamp]
+				return open
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan bool)
+			go func() { done <- bot.holdForUndo(ctx, request) }()
+
+			if tt.reactor != nil || tt.cancel {
+				// Wait for the window to open before reacting
+				for !isOpen() {
+					time.Sleep(time.Millisecond)
+				}
+				if tt.cancel {
+					cancel()
+				} else if cancelled := bot.undo.Cancel(request.Timestamp, *tt.reactor); cancelled == tt.wantSend {
+					t.Errorf("Cancel() = %t, want %t", cancelled, !tt.wantSend)
+				}
+			}
+
+			select {
+			case send := <-done:
+				if send != tt.wantSend {
+					t.Errorf("holdForUndo() = %t, want %t", send, tt.wantSend)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("holdForUndo() didn't return")
+			}
+			if isOpen() {
+				t.Error("undo window still open after holdForUndo returned")
+			}
+		})
+	}
+}
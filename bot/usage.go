@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keys of a usage record: totals since the first request, and since the
+// last periodic summary
+const (
+	usageTotal  = "total"
+	usagePeriod = "period"
+)
+
+// usageTopN is how many chats and users "!usage all" and the summary list
+const usageTopN = 10
+
+// usageCounts is the agent usage of one chat or user
+type usageCounts struct {
+	Requests  int
+	Tokens    int
+	LatencyMS int64 // summed over all requests
+}
+
+// parseUsageCounts reads counts stored as "<requests>/<tokens>/<latency ms>"
+func parseUsageCounts(value string) usageCounts {
+	parts := strings.Split(value, "/")
+	if len(parts) != 3 {
+		return usageCounts{}
+	}
+	var counts usageCounts
+	counts.Requests, _ = strconv.Atoi(parts[0])
+	counts.Tokens, _ = strconv.Atoi(parts[1])
+	counts.LatencyMS, _ = strconv.ParseInt(parts[2], 10, 64)
+	return counts
+}
+
+func (c usageCounts) String() string {
+	return fmt.Sprintf("%d/%d/%d", c.Requests, c.Tokens, c.LatencyMS)
+}
+
+// add returns c with one more request
+func (c usageCounts) add(tokens int, latency time.Duration) usageCounts {
+	return usageCounts{Requests: c.Requests + 1, Tokens: c.Tokens + tokens, LatencyMS: c.LatencyMS + latency.Milliseconds()}
+}
+
+// describe summarizes the counts for people
+func (c usageCounts) describe() string {
+	if c.Requests == 0 {
+		return "no requests"
+	}
+	noun := "requests"
+	if c.Requests == 1 {
+		noun = "request"
+	}
+	return fmt.Sprintf("%d %s, ~%d tokens, %s average", c.Requests, noun, c.Tokens,
+		(time.Duration(c.LatencyMS/int64(c.Requests)) * time.Millisecond).Round(100*time.Millisecond))
+}
+
+// usageStats records agent usage per chat ("chat:<chat ID>") and per user
+// ("user:<number or UUID>"), persisted in the state store
+type usageStats struct {
+	records *chatSettings
+}
+
+func newUsageStats() *usageStats {
+	return &usageStats{records: newChatSettings("usage")}
+}
+
+// Record counts one answered prompt of user in chatID
+func (u *usageStats) Record(chatID, user string, tokens int, latency time.Duration) error {
+	for _, id := range []string{"chat:" + chatID, "user:" + user} {
+		if id == "chat:" || id == "user:" {
+			continue
+		}
+		for _, key := range []string{usageTotal, usagePeriod} {
+			counts := parseUsageCounts(u.records.Get(id, key)).add(tokens, latency)
+			if err := u.records.Set(id, key, counts.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Get returns the usage recorded under id for key
+func (u *usageStats) Get(id, key string) usageCounts {
+	return parseUsageCounts(u.records.Get(id, key))
+}
+
+// Top returns the IDs with prefix using the most tokens under key, at most
+// n of them, along with the sum over every ID with prefix
+func (u *usageStats) Top(prefix, key string, n int) ([]string, usageCounts) {
+	var ids []string
+	var sum usageCounts
+	for _, id := range u.records.IDsWith(key) {
+		if !strings.HasPrefix(id, prefix) {
+			continue
+		}
+		ids = append(ids, id)
+		counts := u.Get(id, key)
+		sum = usageCounts{Requests: sum.Requests + counts.Requests, Tokens: sum.Tokens + counts.Tokens, LatencyMS: sum.LatencyMS + counts.LatencyMS}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := u.Get(ids[i], key), u.Get(ids[j], key)
+		if a.Tokens != b.Tokens {
+			return a.Tokens > b.Tokens
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+	return ids, sum
+}
+
+// Report lists the totals and top chats and users under key
+func (u *usageStats) Report(key string) string {
+	chats, total := u.Top("chat:", key, usageTopN)
+	users, _ := u.Top("user:", key, usageTopN)
+	if total.Requests == 0 {
+		return "No agent usage recorded."
+	}
+
+	lines := []string{"All chats: " + total.describe(), "", "Top chats:"}
+	for _, id := range chats {
+		lines = append(lines, fmt.Sprintf("- %s: %s", strings.TrimPrefix(id, "chat:"), u.Get(id, key).describe()))
+	}
+	lines = append(lines, "", "Top users:")
+	for _, id := range users {
+		lines = append(lines, fmt.Sprintf("- %s: %s", strings.TrimPrefix(id, "user:"), u.Get(id, key).describe()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ResetPeriod starts a new summary period
+func (u *usageStats) ResetPeriod() error {
+	for _, id := range u.records.IDsWith(usagePeriod) {
+		if err := u.records.Set(id, usagePeriod, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordUsage counts an answered prompt for its chat and asker
+func (bot *SignalBot) recordUsage(request AgentRequest, result agentAnswer, latency time.Duration) {
+	user := ""
+	if request.Sender != nil {
+		user = quotaID(request.Sender)
+	}
+	if err := bot.usage.Record(requestChatID(request), user, result.Tokens, latency); err != nil {
+		bot.logger.Printf("Error saving usage: %v", err)
+	}
+}
+
+// runUsageSummary sends the usage since the previous summary to the admin
+// every USAGE_SUMMARY_INTERVAL
+func (bot *SignalBot) runUsageSummary(ctx context.Context) error {
+	ticker := time.NewTicker(bot.config.UsageSummaryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, total := bot.usage.Top("chat:", usagePeriod, 0); total.Requests == 0 {
+				continue
+			}
+			bot.notifyAdmin(adminEventUsage, fmt.Sprintf("Agent usage over the last %s:\n%s", bot.config.UsageSummaryInterval, bot.usage.Report(usagePeriod)))
+			if err := bot.usage.ResetPeriod(); err != nil {
+				bot.logger.Printf("Error resetting usage period: %v", err)
+			}
+		}
+	}
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "usage",
+		usage:   "!usage | !usage all",
+		handler: usageCommand,
+	})
+}
+
+// usageCommand reports the asker's own agent usage, or everyone's to
+// admins with "!usage all"
+func usageCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	if len(args) > 0 && strings.EqualFold(args[0], "all") {
+		if !bot.isAdmin(msg) {
+			return "Only admins can see everyone's usage."
+		}
+		return bot.usage.Report(usageTotal)
+	}
+
+	sender := msg.sender()
+	id := quotaID(&sender)
+	reply := "Your usage: " + bot.usage.Get("user:"+id, usageTotal).describe() + "."
+	if bot.hasQuota(msg) {
+		reply += fmt.Sprintf("\nToday: ~%d of %d tokens.", bot.quotas.Used(id, time.Now()), bot.config.TokenQuotaDaily)
+	}
+	return reply
+}
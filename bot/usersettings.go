@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// settingScope says where a setting's value lives
+type settingScope int
+
+const (
+	scopeChat     settingScope = iota // one value for the whole chat
+	scopePersonal                     // each user has their own value in each chat
+	scopeEither                       // a chat-wide value that users can override for themselves with "!set my"
+)
+
+// personalID is the settings ID of a user's own values in a chat, keyed by
+// UUID so it survives number changes
+func personalID(who AgentSender, chatID string) string {
+	key := memberKey(who)
+	if key == "" || chatID == "" {
+		return ""
+	}
+	return "user:" + key + "@" + chatID
+}
+
+// isPersonalIDOf reports whether a settings ID holds personal values of who
+func isPersonalIDOf(id string, who AgentSender) bool {
+	for _, key := range []string{who.UUID, who.Number} {
+		if key != "" && strings.HasPrefix(id, "user:"+key+"@") {
+			return true
+		}
+	}
+	return false
+}
+
+// userSetting returns the value of key that applies to who in a chat: their
+// own value for personal settings, falling back to the chat's
+func (bot *SignalBot) userSetting(chatID string, who *AgentSender, key string) string {
+	if who != nil && chatSettingDefs[key].scope != scopeChat {
+		if value := bot.settings.Get(personalID(*who, chatID), key); value != "" {
+			return value
+		}
+	}
+	if chatSettingDefs[key].scope == scopePersonal {
+		return ""
+	}
+	return bot.settings.Get(chatID, key)
+}
+
+// settingTarget picks the settings ID "!set" stores key under: personal
+// settings always go to the sender's own values, and "!set my <key>"
+// overrides a chat setting for the sender only. When the key can't be set
+// that way it returns the reply explaining why instead.
+func settingTarget(msg *Message, chatID, key string, mine bool) (string, string) {
+	switch scope := chatSettingDefs[key].scope; {
+	case scope == scopePersonal, scope == scopeEither && mine:
+		id := personalID(msg.sender(), chatID)
+		if id == "" {
+			return "", "Sorry, I can't tell who you are."
+		}
+		return id, ""
+	case mine:
+		return "", fmt.Sprintf("%s applies to the whole chat and can't be set just for you.", key)
+	default:
+		return chatID, ""
+	}
+}
+
+// parseQuietHours parses "22:00-07:00" into minutes after midnight
+func parseQuietHours(value string) (int, int, error) {
+	from, to, found := strings.Cut(value, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("quiet must look like 22:00-07:00, or off")
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, fmt.Errorf("quiet must look like 22:00-07:00, or off")
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil || start.Equal(end) {
+		return 0, 0, fmt.Errorf("quiet must look like 22:00-07:00, or off")
+	}
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+}
+
+// quietUntil returns when quiet hours that include now end, or the zero
+// time when now isn't within them
+func quietUntil(value string, now time.Time) time.Time {
+	start, end, err := parseQuietHours(value)
+	if err != nil {
+		return time.Time{}
+	}
+	minute := now.Hour()*60 + now.Minute()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch {
+	case start < end && minute >= start && minute < end:
+		return midnight.Add(time.Duration(end) * time.Minute)
+	case start > end && minute >= start:
+		return midnight.AddDate(0, 0, 1).Add(time.Duration(end) * time.Minute)
+	case start > end && minute < end:
+		return midnight.Add(time.Duration(end) * time.Minute)
+	}
+	return time.Time{}
+}
+
+// wantsNotices reports whether who wants the bot's status notes in a chat,
+// such as "queued behind your previous question"
+func (bot *SignalBot) wantsNotices(chatID string, who *AgentSender) bool {
+	return bot.userSetting(chatID, who, "notices") != "off"
+}
+
+func init() {
+	chatSettingDefs["quiet"] = chatSetting{
+		description: "your quiet hours, e.g. 22:00-07:00: reminders you set wait until they end",
+		scope:       scopePersonal,
+		normalize: func(value string) (string, error) {
+			if strings.EqualFold(value, "off") {
+				return "", nil
+			}
+			if _, _, err := parseQuietHours(value); err != nil {
+				return "", err
+			}
+			return strings.ReplaceAll(value, " ", ""), nil
+		},
+	}
+	chatSettingDefs["notices"] = chatSetting{
+		description: "on/off: status notes for you, like when your question is queued",
+		scope:       scopePersonal,
+		normalize: func(value string) (string, error) {
+			switch strings.ToLower(value) {
+			case "on", "off":
+				return strings.ToLower(value), nil
+			}
+			return "", fmt.Errorf("notices must be on or off")
+		},
+	}
+
+	registerCommand(&command{
+		name:    "get",
+		usage:   "!get <key>",
+		handler: getCommand,
+	})
+	registerCommand(&command{
+		name:    "settings",
+		usage:   "!settings",
+		handler: settingsCommand,
+	})
+}
+
+// getCommand shows the value of one setting for the sender
+func getCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	if len(args) == 0 {
+		return "Usage: " + commands["get"].usage
+	}
+	chatID := msg.chatID()
+	if chatID == "" {
+		return "Sorry, I can't tell which chat this is."
+	}
+	key := strings.ToLower(args[0])
+	if _, exists := chatSettingDefs[key]; !exists {
+		return fmt.Sprintf("Unknown setting %q. Send !settings to list them.", args[0])
+	}
+
+	sender := msg.sender()
+	value := bot.userSetting(chatID, &sender, key)
+	if value == "" {
+		value = "default"
+	}
+	if chatSettingDefs[key].scope != scopeChat && bot.settings.Get(personalID(sender, chatID), key) != "" {
+		return fmt.Sprintf("%s = %s (yours)", key, value)
+	}
+	return fmt.Sprintf("%s = %s", key, value)
+}
+
+// settingsCommand lists every setting with the value that applies to the
+// sender in this chat
+func settingsCommand(ctx context.Context, bot *SignalBot, msg *Message, args []string) string {
+	chatID := msg.chatID()
+	if chatID == "" {
+		return "Sorry, I can't tell which chat this is."
+	}
+
+	keys := make([]string, 0, len(chatSettingDefs))
+	for key := range chatSettingDefs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sender := msg.sender()
+	own := personalID(sender, chatID)
+	lines := []string{"Usage: " + commands["set"].usage, "Settings for this chat:"}
+	for _, key := range keys {
+		value := bot.userSetting(chatID, &sender, key)
+		if value == "" {
+			value = "default"
+		}
+		if chatSettingDefs[key].scope != scopeChat && bot.settings.Get(own, key) != "" {
+			value += ", yours"
+		}
+		lines = append(lines, fmt.Sprintf("%s = %s (%s)", key, value, chatSettingDefs[key].description))
+	}
+	return strings.Join(lines, "\n")
+}
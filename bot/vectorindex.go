@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// vectorIndexVersion is the current storage format of vector indexes.
+// Bump it when the stored layout changes and migrate older versions on load.
+const vectorIndexVersion = 2
+
+// vectorIndex stores embedded chunks per chat for similarity search
+type vectorIndex interface {
+	// Load opens the index, re-embedding stored chunks with e when they were
+	// embedded by a different embedder
+	Load(ctx context.Context, e embedder) error
+	Add(chatID string, chunks ...KnowledgeChunk) error
+	Search(chatID string, vector []float32, n int) []AgentDocument
+	CountAddedBy(who AgentSender) int
+	ForgetAddedBy(who AgentSender) error
+	Close() error
+}
+
+// vectorIndexes maps VECTOR_STORE values to index constructors. The JSON
+// index is always available; others register from build-tagged files.
+var vectorIndexes = map[string]func(bot *SignalBot) (vectorIndex, error){
+	"json": func(bot *SignalBot) (vectorIndex, error) {
+		return newJSONVectorIndex(filepath.Join(bot.config.DataDir, "knowledge.json"), bot.config.KnowledgeMaxChunks), nil
+	},
+}
+
+// registerVectorIndex makes an index backend selectable with VECTOR_STORE
+func registerVectorIndex(name string, open func(bot *SignalBot) (vectorIndex, error)) {
+	vectorIndexes[name] = open
+}
+
+// openVectorIndex creates the index selected by VECTOR_STORE
+func (bot *SignalBot) openVectorIndex() (vectorIndex, error) {
+	open, exists := vectorIndexes[bot.config.VectorStore]
+	if !exists {
+		names := make([]string, 0, len(vectorIndexes))
+		for name := range vectorIndexes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown VECTOR_STORE %q (available in this build: %s)", bot.config.VectorStore, strings.Join(names, ", "))
+	}
+	return open(bot)
+}
+
+// reembed replaces the vectors of chunks using e
+func reembed(ctx context.Context, e embedder, chunks []KnowledgeChunk) error {
+	const batch = 64
+	for start := 0; start < len(chunks); start += batch {
+		end := min(start+batch, len(chunks))
+		texts := make([]string, 0, end-start)
+		for _, chunk := range chunks[start:end] {
+			texts = append(texts, chunk.Text)
+		}
+		vectors, err := e.Embed(ctx, texts)
+		if err != nil {
+			return err
+		}
+		for i := range vectors {
+			chunks[start+i].Vector = vectors[i]
+		}
+	}
+	return nil
+}
+
+// rankChunks scores chunks against vector and returns the best n
+func rankChunks(chunks []KnowledgeChunk, vector []float32, n int) []AgentDocument {
+	docs := make([]AgentDocument, 0, len(chunks))
+	for _, chunk := range chunks {
+		docs = append(docs, AgentDocument{Source: chunk.Source, Text: chunk.Text, Score: cosine(vector, chunk.Vector)})
+	}
+	sort.SliceStable(docs, func(i, j int) bool { return docs[i].Score > docs[j].Score })
+	if len(docs) > n {
+		docs = docs[:n]
+	}
+	return docs
+}
+
+// jsonVectorIndexFile is the versioned on-disk layout of jsonVectorIndex
+type jsonVectorIndexFile struct {
+	Version  int                         `json:"version"`
+	Embedder string                      `json:"embedder"`
+	Chats    map[string][]KnowledgeChunk `json:"chats"`
+}
+
+// jsonVectorIndex keeps each chat's chunks in memory, persisted as one JSON
+// document unless its path is empty
+type jsonVectorIndex struct {
+	mu        sync.Mutex
+	path      string
+	maxChunks int
+	embedder  string
+	chats     map[string][]KnowledgeChunk // chat ID -> chunks, oldest first
+}
+
+func newJSONVectorIndex(path string, maxChunks int) *jsonVectorIndex {
+	return &jsonVectorIndex{path: path, maxChunks: maxChunks, chats: make(map[string][]KnowledgeChunk)}
+}
+
+// Load implements vectorIndex; a missing file means an empty index
+func (x *jsonVectorIndex) Load(ctx context.Context, e embedder) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.embedder = e.Name()
+
+	data, err := os.ReadFile(x.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var file jsonVectorIndexFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Version == 0 {
+		// Version 0 was a bare chat -> chunks map embedded by hash-512
+		file = jsonVectorIndexFile{Embedder: "hash-512"}
+		if err := json.Unmarshal(data, &file.Chats); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", x.path, err)
+		}
+	} else if file.Version > vectorIndexVersion {
+		return fmt.Errorf("%s has format version %d, newer than this bot supports (%d)", x.path, file.Version, vectorIndexVersion)
+	}
+	if file.Chats == nil {
+		file.Chats = make(map[string][]KnowledgeChunk)
+	}
+	x.chats = file.Chats
+
+	if file.Embedder != x.embedder || file.Version != vectorIndexVersion {
+		if file.Embedder != x.embedder {
+			for _, chunks := range x.chats {
+				if err := reembed(ctx, e, chunks); err != nil {
+					return fmt.Errorf("failed to re-embed knowledge for %s: %w", x.embedder, err)
+				}
+			}
+		}
+		return x.save()
+	}
+	return nil
+}
+
+// Add implements vectorIndex, dropping the oldest chunks beyond maxChunks
+func (x *jsonVectorIndex) Add(chatID string, chunks ...KnowledgeChunk) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	list := append(x.chats[chatID], chunks...)
+	if x.maxChunks > 0 && len(list) > x.maxChunks {
+		list = list[len(list)-x.maxChunks:]
+	}
+	x.chats[chatID] = list
+	return x.save()
+}
+
+// Search implements vectorIndex
+func (x *jsonVectorIndex) Search(chatID string, vector []float32, n int) []AgentDocument {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return rankChunks(x.chats[chatID], vector, n)
+}
+
+// CountAddedBy implements vectorIndex
+func (x *jsonVectorIndex) CountAddedBy(who AgentSender) int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	count := 0
+	for _, list := range x.chats {
+		for _, chunk := range list {
+			if chunk.addedBy(who) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// ForgetAddedBy implements vectorIndex
+func (x *jsonVectorIndex) ForgetAddedBy(who AgentSender) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for chatID, list := range x.chats {
+		kept := list[:0]
+		for _, chunk := range list {
+			if !chunk.addedBy(who) {
+				kept = append(kept, chunk)
+			}
+		}
+		if len(kept) == 0 {
+			delete(x.chats, chatID)
+		} else {
+			x.chats[chatID] = kept
+		}
+	}
+	return x.save()
+}
+
+// Close implements vectorIndex
+func (x *jsonVectorIndex) Close() error {
+	return nil
+}
+
+// save writes the index file; callers must hold x.mu
+func (x *jsonVectorIndex) save() error {
+	if x.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(jsonVectorIndexFile{Version: vectorIndexVersion, Embedder: x.embedder, Chats: x.chats})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(x.path, data)
+}
@@ -0,0 +1,213 @@
+//go:build vectorstore
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	registerVectorIndex("sqlite", func(bot *SignalBot) (vectorIndex, error) {
+		return &sqliteVectorIndex{
+			path:      filepath.Join(bot.config.DataDir, "knowledge.db"),
+			maxChunks: bot.config.KnowledgeMaxChunks,
+		}, nil
+	})
+}
+
+// sqliteVectorIndex stores chunks in SQLite with vectors as little-endian
+// float32 blobs. Search is a brute-force cosine scan of the chat's rows,
+// which stays fast for the few thousand passages a chat accumulates.
+type sqliteVectorIndex struct {
+	mu        sync.Mutex
+	path      string
+	maxChunks int
+	db        *sql.DB
+}
+
+// Load implements vectorIndex, creating or migrating the schema
+func (x *sqliteVectorIndex) Load(ctx context.Context, e embedder) error {
+	db, err := sql.Open("sqlite", x.path)
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(1)
+	x.db = db
+
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS meta (key TEXT PRIMARY KEY, value TEXT NOT NULL);
+		CREATE TABLE IF NOT EXISTS chunks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id TEXT NOT NULL,
+			source TEXT NOT NULL,
+			text TEXT NOT NULL,
+			vector BLOB NOT NULL,
+			added_by TEXT NOT NULL DEFAULT '',
+			added_by_uuid TEXT NOT NULL DEFAULT '',
+			added_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS chunks_chat ON chunks (chat_id, id);
+	`); err != nil {
+		return fmt.Errorf("failed to create knowledge schema: %w", err)
+	}
+
+	version, stored := 0, ""
+	db.QueryRowContext(ctx, `SELECT value FROM meta WHERE key = 'version'`).Scan(&version)
+	db.QueryRowContext(ctx, `SELECT value FROM meta WHERE key = 'embedder'`).Scan(&stored)
+	if version > vectorIndexVersion {
+		return fmt.Errorf("%s has format version %d, newer than this bot supports (%d)", x.path, version, vectorIndexVersion)
+	}
+	if version == 1 {
+		// Version 1 didn't record who added a chunk by UUID
+		if _, err := db.ExecContext(ctx, `ALTER TABLE chunks ADD COLUMN added_by_uuid TEXT NOT NULL DEFAULT ''`); err != nil {
+			return fmt.Errorf("failed to migrate knowledge schema: %w", err)
+		}
+	}
+
+	if stored != "" && stored != e.Name() {
+		if err := x.reembedAll(ctx, e); err != nil {
+			return fmt.Errorf("failed to re-embed knowledge for %s: %w", e.Name(), err)
+		}
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT OR REPLACE INTO meta (key, value) VALUES ('version', ?), ('embedder', ?)`,
+		fmt.Sprint(vectorIndexVersion), e.Name())
+	return err
+}
+
+// reembedAll recomputes every stored vector with e
+func (x *sqliteVectorIndex) reembedAll(ctx context.Context, e embedder) error {
+	rows, err := x.db.QueryContext(ctx, `SELECT id, text FROM chunks`)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	var chunks []KnowledgeChunk
+	for rows.Next() {
+		var id int64
+		var chunk KnowledgeChunk
+		if err := rows.Scan(&id, &chunk.Text); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		chunks = append(chunks, chunk)
+	}
+	rows.Close()
+
+	if err := reembed(ctx, e, chunks); err != nil {
+		return err
+	}
+
+	tx, err := x.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, chunk := range chunks {
+		if _, err := tx.ExecContext(ctx, `UPDATE chunks SET vector = ? WHERE id = ?`, encodeVector(chunk.Vector), ids[i]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Add implements vectorIndex, dropping the oldest chunks beyond maxChunks
+func (x *sqliteVectorIndex) Add(chatID string, chunks ...KnowledgeChunk) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	tx, err := x.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, chunk := range chunks {
+		if _, err := tx.Exec(`INSERT INTO chunks (chat_id, source, text, vector, added_by, added_by_uuid, added_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			chatID, chunk.Source, chunk.Text, encodeVector(chunk.Vector), chunk.AddedBy, chunk.AddedByUUID, chunk.AddedAt.UnixMilli()); err != nil {
+			return err
+		}
+	}
+	if x.maxChunks > 0 {
+		if _, err := tx.Exec(`DELETE FROM chunks WHERE chat_id = ? AND id NOT IN
+			(SELECT id FROM chunks WHERE chat_id = ? ORDER BY id DESC LIMIT ?)`, chatID, chatID, x.maxChunks); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Search implements vectorIndex
+func (x *sqliteVectorIndex) Search(chatID string, vector []float32, n int) []AgentDocument {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	rows, err := x.db.Query(`SELECT source, text, vector, added_by, added_by_uuid, added_at FROM chunks WHERE chat_id = ?`, chatID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var chunks []KnowledgeChunk
+	for rows.Next() {
+		var chunk KnowledgeChunk
+		var blob []byte
+		var addedAt int64
+		if err := rows.Scan(&chunk.Source, &chunk.Text, &blob, &chunk.AddedBy, &chunk.AddedByUUID, &addedAt); err != nil {
+			return nil
+		}
+		chunk.Vector = decodeVector(blob)
+		chunk.AddedAt = time.UnixMilli(addedAt)
+		chunks = append(chunks, chunk)
+	}
+	return rankChunks(chunks, vector, n)
+}
+
+// CountAddedBy implements vectorIndex
+func (x *sqliteVectorIndex) CountAddedBy(who AgentSender) int {
+	var count int
+	x.db.QueryRow(`SELECT COUNT(*) FROM chunks WHERE (? != '' AND added_by_uuid = ?) OR (? != '' AND added_by = ?)`,
+		who.UUID, who.UUID, who.Number, who.Number).Scan(&count)
+	return count
+}
+
+// ForgetAddedBy implements vectorIndex
+func (x *sqliteVectorIndex) ForgetAddedBy(who AgentSender) error {
+	_, err := x.db.Exec(`DELETE FROM chunks WHERE (? != '' AND added_by_uuid = ?) OR (? != '' AND added_by = ?)`,
+		who.UUID, who.UUID, who.Number, who.Number)
+	return err
+}
+
+// Close implements vectorIndex
+func (x *sqliteVectorIndex) Close() error {
+	if x.db == nil {
+		return nil
+	}
+	return x.db.Close()
+}
+
+func encodeVector(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// voiceEnabled reports whether voice-command mode is configured
+func (bot *SignalBot) voiceEnabled() bool {
+	return bot.config.VoiceTranscribeURL != ""
+}
+
+// voiceNote returns the first voice note attached to a received DM
+func (msg *Message) voiceNote() (Attachment, bool) {
+	if msg.Envelope.DataMessage.GroupInfo.GroupId != "" {
+		return Attachment{}, false
+	}
+	for _, a := range msg.Envelope.DataMessage.Attachments {
+		if a.VoiceNote || strings.HasPrefix(a.ContentType, "audio/") {
+			return a, true
+		}
+	}
+	return Attachment{}, false
+}
+
+// handleVoiceNote treats a voice note in a DM that opted in with
+// "!set voice on" as a trigger-free prompt, answering with text and audio.
+// It returns false when msg isn't such a voice note.
+func (bot *SignalBot) handleVoiceNote(ctx context.Context, msg *Message) bool {
+	if !bot.voiceEnabled() {
+		return false
+	}
+	note, ok := msg.voiceNote()
+	if !ok || bot.settings.Get(msg.chatID(), "voice") != "on" {
+		return false
+	}
+
+	if !bot.promptAllowed(msg, true) {
+		return true
+	}
+
+	recipient := msg.getRecipient()
+	if recipient == "" {
+		recipient = msg.Envelope.Source
+	}
+	target := replyTarget{Recipient: recipient, QuoteTimestamp: msg.extractTimestamp(), QuoteAuthor: msg.Envelope.Source}
+
+	bot.logger.Printf("Transcribing voice note from %s", msg.Envelope.Source)
+	prompt, err := bot.transcribe(ctx, filepath.Join(bot.config.AttachmentsDir, note.ID), note.ContentType)
+	if err != nil || strings.TrimSpace(prompt) == "" {
+		bot.logger.Printf("Error transcribing voice note: %v", err)
+		bot.sendReply(target.Recipient, "Sorry, I couldn't understand that voice note.", target.QuoteTimestamp, target.QuoteAuthor)
+		return true
+	}
+
+	request := msg.newAgentRequest(prompt)
+	request.quota = bot.hasQuota(msg)
+	result, sent := bot.answer(ctx, request, target)
+	if !sent {
+		return true
+	}
+
+	if bot.config.VoiceTTSURL == "" {
+		return true
+	}
+	audio, err := bot.synthesize(ctx, strings.Join(result.Replies, "\n\n"))
+	if err != nil {
+		bot.logger.Printf("Error synthesizing reply audio: %v", err)
+		return true
+	}
+	defer os.Remove(audio)
+
+	if err := bot.sendAttachment(target.Recipient, audio, ""); err != nil {
+		bot.logger.Printf("Error sending reply audio: %v", err)
+	}
+	return true
+}
+
+// transcribe sends an audio file to an OpenAI-compatible transcription
+// endpoint (POST multipart "file" + "model", response {"text": ...})
+func (bot *SignalBot) transcribe(ctx context.Context, path, contentType string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open voice note: %w", err)
+	}
+	defer file.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filepath.Base(path)+audioExtension(contentType))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return "", err
+	}
+	form.WriteField("model", bot.config.VoiceTranscribeModel)
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", bot.config.VoiceTranscribeURL, &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create transcription request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	bot.setVoiceAuth(req)
+
+	resp, err := bot.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call transcription service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("transcription service returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode transcription: %w", err)
+	}
+	return result.Text, nil
+}
+
+// synthesize turns text into speech with an OpenAI-compatible TTS
+// endpoint and returns the path of a temporary audio file
+func (bot *SignalBot) synthesize(ctx context.Context, text string) (string, error) {
+	payload, err := json.Marshal(map[string]string{
+		"model": bot.config.VoiceTTSModel,
+		"voice": bot.config.VoiceTTSVoice,
+		"input": text,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", bot.config.VoiceTTSURL, bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("failed to create TTS request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	bot.setVoiceAuth(req)
+
+	resp, err := bot.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call TTS service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("TTS service returned status %d", resp.StatusCode)
+	}
+
+	out, err := os.CreateTemp("", "signalbot-reply-*.mp3")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", fmt.Errorf("failed to read TTS audio: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// setVoiceAuth adds the bearer token for the speech services, if any
+func (bot *SignalBot) setVoiceAuth(req *http.Request) {
+	if bot.config.VoiceAPIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+bot.config.VoiceAPIKey)
+	}
+}
+
+// audioExtension guesses a filename extension so transcription services
+// can detect the audio format
+func audioExtension(contentType string) string {
+	switch contentType {
+	case "audio/aac":
+		return ".aac"
+	case "audio/mpeg":
+		return ".mp3"
+	case "audio/ogg":
+		return ".ogg"
+	case "audio/mp4", "audio/m4a", "audio/x-m4a":
+		return ".m4a"
+	case "audio/wav", "audio/x-wav":
+		return ".wav"
+	default:
+		return ""
+	}
+}
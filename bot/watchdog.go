@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchdogInterval is how often the watchdog checks on signal-cli
+const watchdogInterval = 15 * time.Second
+
+// receiveWatch tracks signal-cli receive calls for the watchdog
+type receiveWatch struct {
+	mu       sync.Mutex
+	started  time.Time          // of the receive in progress, zero if none
+	cancel   context.CancelFunc // stops the receive in progress
+	lastOK   time.Time
+	latency  time.Duration // of the last successful receive
+	failures int           // in a row
+	lastErr  error
+	alerted  bool // the owner was told about the current failures
+}
+
+// Begin records the start of a receive, returning the context it runs in
+func (w *receiveWatch) Begin(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.started = time.Now()
+	w.cancel = cancel
+	return ctx, cancel
+}
+
+// End records the outcome of the receive in progress, reporting whether it
+// ended a run of failures the owner was alerted about
+func (w *receiveWatch) End(err error) (recovered bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err != nil {
+		w.failures++
+		w.lastErr = err
+	} else {
+		recovered = w.alerted
+		w.failures, w.lastErr, w.alerted = 0, nil, false
+		w.lastOK = time.Now()
+		w.latency = w.lastOK.Sub(w.started)
+	}
+	w.started, w.cancel = time.Time{}, nil
+	return recovered
+}
+
+// Stuck cancels the receive in progress if it has run longer than limit,
+// reporting whether it did
+func (w *receiveWatch) Stuck(limit time.Duration) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancel == nil || time.Since(w.started) <= limit {
+		return false
+	}
+	w.cancel()
+	return true
+}
+
+// Alert reports whether the owner should be told about the current
+// failures: once, when there are at least threshold of them
+func (w *receiveWatch) Alert(threshold int) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if threshold <= 0 || w.alerted || w.failures < threshold {
+		return false, nil
+	}
+	w.alerted = true
+	return true, w.lastErr
+}
+
+// String summarizes receiving for !status
+func (w *receiveWatch) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var parts []string
+	if w.lastOK.IsZero() {
+		parts = append(parts, "no successful receive yet")
+	} else {
+		parts = append(parts, fmt.Sprintf("last receive %s ago (took %s)", time.Since(w.lastOK).Round(time.Second), w.latency.Round(time.Millisecond)))
+	}
+	if w.failures > 0 {
+		parts = append(parts, fmt.Sprintf("%d failures in a row: %v", w.failures, w.lastErr))
+	}
+	if !w.started.IsZero() {
+		parts = append(parts, fmt.Sprintf("receiving for %s", time.Since(w.started).Round(time.Second)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// receiveWatched runs receiveMessages under the watchdog
+func (bot *SignalBot) receiveWatched(ctx context.Context) ([]Message, error) {
+	watched, cancel := bot.watch.Begin(ctx)
+	defer cancel()
+
+	messages, err := bot.receiveMessages(watched)
+	if err != nil && ctx.Err() == nil && watched.Err() != nil {
+		err = fmt.Errorf("stopped by the watchdog: %w", err)
+	}
+	if bot.watch.End(err) {
+		bot.notifyAdmin(adminEventWatchdog, "✅ signal-cli is receiving again.")
+	}
+	return messages, err
+}
+
+// runWatchdog stops signal-cli receives that hang past their timeout and
+// tells the owner when receiving keeps failing. Without a daemon each poll
+// starts a fresh signal-cli, so stopping a stuck one restarts it.
+func (bot *SignalBot) runWatchdog(ctx context.Context) error {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	// The timeout should have stopped it by then
+	limit := bot.config.SignalReceiveTimeout + signalKillDelay + watchdogInterval
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		if bot.watch.Stuck(limit) {
+			bot.logger.Printf("Watchdog: signal-cli receive stuck for over %s, stopping it", limit)
+		}
+		if alert, err := bot.watch.Alert(bot.config.WatchdogFailures); alert {
+			bot.logger.Printf("Watchdog: signal-cli receive keeps failing: %v", err)
+			bot.notifyAdmin(adminEventWatchdog, fmt.Sprintf("⚠️ signal-cli failed to receive messages %d times in a row: %v", bot.config.WatchdogFailures, err))
+		}
+	}
+}
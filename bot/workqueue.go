@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Work queue policies for when the queue is full
+const (
+	queuePolicyBlock      = "block"       // stop receiving until there is room
+	queuePolicyDropOldest = "drop-oldest" // drop the longest-waiting message
+	queuePolicyReject     = "reject"      // drop the new message
+)
+
+// queueFullReaction is the apology a dropped message's sender gets
+const queueFullReaction = "🙏"
+
+// Work queue lanes, processed in order: each lane waits until the ones
+// before it are empty
+const (
+	laneDM    = iota // direct messages, when WORK_QUEUE_DM_PRIORITY is set
+	laneGroup        // group messages, or everything without DM priority
+	laneCount
+)
+
+// workQueue holds received messages until the processor gets to them. It is
+// bounded: what happens when it is full depends on WORK_QUEUE_POLICY. With
+// DM priority, direct messages jump ahead of group ones and, when the queue
+// is full, displace them rather than being dropped.
+type workQueue struct {
+	mu       sync.Mutex
+	size     int
+	policy   string
+	dmsFirst bool
+	lanes    [laneCount][]Message
+	ready    chan struct{} // signalled when a message is pushed
+	room     chan struct{} // signalled when a message is popped
+	dropped  atomic.Int64
+}
+
+func newWorkQueue(size int, policy string, dmsFirst bool) *workQueue {
+	return &workQueue{
+		size:     size,
+		policy:   policy,
+		dmsFirst: dmsFirst,
+		ready:    make(chan struct{}, 1),
+		room:     make(chan struct{}, 1),
+	}
+}
+
+// lane returns the lane msg is queued in
+func (q *workQueue) lane(msg *Message) int {
+	if q.dmsFirst && msg.extractGroupId() == "" {
+		return laneDM
+	}
+	return laneGroup
+}
+
+// Push queues msg. When the queue is full it blocks until there is room or
+// ctx ends, or drops a message, which it returns.
+func (q *workQueue) Push(ctx context.Context, msg Message) (*Message, error) {
+	lane := q.lane(&msg)
+	for {
+		q.mu.Lock()
+		if q.len() < q.size {
+			q.lanes[lane] = append(q.lanes[lane], msg)
+			q.mu.Unlock()
+			notify(q.ready)
+			return nil, nil
+		}
+
+		// The oldest message of the last lane is the one to give up: under
+		// drop-oldest if it doesn't outrank msg, under reject only if msg
+		// outranks it
+		victim := laneCount - 1
+		for len(q.lanes[victim]) == 0 {
+			victim--
+		}
+		evict := victim > lane || (victim == lane && q.policy == queuePolicyDropOldest)
+		if q.policy != queuePolicyBlock {
+			q.dropped.Add(1)
+			if !evict {
+				q.mu.Unlock()
+				return &msg, nil
+			}
+			oldest := q.lanes[victim][0]
+			q.lanes[victim] = q.lanes[victim][1:]
+			q.lanes[lane] = append(q.lanes[lane], msg)
+			q.mu.Unlock()
+			notify(q.ready)
+			return &oldest, nil
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.room:
+		}
+	}
+}
+
+// Pop waits for the next message until ctx ends
+func (q *workQueue) Pop(ctx context.Context) (Message, error) {
+	for {
+		q.mu.Lock()
+		for lane := range q.lanes {
+			if len(q.lanes[lane]) > 0 {
+				msg := q.lanes[lane][0]
+				q.lanes[lane] = q.lanes[lane][1:]
+				q.mu.Unlock()
+				notify(q.room)
+				return msg, nil
+			}
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		case <-q.ready:
+		}
+	}
+}
+
+// Snapshot returns a copy of the queued messages, next first
+func (q *workQueue) Snapshot() []Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var messages []Message
+	for _, lane := range q.lanes {
+		messages = append(messages, lane...)
+	}
+	return messages
+}
+
+// Len returns the number of queued messages
+func (q *workQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.len()
+}
+
+// len counts the queued messages; callers must hold q.mu
+func (q *workQueue) len() int {
+	n := 0
+	for _, lane := range q.lanes {
+		n += len(lane)
+	}
+	return n
+}
+
+// Dropped returns how many messages were dropped because the queue was full
+func (q *workQueue) Dropped() int64 {
+	return q.dropped.Load()
+}
+
+// notify wakes a waiter on ch without blocking when one is already pending
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// validQueuePolicy reports whether policy is a WORK_QUEUE_POLICY value
+func validQueuePolicy(policy string) bool {
+	switch policy {
+	case queuePolicyBlock, queuePolicyDropOldest, queuePolicyReject:
+		return true
+	}
+	return false
+}
+
+// enqueueMessages queues a received batch for the processor. Messages
+// still waiting for room stay in the inbox, so stopping while the queue is
+// full doesn't lose them.
+func (bot *SignalBot) enqueueMessages(ctx context.Context, messages []Message) {
+	bot.setUnqueued(messages)
+	for i, msg := range messages {
+		dropped, err := bot.work.Push(ctx, msg)
+		if err != nil {
+			return
+		}
+		bot.setUnqueued(messages[i+1:])
+		if dropped != nil {
+			bot.rejectMessage(*dropped)
+		}
+	}
+}
+
+// setUnqueued records the messages not yet in the work queue and persists
+// the inbox
+func (bot *SignalBot) setUnqueued(messages []Message) {
+	bot.inboxMu.Lock()
+	bot.unqueued = messages
+	bot.inboxMu.Unlock()
+	bot.saveInbox()
+}
+
+// rejectMessage tells the sender of a message dropped because the work
+// queue was full, with a reaction
+func (bot *SignalBot) rejectMessage(dropped Message) {
+	bot.logger.Printf("Work queue full (%d), dropped a message from %s", bot.config.WorkQueueSize, dropped.Envelope.Source)
+	bot.alertQueueOverflow()
+	if dropped.extractContent() != "" {
+		if err := bot.sendReaction(dropped.replyRecipient(), queueFullReaction, dropped.Envelope.Source, dropped.extractTimestamp()); err != nil {
+			bot.logger.Printf("Error reacting to dropped message: %v", err)
+		}
+	}
+}
+
+// inboxMessages returns what has been received but not yet processed: the
+// messages being processed, the queue behind them and any not yet queued.
+// Callers must hold bot.inboxMu.
+func (bot *SignalBot) inboxMessages() []Message {
+	var messages []Message
+	for _, msg := range bot.processing {
+		messages = append(messages, *msg)
+	}
+	messages = append(messages, bot.work.Snapshot()...)
+	return append(messages, bot.unqueued...)
+}
+
+// workQueueStatus summarizes the work queue for !status
+func (bot *SignalBot) workQueueStatus() string {
+	return fmt.Sprintf("%d/%d queued, %d dropped (%s)", bot.work.Len(), bot.config.WorkQueueSize, bot.work.Dropped(), bot.config.WorkQueuePolicy)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// queueMessage builds a message identified by label: labels starting with
+// "g" are group messages, the others direct messages
+func queueMessage(label string) Message {
+	var msg Message
+	msg.Envelope.Source = label
+	if strings.HasPrefix(label, "g") {
+		msg.Envelope.DataMessage.GroupInfo.GroupId = "group"
+	}
+	return msg
+}
+
+func TestWorkQueuePushEviction(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		dmsFirst    bool
+		pushes      []string
+		wantDropped []string // per push, "" when nothing was dropped
+		wantQueue   []string // queued labels, next first
+	}{
+		{
+			name:        "drop-oldest gives up the longest-waiting message",
+			policy:      queuePolicyDropOldest,
+			pushes:      []string{"g1", "g2", "g3", "g4"},
+			wantDropped: []string{"", "", "g1", "g2"},
+			wantQueue:   []string{"g3", "g4"},
+		},
+		{
+			name:        "reject drops the new message",
+			policy:      queuePolicyReject,
+			pushes:      []string{"g1", "g2", "g3"},
+			wantDropped: []string{"", "", "g3"},
+			wantQueue:   []string{"g1", "g2"},
+		},
+		{
+			name:        "without DM priority DMs and groups share one lane",
+			policy:      queuePolicyDropOldest,
+			pushes:      []string{"d1", "g1", "d2"},
+			wantDropped: []string{"", "", "d1"},
+			wantQueue:   []string{"g1", "d2"},
+		},
+		{
+			name:        "a DM displaces the oldest group message under drop-oldest",
+			policy:      queuePolicyDropOldest,
+			dmsFirst:    true,
+			pushes:      []string{"g1", "g2", "d1"},
+			wantDropped: []string{"", "", "g1"},
+			wantQueue:   []string{"d1", "g2"},
+		},
+		{
+			name:        "a DM displaces the oldest group message under reject",
+			policy:      queuePolicyReject,
+			dmsFirst:    true,
+			pushes:      []string{"g1", "g2", "d1"},
+			wantDropped: []string{"", "", "g1"},
+			wantQueue:   []string{"d1", "g2"},
+		},
+		{
+			name:        "a DM never displaces another DM under reject",
+			policy:      queuePolicyReject,
+			dmsFirst:    true,
+			pushes:      []string{"d1", "d2", "d3"},
+			wantDropped: []string{"", "", "d3"},
+			wantQueue:   []string{"d1", "d2"},
+		},
+		{
+			name:        "a DM displaces the oldest DM under drop-oldest",
+			policy:      queuePolicyDropOldest,
+			dmsFirst:    true,
+			pushes:      []string{"d1", "d2", "d3"},
+			wantDropped: []string{"", "", "d1"},
+			wantQueue:   []string{"d2", "d3"},
+		},
+		{
+			name:        "a group message never displaces a DM",
+			policy:      queuePolicyDropOldest,
+			dmsFirst:    true,
+			pushes:      []string{"d1", "d2", "g1"},
+			wantDropped: []string{"", "", "g1"},
+			wantQueue:   []string{"d1", "d2"},
+		},
+		{
+			name:        "group messages are dropped before any DM",
+			policy:      queuePolicyDropOldest,
+			dmsFirst:    true,
+			pushes:      []string{"d1", "g1", "d2", "g2"},
+			wantDropped: []string{"", "", "g1", "g2"},
+			wantQueue:   []string{"d1", "d2"},
+		},
+		{
+			name:        "a group message displaces the oldest one of its lane",
+			policy:      queuePolicyDropOldest,
+			dmsFirst:    true,
+			pushes:      []string{"g1", "g2", "g3"},
+			wantDropped: []string{"", "", "g1"},
+			wantQueue:   []string{"g2", "g3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newWorkQueue(2, tt.policy, tt.dmsFirst)
+			wantCount := 0
+			for i, label := range tt.pushes {
+				dropped, err := q.Push(context.Background(), queueMessage(label))
+				if err != nil {
+					t.Fatalf("Push(%s) error = %v", label, err)
+				}
+				got := ""
+				if dropped != nil {
+					got = dropped.Envelope.Source
+				}
+				if got != tt.wantDropped[i] {
+					t.Errorf("Push(%s) dropped %q, want %q", label, got, tt.wantDropped[i])
+				}
+				if tt.wantDropped[i] != "" {
+					wantCount++
+				}
+			}
+
+			var queued []string
+			for _, msg := range q.Snapshot() {
+				queued = append(queued, msg.Envelope.Source)
+			}
+			if !reflect.DeepEqual(queued, tt.wantQueue) {
+				t.Errorf("queue = %v, want %v", queued, tt.wantQueue)
+			}
+			if q.Dropped() != int64(wantCount) {
+				t.Errorf("Dropped() = %d, want %d", q.Dropped(), wantCount)
+			}
+		})
+	}
+}
+
+func TestWorkQueuePushBlocksWhenFull(t *testing.T) {
+	q := newWorkQueue(1, queuePolicyBlock, true)
+	if _, err := q.Push(context.Background(), queueMessage("g1")); err != nil {
+		t.Fatalf("Push(g1) error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dropped, err := q.Push(ctx, queueMessage("d1"))
+	if !errors.Is(err, context.Canceled) || dropped != nil {
+		t.Fatalf("Push(d1) on a full queue = %v, %v; want nil, context.Canceled", dropped, err)
+	}
+	if q.Len() != 1 || q.Dropped() != 0 {
+		t.Errorf("Len() = %d, Dropped() = %d; want 1, 0", q.Len(), q.Dropped())
+	}
+}
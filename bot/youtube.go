@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var youtubeIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+// youtubeVideoID returns the video ID of a YouTube watch, short or
+// youtu.be link, or "" for any other URL
+func youtubeVideoID(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	host = strings.TrimPrefix(host, "m.")
+
+	var id string
+	switch host {
+	case "youtu.be":
+		id = strings.Trim(u.Path, "/")
+	case "youtube.com", "music.youtube.com":
+		switch {
+		case u.Path == "/watch":
+			id = u.Query().Get("v")
+		case strings.HasPrefix(u.Path, "/shorts/"), strings.HasPrefix(u.Path, "/live/"), strings.HasPrefix(u.Path, "/embed/"):
+			id = strings.Split(u.Path, "/")[2]
+		}
+	}
+	if !youtubeIDPattern.MatchString(id) {
+		return ""
+	}
+	return id
+}
+
+// captionTrack is an entry of a watch page's captionTracks list
+type captionTrack struct {
+	BaseURL      string `json:"baseUrl"`
+	LanguageCode string `json:"languageCode"`
+	Kind         string `json:"kind"` // "asr" for automatic captions
+}
+
+// fetchTranscript reads a video's title and captions from its watch page,
+// preferring TRANSCRIPT_LANGUAGES and human-made captions over automatic
+// ones. The transcript has a [mm:ss] marker at the start of each minute.
+func (bot *SignalBot) fetchTranscript(ctx context.Context, id string) (string, string, error) {
+	page, _, err := bot.download(ctx, "https://www.youtube.com/watch?v="+id)
+	if err != nil {
+		return "", "", err
+	}
+	title, _ := readableText(string(page))
+	title = strings.TrimSuffix(title, " - YouTube")
+
+	tracks, err := captionTracks(page)
+	if err != nil {
+		return title, "", err
+	}
+	track, ok := bot.pickCaptionTrack(tracks)
+	if !ok {
+		return title, "", fmt.Errorf("this video has no captions")
+	}
+
+	data, _, err := bot.download(ctx, track.BaseURL)
+	if err != nil {
+		return title, "", fmt.Errorf("failed to fetch captions: %w", err)
+	}
+	var captions struct {
+		Texts []struct {
+			Start string `xml:"start,attr"`
+			Text  string `xml:",chardata"`
+		} `xml:"text"`
+	}
+	if err := xml.Unmarshal(data, &captions); err != nil {
+		return title, "", fmt.Errorf("failed to parse captions: %w", err)
+	}
+
+	var transcript strings.Builder
+	minute := -1
+	for _, line := range captions.Texts {
+		start, _ := strconv.ParseFloat(line.Start, 64)
+		if m := int(start) / 60; m > minute {
+			minute = m
+			if transcript.Len() > 0 {
+				transcript.WriteString("\n\n")
+			}
+			fmt.Fprintf(&transcript, "[%02d:00]", minute)
+		}
+		transcript.WriteByte(' ')
+		transcript.WriteString(strings.Join(strings.Fields(html.UnescapeString(line.Text)), " "))
+	}
+	if transcript.Len() == 0 {
+		return title, "", fmt.Errorf("the captions are empty")
+	}
+	return title, transcript.String(), nil
+}
+
+// captionTracks finds the captionTracks list embedded in a watch page
+func captionTracks(page []byte) ([]captionTrack, error) {
+	start := bytes.Index(page, []byte(`"captionTracks":`))
+	if start < 0 {
+		return nil, fmt.Errorf("this video has no captions")
+	}
+	var tracks []captionTrack
+	decoder := json.NewDecoder(bytes.NewReader(page[start+len(`"captionTracks":`):]))
+	if err := decoder.Decode(&tracks); err != nil {
+		return nil, fmt.Errorf("failed to read caption list: %w", err)
+	}
+	return tracks, nil
+}
+
+// pickCaptionTrack chooses the best track for TRANSCRIPT_LANGUAGES, falling
+// back to the first one
+func (bot *SignalBot) pickCaptionTrack(tracks []captionTrack) (captionTrack, bool) {
+	if len(tracks) == 0 {
+		return captionTrack{}, false
+	}
+	for _, lang := range bot.config.TranscriptLanguages {
+		var auto *captionTrack
+		for i, track := range tracks {
+			if !strings.EqualFold(strings.SplitN(track.LanguageCode, "-", 2)[0], lang) {
+				continue
+			}
+			if track.Kind != "asr" {
+				return track, true
+			}
+			if auto == nil {
+				auto = &tracks[i]
+			}
+		}
+		if auto != nil {
+			return *auto, true
+		}
+	}
+	return tracks[0], true
+}
@@ -10,12 +10,50 @@ type Env = {
 interface MyState {
 	// Define any state properties you need for your Agent
 }
+
+// Header used by the Signal bot to negotiate the request/response protocol
+const PROTOCOL_HEADER = 'X-Signal-Bot-Protocol';
+
+const DEFAULT_MODEL = '@cf/meta/llama-4-scout-17b-16e-instruct';
+
+type Turn = { role: 'user' | 'assistant' | 'system'; content: string };
+type Document = { source: string; text: string };
+type Image = { source: string; content_type: string; data: string };
+
+// Model used for prompts with attached images the bot couldn't read as text
+const VISION_MODEL = '@cf/llava-hf/llava-1.5-7b-hf';
+
+// localTime renders the current time in an IANA timezone
+function localTime(timezone: string): string {
+	try {
+		return new Date().toLocaleString('en-GB', { timeZone: timezone, dateStyle: 'full', timeStyle: 'short' });
+	} catch {
+		return new Date().toISOString();
+	}
+}
 export class Ziggy extends Agent<Env, MyState> {
 	async onRequest(request: Request): Promise<Response> {
 		if (request.method === 'POST') {
 			try {
-				const { prompt } = (await request.json()) as any;
-				const response = await this.respond(prompt);
+				const { prompt, history, persona, model, parameters, documents, images, language, timezone, system_prompt } = (await request.json()) as any;
+				const response = Array.isArray(images) && images.length > 0 ? await this.describe(prompt, images[0]) : await this.respond(
+					prompt,
+					Array.isArray(history) ? history : [],
+					persona?.system_prompt,
+					model,
+					parameters,
+					Array.isArray(documents) ? documents : [],
+					typeof language === 'string' ? language : undefined,
+					typeof timezone === 'string' ? timezone : undefined,
+					typeof system_prompt === 'string' ? system_prompt : undefined,
+				);
+
+				// v2 clients accept a list of messages; v1 clients only read `response`
+				if (request.headers.get(PROTOCOL_HEADER) === '2') {
+					return new Response(JSON.stringify({ ...response, messages: [{ text: response.response }] }), {
+						headers: { 'Content-Type': 'application/json', [PROTOCOL_HEADER]: '2' },
+					});
+				}
 				return new Response(JSON.stringify(response), {
 					headers: { 'Content-Type': 'application/json' },
 				});
@@ -27,22 +65,51 @@ export class Ziggy extends Agent<Env, MyState> {
 		return new Response('Not Found', { status: 404 });
 	}
 
-	async respond(prompt: string): Promise<any> {
+	// describe answers a prompt about an image with a vision model
+	async describe(prompt: string, image: Image): Promise<any> {
+		const bytes = Uint8Array.from(atob(image.data), (c) => c.charCodeAt(0));
+		const result = (await env.AI.run(VISION_MODEL as any, { image: [...bytes], prompt, max_tokens: 512 })) as any;
+		return { response: result.description };
+	}
+
+	async respond(
+		prompt: string,
+		history: Turn[] = [],
+		persona?: string,
+		model?: string,
+		parameters: { temperature?: number; max_tokens?: number } = {},
+		documents: Document[] = [],
+		language?: string,
+		timezone?: string,
+		chatPrompt?: string,
+	): Promise<any> {
 		try {
 			// const mcpConnection = await this.mcp.connect(
 			//   "https://path-to-mcp-server/sse"
 			// );
 
-			const response = await runWithTools(env.AI as any, '@cf/meta/llama-4-scout-17b-16e-instruct', {
+			// Only Workers AI models can be selected by the bot
+			const selectedModel = typeof model === 'string' && model.startsWith('@cf/') ? model : DEFAULT_MODEL;
+			// Passages of files the chat asked the bot to remember
+			const excerpts = documents.map(({ source, text }) => `[${source}]\n${text}`).join('\n\n');
+			const response = await runWithTools(env.AI as any, selectedModel, {
 				messages: [
 					{
 						role: 'system',
 						content:
-							"You are a highly capable, thoughtful, and precise assistant. You are a Signal bot that responds to messages in a concise, helpful and friendly manner, using emojis where appropriate. You are always upfront about your limitations, and you never make up information. Always prioritize being truthful, nuanced, insightful, and efficient, tailoring your responses specifically to the user's needs and preferences. Feel free to use your available tools to provide live or interesting responses.",
+							"You are a highly capable, thoughtful, and precise assistant. You are a Signal bot that responds to messages in a concise, helpful and friendly manner, using emojis where appropriate. You are always upfront about your limitations, and you never make up information. Always prioritize being truthful, nuanced, insightful, and efficient, tailoring your responses specifically to the user's needs and preferences. Feel free to use your available tools to provide live or interesting responses." +
+							(chatPrompt ? `\n\n${chatPrompt}` : '') +
+							(persona ? `\n\n${persona}` : '') +
+							(excerpts ? `\n\nExcerpts from documents shared earlier in this chat:\n\n${excerpts}` : '') +
+							(language ? `\n\nAlways reply in the language with ISO 639-1 code "${language}".` : '') +
+							(timezone ? `\n\nThe user's timezone is ${timezone}; it is now ${localTime(timezone)} there. Give times in that timezone.` : ''),
 					},
+					...history.map(({ role, content }) => ({ role, content })),
 					{ role: 'user', content: prompt },
 				],
 				tools,
+				...(typeof parameters?.temperature === 'number' && { temperature: parameters.temperature }),
+				...(typeof parameters?.max_tokens === 'number' && { max_tokens: parameters.max_tokens }),
 			});
 			console.log('AI response received:', JSON.stringify(response, null, 2));
 			return response;
@@ -56,6 +123,9 @@ export class Ziggy extends Agent<Env, MyState> {
 export default {
 	async fetch(request, env, ctx): Promise<Response> {
 		const url = new URL(request.url);
+		if (url.pathname === '/health') {
+			return Response.json({ status: 'ok' });
+		}
 		if (url.pathname === '/signal-bot') {
 			let namedAgent = getAgentByName<Env, Ziggy>(env.Ziggy, 'ziggy-bot');
 			let namedResp = (await namedAgent).fetch(request);